@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"gemini-web2api/internal/mockgemini"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8765", "listen address")
+	dir := flag.String("fixtures", "", "extra fixtures directory (*.txt)")
+	fixture := flag.String("fixture", mockgemini.DefaultFixture, "fixture to replay")
+	flag.Parse()
+
+	server, err := mockgemini.NewServer(*dir)
+	if err != nil {
+		log.Fatalf("Failed to load fixtures: %v", err)
+	}
+	if err := server.Use(*fixture); err != nil {
+		log.Fatalf("%v (available: %v)", err, server.Fixtures())
+	}
+
+	log.Printf("Mock Gemini listening on http://%s (fixture: %s, available: %v)", *addr, *fixture, server.Fixtures())
+	log.Printf("Run the server with GEMINI_BASE_URL=http://%s", *addr)
+	if err := http.ListenAndServe(*addr, server.Handler()); err != nil {
+		log.Fatalf("Failed to start mock server: %v", err)
+	}
+}
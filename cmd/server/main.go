@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"gemini-web2api/internal/adapter"
@@ -12,6 +17,8 @@ import (
 	"gemini-web2api/internal/browser"
 	"gemini-web2api/internal/config"
 	"gemini-web2api/internal/gemini"
+	"gemini-web2api/internal/session"
+	"gemini-web2api/internal/storage"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/gin-gonic/gin"
@@ -20,6 +27,7 @@ import (
 
 var (
 	pool           *balancer.AccountPool
+	sessions       *session.Store
 	accountConfigs map[string]string
 	cookiesMu      sync.RWMutex
 )
@@ -36,46 +44,106 @@ func main() {
 	_ = godotenv.Load()
 
 	config.LoadModelMapping()
+	config.LoadModelDefaults()
+
+	state := storage.FromEnv()
+	storage.StartCleanup(state, 10*time.Minute)
 
 	pool = balancer.NewAccountPool()
+	pool.SetStore(state)
+	pool.SetRotation(balancer.RotationFromEnv())
 	accountConfigs = make(map[string]string)
 
-	go loadAccountsAsync()
+	sessions = session.NewStoreFromEnv(state)
+	sessions.StartCleanup(10 * time.Minute)
+
+	prober := adapter.NewModelProber(pool)
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8007"
+	}
 
-	go watchEnvFile()
+	go func() {
+		loadAccountsAsync()
+		logStartupReport(port)
+		runStartupSelfTest()
+		prober.Probe()
+	}()
+
+	// 通过 GEMINI_ACCOUNTS 注入账号时不依赖 .env 文件，无需监听
+	if os.Getenv("GEMINI_ACCOUNTS") == "" {
+		go watchEnvFile()
+	}
+
+	readiness := adapter.NewReadiness(pool)
 
 	r := gin.Default()
 
+	// 所有接口注册在 BASE_PATH 之下；健康检查在鉴权之前注册，编排系统无需携带 API Key
+	basePath := config.BasePath()
+	probes := r.Group(basePath)
+	probes.GET("/health", readiness.HealthHandler)
+	probes.GET("/ready", readiness.ReadyHandler)
+
+	// 图片代理地址会被 <img> 等无法携带 API Key 的客户端直接访问，同样不经过鉴权
+	imageProxy := adapter.NewImageProxy(pool, state)
+	probes.GET(adapter.ImageProxyPath, imageProxy.Handler)
+
 	r.Use(adapter.CORSMiddleware())
+	r.Use(adapter.StreamFlushMiddleware())
 	r.Use(adapter.AuthMiddleware())
+	r.Use(readiness.Middleware())
 	r.Use(adapter.LoggerMiddleware())
+	r.Use(adapter.NewFairScheduler().Middleware())
+
+	// 中间件在分组创建时复制，api 分组必须在 r.Use 之后创建
+	api := r.Group(basePath)
 
 	// OpenAI Protocol
-	r.POST("/v1/chat/completions", adapter.ChatCompletionHandler(pool))
-	r.POST("/v1/images/generations", adapter.ImageGenerationHandler(pool))
-	r.GET("/v1/models", adapter.ListModelsHandler)
+	api.POST("/v1/chat/completions", adapter.ChatCompletionHandler(pool, sessions))
+	api.POST("/v1/responses", adapter.ResponsesHandler(pool, sessions))
+	api.DELETE("/v1/conversations/:id", adapter.DeleteConversationHandler(sessions))
+	api.POST("/v1/images/generations", adapter.ImageGenerationHandler(pool, imageProxy))
+	api.POST("/v1/images/variations", adapter.ImageVariationHandler(pool, imageProxy))
+	api.POST("/v1/audio/transcriptions", adapter.AudioTranscriptionHandler(pool))
+	api.GET("/v1/models", adapter.ListModelsHandler(prober))
 
 	// Claude Protocol
-	r.POST("/v1/messages", adapter.ClaudeMessagesHandler(pool))
-	r.POST("/v1/messages/count_tokens", adapter.ClaudeCountTokensHandler(pool))
-	r.GET("/v1/models/claude", adapter.ClaudeListModelsHandler)
-
-	r.POST("/v1beta/models/*action", adapter.GeminiRouterHandler(pool))
-	r.GET("/v1beta/models", adapter.GeminiListModelsHandler)
-
-	r.GET("/", func(c *gin.Context) {
+	api.POST("/v1/messages", adapter.ClaudeMessagesHandler(pool))
+	api.POST("/v1/messages/count_tokens", adapter.ClaudeCountTokensHandler(pool))
+	api.GET("/v1/models/claude", adapter.ClaudeListModelsHandler)
+
+	api.POST("/v1beta/models/*action", adapter.GeminiRouterHandler(pool))
+	api.GET("/v1beta/models", adapter.GeminiListModelsHandler)
+
+	// Admin：未设置 ADMIN_API_KEY 与 PROXY_API_KEY 时这些接口返回 403
+	admin := api.Group("", adapter.AdminAuthMiddleware())
+	admin.GET("/admin/accounts", adapter.AdminAccountsHandler(pool))
+	admin.POST("/admin/accounts/:id/reset", adapter.AdminResetAccountHandler(pool))
+	admin.POST("/admin/accounts/:id/disable", adapter.AdminSetAccountDisabledHandler(pool, true))
+	admin.POST("/admin/accounts/:id/enable", adapter.AdminSetAccountDisabledHandler(pool, false))
+	admin.POST("/admin/test-all", adapter.AdminTestAllHandler(pool))
+	admin.GET("/admin/conversations/export", adapter.AdminExportConversationsHandler(sessions))
+	admin.POST("/admin/conversations/import", adapter.AdminImportConversationsHandler(sessions, pool))
+	admin.POST("/admin/reload", adapter.AdminReloadHandler(func() (balancer.ReloadResult, error) {
+		_ = godotenv.Load()
+		return reloadAccounts()
+	}))
+
+	// Debug
+	admin.POST("/debug/raw", adapter.DebugRawHandler(pool))
+
+	api.GET("/", func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"status":    "Gemini-Web2API (Go) is running",
-			"docs":      "POST /v1/chat/completions (OpenAI) | POST /v1/messages (Claude) | POST /v1beta/models/{model}:generateContent (Gemini)",
+			"docs":      fmt.Sprintf("POST %[1]s/v1/chat/completions (OpenAI) | POST %[1]s/v1/messages (Claude) | POST %[1]s/v1beta/models/{model}:generateContent (Gemini)", basePath),
 			"protocols": []string{"openai", "claude", "gemini"},
 			"accounts":  pool.Size(),
 		})
 	})
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8007"
-	}
+	flushStorageOnExit()
 
 	log.Printf("Server starting on port %s (accounts loading in background...)", port)
 	if err := r.Run(":" + port); err != nil {
@@ -83,17 +151,39 @@ func main() {
 	}
 }
 
-func accountConfigHash(cookies map[string]string, proxyURL string) string {
-	return cookies["__Secure-1PSID"] + "|" + cookies["__Secure-1PSIDTS"] + "|" + proxyURL
+// flushStorageOnExit 文件存储延迟写盘，收到 Ctrl+C / SIGTERM 时先写入尚未保存的修改再退出
+func flushStorageOnExit() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		storage.FlushAll()
+		os.Exit(0)
+	}()
+}
+
+func accountConfigHash(account browser.AccountConfig) string {
+	cookies, _ := json.Marshal(account.Cookies)
+	headers, _ := json.Marshal(account.Headers)
+	return string(cookies) + "|" + account.ProxyURL + "|" + string(headers) + "|" + strings.Join(account.Models, ",")
 }
 
 func loadAccountsAsync() {
 	log.Println("Loading accounts in background...")
+	if _, err := reloadAccounts(); err != nil {
+		log.Printf("Failed to load cookies: %v", err)
+	}
+}
+
+// reloadAccounts 重新读取账号配置：配置变化的账号重新初始化，初始化失败的账号移出负载均衡池并转入后台重试，
+// 其余账号保持不变。并发调用（.env 监听与 /admin/reload）会串行执行
+func reloadAccounts() (balancer.ReloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
 
-	allCookies, accountIDs, proxyURLs, err := browser.LoadMultiCookies(browser.ParseAccountIDs(os.Getenv("ACCOUNTS")))
+	accounts, err := browser.LoadAccounts(browser.ParseAccountIDs(os.Getenv("ACCOUNTS")))
 	if err != nil {
-		log.Printf("Failed to load cookies: %v", err)
-		return
+		return balancer.ReloadResult{}, err
 	}
 
 	cookiesMu.RLock()
@@ -103,13 +193,11 @@ func loadAccountsAsync() {
 	}
 	cookiesMu.RUnlock()
 
+	accountIDs := make([]string, 0, len(accounts))
 	newConfigs := make(map[string]string)
-	for i, cookies := range allCookies {
-		proxyURL := ""
-		if i < len(proxyURLs) {
-			proxyURL = proxyURLs[i]
-		}
-		newConfigs[accountIDs[i]] = accountConfigHash(cookies, proxyURL)
+	for _, account := range accounts {
+		accountIDs = append(accountIDs, account.ID)
+		newConfigs[account.ID] = accountConfigHash(account)
 	}
 
 	var toInit []int
@@ -125,14 +213,15 @@ func loadAccountsAsync() {
 	}
 
 	if len(toInit) == 0 {
-		log.Println("No cookie changes detected, skipping reload")
-		return
+		log.Println("No cookie changes detected")
+	} else {
+		log.Printf("Detected %d account(s) with cookie changes, %d unchanged", len(toInit), len(toKeep))
 	}
 
-	log.Printf("Detected %d account(s) with cookie changes, %d unchanged", len(toInit), len(toKeep))
-
 	type accountResult struct {
-		entry balancer.AccountEntry
+		entry   balancer.AccountEntry
+		account browser.AccountConfig
+		err     error
 	}
 	results := make(chan accountResult, len(toInit))
 
@@ -140,77 +229,238 @@ func loadAccountsAsync() {
 
 	for _, idx := range toInit {
 		wg.Add(1)
-		go func(i int, c map[string]string, proxyURL string) {
+		go func(account browser.AccountConfig) {
 			defer wg.Done()
 
-			displayID := accountIDs[i]
+			displayID := account.ID
 			if displayID == "" {
 				displayID = "default"
 			}
-			if proxyURL != "" {
-				log.Printf("账号 '%s' 使用代理: %s", displayID, proxyURL)
+			if account.ProxyURL != "" {
+				log.Printf("账号 '%s' 使用代理: %s", displayID, redactURL(account.ProxyURL))
 			}
 
-			const maxRetries = 3
-			for attempt := 1; attempt <= maxRetries; attempt++ {
-				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-
-				done := make(chan error, 1)
-				var client *gemini.Client
-
-				go func() {
-					var err error
-					client, err = gemini.NewClient(c, proxyURL)
-					if err != nil {
-						done <- err
-						return
-					}
-					client.AccountID = accountIDs[i]
-					done <- client.Init()
-				}()
-
-				select {
-				case err := <-done:
-					cancel()
-					if err == nil {
-						results <- accountResult{entry: balancer.AccountEntry{Client: client, AccountID: accountIDs[i], ProxyURL: proxyURL}}
-						log.Printf("Account '%s': ready", displayID)
-						return
-					}
-					if attempt < maxRetries {
-						log.Printf("Account '%s': init failed (attempt %d/%d): %v, retrying in 2s...", displayID, attempt, maxRetries, err)
-						time.Sleep(2 * time.Second)
-					} else {
-						log.Printf("Account '%s': init failed after %d attempts: %v", displayID, maxRetries, err)
-					}
-				case <-ctx.Done():
-					cancel()
-					if attempt < maxRetries {
-						log.Printf("Account '%s': init timeout (attempt %d/%d), retrying in 2s...", displayID, attempt, maxRetries)
-						time.Sleep(2 * time.Second)
-					} else {
-						log.Printf("Account '%s': init timeout after %d attempts, skipped", displayID, maxRetries)
-					}
-				}
+			client, err := initAccount(account, 3)
+			results <- accountResult{
+				entry:   balancer.AccountEntry{Client: client, AccountID: account.ID, ProxyURL: account.ProxyURL, Models: account.Models},
+				account: account,
+				err:     err,
 			}
-		}(idx, allCookies[idx], proxyURLs[idx])
+		}(accounts[idx])
 	}
 
 	wg.Wait()
 	close(results)
 
 	changedAccounts := make(map[string]balancer.AccountEntry)
+	failed := make(map[string]browser.AccountConfig)
+	failedIDs := make(map[string]bool)
 	for result := range results {
+		if result.err != nil {
+			failed[result.entry.AccountID] = result.account
+			failedIDs[result.entry.AccountID] = true
+			continue
+		}
 		changedAccounts[result.entry.AccountID] = result.entry
 	}
 
-	pool.ReplaceAccounts(accountIDs, changedAccounts)
+	reload := pool.ReplaceAccounts(accountIDs, changedAccounts, failedIDs)
 
 	cookiesMu.Lock()
 	accountConfigs = newConfigs
+	currentAccountIDs = accountIDs
+	for id := range changedAccounts {
+		delete(failedAccounts, id)
+	}
+	for id := range failedAccounts {
+		if _, ok := newConfigs[id]; !ok {
+			delete(failedAccounts, id)
+		}
+	}
+	for id, pending := range failed {
+		failedAccounts[id] = pending
+	}
+	pendingCount := len(failedAccounts)
 	cookiesMu.Unlock()
 
+	log.Printf("Account warmup: %d valid / %d failed", len(changedAccounts), len(failed))
+	log.Printf("Account reload: %d added, %d updated, %d removed, %d failed, %d unchanged (%d healthy, %d needs_reauth)",
+		len(reload.Added), len(reload.Updated), len(reload.Removed), len(reload.Failed), len(reload.Unchanged), len(reload.Healthy), len(reload.Unhealthy))
 	log.Printf("Total %d account(s) available for load balancing", pool.Size())
+
+	if pendingCount > 0 {
+		startAccountRetryLoop()
+	}
+	return reload, nil
+}
+
+var (
+	currentAccountIDs []string
+	// failedAccounts 初始化失败、等待后台重试的账号
+	failedAccounts = make(map[string]browser.AccountConfig)
+	retryLoopOnce  sync.Once
+	reloadMu       sync.Mutex
+)
+
+// initAccount 创建并初始化单个账号的客户端，每次尝试限时 10 秒
+func initAccount(account browser.AccountConfig, maxRetries int) (*gemini.Client, error) {
+	displayID := account.ID
+	if displayID == "" {
+		displayID = "default"
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+		done := make(chan error, 1)
+		var client *gemini.Client
+
+		go func() {
+			var err error
+			client, err = gemini.NewClient(account.Cookies, account.ProxyURL)
+			if err != nil {
+				done <- err
+				return
+			}
+			client.AccountID = account.ID
+			client.ExtraHeaders = account.Headers
+			done <- client.Init()
+		}()
+
+		select {
+		case err := <-done:
+			cancel()
+			if err == nil {
+				log.Printf("Account '%s': ready", displayID)
+				return client, nil
+			}
+			lastErr = err
+			if attempt < maxRetries {
+				log.Printf("Account '%s': init failed (attempt %d/%d): %v, retrying in 2s...", displayID, attempt, maxRetries, err)
+				time.Sleep(2 * time.Second)
+			} else {
+				log.Printf("Account '%s': init failed after %d attempts: %v", displayID, maxRetries, err)
+			}
+		case <-ctx.Done():
+			cancel()
+			lastErr = fmt.Errorf("init timeout")
+			if attempt < maxRetries {
+				log.Printf("Account '%s': init timeout (attempt %d/%d), retrying in 2s...", displayID, attempt, maxRetries)
+				time.Sleep(2 * time.Second)
+			} else {
+				log.Printf("Account '%s': init timeout after %d attempts, skipped", displayID, maxRetries)
+			}
+		}
+	}
+	return nil, lastErr
+}
+
+// runStartupSelfTest 在 STARTUP_SELFTEST 开启时发送一次极短的真实请求验证生成链路，
+// 取值 1/true 只记录结果，strict 时失败直接退出（非零状态码）
+func runStartupSelfTest() {
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("STARTUP_SELFTEST")))
+	switch mode {
+	case "", "0", "false", "off":
+		return
+	}
+
+	log.Println("[SelfTest] Sending a test prompt...")
+	reply, err := adapter.SelfTest(pool, os.Getenv("SELFTEST_MODEL"))
+	if err != nil {
+		if mode == "strict" {
+			log.Fatalf("[SelfTest] Failed: %v", err)
+		}
+		log.Printf("[SelfTest] Failed: %v", err)
+		return
+	}
+	log.Printf("[SelfTest] Passed, reply: %.50s", reply)
+}
+
+// startAccountRetryLoop 启动后台协程，定期重新初始化失败的账号并加入负载均衡池。
+// 间隔由 ACCOUNT_RETRY_INTERVAL 控制，默认 5m。
+func startAccountRetryLoop() {
+	retryLoopOnce.Do(func() {
+		interval := 5 * time.Minute
+		if v := strings.TrimSpace(os.Getenv("ACCOUNT_RETRY_INTERVAL")); v != "" {
+			if d, err := time.ParseDuration(v); err == nil && d > 0 {
+				interval = d
+			}
+		}
+
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				retryFailedAccounts()
+			}
+		}()
+		log.Printf("Failed accounts will be retried every %v", interval)
+	})
+}
+
+func retryFailedAccounts() {
+	cookiesMu.RLock()
+	pending := make(map[string]browser.AccountConfig, len(failedAccounts))
+	for id, p := range failedAccounts {
+		pending[id] = p
+	}
+	cookiesMu.RUnlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	log.Printf("Retrying %d failed account(s)...", len(pending))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	recovered := make(map[string]balancer.AccountEntry)
+	for _, account := range pending {
+		wg.Add(1)
+		go func(account browser.AccountConfig) {
+			defer wg.Done()
+			client, err := initAccount(account, 1)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			recovered[account.ID] = balancer.AccountEntry{Client: client, AccountID: account.ID, ProxyURL: account.ProxyURL, Models: account.Models}
+			mu.Unlock()
+		}(account)
+	}
+	wg.Wait()
+
+	if len(recovered) == 0 {
+		return
+	}
+
+	// 重试期间 .env 监听或 /admin/reload 可能已经改变账号列表：持有 reloadMu 后重新读取，
+	// 只接受仍在等待重试且配置没有变化的账号，账号列表以当前配置为准
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	cookiesMu.Lock()
+	accepted := make(map[string]balancer.AccountEntry)
+	for id, entry := range recovered {
+		if account, waiting := failedAccounts[id]; waiting && accountConfigHash(account) == accountConfigHash(pending[id]) {
+			accepted[id] = entry
+			delete(failedAccounts, id)
+		}
+	}
+	stillFailed := make(map[string]bool)
+	for id := range failedAccounts {
+		stillFailed[id] = true
+	}
+	ids := append([]string(nil), currentAccountIDs...)
+	cookiesMu.Unlock()
+
+	if len(accepted) == 0 {
+		return
+	}
+	result := pool.ReplaceAccounts(ids, accepted, stillFailed)
+	log.Printf("Account retry: %d recovered, %d healthy, %d still failing, %d needs_reauth, total %d available",
+		len(accepted), len(result.Healthy), len(stillFailed), len(result.Unhealthy), pool.Size())
 }
 
 func watchEnvFile() {
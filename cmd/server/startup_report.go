@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"gemini-web2api/internal/balancer"
+	"gemini-web2api/internal/config"
+	"gemini-web2api/internal/gemini"
+)
+
+// logStartupReport 首次加载账号后输出一次生效配置的摘要，把分散在各模块中的环境变量集中到一处，
+// 便于排查"没有账号""未开启鉴权"之类的配置问题。API Key 只显示是否设置，代理地址中的密码脱敏，不输出任何 Cookie
+func logStartupReport(port string) {
+	cookiesMu.RLock()
+	configured := len(currentAccountIDs)
+	var failed []string
+	for id := range failedAccounts {
+		failed = append(failed, displayAccount(id))
+	}
+	cookiesMu.RUnlock()
+	sort.Strings(failed)
+
+	lines := []string{
+		fmt.Sprintf("Listen: :%s (plain HTTP, terminate TLS at a reverse proxy) | base path: %s", port, orDefault(config.BasePath(), "/")),
+	}
+
+	if keys := config.APIKeys(); len(keys) > 0 {
+		lines = append(lines, fmt.Sprintf("Auth: enabled (PROXY_API_KEY set, %d key(s))", len(keys)))
+	} else {
+		lines = append(lines, "Auth: DISABLED, anyone who can reach this port can use the accounts (set PROXY_API_KEY)")
+	}
+	switch {
+	case config.AdminAPIKey() != "":
+		lines = append(lines, "Admin endpoints: enabled (ADMIN_API_KEY set)")
+	case len(config.APIKeys()) > 0:
+		lines = append(lines, "Admin endpoints: enabled (same keys as PROXY_API_KEY)")
+	default:
+		lines = append(lines, "Admin endpoints: disabled, /admin and /debug return 403 (set ADMIN_API_KEY or PROXY_API_KEY)")
+	}
+	lines = append(lines, "CORS origins: "+orDefault(strings.TrimSpace(os.Getenv("CORS_ORIGINS")), "*"))
+
+	accounts := fmt.Sprintf("Accounts: %d configured, %d available", configured, pool.Size())
+	if len(failed) > 0 {
+		accounts += fmt.Sprintf(", %d failed and retrying (%s)", len(failed), strings.Join(failed, ", "))
+	}
+	if pool.Size() == 0 {
+		accounts += " - NO ACCOUNTS LOADED, every request will fail until cookies are fixed"
+	}
+	lines = append(lines, accounts)
+
+	proxied := 0
+	for _, entry := range pool.Entries() {
+		if entry.ProxyURL != "" {
+			proxied++
+		}
+	}
+	lines = append(lines, fmt.Sprintf("Proxy: %s | %d of %d available account(s) use a proxy",
+		orDefault(redactURL(os.Getenv("PROXY")), "none"), proxied, pool.Size()))
+
+	endpoints := gemini.DefaultEndpoints()
+	if endpoints.Base != gemini.EndpointBase {
+		lines = append(lines, "Upstream: "+redactURL(endpoints.Base)+" (GEMINI_BASE_URL override)")
+	}
+
+	if mappings := config.ModelMappings(); len(mappings) > 0 {
+		pairs := make([]string, 0, len(mappings))
+		for source, target := range mappings {
+			pairs = append(pairs, source+" -> "+target)
+		}
+		sort.Strings(pairs)
+		lines = append(lines, "Model mapping: "+strings.Join(pairs, ", "))
+	} else {
+		lines = append(lines, "Model mapping: none")
+	}
+
+	rotation := balancer.RotationFromEnv()
+	strategy := rotation.Mode
+	if rotation.Mode == balancer.RotationWindow {
+		strategy += fmt.Sprintf(" (%d requests / %s)", rotation.MaxRequests, rotation.Duration)
+	}
+	lines = append(lines, fmt.Sprintf("Rate limiting: strategy %s | failover %s | throttle cooldown %s | image concurrency %d",
+		strategy, config.AccountFailover(), gemini.ThrottleCooldown(), config.ImageConcurrency()))
+
+	if limits := config.ModelConcurrencyLimits(); len(limits) > 0 {
+		pairs := make([]string, 0, len(limits))
+		for model, n := range limits {
+			pairs = append(pairs, fmt.Sprintf("%s:%d", model, n))
+		}
+		sort.Strings(pairs)
+		lines = append(lines, fmt.Sprintf("Model concurrency: %s | queue up to %s", strings.Join(pairs, ", "), config.ModelConcurrencyWait()))
+	}
+
+	if n, limit := config.FairQueueConcurrency(), config.APIKeyRateLimit(); n > 0 || limit > 0 {
+		lines = append(lines, fmt.Sprintf("Fair queue: %d concurrent request(s) (0 = unlimited), queue up to %s | per-key rate limit %d/min (0 = unlimited)",
+			n, config.FairQueueWait(), limit))
+	}
+
+	storageBackend := orDefault(strings.ToLower(strings.TrimSpace(os.Getenv("STORAGE_BACKEND"))), "memory")
+	lines = append(lines, fmt.Sprintf("Storage: %s | conversation TTL %s", storageBackend, sessions.TTL()))
+	lines = append(lines, fmt.Sprintf("Thinking: visibility %s | format %s | answer only %t",
+		config.ResolveThinkingVisibility(""), config.ResolveThinkingFormat(""), config.AnswerOnly(false)))
+
+	log.Println("[Config] Effective configuration:")
+	for _, line := range lines {
+		log.Printf("[Config]   %s", line)
+	}
+}
+
+// redactURL 去掉地址中的密码（user:pass@ 中的 pass），无法解析时只保留协议与主机之前的部分
+func redactURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "(set, unparsable)"
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+func displayAccount(id string) string {
+	if id == "" {
+		return "default"
+	}
+	return id
+}
+
+func orDefault(v, fallback string) string {
+	if v == "" {
+		return fallback
+	}
+	return v
+}
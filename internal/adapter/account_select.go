@@ -0,0 +1,174 @@
+package adapter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gemini-web2api/internal/balancer"
+	"gemini-web2api/internal/config"
+	"gemini-web2api/internal/gemini"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccountOverrideHeader 指定由哪个账号处理本次请求，default 表示默认账号
+const AccountOverrideHeader = "X-Account-Id"
+
+// requestModelKey gin 上下文中记录本次请求使用的模型，换号重试时同样只选择可以使用该模型的账号
+const requestModelKey = "request_model"
+
+// selectAccountForModel 优先使用请求头 X-Account-Id 指定的账号（已通过 AuthMiddleware 鉴权），
+// 账号不存在、被停用、需要重新认证、处于限流冷却期或不能使用 model（映射后的模型名）时回退到轮询，
+// 轮询同样只选择可以使用该模型的账号
+func selectAccountForModel(c *gin.Context, pool *balancer.AccountPool, model string) (*gemini.Client, string) {
+	return selectPinnedAccount(c, pool, model, "")
+}
+
+// selectPinnedAccount 与 selectAccountForModel 相同，pin 非空时不轮询，而是按 pin 的哈希固定选择账号（见 AccountPool.Pinned），
+// X-Account-Id 仍然优先
+func selectPinnedAccount(c *gin.Context, pool *balancer.AccountPool, model, pin string) (*gemini.Client, string) {
+	c.Set(requestModelKey, model)
+	requested := strings.TrimSpace(c.GetHeader(AccountOverrideHeader))
+	if requested != "" {
+		accountID := requested
+		if accountID == "default" {
+			accountID = ""
+		}
+		client := pool.Get(accountID)
+		if client != nil && client.Available() && !pool.Disabled(accountID) && pool.ServesModel(accountID, model) {
+			exposeAccount(c, accountID)
+			return client, accountID
+		}
+		log.Printf("[Account] Requested account '%s' is unavailable, falling back to load balancing", requested)
+	}
+
+	var client *gemini.Client
+	var accountID string
+	if pin != "" {
+		client, accountID = pool.Pinned(pin, model)
+	} else {
+		client, accountID = pool.NextFor(clientKey(c), model)
+	}
+	if client != nil {
+		exposeAccount(c, accountID)
+	}
+	return client, accountID
+}
+
+// generateWithFailover 调用 generate 发起请求，失败时按 ACCOUNT_FAILOVER 换一个未尝试过的可用账号重试，
+// 最多尝试账号总数次。generate 只有在收到第一个响应帧后才返回成功，换号发生在向客户端写出任何内容之前，
+// 全部账号都失败时才返回最后一个错误。rotate 为 false（续接会话、已向当前账号上传文件）时不换号
+func generateWithFailover(c *gin.Context, pool *balancer.AccountPool, client *gemini.Client, accountID string, rotate bool, generate func(*gemini.Client) (io.ReadCloser, error)) (io.ReadCloser, *gemini.Client, string, error) {
+	mode := config.AccountFailover()
+	tried := map[string]bool{}
+	for attempt := 1; ; attempt++ {
+		body, err := generate(client)
+		if err == nil || !rotate || !shouldFailover(mode, err) || attempt >= pool.Size() {
+			return body, client, accountID, err
+		}
+		tried[accountID] = true
+
+		next, nextID := nextUntriedAccount(pool, tried, c.GetString(requestModelKey))
+		if next == nil {
+			return nil, client, accountID, err
+		}
+		log.Printf("[Account] Account '%s' failed before the first byte (%v), retrying with account '%s'", displayAccountID(accountID), err, displayAccountID(nextID))
+		client, accountID = next, nextID
+		c.Set("account_id", accountID)
+		exposeAccount(c, accountID)
+	}
+}
+
+func shouldFailover(mode string, err error) bool {
+	if errors.Is(err, gemini.ErrRequestTimeout) {
+		// 客户端给出的时限已经用完，换号重试也没有时间了
+		return false
+	}
+	switch mode {
+	case config.FailoverAll:
+		return true
+	case config.FailoverTransient:
+		return isThrottled(err) || errors.Is(err, gemini.ErrUpstreamUnavailable) || errors.Is(err, gemini.ErrNoResponse)
+	case config.FailoverThrottle:
+		return isThrottled(err)
+	}
+	return false
+}
+
+// nextUntriedAccount 轮询下一个本次请求尚未尝试过、可以使用 model 的可用账号，换号时不再考虑 X-Account-Id 与 window 绑定
+func nextUntriedAccount(pool *balancer.AccountPool, tried map[string]bool, model string) (*gemini.Client, string) {
+	for i := 0; i < pool.Size(); i++ {
+		client, accountID := pool.NextForModel(model)
+		if client == nil {
+			return nil, ""
+		}
+		if !tried[accountID] {
+			return client, accountID
+		}
+	}
+	return nil, ""
+}
+
+// unsupportedModel 所有账号都不能使用 model（ALLOWED_MODELS 限制）时返回错误信息，否则返回空串；
+// 账号池为空时返回空串，交给后续的“无可用账号”处理
+func unsupportedModel(pool *balancer.AccountPool, model string) string {
+	if pool.Size() == 0 || pool.HasModel(model) {
+		return ""
+	}
+	return fmt.Sprintf("Model '%s' is not available on any configured account", model)
+}
+
+func isThrottled(err error) bool {
+	return errors.Is(err, gemini.ErrThrottled)
+}
+
+// generateErrorStatus 限流错误按 429 返回并带上 Retry-After（冷却剩余秒数），其余错误按 500 返回
+func generateErrorStatus(c *gin.Context, client *gemini.Client, err error) int {
+	if errors.Is(err, gemini.ErrRequestTimeout) {
+		return http.StatusGatewayTimeout
+	}
+	if !isThrottled(err) {
+		return http.StatusInternalServerError
+	}
+	if remaining := client.CooldownRemaining(); remaining > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+	}
+	return http.StatusTooManyRequests
+}
+
+// exposeAccount 在开启 EXPOSE_ACCOUNT_ID 时通过响应头 X-Account-Id 返回实际处理请求的账号
+func exposeAccount(c *gin.Context, accountID string) {
+	if config.AccountExposure() != config.AccountExposeOff {
+		c.Header(AccountOverrideHeader, displayAccountID(accountID))
+	}
+}
+
+// systemFingerprint 在 EXPOSE_ACCOUNT_ID=fingerprint 时返回写入 system_fingerprint 的账号标识，否则返回空串
+func systemFingerprint(accountID string) string {
+	if config.AccountExposure() != config.AccountExposeFingerprint {
+		return ""
+	}
+	return "account_" + displayAccountID(accountID)
+}
+
+// seedPinKey 请求带 seed 时返回固定路由的 key，相同 seed 的请求（包括重试）总是落在同一账号
+func seedPinKey(seed *int64) string {
+	if seed == nil {
+		return ""
+	}
+	return "seed:" + strconv.FormatInt(*seed, 10)
+}
+
+// clientKey 标识发起请求的客户端（API Key + IP），用于 window 轮换策略把同一客户端的连续请求绑定到同一账号
+func clientKey(c *gin.Context) string {
+	key := firstNonEmpty(c.GetHeader("Authorization"), c.GetHeader("x-goog-api-key"), c.GetHeader("x-api-key"), c.Query("key"))
+	sum := sha256.Sum256([]byte(key))
+	return c.ClientIP() + "|" + hex.EncodeToString(sum[:8])
+}
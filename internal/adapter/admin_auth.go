@@ -0,0 +1,53 @@
+package adapter
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"gemini-web2api/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isAdminPath 管理与调试接口：可以重置 / 停用账号、重新加载配置、导出全部会话，必须鉴权
+func isAdminPath(path string) bool {
+	base := config.BasePath()
+	return strings.HasPrefix(path, base+"/admin/") || strings.HasPrefix(path, base+"/debug/")
+}
+
+// AdminAuthMiddleware 用于 /admin 与 /debug 分组：设置了 ADMIN_API_KEY 时只接受该密钥；
+// 否则已由 AuthMiddleware 按 PROXY_API_KEY 校验；两者都未设置（服务对外完全开放）时一律返回 403，
+// 避免任何能访问端口的人操作账号或导出会话
+func AdminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		adminKey := config.AdminAPIKey()
+		if adminKey == "" {
+			if len(config.APIKeys()) == 0 {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin endpoints are disabled: set ADMIN_API_KEY or PROXY_API_KEY"})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		key := strings.TrimSpace(c.GetHeader("x-goog-api-key"))
+		if token, ok := strings.CutPrefix(strings.TrimSpace(c.GetHeader("Authorization")), "Bearer "); ok {
+			key = strings.TrimSpace(token)
+		}
+		if key == "" {
+			key = strings.TrimSpace(c.Query("key"))
+		}
+		if key == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Admin API Key is missing"})
+			return
+		}
+		// 按常量时间比较，避免通过响应耗时逐字节猜出密钥
+		if subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin API Key"})
+			return
+		}
+		c.Set(apiKeyContextKey, "admin")
+		c.Next()
+	}
+}
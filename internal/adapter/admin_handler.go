@@ -0,0 +1,266 @@
+package adapter
+
+import (
+	"errors"
+	"fmt"
+	"gemini-web2api/internal/balancer"
+	"gemini-web2api/internal/config"
+	"gemini-web2api/internal/gemini"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAccountsHandler 列出所有账号及其认证状态
+func AdminAccountsHandler(pool *balancer.AccountPool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entries := pool.Entries()
+		accounts := make([]gin.H, 0, len(entries))
+		for _, entry := range entries {
+			account := gin.H{
+				"account_id":    displayAccountID(entry.AccountID),
+				"auth_failures": entry.Client.AuthFailures(),
+				"proxy":         entry.ProxyURL != "",
+				"status":        accountStatus(pool, entry),
+			}
+			if len(entry.Models) > 0 {
+				account["models"] = entry.Models
+			}
+			if initErr := entry.Client.InitError(); initErr != "" {
+				account["init_error"] = initErr
+			}
+			if account["status"] == "cooling_down" {
+				account["cooldown_seconds"] = int(math.Ceil(entry.Client.CooldownRemaining().Seconds()))
+			}
+			accounts = append(accounts, account)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"object": "list",
+			"data":   accounts,
+		})
+	}
+}
+
+// accountStatus 账号当前状态：active / disabled / needs_reauth / cooling_down / init_failed（最近一次 Init 失败）
+func accountStatus(pool *balancer.AccountPool, entry balancer.AccountEntry) string {
+	switch {
+	case pool.Disabled(entry.AccountID):
+		return "disabled"
+	case entry.Client.NeedsReauth():
+		return "needs_reauth"
+	case entry.Client.CooldownRemaining() > 0:
+		return "cooling_down"
+	case entry.Client.InitError() != "":
+		return "init_failed"
+	}
+	return "active"
+}
+
+// AdminResetAccountHandler 清除账号的认证失败状态与限流冷却并重新初始化，成功后重新参与负载均衡
+func AdminResetAccountHandler(pool *balancer.AccountPool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		accountID := strings.TrimSpace(c.Param("id"))
+		if accountID == "default" {
+			accountID = ""
+		}
+
+		client := pool.Get(accountID)
+		if client == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+
+		client.ResetAuthState()
+		entry := balancer.AccountEntry{AccountID: accountID, Client: client}
+		if err := client.Init(); err != nil {
+			if errors.Is(err, gemini.ErrAuthRejected) {
+				// 刚更新过 Cookie 仍被拒绝，不再让它参与负载均衡
+				client.MarkNeedsReauth()
+			}
+			status := accountStatus(pool, entry)
+			log.Printf("[Admin] Account '%s' reset, but init failed (%s): %v", displayAccountID(accountID), status, err)
+			c.JSON(http.StatusBadGateway, gin.H{
+				"account_id": displayAccountID(accountID),
+				"status":     status,
+				"error":      err.Error(),
+			})
+			return
+		}
+
+		status := accountStatus(pool, entry)
+		log.Printf("[Admin] Account '%s' reset and re-initialized (%s)", displayAccountID(accountID), status)
+		c.JSON(http.StatusOK, gin.H{
+			"account_id": displayAccountID(accountID),
+			"status":     status,
+		})
+	}
+}
+
+// AdminSetAccountDisabledHandler 手动停用（disabled=true）或重新启用账号：停用的账号保留 Cookie，
+// 只是不再参与负载均衡，也不能通过 X-Account-Id 指定，适合临时在浏览器中手动使用该账号
+func AdminSetAccountDisabledHandler(pool *balancer.AccountPool, disabled bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		accountID := strings.TrimSpace(c.Param("id"))
+		if accountID == "default" {
+			accountID = ""
+		}
+
+		if !pool.SetDisabled(accountID, disabled) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+			return
+		}
+
+		status := "active"
+		if disabled {
+			status = "disabled"
+		}
+		log.Printf("[Admin] Account '%s' %s", displayAccountID(accountID), status)
+		c.JSON(http.StatusOK, gin.H{
+			"account_id": displayAccountID(accountID),
+			"status":     status,
+		})
+	}
+}
+
+// AdminReloadHandler 重新加载账号配置并返回变化情况（新增 / 更新 / 移除 / 初始化失败等）
+func AdminReloadHandler(reload func() (balancer.ReloadResult, error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := reload()
+		if err != nil {
+			log.Printf("[Admin] Account reload failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": gin.H{
+				"message": "Failed to reload accounts: " + err.Error(),
+				"type":    "server_error",
+			}})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"added":     displayAccountIDs(result.Added),
+			"updated":   displayAccountIDs(result.Updated),
+			"removed":   displayAccountIDs(result.Removed),
+			"unchanged": displayAccountIDs(result.Unchanged),
+			"failed":    displayAccountIDs(result.Failed),
+			"unhealthy": displayAccountIDs(result.Unhealthy),
+			"healthy":   displayAccountIDs(result.Healthy),
+		})
+	}
+}
+
+func displayAccountIDs(accountIDs []string) []string {
+	display := make([]string, 0, len(accountIDs))
+	for _, id := range accountIDs {
+		display = append(display, displayAccountID(id))
+	}
+	return display
+}
+
+func displayAccountID(accountID string) string {
+	if accountID == "" {
+		return "default"
+	}
+	return accountID
+}
+
+// testAllConcurrency /admin/test-all 同时请求的账号数上限
+const testAllConcurrency = 4
+
+type TestAllRequest struct {
+	Prompt string `json:"prompt"`
+	Model  string `json:"model"`
+}
+
+// AdminTestAllHandler 把同一个提示词原样发给每个账号，并列返回各账号的回答或错误、耗时与结束原因，
+// 用于排查某个账号被暗中限流（回答明显更短、更差）或地区受限等账号间输出不一致的问题。
+// 停用的账号与不能使用该模型的账号跳过；最多同时请求 testAllConcurrency 个账号
+func AdminTestAllHandler(pool *balancer.AccountPool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req TestAllRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if strings.TrimSpace(req.Prompt) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing 'prompt' field"})
+			return
+		}
+		if promptBlocked(c, req.Prompt) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": blockedPromptMessage})
+			return
+		}
+		model := config.MapModel(firstNonEmpty(strings.TrimSpace(req.Model), defaultSelfTestModel))
+		log.Printf("[Admin] Testing prompt on all accounts | Model: %s | Prompt: %.50s...", model, req.Prompt)
+
+		entries := pool.Entries()
+		results := make([]gin.H, len(entries))
+		sem := make(chan struct{}, testAllConcurrency)
+		var wg sync.WaitGroup
+		for i, entry := range entries {
+			result := gin.H{
+				"account_id": displayAccountID(entry.AccountID),
+				"status":     accountStatus(pool, entry),
+			}
+			results[i] = result
+			if result["status"] == "disabled" {
+				result["skipped"] = "account is disabled"
+				continue
+			}
+			if !entry.Serves(model) {
+				result["skipped"] = fmt.Sprintf("account is not allowed to use model '%s'", model)
+				continue
+			}
+
+			wg.Add(1)
+			go func(client *gemini.Client, result gin.H) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				testAccount(client, req.Prompt, model, result)
+			}(entry.Client, result)
+		}
+		wg.Wait()
+
+		c.JSON(http.StatusOK, gin.H{
+			"object": "list",
+			"model":  model,
+			"data":   results,
+		})
+	}
+}
+
+// testAccount 用单个账号发送提示词并把回答、思考长度、结束原因、耗时或错误写入 result
+func testAccount(client *gemini.Client, prompt, model string, result gin.H) {
+	start := time.Now()
+	defer func() { result["latency_ms"] = time.Since(start).Milliseconds() }()
+
+	respBody, err := client.StreamGenerateContent(prompt, model, nil, nil)
+	if err != nil {
+		result["error"] = err.Error()
+		return
+	}
+	defer respBody.Close()
+
+	var text, thinking strings.Builder
+	var extras responseExtras
+	parseErr := parseGeminiResponseWithExtras(respBody, nil, &extras, func(t, thought string) {
+		text.WriteString(t)
+		thinking.WriteString(thought)
+	})
+	if parseErr != nil {
+		result["error"] = parseErr.Error()
+	} else if text.Len() == 0 {
+		result["error"] = "response contained no text"
+	}
+	result["reply"] = text.String()
+	result["reply_chars"] = utf8.RuneCountInString(text.String())
+	result["thinking_chars"] = utf8.RuneCountInString(thinking.String())
+	if extras.finishReason != "" {
+		result["finish_reason"] = extras.finishReason
+	}
+}
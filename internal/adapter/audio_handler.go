@@ -0,0 +1,190 @@
+package adapter
+
+import (
+	"errors"
+	"fmt"
+	"gemini-web2api/internal/balancer"
+	"gemini-web2api/internal/config"
+	"gemini-web2api/internal/gemini"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxAudioUploadSize 与 OpenAI Whisper 接口保持一致的 25MB 限制
+const maxAudioUploadSize = 25 * 1024 * 1024
+
+const defaultTranscriptionModel = "gemini-2.5-flash"
+
+var audioMimeTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".mpga": "audio/mpeg",
+	".mpeg": "audio/mpeg",
+	".wav":  "audio/wav",
+	".m4a":  "audio/mp4",
+	".mp4":  "audio/mp4",
+	".aac":  "audio/aac",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".flac": "audio/flac",
+	".webm": "audio/webm",
+}
+
+func AudioTranscriptionHandler(pool *balancer.AccountPool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 先限制请求体大小再解析表单，超出限制时返回 413 而不是表单解析失败的 400
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAudioUploadSize+1024*1024)
+		if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": gin.H{
+					"message": fmt.Sprintf("Request body is too large, maximum audio file size is %d bytes", maxAudioUploadSize),
+					"type":    "invalid_request_error",
+				}})
+				return
+			}
+		}
+
+		// 上传音频之前检查 prompt 上下文，命中拦截规则的请求不会触达账号
+		if promptBlocked(c, c.PostForm("prompt")) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{
+				"message": blockedPromptMessage,
+				"type":    "invalid_request_error",
+				"code":    "content_blocked",
+			}})
+			return
+		}
+
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{
+				"message": fmt.Sprintf("Missing or invalid 'file' field: %v", err),
+				"type":    "invalid_request_error",
+			}})
+			return
+		}
+
+		if fileHeader.Size > maxAudioUploadSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": gin.H{
+				"message": fmt.Sprintf("Audio file is too large (%d bytes), maximum is %d bytes", fileHeader.Size, maxAudioUploadSize),
+				"type":    "invalid_request_error",
+			}})
+			return
+		}
+
+		ext := strings.ToLower(filepath.Ext(fileHeader.Filename))
+		mimeType, ok := audioMimeTypes[ext]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{
+				"message": fmt.Sprintf("Unsupported audio format '%s'", ext),
+				"type":    "invalid_request_error",
+			}})
+			return
+		}
+
+		f, err := fileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		model := transcriptionModel(c.PostForm("model"))
+		if msg := unsupportedModel(pool, model); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{
+				"message": msg,
+				"type":    "invalid_request_error",
+			}})
+			return
+		}
+		client, accountID := selectAccountForModel(c, pool, model)
+		if client == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No available accounts"})
+			return
+		}
+
+		c.Set("account_id", accountID)
+
+		language := strings.TrimSpace(c.PostForm("language"))
+		responseFormat := strings.TrimSpace(c.PostForm("response_format"))
+		if responseFormat == "" {
+			responseFormat = "json"
+		}
+
+		log.Printf("[Audio] Request | Model: %s | File: %s | Size: %d | Language: %s",
+			model, fileHeader.Filename, len(data), language)
+
+		fname := fmt.Sprintf("audio_%d%s", time.Now().UnixNano(), ext)
+		fid, err := client.UploadFileWithMime(data, fname, mimeType)
+		if err != nil {
+			log.Printf("[Audio] Failed to upload audio: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": gin.H{
+				"message": "Failed to upload audio: " + err.Error(),
+				"type":    "server_error",
+			}})
+			return
+		}
+
+		prompt := "Transcribe this audio verbatim. Output only the transcription text, without any commentary, timestamps or formatting."
+		if language != "" {
+			prompt += fmt.Sprintf(" The spoken language is %s.", language)
+		}
+		if hint := strings.TrimSpace(c.PostForm("prompt")); hint != "" {
+			prompt += fmt.Sprintf(" Context: %s", hint)
+		}
+
+		gemini.RandomDelay()
+
+		respBody, err := client.StreamGenerateContent(prompt, model, []gemini.FileData{{URL: fid, FileName: fname}}, nil)
+		if err != nil {
+			log.Printf("[Audio] Gemini request failed: %v", err)
+			c.JSON(generateErrorStatus(c, client, err), gin.H{"error": gin.H{
+				"message": "Failed to communicate with Gemini: " + err.Error(),
+				"type":    "server_error",
+			}})
+			return
+		}
+		defer respBody.Close()
+
+		var text strings.Builder
+		if err := parseGeminiResponse(respBody, func(t, _ string) {
+			text.WriteString(t)
+		}); err != nil {
+			log.Printf("[Audio] Failed to read Gemini response: %v", err)
+		}
+
+		transcript := strings.TrimSpace(text.String())
+		if transcript == "" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": gin.H{
+				"message": "Empty transcription",
+				"type":    "server_error",
+			}})
+			return
+		}
+
+		if responseFormat == "text" {
+			c.String(http.StatusOK, transcript)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"text": transcript})
+	}
+}
+
+// transcriptionModel 把 whisper-1 等外部模型名落到可用的 Gemini 模型上
+func transcriptionModel(requested string) string {
+	model := config.MapModel(strings.TrimSpace(requested))
+	if _, ok := gemini.ModelHeaders[model]; ok && !isImageModel(model) {
+		return model
+	}
+	return defaultTranscriptionModel
+}
@@ -1,13 +1,13 @@
 package adapter
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"gemini-web2api/internal/balancer"
 	"gemini-web2api/internal/claude"
 	"gemini-web2api/internal/config"
 	"gemini-web2api/internal/gemini"
+	"gemini-web2api/internal/ids"
 	"io"
 	"log"
 	"net/http"
@@ -19,19 +19,7 @@ import (
 
 func ClaudeMessagesHandler(pool *balancer.AccountPool) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		client, accountID := pool.Next()
-		if client == nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{
-				"type": "error",
-				"error": gin.H{
-					"type":    "overloaded_error",
-					"message": "No available accounts",
-				},
-			})
-			return
-		}
-
-		c.Set("account_id", accountID)
+		betas := claudeBetas(c)
 
 		var req claude.ClaudeRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -44,6 +32,7 @@ func ClaudeMessagesHandler(pool *balancer.AccountPool) gin.HandlerFunc {
 			})
 			return
 		}
+		req.Stream = req.Stream && streamingAllowed(c)
 
 		if len(req.Messages) == 0 {
 			c.JSON(http.StatusBadRequest, gin.H{
@@ -56,6 +45,8 @@ func ClaudeMessagesHandler(pool *balancer.AccountPool) gin.HandlerFunc {
 			return
 		}
 
+		applyClaudeModelSuffix(&req)
+
 		log.Printf("[Claude] Request | Model: %s | Stream: %v | Messages: %d | Tools: %d",
 			req.Model, req.Stream, len(req.Messages), len(req.Tools))
 
@@ -63,18 +54,100 @@ func ClaudeMessagesHandler(pool *balancer.AccountPool) gin.HandlerFunc {
 		if mappedModel != req.Model {
 			log.Printf("[Claude] Model mapped: %s -> %s", req.Model, mappedModel)
 		}
+		mappedModel = applyClaudeModelDefaults(&req, mappedModel)
+		if msg := unsupportedModel(pool, mappedModel); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"type":    "invalid_request_error",
+					"message": msg,
+				},
+			})
+			return
+		}
+		// 在上传任何附件之前检查文本，命中拦截规则的请求不会触达账号
+		if promptBlocked(c, requestText(req.System, req.Messages)) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"type":    "invalid_request_error",
+					"message": blockedPromptMessage,
+				},
+			})
+			return
+		}
+		if msg := invalidClaudeFile(req.Messages); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"type":    "invalid_request_error",
+					"message": msg,
+				},
+			})
+			return
+		}
+		release, busy := acquireModelSlot(c, mappedModel)
+		if busy != "" {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"type":    "rate_limit_error",
+					"message": busy,
+				},
+			})
+			return
+		}
+		defer release()
+
+		client, accountID := selectAccountForModel(c, pool, mappedModel)
+		if client == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"type":    "overloaded_error",
+					"message": "No available accounts",
+				},
+			})
+			return
+		}
+
+		c.Set("account_id", accountID)
 
 		prompt, files := buildClaudePrompt(&req, client)
+		prompt = prependLanguageInstruction(prompt, req.Language)
+		prompt = prependGlobalSystemPrompt(prompt)
+		prompt = prependCurrentTime(prompt)
+
+		if msg := checkPromptSize(prompt, mappedModel); msg != "" {
+			log.Printf("[Claude] %s", msg)
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"type":    "request_too_large",
+					"message": msg,
+				},
+			})
+			return
+		}
 
 		gemini.RandomDelay()
 
-		respBody, err := client.StreamGenerateContent(prompt, mappedModel, files, nil)
+		timeoutCtx, cancel := requestTimeoutContext(c)
+		defer cancel()
+		respBody, client, accountID, err := generateWithFailover(c, pool, client, accountID, len(files) == 0, func(client *gemini.Client) (io.ReadCloser, error) {
+			return client.StreamGenerateContentWithOptions(prompt, mappedModel, files, nil, gemini.GenerateOptions{Language: localeCode(req.Language), Context: timeoutCtx})
+		})
 		if err != nil {
 			log.Printf("[Claude] Gemini request failed: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{
+			status := generateErrorStatus(c, client, err)
+			errType := "api_error"
+			if status == http.StatusTooManyRequests {
+				errType = "rate_limit_error"
+			}
+			c.JSON(status, gin.H{
 				"type": "error",
 				"error": gin.H{
-					"type":    "api_error",
+					"type":    errType,
 					"message": fmt.Sprintf("Failed to communicate with Gemini: %v", err),
 				},
 			})
@@ -82,6 +155,11 @@ func ClaudeMessagesHandler(pool *balancer.AccountPool) gin.HandlerFunc {
 		}
 		defer respBody.Close()
 
+		thinkingVisibility := config.ResolveThinkingVisibility(req.ThinkingVisibility)
+		if config.AnswerOnly(req.AnswerOnly) {
+			thinkingVisibility = config.ThinkingHide
+		}
+
 		if req.Stream {
 			c.Header("Content-Type", "text/event-stream")
 			c.Header("Cache-Control", "no-cache")
@@ -90,6 +168,12 @@ func ClaudeMessagesHandler(pool *balancer.AccountPool) gin.HandlerFunc {
 
 			c.Stream(func(w io.Writer) bool {
 				processor := claude.NewStreamProcessor(req.Model, w)
+				processor.SetThinkingVisibility(thinkingVisibility)
+				processor.SetStopSequences(req.StopSequences)
+				processor.SetBetas(betas)
+				processor.SetPrompt(prompt)
+				processor.SetWebSearch(claude.HasWebSearchTool(req.Tools))
+				processor.SetToolUse(newClaudeToolParser(&req))
 				processor.ProcessGeminiStream(respBody)
 				return false
 			})
@@ -97,26 +181,56 @@ func ClaudeMessagesHandler(pool *balancer.AccountPool) gin.HandlerFunc {
 			var fullText string
 			var fullThinking string
 
-			parseGeminiResponse(respBody, func(text, thought string) {
-				fullText += text
+			stopMatcher := claude.NewStopSequenceMatcher(req.StopSequences)
+			extras := responseExtras{prompt: prompt}
+			if claude.HasWebSearchTool(req.Tools) {
+				extras.sources = &gemini.SourceList{}
+			}
+			parseErr := parseGeminiResponseWithExtras(respBody, nil, &extras, func(text, thought string) {
+				fullText += stopMatcher.Feed(text)
 				fullThinking += thought
 			})
+			fullText += stopMatcher.Flush()
+			// 与 ChatCompletionHandler 相同：什么都没有解析到时返回 500，否则返回已收到的部分并以 max_tokens 结束
+			if parseErr != nil {
+				if fullText == "" && fullThinking == "" {
+					log.Printf("[Claude] Gemini response parse failed: %v", parseErr)
+					c.JSON(http.StatusInternalServerError, gin.H{
+						"type": "error",
+						"error": gin.H{
+							"type":    "api_error",
+							"message": "Failed to read Gemini response: " + parseErr.Error(),
+						},
+					})
+					return
+				}
+				log.Printf("[Claude] Gemini response parse failed, returning partial content: %v", parseErr)
+			}
+			var toolBlocks []claude.ContentBlock
+			if tools := newClaudeToolParser(&req); tools != nil {
+				fullText = tools.Feed(fullText) + tools.Finish()
+				toolBlocks = tools.Blocks()
+			}
 
 			var contentBlocks []claude.ContentBlock
 
-			if fullThinking != "" {
+			if fullThinking != "" && thinkingVisibility != config.ThinkingHide {
 				contentBlocks = append(contentBlocks, claude.ContentBlock{
-					Type:     "thinking",
-					Thinking: fullThinking,
+					Type:      "thinking",
+					Thinking:  fullThinking,
+					Signature: extras.thoughtSignature,
 				})
 			}
 
+			contentBlocks = append(contentBlocks, claude.WebSearchBlocks(extras.sources)...)
+
 			if fullText != "" {
 				contentBlocks = append(contentBlocks, claude.ContentBlock{
 					Type: "text",
 					Text: fullText,
 				})
 			}
+			contentBlocks = append(contentBlocks, toolBlocks...)
 
 			if len(contentBlocks) == 0 {
 				contentBlocks = append(contentBlocks, claude.ContentBlock{
@@ -126,18 +240,28 @@ func ClaudeMessagesHandler(pool *balancer.AccountPool) gin.HandlerFunc {
 			}
 
 			response := claude.ClaudeResponse{
-				ID:         fmt.Sprintf("msg_%d", time.Now().UnixNano()),
+				ID:         ids.New("msg_"),
 				Type:       "message",
 				Role:       "assistant",
 				Model:      req.Model,
 				Content:    contentBlocks,
-				StopReason: "end_turn",
+				StopReason: claude.MapFinishReason(extras.finishReason),
 				Usage: claude.Usage{
 					InputTokens:  0,
 					OutputTokens: 0,
 				},
 			}
 
+			if parseErr != nil {
+				response.StopReason = "max_tokens"
+			} else if len(toolBlocks) > 0 {
+				response.StopReason = "tool_use"
+			} else if seq := stopMatcher.Matched(); seq != "" {
+				response.StopReason = "stop_sequence"
+				response.StopSequence = &seq
+			}
+			betas.ApplyUsage(&response.Usage)
+
 			c.JSON(http.StatusOK, response)
 		}
 	}
@@ -176,6 +300,7 @@ func ClaudeListModelsHandler(c *gin.Context) {
 
 func ClaudeCountTokensHandler(pool *balancer.AccountPool) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		claudeBetas(c)
 		var req claude.ClaudeRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{
@@ -208,10 +333,30 @@ func ClaudeCountTokensHandler(pool *balancer.AccountPool) gin.HandlerFunc {
 	}
 }
 
+// claudeBetas 解析 anthropic-beta 请求头，并在响应头中回显 anthropic-version 与声明的 beta，
+// 避免 Claude Code 等客户端提示版本不匹配
+func claudeBetas(c *gin.Context) claude.Betas {
+	version := strings.TrimSpace(c.GetHeader("anthropic-version"))
+	if version == "" {
+		version = claude.DefaultAnthropicVersion
+	}
+	c.Header("anthropic-version", version)
+
+	betas := claude.ParseBetas(c.Request.Header.Values("anthropic-beta"))
+	if len(betas.Raw) > 0 {
+		c.Header("anthropic-beta", strings.Join(betas.Raw, ","))
+	}
+	return betas
+}
+
+// buildClaudePrompt 把 Claude 消息拼接为提示词：开头是工具说明（见 claudeToolsInstruction）与 system，
+// 之后按顺序写入各轮消息，附件上传后以 [Image N] 标记位置
 func buildClaudePrompt(req *claude.ClaudeRequest, client *gemini.Client) (string, []gemini.FileData) {
 	var builder strings.Builder
 	var files []gemini.FileData
 
+	builder.WriteString(claudeToolsInstruction(req))
+
 	if req.System != nil {
 		if sysPrompt, _ := claude.ParseSystemPrompt(req.System); sysPrompt != "" {
 			builder.WriteString("**System**: ")
@@ -238,40 +383,7 @@ func buildClaudePrompt(req *claude.ClaudeRequest, client *gemini.Client) (string
 		if strContent != "" {
 			builder.WriteString(strContent)
 		} else {
-			for _, block := range blocks {
-				switch block.Type {
-				case "text":
-					builder.WriteString(block.Text)
-				case "thinking":
-					builder.WriteString(fmt.Sprintf("<thinking>%s</thinking>", block.Thinking))
-				case "tool_use":
-					argsJSON, _ := json.Marshal(block.Input)
-					builder.WriteString(fmt.Sprintf("<tool_use id=\"%s\" name=\"%s\">%s</tool_use>",
-						block.ID, block.Name, string(argsJSON)))
-				case "tool_result":
-					var contentStr string
-					if block.Content != nil {
-						json.Unmarshal(block.Content, &contentStr)
-					}
-					builder.WriteString(fmt.Sprintf("<tool_result id=\"%s\">%s</tool_result>",
-						block.ToolUseID, contentStr))
-				case "image":
-					if block.Source != nil && block.Source.Type == "base64" {
-						data, err := base64.StdEncoding.DecodeString(block.Source.Data)
-						if err == nil {
-							fname := fmt.Sprintf("image_%d.png", time.Now().UnixNano())
-							fid, err := client.UploadFile(data, fname)
-							if err == nil {
-								files = append(files, gemini.FileData{
-									URL:      fid,
-									FileName: fname,
-								})
-								builder.WriteString("[Image]")
-							}
-						}
-					}
-				}
-			}
+			writeClaudeBlocks(&builder, blocks, client, &files)
 		}
 
 		builder.WriteString("\n\n")
@@ -284,3 +396,111 @@ func buildClaudePrompt(req *claude.ClaudeRequest, client *gemini.Client) (string
 
 	return finalPrompt, files
 }
+
+// invalidClaudeFile 在上传附件之前校验所有 gemini_file 块（包括 tool_result 中嵌套的块），
+// 与 OpenAI 接口一样拒绝无效的引用，返回错误信息，全部有效时返回空串
+func invalidClaudeFile(messages []claude.Message) string {
+	for i, msg := range messages {
+		blocks, _, err := claude.ParseMessageContent(msg.Content)
+		if err != nil {
+			continue
+		}
+		if err := validateClaudeFileBlocks(blocks); err != nil {
+			return fmt.Sprintf("Invalid gemini_file in messages[%d]: %v", i, err)
+		}
+	}
+	return ""
+}
+
+func validateClaudeFileBlocks(blocks []claude.ContentBlock) error {
+	for _, block := range blocks {
+		switch block.Type {
+		case "gemini_file":
+			if _, err := geminiFileRef(block.URL, block.FileName); err != nil {
+				return err
+			}
+		case "tool_result":
+			if block.Content == nil {
+				continue
+			}
+			if nested, _, err := claude.ParseMessageContent(block.Content); err == nil {
+				if err := validateClaudeFileBlocks(nested); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeClaudeBlocks 按块的原始顺序写入提示词，相邻块之间换行分隔。图片上传后在所在位置写入带序号的
+// [Image N] 标记，序号与 files 中的顺序一致，带标注的截图与前后说明文字的相对位置得以保留；
+// tool_result 的内容为块数组时递归处理，其中的图片同样按顺序上传；gemini_file 块引用已上传的文件，不再上传
+func writeClaudeBlocks(builder *strings.Builder, blocks []claude.ContentBlock, client *gemini.Client, files *[]gemini.FileData) {
+	for i, block := range blocks {
+		if i > 0 && builder.Len() > 0 && !strings.HasSuffix(builder.String(), "\n") {
+			builder.WriteString("\n")
+		}
+		switch block.Type {
+		case "text":
+			builder.WriteString(block.Text)
+		case "thinking":
+			builder.WriteString(fmt.Sprintf("<thinking>%s</thinking>", block.Thinking))
+		case "tool_use":
+			argsJSON, _ := json.Marshal(block.Input)
+			builder.WriteString(fmt.Sprintf("<tool_use id=\"%s\" name=\"%s\">%s</tool_use>",
+				block.ID, block.Name, string(argsJSON)))
+		case "tool_result":
+			builder.WriteString(fmt.Sprintf("<tool_result id=\"%s\">", block.ToolUseID))
+			if block.Content != nil {
+				if nested, str, err := claude.ParseMessageContent(block.Content); err == nil {
+					if str != "" {
+						builder.WriteString(str)
+					} else {
+						writeClaudeBlocks(builder, nested, client, files)
+					}
+				}
+			}
+			builder.WriteString("</tool_result>")
+		case "image":
+			writeClaudeImage(builder, block.Source, client, files)
+		case "gemini_file":
+			// 已由 invalidClaudeFile 校验
+			file, _ := geminiFileRef(block.URL, block.FileName)
+			*files = append(*files, file)
+			builder.WriteString(fmt.Sprintf("[Image %d]", len(*files)))
+		}
+	}
+}
+
+// writeClaudeImage 上传 base64 图片并写入 [Image N]；url 类型写入地址，上传失败时写入占位，保持后续图片的序号与位置
+func writeClaudeImage(builder *strings.Builder, source *claude.ImageSource, client *gemini.Client, files *[]gemini.FileData) {
+	if source == nil {
+		return
+	}
+	switch source.Type {
+	case "base64":
+		data, err := openBase64(source.Data, maxImageUploadSize)
+		if err != nil {
+			log.Printf("[Claude] Skipping invalid image: %v", err)
+			builder.WriteString("[Image unavailable]")
+			return
+		}
+		fname := imageFileName(strings.ToLower(source.MediaType), time.Now().UnixNano())
+		fid, err := client.UploadReader(data, fname, "application/octet-stream")
+		if err != nil {
+			log.Printf("[Claude] Failed to upload image: %v", err)
+			builder.WriteString("[Image unavailable]")
+			return
+		}
+		*files = append(*files, gemini.FileData{
+			URL:      fid,
+			FileName: fname,
+		})
+		builder.WriteString(fmt.Sprintf("[Image %d]", len(*files)))
+	case "url":
+		if source.URL != "" {
+			builder.WriteString(fmt.Sprintf("[Image URL: %s]", source.URL))
+		}
+	}
+}
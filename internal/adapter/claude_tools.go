@@ -0,0 +1,93 @@
+package adapter
+
+import (
+	"encoding/json"
+	"log"
+
+	"gemini-web2api/internal/claude"
+	"gemini-web2api/internal/ids"
+)
+
+// claudeToolsInstruction 把 Claude 的 tools / tool_choice 转换为与 OpenAI 路径相同的工具说明，
+// web_search 等服务端工具不在其中；tool_choice 为 none 或没有可调用的工具时返回空串
+func claudeToolsInstruction(req *claude.ClaudeRequest) string {
+	var tools []OpenAITool
+	for _, tool := range req.Tools {
+		if tool.IsWebSearch() || tool.Name == nil || *tool.Name == "" {
+			continue
+		}
+		fn := OpenAIFunction{Name: *tool.Name, Parameters: tool.InputSchema}
+		if tool.Description != nil {
+			fn.Description = *tool.Description
+		}
+		tools = append(tools, OpenAITool{Type: "function", Function: fn})
+	}
+	return buildToolsInstruction(tools, claudeToolChoice(req.ToolChoice), claudeParallelToolUse(req.ToolChoice))
+}
+
+// claudeToolChoice 换算为 OpenAI 的 tool_choice：any 对应 required，tool 对应指定函数，auto 与未设置相同
+func claudeToolChoice(choice *claude.ToolChoice) interface{} {
+	if choice == nil {
+		return nil
+	}
+	switch choice.Type {
+	case "any":
+		return "required"
+	case "tool":
+		if choice.Name != "" {
+			return map[string]interface{}{"function": map[string]interface{}{"name": choice.Name}}
+		}
+	case "none":
+		return "none"
+	}
+	return nil
+}
+
+// claudeParallelToolUse disable_parallel_tool_use 为 true 时每次回复最多调用一个工具
+func claudeParallelToolUse(choice *claude.ToolChoice) bool {
+	return choice == nil || choice.DisableParallelToolUse == nil || !*choice.DisableParallelToolUse
+}
+
+// claudeToolParser 用 OpenAI 路径的 toolCallExtractor 识别 <tool_use> 块，再转换为 Claude 的 tool_use 块
+type claudeToolParser struct {
+	extractor toolCallExtractor
+}
+
+// newClaudeToolParser 提示词中带有工具说明时返回解析器，否则返回 nil（正文原样输出）
+func newClaudeToolParser(req *claude.ClaudeRequest) claude.ToolUseParser {
+	if claudeToolsInstruction(req) == "" {
+		return nil
+	}
+	return &claudeToolParser{extractor: toolCallExtractor{single: !claudeParallelToolUse(req.ToolChoice)}}
+}
+
+func (p *claudeToolParser) Feed(text string) string {
+	return p.extractor.Feed(text)
+}
+
+func (p *claudeToolParser) Finish() string {
+	return p.extractor.Finish()
+}
+
+// Blocks 参数不是 JSON 对象时记录日志并使用空对象，保证 input 始终是对象
+func (p *claudeToolParser) Blocks() []claude.ContentBlock {
+	calls := p.extractor.Calls()
+	if len(calls) == 0 {
+		return nil
+	}
+	blocks := make([]claude.ContentBlock, 0, len(calls))
+	for _, call := range calls {
+		input := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(call.Function.Arguments), &input); err != nil {
+			log.Printf("[Claude] Tool %s returned arguments that are not a JSON object, using {}: %v", call.Function.Name, err)
+			input = map[string]interface{}{}
+		}
+		blocks = append(blocks, claude.ContentBlock{
+			Type:  "tool_use",
+			ID:    ids.New("toolu_"),
+			Name:  call.Function.Name,
+			Input: input,
+		})
+	}
+	return blocks
+}
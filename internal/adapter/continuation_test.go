@@ -0,0 +1,127 @@
+package adapter
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gemini-web2api/internal/session"
+	"gemini-web2api/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// TestAutoContinue 结束原因为 MAX_TOKENS 时自动续写并拼接；续写请求失败时返回已有内容并标记为 length
+func TestAutoContinue(t *testing.T) {
+	t.Setenv("AUTO_CONTINUE_MAX", "1")
+	for _, tc := range []struct {
+		name         string
+		second       func(w http.ResponseWriter, r *http.Request, mock http.Handler)
+		wantContent  string
+		wantFinish   string
+		wantRequests int
+	}{
+		{
+			name: "continued",
+			second: func(w http.ResponseWriter, r *http.Request, mock http.Handler) {
+				r.URL.RawQuery += "&fixture=chat"
+				mock.ServeHTTP(w, r)
+			},
+			wantContent:  "Here is the first part of a very long answer that stops in the middle of aHello, world!",
+			wantFinish:   "stop",
+			wantRequests: 2,
+		},
+		{
+			name: "continuation fails",
+			second: func(w http.ResponseWriter, r *http.Request, mock http.Handler) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			wantContent:  "Here is the first part of a very long answer that stops in the middle of a",
+			wantFinish:   "length",
+			wantRequests: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var prompts []string
+			pool := newWrappedPool(t, func(mock http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if !strings.HasSuffix(r.URL.Path, "/StreamGenerate") {
+						mock.ServeHTTP(w, r)
+						return
+					}
+					r.ParseForm()
+					mu.Lock()
+					prompts = append(prompts, gjson.Parse(gjson.Parse(r.PostForm.Get("f.req")).Get("1").String()).Get("0.0").String())
+					n := len(prompts)
+					mu.Unlock()
+					if n == 1 {
+						r.URL.RawQuery += "&fixture=max_tokens"
+						mock.ServeHTTP(w, r)
+						return
+					}
+					tc.second(w, r, mock)
+				})
+			})
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.POST("/v1/chat/completions", ChatCompletionHandler(pool, session.NewStore(storage.NewMemory(), time.Hour)))
+			api := httptest.NewServer(r)
+			defer api.Close()
+
+			resp, err := http.Post(api.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model":"gemini-2.5-flash","messages":[{"role":"user","content":"Write a long answer"}]}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var out struct {
+				Choices []struct {
+					Message struct {
+						Content string `json:"content"`
+					} `json:"message"`
+					FinishReason string `json:"finish_reason"`
+				} `json:"choices"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != http.StatusOK || len(out.Choices) != 1 {
+				t.Fatalf("status = %d, body = %+v", resp.StatusCode, out)
+			}
+			if got := out.Choices[0].Message.Content; got != tc.wantContent {
+				t.Fatalf("content = %q, want %q", got, tc.wantContent)
+			}
+			if got := out.Choices[0].FinishReason; got != tc.wantFinish {
+				t.Fatalf("finish_reason = %q, want %q", got, tc.wantFinish)
+			}
+			if len(prompts) != tc.wantRequests || !strings.HasPrefix(prompts[1], "Continue") {
+				t.Fatalf("upstream prompts = %q", prompts)
+			}
+		})
+	}
+}
+
+func TestLooksTruncated(t *testing.T) {
+	t.Setenv("AUTO_CONTINUE_MIN_LENGTH", "20")
+	for _, tc := range []struct {
+		answer, reason string
+		want           bool
+	}{
+		{"Short and complete.", "", false},
+		{"Short but stopped by the limit", "MAX_TOKENS", true},
+		{"```go\nfunc main() {", "", true},
+		{"A long answer that ends in the middle of a", "", true},
+		{"A long answer that ends with a full stop.", "", false},
+		{"A long answer ending in a code block\n```go\nx := 1\n```\n", "", false},
+	} {
+		if got := looksTruncated(tc.answer, tc.answer, tc.reason); got != tc.want {
+			t.Errorf("looksTruncated(%q, %q) = %v, want %v", tc.answer, tc.reason, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package adapter
+
+import (
+	"fmt"
+	"gemini-web2api/internal/balancer"
+	"gemini-web2api/internal/session"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DeleteConversationHandler 结束一个会话：删除保存的 ChatMetadata 与账号粘滞绑定，
+// 之后使用同一 conversation_id 的请求会在新账号上开始全新的上下文
+func DeleteConversationHandler(sessions *session.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := strings.TrimSpace(c.Param("id"))
+		if !sessions.Delete(id) {
+			c.JSON(http.StatusNotFound, gin.H{"error": gin.H{
+				"message": "Conversation not found: " + id,
+				"type":    "invalid_request_error",
+			}})
+			return
+		}
+
+		log.Printf("[Session] Conversation %s deleted", id)
+		c.JSON(http.StatusOK, gin.H{
+			"id":      id,
+			"object":  "conversation.deleted",
+			"deleted": true,
+		})
+	}
+}
+
+// conversationExportVersion 导出格式的版本号，格式不兼容地变化时递增，导入时拒绝更新的版本
+const conversationExportVersion = 1
+
+// conversationExport GET /admin/conversations/export 的响应，也是 POST /admin/conversations/import 的请求体
+type conversationExport struct {
+	Version       int             `json:"version"`
+	ExportedAt    time.Time       `json:"exported_at"`
+	Conversations []session.Entry `json:"conversations"`
+}
+
+// AdminExportConversationsHandler 导出保存的会话元数据与账号绑定，用于迁移到其他部署
+func AdminExportConversationsHandler(sessions *session.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entries := sessions.Export()
+		if entries == nil {
+			entries = []session.Entry{}
+		}
+		log.Printf("[Session] Exported %d conversation(s)", len(entries))
+		c.JSON(http.StatusOK, conversationExport{
+			Version:       conversationExportVersion,
+			ExportedAt:    time.Now().UTC(),
+			Conversations: entries,
+		})
+	}
+}
+
+// AdminImportConversationsHandler 导入 AdminExportConversationsHandler 导出的会话，已存在的 ID 会被覆盖。
+// 绑定的账号在本实例中不存在时仍然导入（续接时按账号不可用处理），并在 unknown_accounts 中列出
+func AdminImportConversationsHandler(sessions *session.Store, pool *balancer.AccountPool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req conversationExport
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": err.Error(), "type": "invalid_request_error"}})
+			return
+		}
+		if req.Version < 1 || req.Version > conversationExportVersion {
+			c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{
+				"message": fmt.Sprintf("Unsupported export version %d (supported: %d)", req.Version, conversationExportVersion),
+				"type":    "invalid_request_error",
+				"param":   "version",
+			}})
+			return
+		}
+
+		imported, skipped := 0, 0
+		unknown := []string{}
+		seen := make(map[string]bool)
+		for _, entry := range req.Conversations {
+			if !sessions.Import(entry) {
+				skipped++
+				continue
+			}
+			imported++
+			if id := entry.AccountID; !seen[id] && pool.Get(id) == nil {
+				seen[id] = true
+				unknown = append(unknown, id)
+			}
+		}
+
+		log.Printf("[Session] Imported %d conversation(s), skipped %d", imported, skipped)
+		c.JSON(http.StatusOK, gin.H{
+			"imported":         imported,
+			"skipped":          skipped,
+			"unknown_accounts": unknown,
+		})
+	}
+}
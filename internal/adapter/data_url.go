@@ -0,0 +1,226 @@
+package adapter
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"gemini-web2api/internal/gemini"
+)
+
+// defaultDataURLMime data URL 未声明 MIME 时按 PNG 处理
+const defaultDataURLMime = "image/png"
+
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/jpg":  ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/heic": ".heic",
+	"image/heif": ".heif",
+}
+
+// decodeDataURL 解析 data:[<mime>][;base64],<data>，兼容 base64url、缺少填充、
+// 夹带空白或被 URL 编码的 base64，以及省略 MIME 的写法
+func decodeDataURL(dataURL string) ([]byte, string, error) {
+	payload, mimeType, isBase64, err := splitDataURL(dataURL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !isBase64 {
+		if payload == "" {
+			return nil, "", fmt.Errorf("data URL has an empty payload")
+		}
+		return []byte(payload), mimeType, nil
+	}
+
+	data, err := decodeBase64Loose(payload)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid base64 payload: %v", err)
+	}
+	if len(data) == 0 {
+		return nil, "", fmt.Errorf("data URL has an empty payload")
+	}
+	return data, mimeType, nil
+}
+
+// openDataURL 与 decodeDataURL 相同，但不一次性解码：先校验 base64 并检查解码后的大小不超过 maxSize，
+// 再返回边读边解码的 Reader 直接写入上传请求，避免多张大图同时上传时在内存中各保留多份副本
+func openDataURL(dataURL string, maxSize int) (io.Reader, string, error) {
+	payload, mimeType, isBase64, err := splitDataURL(dataURL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !isBase64 {
+		if payload == "" {
+			return nil, "", fmt.Errorf("data URL has an empty payload")
+		}
+		if len(payload) > maxSize {
+			return nil, "", fmt.Errorf("data is too large (%d bytes), maximum is %d bytes", len(payload), maxSize)
+		}
+		return strings.NewReader(payload), mimeType, nil
+	}
+
+	r, err := openBase64(payload, maxSize)
+	if err != nil {
+		return nil, "", err
+	}
+	return r, mimeType, nil
+}
+
+// splitDataURL 拆分 data URL 的 MIME、是否 base64 与数据部分，数据被 URL 编码时先解码
+func splitDataURL(dataURL string) (payload, mimeType string, isBase64 bool, err error) {
+	rest, ok := strings.CutPrefix(dataURL, "data:")
+	if !ok {
+		return "", "", false, fmt.Errorf("not a data URL")
+	}
+	header, payload, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", "", false, fmt.Errorf("data URL is missing the ',' separator")
+	}
+
+	params := strings.Split(header, ";")
+	mimeType = strings.ToLower(strings.TrimSpace(params[0]))
+	if mimeType == "" {
+		mimeType = defaultDataURLMime
+	}
+	for _, p := range params[1:] {
+		if strings.EqualFold(strings.TrimSpace(p), "base64") {
+			isBase64 = true
+		}
+	}
+
+	if strings.Contains(payload, "%") {
+		if unescaped, err := url.PathUnescape(payload); err == nil {
+			payload = unescaped
+		}
+	}
+	return payload, mimeType, isBase64, nil
+}
+
+// openBase64 不复制数据地扫描一遍 base64：校验字符集（标准或 URL 安全，可带填充与空白）并计算解码后的大小，
+// 超过 maxSize 时直接返回错误；通过校验后返回的 Reader 在读取时才逐块解码，不会再出现解码错误
+func openBase64(s string, maxSize int) (io.Reader, error) {
+	s = strings.TrimRight(s, "= \t\r\n")
+
+	var n int
+	var std, urlSafe bool
+	for i := 0; i < len(s); i++ {
+		switch b := s[i]; {
+		case b >= 'A' && b <= 'Z', b >= 'a' && b <= 'z', b >= '0' && b <= '9':
+		case b == '+' || b == '/':
+			std = true
+		case b == '-' || b == '_':
+			urlSafe = true
+		case isBase64Space(b):
+			continue
+		default:
+			return nil, fmt.Errorf("invalid base64 payload: illegal character %q at offset %d", b, i)
+		}
+		n++
+	}
+	if std && urlSafe {
+		return nil, fmt.Errorf("invalid base64 payload: mixes standard and URL-safe alphabets")
+	}
+	if n%4 == 1 {
+		return nil, fmt.Errorf("invalid base64 payload: truncated data")
+	}
+
+	size := n/4*3 + max(n%4-1, 0)
+	if size == 0 {
+		return nil, fmt.Errorf("data URL has an empty payload")
+	}
+	if size > maxSize {
+		return nil, fmt.Errorf("data is too large (%d bytes), maximum is %d bytes", size, maxSize)
+	}
+
+	enc := base64.RawStdEncoding
+	if urlSafe {
+		enc = base64.RawURLEncoding
+	}
+	return base64.NewDecoder(enc, base64SpaceFilter{strings.NewReader(s)}), nil
+}
+
+func isBase64Space(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// base64SpaceFilter 读取时去掉 base64 中夹带的空白（标准库的 Decoder 只忽略换行）
+type base64SpaceFilter struct {
+	r io.Reader
+}
+
+func (f base64SpaceFilter) Read(p []byte) (int, error) {
+	for {
+		n, err := f.r.Read(p)
+		kept := 0
+		for _, b := range p[:n] {
+			if !isBase64Space(b) {
+				p[kept] = b
+				kept++
+			}
+		}
+		if kept > 0 || err != nil {
+			return kept, err
+		}
+	}
+}
+
+// decodeBase64Loose 去掉空白后依次尝试标准 / URL 安全、带填充 / 不带填充四种编码
+func decodeBase64Loose(s string) ([]byte, error) {
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\n', '\r':
+			return -1
+		}
+		return r
+	}, s)
+
+	encodings := []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding}
+	var firstErr error
+	for _, enc := range encodings {
+		data, err := enc.DecodeString(s)
+		if err == nil {
+			return data, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+// imageFileName 根据 MIME 生成上传时使用的文件名
+func imageFileName(mimeType string, nanos int64) string {
+	ext, ok := imageExtensions[mimeType]
+	if !ok {
+		ext = ".png"
+	}
+	return fmt.Sprintf("image_%d%s", nanos, ext)
+}
+
+// fileRefNameRegex gemini_file 片段中客户端指定的文件名，Gemini 按扩展名判断文件类型
+var fileRefNameRegex = regexp.MustCompile(`^[A-Za-z0-9_. -]{1,128}$`)
+
+// geminiFileRef 校验 gemini_file 片段引用的已上传文件（上传接口返回的地址），通过时直接随请求发送、不再重新上传。
+// 未指定文件名时按 PNG 图片命名
+func geminiFileRef(ref, fileName string) (gemini.FileData, error) {
+	ref = strings.TrimSpace(ref)
+	if !gemini.ValidFileRef(ref) {
+		return gemini.FileData{}, fmt.Errorf("url must be a Gemini upload reference like /contrib_service/ttl_1d/<id>")
+	}
+	fileName = strings.TrimSpace(fileName)
+	if fileName == "" {
+		fileName = imageFileName("", time.Now().UnixNano())
+	} else if !fileRefNameRegex.MatchString(fileName) {
+		return gemini.FileData{}, fmt.Errorf("invalid file_name %q", fileName)
+	}
+	return gemini.FileData{URL: ref, FileName: fileName}, nil
+}
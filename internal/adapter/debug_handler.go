@@ -0,0 +1,80 @@
+package adapter
+
+import (
+	"gemini-web2api/internal/balancer"
+	"gemini-web2api/internal/config"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type DebugRawRequest struct {
+	Prompt string `json:"prompt"`
+	Model  string `json:"model"`
+}
+
+// DebugRawHandler 把提示词直接发给 Gemini，并把未经解析的 StreamGenerate 响应原样返回，
+// 用于分析新模型的图片 / 思考等载荷结构。路由位于 /debug 分组，受 AdminAuthMiddleware 保护
+func DebugRawHandler(pool *balancer.AccountPool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req DebugRawRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if strings.TrimSpace(req.Prompt) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing 'prompt' field"})
+			return
+		}
+		if promptBlocked(c, req.Prompt) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": blockedPromptMessage})
+			return
+		}
+
+		model := config.MapModel(firstNonEmpty(strings.TrimSpace(req.Model), defaultSelfTestModel))
+		if msg := unsupportedModel(pool, model); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		client, accountID := selectAccountForModel(c, pool, model)
+		if client == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No available accounts"})
+			return
+		}
+
+		c.Set("account_id", accountID)
+
+		log.Printf("[Debug] Raw request | Model: %s | Prompt: %.50s...", model, req.Prompt)
+
+		respBody, err := client.StreamGenerateContent(req.Prompt, model, nil, nil)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to communicate with Gemini: " + err.Error()})
+			return
+		}
+		defer respBody.Close()
+
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.Header("X-Gemini-Model", model)
+		c.Status(http.StatusOK)
+
+		buf := make([]byte, 32*1024)
+		for {
+			n, readErr := respBody.Read(buf)
+			if n > 0 {
+				if _, err := c.Writer.Write(buf[:n]); err != nil {
+					return
+				}
+				c.Writer.Flush()
+			}
+			if readErr != nil {
+				if readErr != io.EOF {
+					log.Printf("[Debug] Failed to read raw response: %v", readErr)
+				}
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package adapter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gemini-web2api/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitWindow API_KEY_RATE_LIMIT 的计数窗口
+const rateLimitWindow = time.Minute
+
+// FairScheduler 按 API Key 公平分配上游请求：同时进行的生成请求达到 FAIR_QUEUE_CONCURRENCY 后，
+// 新请求进入各自密钥的队列，空出位置时在有请求排队的密钥之间轮流放行，而不是先到先得，
+// 一个密钥短时间内发出大量请求也只会排在自己的队列里，不会挤占其他团队的访问。
+// 同时按 API_KEY_RATE_LIMIT 限制每个密钥每分钟的请求数
+type FairScheduler struct {
+	capacity  int
+	wait      time.Duration
+	rateLimit int
+
+	mu       sync.Mutex
+	inflight int
+	queues   map[string][]*fairWaiter
+	// order 有请求排队的密钥，按轮转顺序排列，next 为下一个放行的位置
+	order   []string
+	next    int
+	windows map[string]*rateWindow
+}
+
+// fairWaiter 排队中的请求，granted 收到信号时表示已分到位置（inflight 已替它计数）
+type fairWaiter struct {
+	granted chan struct{}
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func NewFairScheduler() *FairScheduler {
+	return &FairScheduler{
+		capacity:  config.FairQueueConcurrency(),
+		wait:      config.FairQueueWait(),
+		rateLimit: config.APIKeyRateLimit(),
+		queues:    make(map[string][]*fairWaiter),
+		windows:   make(map[string]*rateWindow),
+	}
+}
+
+// Middleware 对 /v1 与 /v1beta（BASE_PATH 之下）的生成请求（POST，count_tokens 除外）应用按密钥限速与公平排队，
+// 位置从进入处理函数之前开始占用，直到响应（包括流式输出）结束。两项都未配置时直接放行
+func (s *FairScheduler) Middleware() gin.HandlerFunc {
+	apiPrefix := config.BasePath() + "/v1"
+	return func(c *gin.Context) {
+		if (s.capacity == 0 && s.rateLimit == 0) || c.Request.Method != http.MethodPost ||
+			!strings.HasPrefix(c.Request.URL.Path, apiPrefix) || strings.HasSuffix(c.Request.URL.Path, "/count_tokens") {
+			c.Next()
+			return
+		}
+		key := c.GetString(apiKeyContextKey)
+
+		if retryAfter, ok := s.allow(key, time.Now()); !ok {
+			log.Printf("[FairQueue] Rate limit exceeded for %s (%d requests per minute)", displayAPIKey(key), s.rateLimit)
+			c.Header("Retry-After", strconv.Itoa(max(int(retryAfter.Seconds()), 1)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": gin.H{
+				"message": fmt.Sprintf("Rate limit exceeded for this API key: %d requests per minute", s.rateLimit),
+				"type":    "rate_limit_error",
+				"code":    "api_key_rate_limit",
+			}})
+			return
+		}
+
+		release, ok := s.acquire(c.Request.Context(), key)
+		if !ok {
+			log.Printf("[FairQueue] Request from %s got no slot within %s or the client disconnected, rejected", displayAPIKey(key), s.wait)
+			c.Header("Retry-After", strconv.Itoa(max(int(s.wait.Seconds()), 1)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": gin.H{
+				"message": "Server is busy: too many requests queued, please retry later",
+				"type":    "rate_limit_error",
+				"code":    "queue_timeout",
+			}})
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
+
+// allow 按固定的一分钟窗口计数，超出时返回窗口剩余时间
+func (s *FairScheduler) allow(key string, now time.Time) (time.Duration, bool) {
+	if s.rateLimit == 0 {
+		return 0, true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.windows[key]
+	if !ok || now.Sub(w.start) >= rateLimitWindow {
+		w = &rateWindow{start: now}
+		s.windows[key] = w
+	}
+	if w.count >= s.rateLimit {
+		return rateLimitWindow - now.Sub(w.start), false
+	}
+	w.count++
+	return 0, true
+}
+
+// acquire 占用一个位置，已满时在 key 的队列中最多等待 FAIR_QUEUE_WAIT，客户端断开时放弃
+func (s *FairScheduler) acquire(ctx context.Context, key string) (release func(), ok bool) {
+	if s.capacity == 0 {
+		return func() {}, true
+	}
+
+	s.mu.Lock()
+	if s.inflight < s.capacity && len(s.order) == 0 {
+		s.inflight++
+		s.mu.Unlock()
+		return s.release, true
+	}
+	waiter := &fairWaiter{granted: make(chan struct{}, 1)}
+	if len(s.queues[key]) == 0 {
+		s.order = append(s.order, key)
+	}
+	s.queues[key] = append(s.queues[key], waiter)
+	queued := len(s.queues[key])
+	s.mu.Unlock()
+	log.Printf("[FairQueue] All %d slot(s) in use, queuing request from %s (%d queued for this key)", s.capacity, displayAPIKey(key), queued)
+
+	timer := time.NewTimer(s.wait)
+	defer timer.Stop()
+	select {
+	case <-waiter.granted:
+		return s.release, true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.removeWaiter(key, waiter) {
+		// 超时的同时已被放行：位置已经算在本请求名下，交给下一个排队的请求
+		s.handOffLocked()
+	}
+	return nil, false
+}
+
+// release 释放位置：有请求排队时直接转交给轮转到的下一个密钥，否则减少计数
+func (s *FairScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handOffLocked()
+}
+
+func (s *FairScheduler) handOffLocked() {
+	if len(s.order) == 0 {
+		s.inflight--
+		return
+	}
+	if s.next >= len(s.order) {
+		s.next = 0
+	}
+	key := s.order[s.next]
+	queue := s.queues[key]
+	waiter := queue[0]
+	if len(queue) == 1 {
+		delete(s.queues, key)
+		s.order = append(s.order[:s.next], s.order[s.next+1:]...)
+	} else {
+		s.queues[key] = queue[1:]
+		s.next++
+	}
+	waiter.granted <- struct{}{}
+}
+
+// removeWaiter 把放弃等待的请求移出队列，返回 false 表示它已经被放行
+func (s *FairScheduler) removeWaiter(key string, waiter *fairWaiter) bool {
+	queue := s.queues[key]
+	for i, w := range queue {
+		if w != waiter {
+			continue
+		}
+		if len(queue) == 1 {
+			delete(s.queues, key)
+			for j, k := range s.order {
+				if k == key {
+					s.order = append(s.order[:j], s.order[j+1:]...)
+					if j < s.next {
+						s.next--
+					}
+					break
+				}
+			}
+		} else {
+			s.queues[key] = append(queue[:i:i], queue[i+1:]...)
+		}
+		return true
+	}
+	return false
+}
+
+// displayAPIKey 未开启鉴权时所有请求属于同一个队列
+func displayAPIKey(key string) string {
+	if key == "" {
+		return "anonymous"
+	}
+	return key
+}
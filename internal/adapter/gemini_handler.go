@@ -1,8 +1,8 @@
 package adapter
 
 import (
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gemini-web2api/internal/balancer"
 	"gemini-web2api/internal/config"
@@ -95,14 +95,6 @@ func GeminiRouterHandler(pool *balancer.AccountPool) gin.HandlerFunc {
 }
 
 func geminiGenerateContent(c *gin.Context, pool *balancer.AccountPool, model string) {
-	client, accountID := pool.Next()
-	if client == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No available accounts"})
-		return
-	}
-
-	c.Set("account_id", accountID)
-
 	var req GeminiGenerateContentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
@@ -118,29 +110,70 @@ func geminiGenerateContent(c *gin.Context, pool *balancer.AccountPool, model str
 	if mappedModel != model {
 		log.Printf("[Gemini] 模型映射: %s -> %s", model, mappedModel)
 	}
+	if msg := unsupportedModel(pool, mappedModel); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	// 在上传 inlineData 之前检查文本，命中拦截规则的请求不会触达账号
+	if promptBlocked(c, requestText(req.SystemInstruction, req.Contents)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": blockedPromptMessage})
+		return
+	}
+	release, busy := acquireModelSlot(c, mappedModel)
+	if busy != "" {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": busy})
+		return
+	}
+	defer release()
+
+	client, accountID := selectAccountForModel(c, pool, mappedModel)
+	if client == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No available accounts"})
+		return
+	}
+
+	c.Set("account_id", accountID)
 
 	prompt, files := buildGeminiPrompt(&req, client)
 	if strings.TrimSpace(prompt) == "" {
 		prompt = "Hello"
 	}
+	prompt = prependGlobalSystemPrompt(prompt)
+	prompt = prependCurrentTime(prompt)
 
 	log.Printf("[Gemini] 请求 | 模型: %s | 流式: false | 内容段: %d | 文件: %d", model, len(req.Contents), len(files))
 
+	if msg := checkPromptSize(prompt, mappedModel); msg != "" {
+		log.Printf("[Gemini] %s", msg)
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": msg})
+		return
+	}
+
 	gemini.RandomDelay()
-	respBody, err := client.StreamGenerateContent(prompt, mappedModel, files, nil)
+	timeoutCtx, cancel := requestTimeoutContext(c)
+	defer cancel()
+	opts := gemini.GenerateOptions{Context: timeoutCtx}
+	respBody, client, accountID, err := generateWithFailover(c, pool, client, accountID, len(files) == 0, func(client *gemini.Client) (io.ReadCloser, error) {
+		return client.StreamGenerateContentWithOptions(prompt, mappedModel, files, nil, opts)
+	})
 	if err != nil {
 		log.Printf("[Gemini] 请求失败: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(generateErrorStatus(c, client, err), gin.H{"error": err.Error()})
 		return
 	}
 	defer respBody.Close()
 
 	var fullText strings.Builder
-	parseGeminiResponse(respBody, func(text, thought string) {
+	var extras responseExtras
+	parseErr := parseGeminiResponseWithExtras(respBody, nil, &extras, func(text, thought string) {
 		if text != "" {
 			fullText.WriteString(text)
 		}
 	})
+	finishReason := "STOP"
+	if extras.outputCap.Exceeded() || errors.Is(parseErr, gemini.ErrRequestTimeout) {
+		finishReason = "MAX_TOKENS"
+	}
 
 	resp := GeminiGenerateContentResponse{
 		Candidates: []GeminiCandidate{
@@ -149,7 +182,7 @@ func geminiGenerateContent(c *gin.Context, pool *balancer.AccountPool, model str
 					Role:  "model",
 					Parts: []GeminiPart{{Text: fullText.String()}},
 				},
-				FinishReason: "STOP",
+				FinishReason: finishReason,
 			},
 		},
 		UsageMetadata: &GeminiUsageMetadata{
@@ -164,14 +197,6 @@ func geminiGenerateContent(c *gin.Context, pool *balancer.AccountPool, model str
 }
 
 func geminiStreamGenerateContent(c *gin.Context, pool *balancer.AccountPool, model string) {
-	client, accountID := pool.Next()
-	if client == nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No available accounts"})
-		return
-	}
-
-	c.Set("account_id", accountID)
-
 	var req GeminiGenerateContentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid request body: %v", err)})
@@ -187,19 +212,55 @@ func geminiStreamGenerateContent(c *gin.Context, pool *balancer.AccountPool, mod
 	if mappedModel != model {
 		log.Printf("[Gemini] 模型映射: %s -> %s", model, mappedModel)
 	}
+	if msg := unsupportedModel(pool, mappedModel); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	// 在上传 inlineData 之前检查文本，命中拦截规则的请求不会触达账号
+	if promptBlocked(c, requestText(req.SystemInstruction, req.Contents)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": blockedPromptMessage})
+		return
+	}
+	release, busy := acquireModelSlot(c, mappedModel)
+	if busy != "" {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": busy})
+		return
+	}
+	defer release()
+
+	client, accountID := selectAccountForModel(c, pool, mappedModel)
+	if client == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No available accounts"})
+		return
+	}
+
+	c.Set("account_id", accountID)
 
 	prompt, files := buildGeminiPrompt(&req, client)
 	if strings.TrimSpace(prompt) == "" {
 		prompt = "Hello"
 	}
+	prompt = prependGlobalSystemPrompt(prompt)
+	prompt = prependCurrentTime(prompt)
 
 	log.Printf("[Gemini] 请求 | 模型: %s | 流式: true | 内容段: %d | 文件: %d", model, len(req.Contents), len(files))
 
+	if msg := checkPromptSize(prompt, mappedModel); msg != "" {
+		log.Printf("[Gemini] %s", msg)
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": msg})
+		return
+	}
+
 	gemini.RandomDelay()
-	respBody, err := client.StreamGenerateContent(prompt, mappedModel, files, nil)
+	timeoutCtx, cancel := requestTimeoutContext(c)
+	defer cancel()
+	opts := gemini.GenerateOptions{Context: timeoutCtx}
+	respBody, client, accountID, err := generateWithFailover(c, pool, client, accountID, len(files) == 0, func(client *gemini.Client) (io.ReadCloser, error) {
+		return client.StreamGenerateContentWithOptions(prompt, mappedModel, files, nil, opts)
+	})
 	if err != nil {
 		log.Printf("[Gemini] 请求失败: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(generateErrorStatus(c, client, err), gin.H{"error": err.Error()})
 		return
 	}
 	defer respBody.Close()
@@ -327,7 +388,7 @@ func appendGeminiParts(builder *strings.Builder, client *gemini.Client, files *[
 			}
 		}
 
-		data, err := decodeBase64Flex(dataStr)
+		data, err := openBase64(dataStr, maxImageUploadSize)
 		if err != nil {
 			log.Printf("[Gemini] 解析 inlineData 失败: %v", err)
 			continue
@@ -337,7 +398,7 @@ func appendGeminiParts(builder *strings.Builder, client *gemini.Client, files *[
 		ext := mimeTypeToExt(mimeType)
 		filename := fmt.Sprintf("inline_%d%s", time.Now().UnixNano(), ext)
 
-		fid, err := client.UploadFile(data, filename)
+		fid, err := client.UploadReader(data, filename, "application/octet-stream")
 		if err != nil {
 			log.Printf("[Gemini] 上传图片失败: %v", err)
 			continue
@@ -377,25 +438,3 @@ func mimeTypeToExt(mimeType string) string {
 	}
 	return ".bin"
 }
-
-func decodeBase64Flex(s string) ([]byte, error) {
-	s = strings.TrimSpace(s)
-	if s == "" {
-		return nil, fmt.Errorf("空的 base64 数据")
-	}
-
-	if data, err := base64.StdEncoding.DecodeString(s); err == nil {
-		return data, nil
-	}
-	if data, err := base64.RawStdEncoding.DecodeString(s); err == nil {
-		return data, nil
-	}
-	if data, err := base64.URLEncoding.DecodeString(s); err == nil {
-		return data, nil
-	}
-	if data, err := base64.RawURLEncoding.DecodeString(s); err == nil {
-		return data, nil
-	}
-
-	return nil, fmt.Errorf("base64 解码失败")
-}
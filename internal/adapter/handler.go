@@ -1,19 +1,25 @@
 package adapter
 
 import (
-	"bufio"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gemini-web2api/internal/balancer"
+	"gemini-web2api/internal/config"
 	"gemini-web2api/internal/gemini"
+	"gemini-web2api/internal/ids"
+	"gemini-web2api/internal/session"
 	"io"
 	"log"
 	"net/http"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/tidwall/gjson"
@@ -22,20 +28,75 @@ import (
 type ChatMessage struct {
 	Role    string      `json:"role"`
 	Content interface{} `json:"content"`
+	// ToolCalls 助手消息中此前发起的工具调用，ToolCallID / Name 用于 tool 消息回传结果
+	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+	Name       string           `json:"name,omitempty"`
+	// ReasoningSignature 助手消息回传的思考签名（即回复中的 reasoning_signature），续接会话时发回上游
+	ReasoningSignature string `json:"reasoning_signature,omitempty"`
 }
 
 type ChatRequest struct {
-	Messages []ChatMessage `json:"messages"`
-	Stream   bool          `json:"stream"`
-	Model    string        `json:"model"`
+	Messages       []ChatMessage `json:"messages"`
+	Stream         bool          `json:"stream"`
+	Model          string        `json:"model"`
+	ConversationID string        `json:"conversation_id,omitempty"`
+	Language       string        `json:"language,omitempty"`
+	Locale         string        `json:"locale,omitempty"`
+	// ThinkingVisibility 思考过程输出方式: show / hide / summary，留空使用 THINKING_VISIBILITY
+	ThinkingVisibility string `json:"thinking_visibility,omitempty"`
+	// ThinkingFormat 思考过程呈现格式: reasoning_content / think_tags，留空使用 THINKING_FORMAT
+	ThinkingFormat string `json:"thinking_format,omitempty"`
+	// AnswerOnly 只返回最终回答，丢弃思考过程（优先于 ThinkingVisibility），见 config.AnswerOnly
+	AnswerOnly bool `json:"answer_only,omitempty"`
+	// WebSources 联网搜索引用来源呈现方式: off / field / list / footnotes，留空使用 WEB_SOURCES
+	WebSources string       `json:"web_sources,omitempty"`
+	Tools      []OpenAITool `json:"tools,omitempty"`
+	ToolChoice interface{}  `json:"tool_choice,omitempty"`
+	// ParallelToolCalls 为 false 时每次回复最多返回一个工具调用，未设置时允许多个，见 parallelToolCalls
+	ParallelToolCalls *bool `json:"parallel_tool_calls,omitempty"`
+	// LogitBias 仅尽力转换为提示词指令，见 prependLogitBiasInstruction
+	LogitBias map[string]float64 `json:"logit_bias,omitempty"`
+	// MaxCompletionTokens 新版 OpenAI 客户端使用的字段，与 MaxTokens 同时存在时优先，见 outputLimit
+	MaxTokens           *int `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int `json:"max_completion_tokens,omitempty"`
+	// Temperature / TopP / TopK Gemini Web 不支持采样参数，仅接受（可由模型名后缀或 MODEL_DEFAULTS 补全）
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	TopK        *int     `json:"top_k,omitempty"`
+	// Seed Gemini Web 没有随机种子参数，同一 seed 固定路由到同一账号，见 seedPinKey
+	Seed *int64 `json:"seed,omitempty"`
+	// ReasoningEffort low / medium / high，转换为思考开关，见 applyReasoningEffort
+	ReasoningEffort string `json:"reasoning_effort,omitempty"`
+	// ThinkingBudget 思考 token 预算，Gemini Web 无法限制思考长度，按阈值转换为 reasoning_effort，见 applyThinkingBudget
+	ThinkingBudget *int `json:"thinking_budget,omitempty"`
+	// Store 新版 OpenAI 客户端发送的字段，本服务不保存补全结果，忽略；Metadata 仅记录到日志
+	Store    *bool             `json:"store,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
+// CORSMiddleware 根据 CORS_ORIGINS 设置跨域策略：
+// 留空或 "*" 时允许任意来源（不携带凭据）；
+// 配置逗号分隔的来源列表时仅回显匹配的 Origin，并允许携带凭据。
 func CORSMiddleware() gin.HandlerFunc {
+	allowAll, allowed := parseCORSOrigins(os.Getenv("CORS_ORIGINS"))
+	if !allowAll {
+		log.Printf("[CORS] Allowed origins: %v", allowed)
+	}
+
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
+		if allowAll {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Writer.Header().Add("Vary", "Origin")
+			origin := c.GetHeader("Origin")
+			if origin != "" && allowed[strings.TrimRight(origin, "/")] {
+				c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+				c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Account-Id, X-Request-Timeout, X-Disable-Streaming, anthropic-version, anthropic-beta")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -45,11 +106,37 @@ func CORSMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// parseCORSOrigins 解析 CORS_ORIGINS：留空或包含 "*" 时返回 true（允许任意来源），
+// 否则返回去掉末尾斜杠后的来源集合
+func parseCORSOrigins(value string) (bool, map[string]bool) {
+	allowed := make(map[string]bool)
+	for _, origin := range strings.Split(value, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin == "" {
+			continue
+		}
+		if origin == "*" {
+			return true, nil
+		}
+		allowed[origin] = true
+	}
+	if len(allowed) == 0 {
+		return true, nil
+	}
+	return false, allowed
+}
+
+// apiKeyContextKey 鉴权通过后保存在 gin.Context 中的密钥标识（key1、key2……，按 PROXY_API_KEY 中的顺序），
+// 公平调度与按密钥限速以此区分租户，日志中也只出现该标识而不是密钥本身
+const apiKeyContextKey = "api_key"
+
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		requiredKey := strings.TrimSpace(os.Getenv("PROXY_API_KEY"))
+		keys := config.APIKeys()
 
-		if requiredKey == "" {
+		// 设置了 ADMIN_API_KEY 时管理接口由 AdminAuthMiddleware 单独校验
+		if len(keys) == 0 || (config.AdminAPIKey() != "" && isAdminPath(c.Request.URL.Path)) {
 			c.Next()
 			return
 		}
@@ -58,11 +145,13 @@ func AuthMiddleware() gin.HandlerFunc {
 		headerKey := strings.TrimSpace(c.GetHeader("x-goog-api-key"))
 		authHeader := strings.TrimSpace(c.GetHeader("Authorization"))
 
-		if queryKey != "" && queryKey == requiredKey {
+		if label := matchAPIKey(keys, queryKey); label != "" {
+			c.Set(apiKeyContextKey, label)
 			c.Next()
 			return
 		}
-		if headerKey != "" && headerKey == requiredKey {
+		if label := matchAPIKey(keys, headerKey); label != "" {
+			c.Set(apiKeyContextKey, label)
 			c.Next()
 			return
 		}
@@ -70,8 +159,8 @@ func AuthMiddleware() gin.HandlerFunc {
 		if authHeader != "" {
 			parts := strings.SplitN(authHeader, " ", 2)
 			if len(parts) == 2 && parts[0] == "Bearer" {
-				token := strings.TrimSpace(parts[1])
-				if token == requiredKey {
+				if label := matchAPIKey(keys, strings.TrimSpace(parts[1])); label != "" {
+					c.Set(apiKeyContextKey, label)
 					c.Next()
 					return
 				}
@@ -93,6 +182,19 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// matchAPIKey 返回 candidate 对应的密钥标识，不匹配任何密钥时返回空串
+func matchAPIKey(keys []string, candidate string) string {
+	if candidate == "" {
+		return ""
+	}
+	for i, key := range keys {
+		if candidate == key {
+			return "key" + strconv.Itoa(i+1)
+		}
+	}
+	return ""
+}
+
 func LoggerMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
@@ -115,47 +217,116 @@ func LoggerMiddleware() gin.HandlerFunc {
 	}
 }
 
-func ListModelsHandler(c *gin.Context) {
-	type ModelCard struct {
-		ID      string `json:"id"`
-		Object  string `json:"object"`
-		Created int64  `json:"created"`
-		OwnedBy string `json:"owned_by"`
-	}
+// openAIModel /v1/models 中的模型，ContextWindow 为 Gemini 官方公布的输入上限（token）
+type openAIModel struct {
+	ID            string
+	ContextWindow int
+}
 
-	models := []ModelCard{
-		{ID: "gemini-2.5-flash", Object: "model", Created: time.Now().Unix(), OwnedBy: "Google"},
-		{ID: "gemini-3.1-pro-preview", Object: "model", Created: time.Now().Unix(), OwnedBy: "Google"},
-		{ID: "gemini-3-flash-preview", Object: "model", Created: time.Now().Unix(), OwnedBy: "Google"},
-		{ID: "gemini-3-flash-preview-no-thinking", Object: "model", Created: time.Now().Unix(), OwnedBy: "Google"},
-		{ID: "gemini-2.5-flash-image", Object: "model", Created: time.Now().Unix(), OwnedBy: "Google"},
-		{ID: "gemini-3-pro-image-preview", Object: "model", Created: time.Now().Unix(), OwnedBy: "Google"},
-	}
+var openAIModels = []openAIModel{
+	{ID: "gemini-2.5-flash", ContextWindow: 1048576},
+	{ID: "gemini-3.1-pro-preview", ContextWindow: 1048576},
+	{ID: "gemini-3-flash-preview", ContextWindow: 1048576},
+	{ID: "gemini-3-flash-preview-no-thinking", ContextWindow: 1048576},
+	{ID: "gemini-2.5-flash-image", ContextWindow: 32768},
+	{ID: "gemini-3-pro-image-preview", ContextWindow: 65536},
+}
+
+// ListModelsHandler 返回模型列表：没有 ModelHeaders 的模型会回退到同系列的其他模型，不列出；
+// 开启 MODEL_PROBE 时再去掉探测失败的模型
+func ListModelsHandler(prober *ModelProber) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		type ModelCard struct {
+			ID            string `json:"id"`
+			Object        string `json:"object"`
+			Created       int64  `json:"created"`
+			OwnedBy       string `json:"owned_by"`
+			ContextWindow int    `json:"context_window"`
+		}
 
-	c.JSON(http.StatusOK, gin.H{
-		"object": "list",
-		"data":   models,
-	})
+		models := make([]ModelCard, 0, len(openAIModels))
+		for _, m := range openAIModels {
+			if !hasModelHeaders(m.ID) || !prober.Available(m.ID) {
+				continue
+			}
+			models = append(models, ModelCard{
+				ID:            m.ID,
+				Object:        "model",
+				Created:       time.Now().Unix(),
+				OwnedBy:       "Google",
+				ContextWindow: m.ContextWindow,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"object": "list",
+			"data":   models,
+		})
+	}
 }
 
 func isImageModel(model string) bool {
 	return strings.Contains(strings.ToLower(model), "image")
 }
 
-func ChatCompletionHandler(pool *balancer.AccountPool) gin.HandlerFunc {
+func ChatCompletionHandler(pool *balancer.AccountPool, sessions *session.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		client, accountID := pool.Next()
+		var req ChatRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.Stream = req.Stream && streamingAllowed(c)
+
+		req.applyModelSuffix()
+		req.applyModelDefaults(config.MapModel(req.Model))
+		req.applyReasoningEffort()
+		mappedModel := config.MapModel(req.Model)
+		if msg := unsupportedModel(pool, mappedModel); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{
+				"message": msg,
+				"type":    "invalid_request_error",
+				"param":   "model",
+			}})
+			return
+		}
+		release, busy := acquireModelSlot(c, mappedModel)
+		if busy != "" {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": gin.H{
+				"message": busy,
+				"type":    "rate_limit_error",
+				"code":    "model_concurrency_limit",
+			}})
+			return
+		}
+		defer release()
+
+		var meta *gemini.ChatMetadata
+		var client *gemini.Client
+		var accountID string
+		if conv, ok := sessions.Get(req.ConversationID); ok {
+			if sticky := pool.Get(conv.AccountID); sticky != nil && !pool.Disabled(conv.AccountID) && pool.ServesModel(conv.AccountID, mappedModel) {
+				client, accountID = sticky, conv.AccountID
+				meta = &conv.Metadata
+				if sig := lastReasoningSignature(req.Messages); sig != "" {
+					meta.ThoughtSignature = sig
+				}
+				exposeAccount(c, accountID)
+			} else {
+				log.Printf("[Session] Account '%s' for conversation %s is unavailable, starting a new conversation", conv.AccountID, req.ConversationID)
+			}
+		}
+		if client == nil {
+			client, accountID = selectPinnedAccount(c, pool, mappedModel, seedPinKey(req.Seed))
+		}
 		if client == nil {
 			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No available accounts"})
 			return
 		}
 
 		c.Set("account_id", accountID)
-
-		var req ChatRequest
-		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
+		if len(req.Metadata) > 0 {
+			log.Printf("[OpenAI] Request metadata: %v", req.Metadata)
 		}
 
 		// Check if this is an image model request
@@ -164,94 +335,135 @@ func ChatCompletionHandler(pool *balancer.AccountPool) gin.HandlerFunc {
 			return
 		}
 
-		var promptBuilder strings.Builder
-		var files []gemini.FileData
-
-		for _, msg := range req.Messages {
-			role := "User"
-			if strings.EqualFold(msg.Role, "model") || strings.EqualFold(msg.Role, "assistant") {
-				role = "Model"
-			} else if strings.EqualFold(msg.Role, "system") {
-				role = "System"
-			}
-
-			promptBuilder.WriteString(fmt.Sprintf("**%s**: ", role))
-
-			switch v := msg.Content.(type) {
-			case string:
-				promptBuilder.WriteString(v)
-			case []interface{}:
-				for _, part := range v {
-					p, ok := part.(map[string]interface{})
-					if !ok {
-						continue
-					}
-
-					typeStr, _ := p["type"].(string)
-
-					if typeStr == "text" {
-						if text, ok := p["text"].(string); ok {
-							promptBuilder.WriteString(text)
-						}
-					} else if typeStr == "image_url" {
-						if imgMap, ok := p["image_url"].(map[string]interface{}); ok {
-							if urlStr, ok := imgMap["url"].(string); ok {
-								if strings.HasPrefix(urlStr, "data:") {
-									parts := strings.Split(urlStr, ",")
-									if len(parts) == 2 {
-										data, err := base64.StdEncoding.DecodeString(parts[1])
-										if err == nil {
-											fname := fmt.Sprintf("image_%d.png", time.Now().UnixNano())
-											fid, err := client.UploadFile(data, fname)
-											if err == nil {
-												files = append(files, gemini.FileData{
-													URL:      fid,
-													FileName: fname,
-												})
-												promptBuilder.WriteString("[Image]")
-											} else {
-												log.Printf("Failed to upload image: %v", err)
-											}
-										}
-									}
-								} else {
-									promptBuilder.WriteString(fmt.Sprintf("[Image URL: %s]", urlStr))
-								}
-							}
-						}
-					}
-				}
-			}
-			promptBuilder.WriteString("\n\n")
-		}
-
-		finalPrompt := promptBuilder.String()
-		if finalPrompt == "" {
-			finalPrompt = "Hello"
+		prompt, ok := buildChatPrompt(c, client, &req, meta)
+		if !ok {
+			return
 		}
+		finalPrompt, files, toolsInstruction := prompt.text, prompt.files, prompt.toolsInstruction
 
 		gemini.RandomDelay()
 
-		respBody, err := client.StreamGenerateContent(finalPrompt, req.Model, files, nil)
+		timeoutCtx, cancel := requestTimeoutContext(c)
+		defer cancel()
+		opts := gemini.GenerateOptions{Language: localeCode(firstNonEmpty(req.Language, req.Locale)), Context: timeoutCtx}
+		respBody, client, accountID, err := generateWithFailover(c, pool, client, accountID, meta == nil && len(files) == 0, func(client *gemini.Client) (io.ReadCloser, error) {
+			return client.StreamGenerateContentWithOptions(finalPrompt, req.Model, files, meta, opts)
+		})
 		if err != nil {
 			log.Printf("Gemini request failed: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to communicate with Gemini: " + err.Error()})
+			c.JSON(generateErrorStatus(c, client, err), gin.H{"error": "Failed to communicate with Gemini: " + err.Error()})
 			return
 		}
 		defer respBody.Close()
 
-		id := fmt.Sprintf("chatcmpl-%d", time.Now().Unix())
+		id := ids.New("chatcmpl-")
 		created := time.Now().Unix()
+		thinkingVisibility := config.ResolveThinkingVisibility(req.ThinkingVisibility)
+		if config.AnswerOnly(req.AnswerOnly) {
+			thinkingVisibility = config.ThinkingHide
+		}
+		thinkTags := config.ResolveThinkingFormat(req.ThinkingFormat) == config.ThinkingFormatTags
+		fingerprint := systemFingerprint(accountID)
+		limiter := newTokenLimiter(req.outputLimit())
+		webSources := config.ResolveWebSources(req.WebSources)
+		extras := responseExtras{prompt: finalPrompt}
+		if webSources != config.WebSourcesOff {
+			extras.sources = &gemini.SourceList{}
+		}
+		sources := extras.sources
+
+		var respMeta gemini.ChatMetadata
+		if meta != nil {
+			respMeta = *meta
+		}
+		saveSession := func() {
+			if req.ConversationID != "" {
+				sessions.Put(req.ConversationID, respMeta, accountID)
+			}
+		}
 
 		// Handle non-streaming request (stream: false)
 		if !req.Stream {
 			var fullText strings.Builder
 			var fullThinking strings.Builder
+			// 回答中生成的图片，追加在正文之后
+			var images []string
+			extras.onImage = func(url string) {
+				if md := generatedImageMarkdown(client, url, len(images)+1); md != "" {
+					images = append(images, md)
+				}
+			}
 
-			parseGeminiResponse(respBody, func(text, thought string) {
+			truncated, err := parseWithContinuation(client, req.Model, respBody, &respMeta, &extras, opts, func(text, thought string) {
 				fullText.WriteString(text)
 				fullThinking.WriteString(thought)
 			})
+			finishReason := config.OpenAIFinishReason(extras.finishReason)
+			if truncated {
+				finishReason = "length"
+			}
+			content := fullText.String()
+			var toolCalls []OpenAIToolCall
+			if toolsInstruction != "" {
+				extractor := &toolCallExtractor{single: !req.parallelToolCalls()}
+				content = extractor.Feed(content) + extractor.Finish()
+				toolCalls = extractor.Calls()
+				if len(toolCalls) > 0 {
+					finishReason = "tool_calls"
+				}
+			}
+			content = limiter.Take(content)
+			if limiter.Exceeded() && len(toolCalls) == 0 {
+				finishReason = "length"
+			}
+			if err != nil {
+				if fullText.Len() == 0 && fullThinking.Len() == 0 {
+					log.Printf("Gemini response parse failed: %v", err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read Gemini response: " + err.Error()})
+					return
+				}
+				log.Printf("Gemini response parse failed, returning partial content: %v", err)
+				finishReason = "length"
+			}
+			saveSession()
+
+			for _, md := range images {
+				if content != "" {
+					content += "\n\n"
+				}
+				content += md
+			}
+			if webSources != config.WebSourcesField {
+				content += renderSources(sources.Items(), webSources)
+			}
+			message := map[string]interface{}{
+				"role":    "assistant",
+				"content": content,
+			}
+			if webSources == config.WebSourcesField && len(sources.Items()) > 0 {
+				message["sources"] = sources.Items()
+			}
+			if annotations := urlCitations(sources, utf8.RuneCountInString(content)); webSources == config.WebSourcesField && len(annotations) > 0 {
+				message["annotations"] = annotations
+			}
+			if len(toolCalls) > 0 {
+				message["tool_calls"] = toolCalls
+				if content == "" {
+					message["content"] = nil
+				}
+			}
+			if thinkingVisibility != config.ThinkingHide {
+				if thinkTags {
+					if fullThinking.Len() > 0 {
+						message["content"] = thinkOpenTag + fullThinking.String() + thinkCloseTag + content
+					}
+				} else if fullThinking.Len() > 0 {
+					message[config.ReasoningField()] = fullThinking.String()
+				}
+				if extras.thoughtSignature != "" {
+					message["reasoning_signature"] = extras.thoughtSignature
+				}
+			}
 
 			resp := map[string]interface{}{
 				"id":      id,
@@ -260,16 +472,18 @@ func ChatCompletionHandler(pool *balancer.AccountPool) gin.HandlerFunc {
 				"model":   req.Model,
 				"choices": []map[string]interface{}{
 					{
-						"index": 0,
-						"message": map[string]interface{}{
-							"role":              "assistant",
-							"content":           fullText.String(),
-							"reasoning_content": fullThinking.String(),
-						},
-						"finish_reason": "stop",
+						"index":         0,
+						"message":       message,
+						"finish_reason": finishReason,
 					},
 				},
 			}
+			if req.ConversationID != "" {
+				resp["conversation_id"] = req.ConversationID
+			}
+			if fingerprint != "" {
+				resp["system_fingerprint"] = fingerprint
+			}
 			c.JSON(http.StatusOK, resp)
 			return
 		}
@@ -281,35 +495,432 @@ func ChatCompletionHandler(pool *balancer.AccountPool) gin.HandlerFunc {
 		c.Header("Transfer-Encoding", "chunked")
 
 		// Send initial Role packet (Required by Cline and others)
-		sendSSERole(c.Writer, id, created, req.Model)
+		sw := newSSEWriter(c.Writer)
+		sendSSERole(sw, id, created, req.Model, fingerprint)
+
+		reasoningField := config.ReasoningField()
+		c.Stream(func(io.Writer) bool {
+			w := sw
+			thinkOpen := false
+			sendThinking := func(thought string) {
+				if !thinkTags {
+					sendSSEThinking(w, id, created, req.Model, reasoningField, thought)
+					return
+				}
+				if !thinkOpen {
+					thought = thinkOpenTag + thought
+					thinkOpen = true
+				}
+				sendSSE(w, id, created, req.Model, thought)
+			}
+			closeThinking := func() {
+				if thinkOpen {
+					sendSSE(w, id, created, req.Model, thinkCloseTag)
+					thinkOpen = false
+				}
+			}
+
+			var thinkingSummary strings.Builder
+			flushSummary := func() {
+				if thinkingSummary.Len() > 0 {
+					sendThinking(thinkingSummary.String())
+					thinkingSummary.Reset()
+				}
+			}
+
+			var extractor *toolCallExtractor
+			if toolsInstruction != "" {
+				extractor = &toolCallExtractor{stream: true, single: !req.parallelToolCalls()}
+			}
+			sendToolCallDeltas := func() {
+				for _, d := range extractor.TakeDeltas() {
+					flushSummary()
+					closeThinking()
+					sendSSEToolCallDelta(w, id, created, req.Model, d)
+				}
+			}
+			// sentChars 已发送的正文字符数，用于 url_citation 注释的 end_index
+			sentChars := 0
+			sendText := func(text string) {
+				text = limiter.Take(text)
+				if text == "" {
+					return
+				}
+				sentChars += utf8.RuneCountInString(text)
+				flushSummary()
+				closeThinking()
+				sendSSE(w, id, created, req.Model, text)
+			}
+			// 生成的图片到达时立即下载并作为单独的 chunk 发送，不计入 max_tokens
+			imageCount := 0
+			extras.onImage = func(url string) {
+				md := generatedImageMarkdown(client, url, imageCount+1)
+				if md == "" {
+					return
+				}
+				imageCount++
+				flushSummary()
+				closeThinking()
+				sendSSE(w, id, created, req.Model, "\n\n"+md)
+			}
 
-		c.Stream(func(w io.Writer) bool {
-			parseGeminiResponse(respBody, func(text, thought string) {
+			truncated, err := parseWithContinuation(client, req.Model, respBody, &respMeta, &extras, opts, func(text, thought string) {
 				if thought != "" {
-					sendSSEThinking(w, id, created, req.Model, thought)
+					switch thinkingVisibility {
+					case config.ThinkingShow:
+						sendThinking(thought)
+					case config.ThinkingSummary:
+						thinkingSummary.WriteString(thought)
+					}
+				}
+				if extractor != nil {
+					text = extractor.Feed(text)
 				}
-				if text != "" {
-					sendSSE(w, id, created, req.Model, text)
+				sendText(text)
+				if extractor != nil {
+					sendToolCallDeltas()
 				}
 			})
+
+			if err != nil {
+				log.Printf("Gemini stream ended with an error, finishing with the content sent so far: %v", err)
+			}
+			finishReason := config.OpenAIFinishReason(extras.finishReason)
+			if truncated {
+				finishReason = "length"
+			}
+			if extractor != nil {
+				sendText(extractor.Finish())
+				sendToolCallDeltas()
+				if len(extractor.Calls()) > 0 {
+					finishReason = "tool_calls"
+				}
+			}
+			if limiter.Exceeded() && finishReason != "tool_calls" {
+				finishReason = "length"
+			}
+			flushSummary()
+			closeThinking()
+			if extras.thoughtSignature != "" && thinkingVisibility != config.ThinkingHide {
+				sendSSEDeltaField(w, id, created, req.Model, "reasoning_signature", extras.thoughtSignature)
+			}
+			if items := sources.Items(); len(items) > 0 {
+				if webSources == config.WebSourcesField {
+					sendSSEDeltaField(w, id, created, req.Model, "sources", items)
+				} else {
+					sendSSE(w, id, created, req.Model, renderSources(items, webSources))
+				}
+			}
+			if annotations := urlCitations(sources, sentChars); webSources == config.WebSourcesField && len(annotations) > 0 {
+				sendSSEDeltaField(w, id, created, req.Model, "annotations", annotations)
+			}
+			sendSSEFinish(w, id, created, req.Model, finishReason)
 			return false
 		})
+		saveSession()
+
+		writeSSEData(sw, "[DONE]")
+	}
+}
+
+// chatPrompt 由 OpenAI 消息拼接出的提示词与需要随请求发送的附件
+type chatPrompt struct {
+	text  string
+	files []gemini.FileData
+	// toolsInstruction 非空时需要从回答中提取工具调用
+	toolsInstruction string
+}
 
-		w := c.Writer
-		fmt.Fprintf(w, "data: [DONE]\n\n")
-		w.(http.Flusher).Flush()
+// lastReasoningSignature 返回最后一条助手消息回传的思考签名，客户端没有回传时为空（沿用会话中保存的签名）
+func lastReasoningSignature(messages []ChatMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "assistant" {
+			return messages[i].ReasoningSignature
+		}
 	}
+	return ""
 }
 
+// buildChatPrompt 把请求中的消息拼接为 Gemini 提示词并上传其中的图片、视频与音频，
+// 续接会话（meta 非 nil）时只发送最后一条助手回复之后的消息；请求无效或上传失败时已写出错误响应，返回 false
+func buildChatPrompt(c *gin.Context, client *gemini.Client, req *ChatRequest, meta *gemini.ChatMetadata) (chatPrompt, bool) {
+	var promptBuilder strings.Builder
+	var files []gemini.FileData
+
+	messages := req.Messages
+	if meta != nil {
+		// 延续已有会话时 Gemini 端已保存历史，只发送最后一条助手回复之后的新消息
+		messages = messagesAfterLastAssistant(messages)
+	}
+
+	// 在上传任何附件之前检查文本，命中拦截规则的请求不会触达账号
+	if promptBlocked(c, requestText(messages)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{
+			"message": blockedPromptMessage,
+			"type":    "invalid_request_error",
+			"code":    "content_blocked",
+		}})
+		return chatPrompt{}, false
+	}
+
+	// 开头连续的 system 消息合并为一条系统指令（与 Claude 路径的 system 字段一致），
+	// 对话中途插入的 system 消息保留原位置，用 <system_note> 包裹以免与用户轮次混淆
+	leadingSystem, rest := splitLeadingSystemMessages(messages)
+	if leadingSystem != "" {
+		promptBuilder.WriteString("**System**: ")
+		promptBuilder.WriteString(leadingSystem)
+		promptBuilder.WriteString("\n\n")
+	}
+
+	// 续接会话时只发送部分消息，报错时换算回原始请求中的下标
+	msgOffset := len(req.Messages) - len(rest)
+	for i, msg := range rest {
+		msgIndex := msgOffset + i
+		if isSystemRole(msg.Role) {
+			promptBuilder.WriteString(fmt.Sprintf("**System**: <system_note>%s</system_note>\n\n", messageText(msg.Content)))
+			continue
+		}
+		if strings.EqualFold(msg.Role, "tool") || strings.EqualFold(msg.Role, "function") {
+			promptBuilder.WriteString(formatToolResult(msg))
+			promptBuilder.WriteString("\n\n")
+			continue
+		}
+
+		role := "User"
+		if strings.EqualFold(msg.Role, "model") || strings.EqualFold(msg.Role, "assistant") {
+			role = "Model"
+		}
+
+		promptBuilder.WriteString(fmt.Sprintf("**%s**: ", role))
+
+		switch v := msg.Content.(type) {
+		case string:
+			promptBuilder.WriteString(v)
+		case []interface{}:
+			for _, part := range v {
+				p, ok := part.(map[string]interface{})
+				if !ok {
+					continue
+				}
+
+				typeStr, _ := p["type"].(string)
+
+				if typeStr == "text" {
+					if text, ok := p["text"].(string); ok {
+						promptBuilder.WriteString(text)
+					}
+				} else if typeStr == "image_url" {
+					if imgMap, ok := p["image_url"].(map[string]interface{}); ok {
+						if urlStr, ok := imgMap["url"].(string); ok {
+							if strings.HasPrefix(urlStr, "data:") {
+								data, mimeType, err := openDataURL(urlStr, maxImageUploadSize)
+								if err != nil {
+									log.Printf("Malformed image data URL in message %d: %v", msgIndex, err)
+									c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{
+										"message": fmt.Sprintf("Invalid image_url in messages[%d]: %v", msgIndex, err),
+										"type":    "invalid_request_error",
+									}})
+									return chatPrompt{}, false
+								}
+								fname := imageFileName(mimeType, time.Now().UnixNano())
+								fid, err := client.UploadReader(data, fname, "application/octet-stream")
+								if err == nil {
+									files = append(files, gemini.FileData{
+										URL:      fid,
+										FileName: fname,
+									})
+									promptBuilder.WriteString("[Image]")
+								} else {
+									log.Printf("Failed to upload image: %v", err)
+								}
+							} else {
+								promptBuilder.WriteString(fmt.Sprintf("[Image URL: %s]", urlStr))
+							}
+						}
+					}
+				} else if typeStr == "video_url" {
+					if videoMap, ok := p["video_url"].(map[string]interface{}); ok {
+						if urlStr, ok := videoMap["url"].(string); ok {
+							if !strings.HasPrefix(urlStr, "data:") {
+								promptBuilder.WriteString(fmt.Sprintf("[Video URL: %s]", urlStr))
+								continue
+							}
+							data, _, err := decodeDataURL(urlStr)
+							var mimeType string
+							if err == nil {
+								mimeType, err = prepareVideo(data)
+							}
+							if err != nil {
+								log.Printf("Rejected video in message %d: %v", msgIndex, err)
+								c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{
+									"message": fmt.Sprintf("Invalid video_url in messages[%d]: %v", msgIndex, err),
+									"type":    "invalid_request_error",
+								}})
+								return chatPrompt{}, false
+							}
+							fname := videoFileName(mimeType, time.Now().UnixNano())
+							fid, err := client.UploadResumable(data, fname, mimeType)
+							if err != nil {
+								log.Printf("Failed to upload video: %v", err)
+								c.JSON(http.StatusBadGateway, gin.H{"error": gin.H{
+									"message": "Failed to upload video: " + err.Error(),
+									"type":    "server_error",
+								}})
+								return chatPrompt{}, false
+							}
+							files = append(files, gemini.FileData{
+								URL:      fid,
+								FileName: fname,
+							})
+							promptBuilder.WriteString("[Video]")
+						}
+					}
+				} else if typeStr == "gemini_file" {
+					urlStr, _ := p["url"].(string)
+					fileName, _ := p["file_name"].(string)
+					file, err := geminiFileRef(urlStr, fileName)
+					if err != nil {
+						c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{
+							"message": fmt.Sprintf("Invalid gemini_file in messages[%d]: %v", msgIndex, err),
+							"type":    "invalid_request_error",
+						}})
+						return chatPrompt{}, false
+					}
+					files = append(files, file)
+					promptBuilder.WriteString("[Image]")
+				} else if typeStr == "input_audio" {
+					data, mimeType, fname, err := prepareInputAudio(p, time.Now().UnixNano())
+					if err != nil {
+						log.Printf("Rejected audio in message %d: %v", msgIndex, err)
+						c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{
+							"message": fmt.Sprintf("Invalid input_audio in messages[%d]: %v", msgIndex, err),
+							"type":    "invalid_request_error",
+						}})
+						return chatPrompt{}, false
+					}
+					fid, err := client.UploadFileWithMime(data, fname, mimeType)
+					if err != nil {
+						log.Printf("Failed to upload audio: %v", err)
+						c.JSON(http.StatusBadGateway, gin.H{"error": gin.H{
+							"message": "Failed to upload audio: " + err.Error(),
+							"type":    "server_error",
+						}})
+						return chatPrompt{}, false
+					}
+					files = append(files, gemini.FileData{
+						URL:      fid,
+						FileName: fname,
+					})
+					promptBuilder.WriteString("[Audio]")
+				}
+			}
+		}
+		promptBuilder.WriteString(formatToolCalls(msg.ToolCalls))
+		promptBuilder.WriteString("\n\n")
+	}
+
+	finalPrompt := promptBuilder.String()
+	if finalPrompt == "" {
+		finalPrompt = "Hello"
+	}
+
+	language := firstNonEmpty(req.Language, req.Locale)
+	finalPrompt = prependLanguageInstruction(finalPrompt, language)
+	finalPrompt = prependLogitBiasInstruction(finalPrompt, req.LogitBias)
+	finalPrompt = prependMaxTokensInstruction(finalPrompt, req.outputLimit())
+	toolsInstruction := buildToolsInstruction(req.Tools, req.ToolChoice, req.parallelToolCalls())
+	finalPrompt = toolsInstruction + finalPrompt
+	if meta == nil {
+		// 续接会话时 Gemini 端的历史中已包含全局指令
+		finalPrompt = prependGlobalSystemPrompt(finalPrompt)
+	}
+	finalPrompt = prependCurrentTime(finalPrompt)
+
+	if msg := checkPromptSize(finalPrompt, config.MapModel(req.Model)); msg != "" {
+		log.Printf("[OpenAI] %s", msg)
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": gin.H{
+			"message": msg,
+			"type":    "invalid_request_error",
+			"code":    "context_length_exceeded",
+		}})
+		return chatPrompt{}, false
+	}
+
+	return chatPrompt{text: finalPrompt, files: files, toolsInstruction: toolsInstruction}, true
+}
+
+// think_tags 格式下包裹思考过程的标签
+const (
+	thinkOpenTag  = "<think>\n"
+	thinkCloseTag = "\n</think>\n\n"
+)
+
 // Extract common parsing logic
-func parseGeminiResponse(reader io.Reader, onChunk func(text, thought string)) {
-	scanner := bufio.NewScanner(reader)
-	buf := make([]byte, 0, 1024*1024)
-	scanner.Buffer(buf, 10*1024*1024)
+// 返回扫描过程中的错误（如单行超出缓冲区），此前已回调的内容不受影响
+func parseGeminiResponse(reader io.Reader, onChunk func(text, thought string)) error {
+	return parseGeminiResponseWithMeta(reader, nil, onChunk)
+}
+
+// parseGeminiResponseWithMeta 与 parseGeminiResponse 相同，同时把响应中的会话元数据写入 meta
+func parseGeminiResponseWithMeta(reader io.Reader, meta *gemini.ChatMetadata, onChunk func(text, thought string)) error {
+	return parseGeminiResponseWithExtras(reader, meta, nil, onChunk)
+}
+
+// responseExtras 解析时从候选中额外收集的信息
+type responseExtras struct {
+	// sources 非 nil 时收集联网搜索的引用来源
+	sources *gemini.SourceList
+	// finishReason Gemini 在候选中报告的结束原因（如 SAFETY），没有时为空
+	finishReason string
+	// thoughtSignature 思考过程的签名，见 gemini.CandidateThoughtSignature，没有时为空
+	thoughtSignature string
+	// prompt 本次发送的提示词，供 strip_prompt_echo 等后处理阶段使用
+	prompt string
+	// outputCap 整个请求（含续写）共用的输出上限，首次解析时创建
+	outputCap *gemini.OutputCap
+	// onImage 非 nil 时每出现一张新的生成图片就以其地址调用一次
+	onImage func(url string)
+	images  gemini.GeneratedImageList
+}
+
+// parseGeminiResponseWithExtras 与 parseGeminiResponseWithMeta 相同，extras 非 nil 时同时收集引用来源与结束原因。
+// 输出超过 MAX_OUTPUT_BYTES 时停止读取，结束原因记为 MAX_TOKENS
+func parseGeminiResponseWithExtras(reader io.Reader, meta *gemini.ChatMetadata, extras *responseExtras, onChunk func(text, thought string)) error {
+	scanner := gemini.NewResponseScanner(reader)
+
+	pipeline := gemini.NewOutputPipeline()
+	var outputCap *gemini.OutputCap
+	if extras != nil {
+		pipeline.SetPrompt(extras.prompt)
+		if extras.outputCap == nil {
+			extras.outputCap = gemini.NewOutputCap()
+		}
+		outputCap = extras.outputCap
+	} else {
+		outputCap = gemini.NewOutputCap()
+	}
+
+	// emitDelta 按 MAX_OUTPUT_BYTES 截取增量，经过后处理链后输出
+	emitDelta := func(deltaText, deltaThoughts string) {
+		if deltaText == "" && deltaThoughts == "" {
+			return
+		}
+		if deltaText, deltaThoughts = outputCap.Take(deltaText, deltaThoughts); outputCap.Exceeded() {
+			log.Printf("[Parser] Output exceeded MAX_OUTPUT_BYTES (%d), cutting off the upstream response", config.MaxOutputBytes())
+			if extras != nil {
+				extras.finishReason = "MAX_TOKENS"
+			}
+		}
+
+		deltaText = pipeline.Text(deltaText)
+		deltaThoughts = pipeline.Thought(deltaThoughts)
 
-	var lastText, lastThoughts string
+		if deltaText != "" || deltaThoughts != "" {
+			onChunk(deltaText, deltaThoughts)
+		}
+	}
 
-	for scanner.Scan() {
+	for !outputCap.Exceeded() && scanner.Scan() {
 		line := strings.TrimPrefix(scanner.Text(), ")]}'")
 		line = strings.TrimSpace(line)
 		if line == "" {
@@ -329,58 +940,148 @@ func parseGeminiResponse(reader io.Reader, onChunk func(text, thought string)) {
 
 			inner := gjson.Parse(dataStr)
 
+			if meta != nil {
+				if cid := inner.Get("1.0").String(); cid != "" {
+					meta.CID = cid
+				}
+				if rid := inner.Get("1.1").String(); rid != "" {
+					meta.RID = rid
+				}
+			}
+
 			candidates := inner.Get("4")
 			if candidates.IsArray() {
 				candidates.ForEach(func(_, candidate gjson.Result) bool {
-					rawText := candidate.Get("1.0").String()
-					rawThoughts := candidate.Get("37.0.0").String()
-
-					deltaText := ""
-					deltaThoughts := ""
-
-					rawRunes := []rune(rawText)
-					lastRunes := []rune(lastText)
-					if len(rawRunes) > len(lastRunes) {
-						deltaText = string(rawRunes[len(lastRunes):])
-						lastText = rawText
-					} else if len(lastRunes) == 0 && len(rawRunes) > 0 {
-						deltaText = rawText
-						lastText = rawText
-					}
-
-					rawThoughtRunes := []rune(rawThoughts)
-					lastThoughtRunes := []rune(lastThoughts)
-					if len(rawThoughtRunes) > len(lastThoughtRunes) {
-						deltaThoughts = string(rawThoughtRunes[len(lastThoughtRunes):])
-						lastThoughts = rawThoughts
-					} else if len(lastThoughtRunes) == 0 && len(rawThoughtRunes) > 0 {
-						deltaThoughts = rawThoughts
-						lastThoughts = rawThoughts
+					sig := gemini.CandidateThoughtSignature(candidate)
+					if meta != nil {
+						if rcid := candidate.Get("0").String(); rcid != "" {
+							meta.RCID = rcid
+						}
+						if sig != "" {
+							meta.ThoughtSignature = sig
+						}
 					}
 
-					if deltaText == "" && deltaThoughts == "" {
-						return true
+					if extras != nil {
+						extras.sources.Add(candidate)
+						if reason := gemini.CandidateFinishReason(candidate); reason != "" {
+							extras.finishReason = reason
+						}
+						if sig != "" {
+							extras.thoughtSignature = sig
+						}
 					}
 
-					deltaText = strings.ReplaceAll(deltaText, `\<`, `<`)
-					deltaText = strings.ReplaceAll(deltaText, `\>`, `>`)
-					deltaText = strings.ReplaceAll(deltaText, `\_`, `_`)
-					deltaText = strings.ReplaceAll(deltaText, `\[`, `[`)
-					deltaText = strings.ReplaceAll(deltaText, `\]`, `]`)
-					deltaText = filterImagePlaceholders(deltaText)
-
-					if deltaText != "" || deltaThoughts != "" {
-						onChunk(deltaText, deltaThoughts)
+					emitDelta(pipeline.TextDelta(candidate.Get("1.0").String()), pipeline.ThoughtDelta(candidate.Get("37.0.0").String()))
+					if extras != nil && extras.onImage != nil {
+						for _, url := range extras.images.Add(candidate) {
+							extras.onImage(url)
+						}
 					}
-					return true
+					return !outputCap.Exceeded()
 				})
 			}
-			return true
+			return !outputCap.Exceeded()
 		})
 	}
+
+	if !outputCap.Exceeded() {
+		emitDelta(pipeline.SnapshotRemainder())
+	}
+	if text, thought := pipeline.Flush(); text != "" || thought != "" {
+		onChunk(text, thought)
+	}
+
+	if errors.Is(scanner.Err(), gemini.ErrRequestTimeout) {
+		log.Printf("[Parser] %s expired, returning the partial response", RequestTimeoutHeader)
+		if extras != nil {
+			extras.finishReason = "MAX_TOKENS"
+		}
+	}
+	return gemini.LogScanError("Parser", scanner.Err())
+}
+
+// parseWithContinuation 解析响应，回答疑似被截断时复用会话元数据自动发送续写请求，
+// 续写内容通过同一个 onChunk 接在后面输出。最多续写 config.MaxContinuations() 次，
+// 返回值 truncated 表示达到上限后回答仍不完整
+func parseWithContinuation(client *gemini.Client, model string, respBody io.Reader, meta *gemini.ChatMetadata, extras *responseExtras, opts gemini.GenerateOptions, onChunk func(text, thought string)) (bool, error) {
+	// answer 累计的完整回答，segment 最近一次响应的回答，按长度判断截断时只看 segment
+	var answer, segment strings.Builder
+	collect := func(text, thought string) {
+		answer.WriteString(text)
+		segment.WriteString(text)
+		onChunk(text, thought)
+	}
+
+	err := parseGeminiResponseWithExtras(respBody, meta, extras, collect)
+	maxContinuations := config.MaxContinuations()
+	if maxContinuations == 0 {
+		return false, err
+	}
+
+	for i := 0; i < maxContinuations; i++ {
+		if extras != nil && extras.outputCap.Exceeded() || errors.Is(err, gemini.ErrRequestTimeout) {
+			return true, err
+		}
+		if answer.Len() == 0 || !(err != nil || looksTruncated(answer.String(), segment.String(), finishReasonOf(extras))) {
+			return false, err
+		}
+		if meta.CID == "" {
+			return true, err
+		}
+
+		log.Printf("[Continue] Answer looks truncated, requesting continuation %d/%d", i+1, maxContinuations)
+		gemini.RandomDelay()
+
+		cont, reqErr := client.StreamGenerateContentWithOptions(config.ContinuePrompt(), model, nil, meta, opts)
+		if reqErr != nil {
+			log.Printf("[Continue] Continuation request failed: %v", reqErr)
+			return true, fmt.Errorf("continuation request failed: %w", reqErr)
+		}
+		if extras != nil {
+			extras.prompt = config.ContinuePrompt()
+			// 结束原因只看续写的这一次响应，避免上一段的 MAX_TOKENS 一直触发续写
+			extras.finishReason = ""
+		}
+		segment.Reset()
+		err = parseGeminiResponseWithExtras(cont, meta, extras, collect)
+		cont.Close()
+	}
+
+	return err != nil || looksTruncated(answer.String(), segment.String(), finishReasonOf(extras)), err
 }
 
-func sendSSERole(w io.Writer, id string, created int64, model string) {
+func finishReasonOf(extras *responseExtras) string {
+	if extras == nil {
+		return ""
+	}
+	return extras.finishReason
+}
+
+// looksTruncated 判断回答是否在中途被截断：Gemini 报告的结束原因为 MAX_TOKENS、代码块没有闭合，
+// 或最近一段回答达到 AUTO_CONTINUE_MIN_LENGTH 且没有在句末、代码块结尾处结束（Web 接口截断长回答时通常不给结束原因）
+func looksTruncated(answer, segment, finishReason string) bool {
+	if strings.EqualFold(finishReason, "MAX_TOKENS") {
+		return true
+	}
+	if strings.Count(answer, "```")%2 == 1 {
+		return true
+	}
+	minLength := config.ContinueMinLength()
+	return minLength > 0 && utf8.RuneCountInString(segment) >= minLength && !endsCleanly(segment)
+}
+
+// sentenceEnds 视为正常结束的末尾字符：句末标点、右括号与引号、Markdown 强调与代码标记
+const sentenceEnds = ".!?。！？…)]}）」』\"'”’*`|>"
+
+func endsCleanly(text string) bool {
+	text = strings.TrimRightFunc(text, unicode.IsSpace)
+	last, _ := utf8.DecodeLastRuneInString(text)
+	return text == "" || strings.ContainsRune(sentenceEnds, last)
+}
+
+// sendSSERole 发送首个携带 role 的 chunk，fingerprint 非空时写入 system_fingerprint
+func sendSSERole(w io.Writer, id string, created int64, model, fingerprint string) {
 	resp := map[string]interface{}{
 		"id":      id,
 		"object":  "chat.completion.chunk",
@@ -396,9 +1097,11 @@ func sendSSERole(w io.Writer, id string, created int64, model string) {
 			},
 		},
 	}
+	if fingerprint != "" {
+		resp["system_fingerprint"] = fingerprint
+	}
 	bytes, _ := json.Marshal(resp)
-	fmt.Fprintf(w, "data: %s\n\n", bytes)
-	w.(http.Flusher).Flush()
+	writeSSEData(w, string(bytes))
 }
 
 func sendSSE(w io.Writer, id string, created int64, model, content string) {
@@ -418,11 +1121,52 @@ func sendSSE(w io.Writer, id string, created int64, model, content string) {
 		},
 	}
 	bytes, _ := json.Marshal(resp)
-	fmt.Fprintf(w, "data: %s\n\n", bytes)
-	w.(http.Flusher).Flush()
+	writeSSEData(w, string(bytes))
+}
+
+// sendSSEDeltaField 在 finish chunk 之前发送只携带一个非标准 delta 字段（如 sources）的 chunk
+func sendSSEDeltaField(w io.Writer, id string, created int64, model, field string, value interface{}) {
+	resp := map[string]interface{}{
+		"id":      id,
+		"object":  "chat.completion.chunk",
+		"created": created,
+		"model":   model,
+		"choices": []map[string]interface{}{
+			{
+				"index": 0,
+				"delta": map[string]interface{}{
+					field: value,
+				},
+				"finish_reason": nil,
+			},
+		},
+	}
+	bytes, _ := json.Marshal(resp)
+	writeSSEData(w, string(bytes))
+}
+
+// sendSSEFinish 发送携带 finish_reason 的最后一个 chunk
+func sendSSEFinish(w io.Writer, id string, created int64, model, finishReason string) {
+	resp := map[string]interface{}{
+		"id":      id,
+		"object":  "chat.completion.chunk",
+		"created": created,
+		"model":   model,
+		"choices": []map[string]interface{}{
+			{
+				"index":         0,
+				"delta":         map[string]string{},
+				"finish_reason": finishReason,
+			},
+		},
+	}
+	bytes, _ := json.Marshal(resp)
+	writeSSEData(w, string(bytes))
 }
 
-func sendSSEThinking(w io.Writer, id string, created int64, model, thinking string) {
+// sendSSEThinking 思考片段只放在 field（reasoning_content 或 reasoning）中，不附带空的 content，
+// 与非流式响应的 message 字段保持一致
+func sendSSEThinking(w io.Writer, id string, created int64, model, field, thinking string) {
 	resp := map[string]interface{}{
 		"id":      id,
 		"object":  "chat.completion.chunk",
@@ -432,22 +1176,97 @@ func sendSSEThinking(w io.Writer, id string, created int64, model, thinking stri
 			{
 				"index": 0,
 				"delta": map[string]string{
-					"reasoning_content": thinking,
-					"content":           "",
+					field: thinking,
 				},
 				"finish_reason": nil,
 			},
 		},
 	}
 	bytes, _ := json.Marshal(resp)
-	fmt.Fprintf(w, "data: %s\n\n", bytes)
-	w.(http.Flusher).Flush()
+	writeSSEData(w, string(bytes))
 }
 
-var imagePlaceholderRegex = regexp.MustCompile(`\s*https?://googleusercontent\.com/image_generation_content/\d+\s*`)
+// prependGlobalSystemPrompt 在提示词最前面加入 GLOBAL_SYSTEM_PROMPT，
+// 客户端自带的 system 消息保留在其后，两者同时生效
+func prependGlobalSystemPrompt(prompt string) string {
+	global := config.GlobalSystemPrompt()
+	if global == "" {
+		return prompt
+	}
+	return fmt.Sprintf("**System**: %s\n\n%s", global, prompt)
+}
+
+// prependCurrentTime 开启 INJECT_CURRENT_TIME 时在提示词最前面加入当前日期时间。
+// Gemini 网页版的模型不知道"今天"是哪天，续接会话时同样注入，保证日期随每次请求更新
+func prependCurrentTime(prompt string) string {
+	if !config.CurrentTimeEnabled() {
+		return prompt
+	}
+	now := time.Now().In(config.CurrentTimeLocation()).Format(config.CurrentTimeFormat())
+	return fmt.Sprintf("**System**: The current date and time is %s.\n\n%s", now, prompt)
+}
+
+// prependLanguageInstruction 在提示词前加入语言指令，language 为空时原样返回
+func prependLanguageInstruction(prompt, language string) string {
+	language = strings.TrimSpace(language)
+	if language == "" {
+		return prompt
+	}
+	return fmt.Sprintf("**System**: Always respond in %s, regardless of the language used in the conversation.\n\n%s", language, prompt)
+}
+
+var localeCodeRegex = regexp.MustCompile(`^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]{2,4})?$`)
+
+// localeCode 仅当 language 形如 ja / zh-TW 时返回，用于协议中的语言字段；
+// "Spanish" 这类自然语言名称只通过提示词指令生效
+func localeCode(language string) string {
+	language = strings.TrimSpace(language)
+	if localeCodeRegex.MatchString(language) {
+		return strings.ReplaceAll(language, "_", "-")
+	}
+	return ""
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// splitLeadingSystemMessages 合并开头连续的 system（及 developer）消息，返回合并后的文本与剩余消息
+func splitLeadingSystemMessages(messages []ChatMessage) (string, []ChatMessage) {
+	var parts []string
+	i := 0
+	for ; i < len(messages); i++ {
+		if !isSystemRole(messages[i].Role) {
+			break
+		}
+		if text := strings.TrimSpace(messageText(messages[i].Content)); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n\n"), messages[i:]
+}
+
+// isSystemRole developer 是新版 OpenAI 接口中 system 的别名
+func isSystemRole(role string) bool {
+	return strings.EqualFold(role, "system") || strings.EqualFold(role, "developer")
+}
+
+func messagesAfterLastAssistant(messages []ChatMessage) []ChatMessage {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if strings.EqualFold(messages[i].Role, "assistant") || strings.EqualFold(messages[i].Role, "model") {
+			return messages[i+1:]
+		}
+	}
+	return messages
+}
 
 func filterImagePlaceholders(text string) string {
-	return imagePlaceholderRegex.ReplaceAllString(text, "")
+	return gemini.StripImagePlaceholders(text)
 }
 
 func parseGeminiResponseFromBytes(content []byte, onChunk func(text, thought string, imgURL string)) {
@@ -500,13 +1319,6 @@ func parseGeminiResponseFromBytes(content []byte, onChunk func(text, thought str
 		text := candidate.Get("1.0").String()
 		thoughts := candidate.Get("37.0.0").String()
 
-		text = strings.ReplaceAll(text, `\<`, `<`)
-		text = strings.ReplaceAll(text, `\>`, `>`)
-		text = strings.ReplaceAll(text, `\_`, `_`)
-		text = strings.ReplaceAll(text, `\[`, `[`)
-		text = strings.ReplaceAll(text, `\]`, `]`)
-		text = filterImagePlaceholders(text)
-
 		var imgURL string
 
 		if candidate.Get("12.7.0").Exists() {
@@ -522,12 +1334,7 @@ func parseGeminiResponseFromBytes(content []byte, onChunk func(text, thought str
 				}
 
 				if finishedText := imgCandidate.Get("1.0").String(); finishedText != "" {
-					text = filterImagePlaceholders(finishedText)
-					text = strings.ReplaceAll(text, `\<`, `<`)
-					text = strings.ReplaceAll(text, `\>`, `>`)
-					text = strings.ReplaceAll(text, `\_`, `_`)
-					text = strings.ReplaceAll(text, `\[`, `[`)
-					text = strings.ReplaceAll(text, `\]`, `]`)
+					text = finishedText
 				}
 
 				imgCandidate.Get("12.7.0").ForEach(func(_, genImg gjson.Result) bool {
@@ -544,12 +1351,13 @@ func parseGeminiResponseFromBytes(content []byte, onChunk func(text, thought str
 			}
 		}
 
+		text, thoughts = gemini.NewOutputPipeline().Complete(text, thoughts)
 		onChunk(text, thoughts, imgURL)
 	}
 }
 
 func downloadImageAsBase64(url string, cookies map[string]string) string {
-	client := &http.Client{Timeout: 60 * time.Second}
+	client := imageHTTPClient
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -654,8 +1462,18 @@ func extractImageURLsFromResponse(reader io.Reader) []string {
 	return urls
 }
 
+// generatedImageMarkdown 下载生成的图片，返回以 data URL 内嵌的 Markdown 图片，下载失败时返回空串
+func generatedImageMarkdown(client *gemini.Client, url string, n int) string {
+	data, err := client.FetchImage(gemini.FullSizeImageURL(url))
+	if err != nil {
+		log.Printf("[Images] Failed to fetch image: %v", err)
+		return ""
+	}
+	return fmt.Sprintf("![Generated Image %d](data:%s;base64,%s)", n, http.DetectContentType(data), base64.StdEncoding.EncodeToString(data))
+}
+
 func handleImageChatRequest(c *gin.Context, client *gemini.Client, req ChatRequest) {
-	id := fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
+	id := ids.New("chatcmpl-")
 	created := time.Now().Unix()
 
 	// Extract prompt from last user message
@@ -683,10 +1501,18 @@ func handleImageChatRequest(c *gin.Context, client *gemini.Client, req ChatReque
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No prompt found in messages"})
 		return
 	}
+	if promptBlocked(c, prompt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{
+			"message": blockedPromptMessage,
+			"type":    "invalid_request_error",
+			"code":    "content_blocked",
+		}})
+		return
+	}
 
-	respBody, err := client.StreamGenerateContent(fmt.Sprintf("Generate an image of %s", prompt), req.Model, nil, nil)
+	respBody, err := client.StreamGenerateContent(config.BuildImagePrompt(prompt, "", ""), req.Model, nil, nil)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(generateErrorStatus(c, client, err), gin.H{"error": err.Error()})
 		return
 	}
 	defer respBody.Close()
@@ -704,17 +1530,9 @@ func handleImageChatRequest(c *gin.Context, client *gemini.Client, req ChatReque
 	// Download images using gemini client
 	var content strings.Builder
 	for i, imgURL := range imageURLs {
-		fullURL := imgURL
-		if !strings.Contains(fullURL, "=s") {
-			fullURL = imgURL + "=s2048"
-		}
-		data, err := client.FetchImage(fullURL)
-		if err != nil {
-			log.Printf("[Images] Failed to fetch image: %v", err)
-			continue
+		if md := generatedImageMarkdown(client, imgURL, i+1); md != "" {
+			content.WriteString(md + "\n\n")
 		}
-		b64 := base64.StdEncoding.EncodeToString(data)
-		content.WriteString(fmt.Sprintf("![Generated Image %d](data:image/png;base64,%s)\n\n", i+1, b64))
 	}
 
 	if content.Len() == 0 {
@@ -730,10 +1548,10 @@ func handleImageChatRequest(c *gin.Context, client *gemini.Client, req ChatReque
 		c.Header("Cache-Control", "no-cache")
 		c.Header("Connection", "keep-alive")
 
-		sendSSERole(c.Writer, id, created, req.Model)
-		sendSSE(c.Writer, id, created, req.Model, content.String())
-		fmt.Fprintf(c.Writer, "data: [DONE]\n\n")
-		c.Writer.(http.Flusher).Flush()
+		sw := newSSEWriter(c.Writer)
+		sendSSERole(sw, id, created, req.Model, "")
+		sendSSE(sw, id, created, req.Model, content.String())
+		writeSSEData(sw, "[DONE]")
 	} else {
 		c.JSON(http.StatusOK, gin.H{
 			"id":      id,
@@ -4,11 +4,15 @@ import (
 	"encoding/base64"
 	"fmt"
 	"gemini-web2api/internal/balancer"
+	"gemini-web2api/internal/config"
 	"gemini-web2api/internal/gemini"
 	"io"
 	"log"
 	"net/http"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -48,16 +52,10 @@ func sizeToAspectRatio(size string) string {
 	return "1:1"
 }
 
-func ImageGenerationHandler(pool *balancer.AccountPool) gin.HandlerFunc {
+// ImageGenerationHandler OpenAI 兼容的 /v1/images/generations：按 prompt 生成图片，n > 1 时按需补发请求，
+// 以 b64_json 或（经 ImageProxy 代理的）url 返回
+func ImageGenerationHandler(pool *balancer.AccountPool, proxy *ImageProxy) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		client, accountID := pool.Next()
-		if client == nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No available accounts"})
-			return
-		}
-
-		c.Set("account_id", accountID)
-
 		var req ImageGenerationRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -68,6 +66,10 @@ func ImageGenerationHandler(pool *balancer.AccountPool) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing 'prompt' field"})
 			return
 		}
+		if promptBlocked(c, req.Prompt) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": blockedPromptMessage})
+			return
+		}
 
 		if req.N <= 0 {
 			req.N = 1
@@ -85,71 +87,373 @@ func ImageGenerationHandler(pool *balancer.AccountPool) gin.HandlerFunc {
 			req.ResponseFormat = "b64_json"
 		}
 
+		mappedModel := config.MapModel(req.Model)
+		if msg := unsupportedModel(pool, mappedModel); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		release, busy := acquireModelSlot(c, mappedModel)
+		if busy != "" {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": busy})
+			return
+		}
+		defer release()
+		client, accountID := selectAccountForModel(c, pool, mappedModel)
+		if client == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No available accounts"})
+			return
+		}
+
+		c.Set("account_id", accountID)
+
 		log.Printf("[Images] Request | Model: %s | Prompt: %.50s... | N: %d | Size: %s",
 			req.Model, req.Prompt, req.N, req.Size)
 
-		finalPrompt := fmt.Sprintf("Generate an image of %s", req.Prompt)
-		if req.Quality == "hd" {
-			finalPrompt += " (high quality, highly detailed, 4k resolution, hdr)"
-		}
-		if req.Style == "vivid" {
-			finalPrompt += " (vivid colors, dramatic lighting, rich details)"
-		} else if req.Style == "natural" {
-			finalPrompt += " (natural lighting, realistic, photorealistic)"
-		}
+		finalPrompt := config.BuildImagePrompt(req.Prompt, req.Quality, req.Style)
+		format, multipartResp := imageResponseFormat(c, req.ResponseFormat)
 
 		gemini.RandomDelay()
 
-		var images []gin.H
-		var errors []string
-
-		for i := 0; i < req.N; i++ {
-			respBody, err := client.StreamGenerateContent(finalPrompt, req.Model, nil, nil)
+		rotate := config.ImageRotateAccounts() && c.GetHeader(AccountOverrideHeader) == ""
+		results := collectImageResults(req.N, func(i int) imageResult {
+			client, accountID := client, accountID
+			if rotate && i > 0 {
+				if next, nextID := pool.NextForModel(mappedModel); next != nil {
+					client, accountID = next, nextID
+					log.Printf("[Images] Request %d uses account '%s'", i, displayAccountID(nextID))
+				}
+			}
+			extracted, refusal, err := generateImages(client, finalPrompt, req.Model, nil, format)
+			if format == "url" {
+				proxy.rewrite(c, extracted, accountID)
+			}
 			if err != nil {
 				log.Printf("[Images] Request %d failed: %v", i, err)
-				errors = append(errors, err.Error())
-				continue
+			} else if len(extracted) > 0 {
+				log.Printf("[Images] Request %d succeeded, got %d images", i, len(extracted))
 			}
+			return imageResult{images: extracted, refusal: refusal, err: err}
+		})
 
-			extracted := extractImagesFromResponse(respBody, req.ResponseFormat, client.Cookies)
-			respBody.Close()
+		respondImageResults(c, client, "Failed to generate images", results, req.N, multipartResp)
+	}
+}
 
-			if len(extracted) > 0 {
-				images = append(images, extracted...)
-				log.Printf("[Images] Request %d succeeded, got %d images", i, len(extracted))
-			} else {
-				errors = append(errors, "No images generated")
+// maxImageUploadSize 图片变体接口允许上传的最大图片大小
+const maxImageUploadSize = 20 * 1024 * 1024
+
+// ImageVariationHandler OpenAI 兼容的 /v1/images/variations：上传 multipart 表单中的 image，
+// 让图片模型生成 n 张变体，返回格式与 ImageGenerationHandler 相同
+func ImageVariationHandler(pool *balancer.AccountPool, proxy *ImageProxy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImageUploadSize+1024*1024)
+
+		fileHeader, err := c.FormFile("image")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{
+				"message": fmt.Sprintf("Missing or invalid 'image' field: %v", err),
+				"type":    "invalid_request_error",
+			}})
+			return
+		}
+		if fileHeader.Size > maxImageUploadSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": gin.H{
+				"message": fmt.Sprintf("Image is too large (%d bytes), maximum is %d bytes", fileHeader.Size, maxImageUploadSize),
+				"type":    "invalid_request_error",
+			}})
+			return
+		}
+
+		f, err := fileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		req := ImageGenerationRequest{
+			Model:          c.PostForm("model"),
+			Size:           c.PostForm("size"),
+			ResponseFormat: c.PostForm("response_format"),
+		}
+		req.N, _ = strconv.Atoi(c.PostForm("n"))
+		if req.N <= 0 {
+			req.N = 1
+		}
+		if req.N > 4 {
+			req.N = 4
+		}
+		if req.Model == "" || !isImageModel(req.Model) {
+			req.Model = "gemini-2.5-flash-image"
+		}
+		if req.Size == "" {
+			req.Size = "1024x1024"
+		}
+		if req.ResponseFormat == "" {
+			req.ResponseFormat = "b64_json"
+		}
+
+		mappedModel := config.MapModel(req.Model)
+		if msg := unsupportedModel(pool, mappedModel); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		release, busy := acquireModelSlot(c, mappedModel)
+		if busy != "" {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": busy})
+			return
+		}
+		defer release()
+
+		client, accountID := selectAccountForModel(c, pool, mappedModel)
+		if client == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No available accounts"})
+			return
+		}
+
+		c.Set("account_id", accountID)
+
+		log.Printf("[Images] Variation request | Model: %s | File: %s | N: %d | Size: %s",
+			req.Model, fileHeader.Filename, req.N, req.Size)
+
+		ext := strings.ToLower(filepath.Ext(fileHeader.Filename))
+		if ext == "" {
+			ext = ".png"
+		}
+		fname := fmt.Sprintf("image_%d%s", time.Now().UnixNano(), ext)
+		fid, err := client.UploadFile(data, fname)
+		if err != nil {
+			log.Printf("[Images] Failed to upload image: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": gin.H{
+				"message": "Failed to upload image: " + err.Error(),
+				"type":    "server_error",
+			}})
+			return
+		}
+		files := []gemini.FileData{{URL: fid, FileName: fname}}
+
+		format, multipartResp := imageResponseFormat(c, req.ResponseFormat)
+		prompt := fmt.Sprintf("Create a variation of the attached image. Keep the same subject, composition and style, but vary the details. Use an aspect ratio of %s.", sizeToAspectRatio(req.Size))
+
+		gemini.RandomDelay()
+
+		results := collectImageResults(req.N, func(i int) imageResult {
+			extracted, refusal, err := generateImages(client, prompt, req.Model, files, format)
+			if format == "url" {
+				proxy.rewrite(c, extracted, accountID)
 			}
+			if err != nil {
+				log.Printf("[Images] Variation request %d failed: %v", i, err)
+			}
+			return imageResult{images: extracted, refusal: refusal, err: err}
+		})
+
+		respondImageResults(c, client, "Failed to generate image variations", results, req.N, multipartResp)
+	}
+}
+
+// generateImages 发送一次图片请求。没有拿到图片但 Gemini 回复了文字（拒绝或当作聊天回答）时，
+// 用 config.StrengthenImagePrompt 强化提示词重试，最多 config.ImageEmptyRetries() 次；
+// 返回的 refusal 为最后一次的文字回复，网络错误直接返回 err 不重试
+func generateImages(client *gemini.Client, prompt, model string, files []gemini.FileData, format string) ([]gin.H, string, error) {
+	retries := config.ImageEmptyRetries()
+	var refusal string
+	for attempt := 0; attempt <= retries; attempt++ {
+		p := prompt
+		if attempt > 0 {
+			log.Printf("[Images] Got text instead of an image, retrying with a stronger instruction (%d/%d)", attempt, retries)
+			p = config.StrengthenImagePrompt(prompt)
+			gemini.RandomDelay()
+		}
+
+		respBody, err := client.StreamGenerateContent(p, model, files, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		images, text := extractImagesFromResponse(respBody, format, client.Cookies)
+		respBody.Close()
+
+		if len(images) > 0 {
+			return images, "", nil
 		}
+		refusal = strings.TrimSpace(text)
+		if refusal == "" {
+			// 空回复不是拒绝，换提示词也无济于事
+			return nil, "", nil
+		}
+	}
+	return nil, refusal, nil
+}
+
+// imageResult 一次图片生成请求（序号固定）的结果
+type imageResult struct {
+	images  []gin.H
+	refusal string
+	err     error
+}
+
+// collectImageResults 凑齐 n 张图片所需的请求：Gemini 一次可能返回多张图片，先发一个请求，
+// 不够时再按缺少的张数补发（并发度同 runImageGenerations），请求总数不超过 n。
+// 失败或被拒绝的请求占一个位置，保证循环一定结束
+func collectImageResults(n int, generate func(i int) imageResult) []imageResult {
+	var results []imageResult
+	slots := 0
+	for slots < n && len(results) < n {
+		batch := min(n-slots, n-len(results))
+		if len(results) == 0 {
+			batch = 1
+		}
+		for _, r := range runImageGenerations(len(results), batch, generate) {
+			results = append(results, r)
+			slots += max(len(r.images), 1)
+		}
+	}
+	if len(results) < n {
+		log.Printf("[Images] Got %d images from %d request(s) for n=%d", slots, len(results), n)
+	}
+	return results
+}
+
+// runImageGenerations 执行序号 offset 起的 n 次图片生成，最多 config.ImageConcurrency() 个同时进行。
+// 除整次请求的第一个以外，每个请求发出前等待 IMAGE_REQUEST_DELAY，并发时各请求的发出时间同样错开。
+// 每个请求只写入自己序号对应的位置，返回的结果按请求序号排列，与完成先后无关
+func runImageGenerations(offset, n int, generate func(i int) imageResult) []imageResult {
+	results := make([]imageResult, n)
+	delayMin, delayMax := config.ImageRequestDelay()
+	pause := func(i int) {
+		if offset+i > 0 {
+			gemini.RandomDelayBetween(delayMin, delayMax)
+		}
+	}
+
+	concurrency := config.ImageConcurrency()
+	if concurrency > n {
+		concurrency = n
+	}
+	if concurrency <= 1 {
+		for i := range results {
+			pause(i)
+			results[i] = generate(offset + i)
+		}
+		return results
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		sem <- struct{}{}
+		pause(i)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = generate(offset + i)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
 
-		if len(images) == 0 {
-			errMsg := "Failed to generate images"
-			if len(errors) > 0 {
-				errMsg = strings.Join(errors, "; ")
+// respondImageResults 按请求序号拼接图片，最多返回 n 个条目。部分请求失败时在对应位置放入 error 条目而不是跳过，
+// 保证后续请求产出的图片不会前移；全部失败时交给 respondNoImages
+func respondImageResults(c *gin.Context, client *gemini.Client, fallback string, results []imageResult, n int, multipartResp bool) {
+	var images []gin.H
+	var errors, refusals []string
+	var throttled error
+	succeeded := false
+
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			errors = append(errors, r.err.Error())
+			if isThrottled(r.err) {
+				throttled = r.err
 			}
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": gin.H{
-					"message": errMsg,
-					"type":    "server_error",
-				},
-			})
-			return
+			images = append(images, imageErrorEntry(r.err.Error(), "server_error", ""))
+		case len(r.images) > 0:
+			images = append(images, r.images...)
+			succeeded = true
+		case r.refusal != "":
+			refusals = append(refusals, r.refusal)
+			images = append(images, imageErrorEntry("Gemini declined to generate an image: "+r.refusal, "invalid_request_error", "content_policy_violation"))
+		default:
+			errors = append(errors, "No images generated")
+			images = append(images, imageErrorEntry("No images generated", "server_error", ""))
 		}
+	}
+
+	if !succeeded {
+		respondNoImages(c, client, fallback, errors, refusals, throttled)
+		return
+	}
+
+	if len(images) > n {
+		images = images[:n]
+	}
+	respondImages(c, images, multipartResp)
+}
 
-		c.JSON(http.StatusOK, gin.H{
-			"created": time.Now().Unix(),
-			"data":    images,
+// imageErrorEntry data 中占位的失败条目
+func imageErrorEntry(message, errType, code string) gin.H {
+	e := gin.H{
+		"message": message,
+		"type":    errType,
+	}
+	if code != "" {
+		e["code"] = code
+	}
+	return gin.H{"error": e}
+}
+
+// respondNoImages 所有请求都没有产出图片：只要有网络/服务错误就按 500 返回（被限流时为 429），
+// 全部是文字拒绝时按 OpenAI 的内容策略错误返回 400 并带上 Gemini 的回复
+func respondNoImages(c *gin.Context, client *gemini.Client, fallback string, errors, refusals []string, throttled error) {
+	if len(errors) == 0 && len(refusals) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"message": "Gemini declined to generate an image: " + refusals[0],
+				"type":    "invalid_request_error",
+				"code":    "content_policy_violation",
+			},
 		})
+		return
 	}
+
+	errMsg := fallback
+	if len(errors) > 0 {
+		errMsg = strings.Join(errors, "; ")
+	}
+	if throttled != nil {
+		c.JSON(generateErrorStatus(c, client, throttled), gin.H{
+			"error": gin.H{
+				"message": errMsg,
+				"type":    "rate_limit_error",
+			},
+		})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error": gin.H{
+			"message": errMsg,
+			"type":    "server_error",
+		},
+	})
 }
 
-func extractImagesFromResponse(reader io.Reader, format string, cookies map[string]string) []gin.H {
+// extractImagesFromResponse 返回生成的图片以及回复中的文字（没有图片时用于识别拒绝）
+func extractImagesFromResponse(reader io.Reader, format string, cookies map[string]string) ([]gin.H, string) {
 	var images []gin.H
+	var text string
 
 	content, err := io.ReadAll(reader)
 	if err != nil {
 		log.Printf("[Images] Failed to read response: %v", err)
-		return images
+		return images, text
 	}
 
 	var allParts []gjson.Result
@@ -174,7 +478,7 @@ func extractImagesFromResponse(reader io.Reader, format string, cookies map[stri
 
 	if len(allParts) == 0 {
 		log.Printf("[Images] No parts found in response")
-		return images
+		return images, text
 	}
 
 	bodyIndex := -1
@@ -186,16 +490,22 @@ func extractImagesFromResponse(reader io.Reader, format string, cookies map[stri
 			continue
 		}
 		inner := gjson.Parse(dataStr)
-		if inner.Get("4").Exists() {
+		if !inner.Get("4").Exists() {
+			continue
+		}
+		if bodyIndex < 0 {
 			bodyIndex = i
 			body = inner
-			break
+		}
+		if t := inner.Get("4.0.1.0").String(); t != "" {
+			text = t
 		}
 	}
+	text, _ = gemini.NewOutputPipeline().Complete(text, "")
 
 	if bodyIndex < 0 || !body.Exists() {
 		log.Printf("[Images] No body found in response")
-		return images
+		return images, text
 	}
 
 	for i := bodyIndex; i < len(allParts); i++ {
@@ -227,11 +537,18 @@ func extractImagesFromResponse(reader io.Reader, format string, cookies map[stri
 			log.Printf("[Images] Found image %d URL: %s...", idx.Int(), fullSizeURL[:minInt(len(fullSizeURL), 60)])
 
 			if format == "url" {
-				images = append(images, gin.H{"url": fullSizeURL})
+				image := gin.H{"url": fullSizeURL}
+				addImageMetadata(image, genImg, int(idx.Int()), nil)
+				images = append(images, image)
 			} else {
-				data := fetchImageWithCookies(fullSizeURL, cookies)
-				if data != "" {
-					images = append(images, gin.H{"b64_json": data})
+				data := fetchImageBytes(fullSizeURL, cookies)
+				if len(data) > 0 {
+					image := gin.H{"b64_json": base64.StdEncoding.EncodeToString(data)}
+					if format == imageFormatBytes {
+						image = gin.H{imageBytesKey: data}
+					}
+					addImageMetadata(image, genImg, int(idx.Int()), data)
+					images = append(images, image)
 				}
 			}
 			return true
@@ -242,7 +559,7 @@ func extractImagesFromResponse(reader io.Reader, format string, cookies map[stri
 		}
 	}
 
-	return images
+	return images, text
 }
 
 func getNestedValue(data interface{}, path []int) interface{} {
@@ -269,15 +586,36 @@ func getNestedValueStr(data interface{}, path []int) string {
 	return str
 }
 
+// imageHTTPClient 在多次图片下载之间复用连接，避免 N>1 时每张图都重新握手。
+// Cookie 通过请求头逐次携带，不使用共享的 CookieJar。
+var imageHTTPClient = &http.Client{
+	Timeout: 60 * time.Second,
+	Transport: &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConns:        32,
+		MaxIdleConnsPerHost: 8,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		ForceAttemptHTTP2:   true,
+	},
+}
+
 func fetchImageWithCookies(url string, cookies map[string]string) string {
-	client := &http.Client{
-		Timeout: 60 * time.Second,
+	data := fetchImageBytes(url, cookies)
+	if len(data) == 0 {
+		return ""
 	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// fetchImageBytes 携带账号 Cookie 下载图片原始数据，失败时返回 nil
+func fetchImageBytes(url string, cookies map[string]string) []byte {
+	client := imageHTTPClient
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		log.Printf("[Images] Failed to create request: %v", err)
-		return ""
+		return nil
 	}
 
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
@@ -294,26 +632,22 @@ func fetchImageWithCookies(url string, cookies map[string]string) string {
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Printf("[Images] Failed to fetch image: %v", err)
-		return ""
+		return nil
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
 		log.Printf("[Images] Image fetch returned status %d for URL: %s", resp.StatusCode, url[:minInt(len(url), 80)])
-		return ""
+		return nil
 	}
 
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("[Images] Failed to read image data: %v", err)
-		return ""
+		return nil
 	}
 
-	if len(data) == 0 {
-		return ""
-	}
-
-	return base64.StdEncoding.EncodeToString(data)
+	return data
 }
 
 func minInt(a, b int) int {
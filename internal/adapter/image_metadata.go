@@ -0,0 +1,38 @@
+package adapter
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// addImageMetadata 把生成结果中能拿到的元数据写入 OpenAI 图片对象：
+// revised_prompt 取 Gemini 给图片生成的描述（genImg[3][5][idx]，缺失时取第一条），
+// width / height 在拿到图片数据时从文件头解析。Gemini Web 不返回 seed，因此不提供该字段。
+func addImageMetadata(img gin.H, genImg gjson.Result, idx int, data []byte) {
+	alt := genImg.Get(fmt.Sprintf("3.5.%d", idx)).String()
+	if alt == "" {
+		alt = genImg.Get("3.5.0").String()
+	}
+	if alt = strings.TrimSpace(alt); alt != "" {
+		img["revised_prompt"] = alt
+	}
+
+	if len(data) == 0 {
+		return
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	img["width"] = cfg.Width
+	img["height"] = cfg.Height
+	img["format"] = format
+}
@@ -0,0 +1,101 @@
+package adapter
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// imageFormatBytes 内部使用的 response_format：图片以原始字节保存在 imageBytesKey 下，供 multipart 响应使用
+const (
+	imageFormatBytes = "bytes"
+	imageBytesKey    = "bytes"
+)
+
+// imageResponseFormat 请求头 Accept 包含 multipart/mixed 时改为返回原始图片字节（url 格式除外），
+// 避免 base64 带来的约 33% 体积开销；返回实际使用的格式以及是否使用 multipart 响应
+func imageResponseFormat(c *gin.Context, requested string) (string, bool) {
+	if requested != "url" && strings.Contains(strings.ToLower(c.GetHeader("Accept")), "multipart/mixed") {
+		return imageFormatBytes, true
+	}
+	return requested, false
+}
+
+// respondImages 按 JSON（默认）或 multipart/mixed 返回生成的图片
+func respondImages(c *gin.Context, images []gin.H, multipartResp bool) {
+	if !multipartResp {
+		c.JSON(http.StatusOK, gin.H{
+			"created": time.Now().Unix(),
+			"data":    images,
+		})
+		return
+	}
+	writeMultipartImages(c, images)
+}
+
+// writeMultipartImages 第一部分为与 JSON 响应相同结构的元数据（不含图片数据），
+// 之后 data 中每个条目一个部分，顺序一致；失败的占位条目以 application/json 部分返回其 error
+func writeMultipartImages(c *gin.Context, images []gin.H) {
+	mw := multipart.NewWriter(c.Writer)
+	c.Header("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	c.Status(http.StatusOK)
+
+	meta := make([]gin.H, 0, len(images))
+	for _, img := range images {
+		m := gin.H{}
+		for k, v := range img {
+			if k != imageBytesKey {
+				m[k] = v
+			}
+		}
+		meta = append(meta, m)
+	}
+	metaJSON, _ := json.Marshal(gin.H{
+		"created": time.Now().Unix(),
+		"data":    meta,
+	})
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", "application/json")
+	if part, err := mw.CreatePart(h); err == nil {
+		part.Write(metaJSON)
+	}
+
+	for i, img := range images {
+		if errEntry, ok := img["error"]; ok {
+			h := make(textproto.MIMEHeader)
+			h.Set("Content-Type", "application/json")
+			part, err := mw.CreatePart(h)
+			if err != nil {
+				return
+			}
+			errJSON, _ := json.Marshal(gin.H{"error": errEntry})
+			part.Write(errJSON)
+			continue
+		}
+
+		data, _ := img[imageBytesKey].([]byte)
+		format, _ := img["format"].(string)
+		if format == "" {
+			format = "png"
+		}
+
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", "image/"+format)
+		h.Set("Content-Disposition", fmt.Sprintf(`inline; filename="image_%d.%s"`, i+1, format))
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			return
+		}
+		if _, err := part.Write(data); err != nil {
+			return
+		}
+	}
+	mw.Close()
+}
@@ -0,0 +1,58 @@
+package adapter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestImageGenerationsKeepIndexOrder(t *testing.T) {
+	t.Setenv("IMAGE_CONCURRENCY", "4")
+	t.Setenv("IMAGE_REQUEST_DELAY", "0")
+
+	const n = 4
+	// 序号越小完成得越晚，并发时完成顺序与请求顺序相反；第 2 个请求失败
+	results := runImageGenerations(0, n, func(i int) imageResult {
+		time.Sleep(time.Duration(n-i) * 20 * time.Millisecond)
+		if i == 1 {
+			return imageResult{err: errors.New("upstream failed")}
+		}
+		return imageResult{images: []gin.H{{"url": fmt.Sprintf("https://example.com/%d.png", i)}}}
+	})
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	respondImageResults(c, nil, "", results, n, false)
+
+	var resp struct {
+		Data []struct {
+			URL   string `json:"url"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v\n%s", err, w.Body.String())
+	}
+	if len(resp.Data) != n {
+		t.Fatalf("got %d entries, want %d: %s", len(resp.Data), n, w.Body.String())
+	}
+	for i, entry := range resp.Data {
+		if i == 1 {
+			if entry.Error == nil {
+				t.Fatalf("entry 1 = %+v, want an error placeholder", entry)
+			}
+			continue
+		}
+		if want := fmt.Sprintf("https://example.com/%d.png", i); entry.URL != want {
+			t.Fatalf("entry %d url = %q, want %q", i, entry.URL, want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package adapter
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gemini-web2api/internal/balancer"
+	"gemini-web2api/internal/config"
+	"gemini-web2api/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 存储键前缀：image_ref:<ref> 保存原始地址与所属账号，image_url:<sha256(原始地址)> 保存已分配的 ref，
+// 同一张图片多次返回时复用同一个代理地址
+const (
+	imageRefKeyPrefix = "image_ref:"
+	imageURLKeyPrefix = "image_url:"
+)
+
+// ImageProxyPath 图片代理接口的路径（位于 BASE_PATH 之下）
+const ImageProxyPath = "/images/proxy"
+
+// imageRef 代理地址对应的原始图片
+type imageRef struct {
+	URL       string `json:"url"`
+	AccountID string `json:"account_id"`
+}
+
+// ImageProxy 把 Gemini 的图片地址换成本服务的代理地址。Gemini 返回的图片地址需要账号 Cookie 且签名会过期，
+// 客户端稍后再访问时得到 403；代理地址每次访问都用生成图片的账号重新下载
+type ImageProxy struct {
+	pool  *balancer.AccountPool
+	store storage.Store
+}
+
+func NewImageProxy(pool *balancer.AccountPool, store storage.Store) *ImageProxy {
+	return &ImageProxy{pool: pool, store: store}
+}
+
+// rewrite 未开启 IMAGE_URL_PROXY 时不做任何处理，否则把 images 中的 url 换成代理地址
+func (p *ImageProxy) rewrite(c *gin.Context, images []gin.H, accountID string) {
+	if p == nil || !config.ImageURLProxy() {
+		return
+	}
+	base := imageProxyBaseURL(c)
+	for _, image := range images {
+		original, _ := image["url"].(string)
+		if original == "" {
+			continue
+		}
+		ref := p.register(original, accountID)
+		if ref == "" {
+			continue
+		}
+		image["url"] = base + ImageProxyPath + "?ref=" + ref
+	}
+}
+
+// register 返回 original 的 ref，已登记过的地址复用原来的 ref，同时刷新有效期
+func (p *ImageProxy) register(original, accountID string) string {
+	var ref string
+	if storage.GetJSON(p.store, imageURLKey(original), &ref) && ref != "" {
+		var entry imageRef
+		if storage.GetJSON(p.store, imageRefKeyPrefix+ref, &entry) {
+			p.touch(ref, entry)
+			return ref
+		}
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		log.Printf("[Images] Failed to generate proxy ref: %v", err)
+		return ""
+	}
+	ref = hex.EncodeToString(buf)
+	p.touch(ref, imageRef{URL: original, AccountID: accountID})
+	return ref
+}
+
+// touch 写入 ref 的两条记录并重新计算有效期
+func (p *ImageProxy) touch(ref string, entry imageRef) {
+	ttl := config.ImageProxyTTL()
+	storage.SetJSON(p.store, imageRefKeyPrefix+ref, entry, ttl)
+	storage.SetJSON(p.store, imageURLKey(entry.URL), ref, ttl)
+}
+
+func imageURLKey(original string) string {
+	sum := sha256.Sum256([]byte(original))
+	return imageURLKeyPrefix + hex.EncodeToString(sum[:])
+}
+
+// Handler GET /images/proxy?ref=...：用所属账号重新下载图片并返回原始数据。
+// 注册在鉴权之前，<img> 等无法携带 API Key 的客户端也能直接访问，ref 是随机生成的，无法猜测
+func (p *ImageProxy) Handler(c *gin.Context) {
+	ref := c.Query("ref")
+	var entry imageRef
+	if ref == "" || !storage.GetJSON(p.store, imageRefKeyPrefix+ref, &entry) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found or expired"})
+		return
+	}
+
+	client := p.pool.Get(entry.AccountID)
+	if client == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": fmt.Sprintf("Account '%s' is no longer available", displayAccountID(entry.AccountID))})
+		return
+	}
+	data, err := client.FetchImage(entry.URL)
+	if err != nil {
+		log.Printf("[Images] Proxy fetch failed for account '%s': %v", displayAccountID(entry.AccountID), err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch image: " + err.Error()})
+		return
+	}
+
+	// 访问过的图片重新计时，仍在使用的地址不会过期
+	p.touch(ref, entry)
+
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(config.ImageProxyTTL().Seconds())))
+	c.Data(http.StatusOK, http.DetectContentType(data), data)
+}
+
+// imageProxyBaseURL 代理地址的前缀：优先使用 IMAGE_PROXY_BASE_URL，否则由请求（含反向代理的 X-Forwarded-* 头）与 BASE_PATH 推断
+func imageProxyBaseURL(c *gin.Context) string {
+	if base := config.ImageProxyBaseURL(); base != "" {
+		return base
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := strings.TrimSpace(strings.Split(c.GetHeader("X-Forwarded-Proto"), ",")[0]); proto != "" {
+		scheme = proto
+	}
+	host := c.Request.Host
+	if fwd := strings.TrimSpace(strings.Split(c.GetHeader("X-Forwarded-Host"), ",")[0]); fwd != "" {
+		host = fwd
+	}
+	u := url.URL{Scheme: scheme, Host: host, Path: config.BasePath()}
+	return u.String()
+}
@@ -0,0 +1,69 @@
+package adapter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// audioSignatures 按文件头校验 input_audio 声明的格式，没有列出的格式只检查扩展名与大小
+var audioSignatures = map[string]func([]byte) bool{
+	"wav": func(b []byte) bool { return len(b) >= 12 && string(b[0:4]) == "RIFF" && string(b[8:12]) == "WAVE" },
+	"mp3": func(b []byte) bool {
+		return bytes.HasPrefix(b, []byte("ID3")) || (len(b) >= 2 && b[0] == 0xFF && b[1]&0xE0 == 0xE0)
+	},
+	"flac": func(b []byte) bool { return bytes.HasPrefix(b, []byte("fLaC")) },
+	"ogg":  func(b []byte) bool { return bytes.HasPrefix(b, []byte("OggS")) },
+	"webm": func(b []byte) bool { return bytes.HasPrefix(b, []byte{0x1A, 0x45, 0xDF, 0xA3}) },
+	"m4a":  isMP4Container,
+	"mp4":  isMP4Container,
+}
+
+func isMP4Container(b []byte) bool {
+	return len(b) >= 12 && string(b[4:8]) == "ftyp"
+}
+
+// prepareInputAudio 解码 OpenAI 的 input_audio 片段（{"data": base64, "format": "wav"}），
+// 校验格式、文件头与大小（与转写接口相同的 25MB 上限），返回音频数据与上传时使用的 MIME 和文件名
+func prepareInputAudio(part map[string]interface{}, nanos int64) ([]byte, string, string, error) {
+	audio, ok := part["input_audio"].(map[string]interface{})
+	if !ok {
+		return nil, "", "", fmt.Errorf("input_audio must be an object with data and format")
+	}
+	raw, _ := audio["data"].(string)
+	format, _ := audio["format"].(string)
+	format = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(format), "."))
+	if raw == "" || format == "" {
+		return nil, "", "", fmt.Errorf("input_audio requires both data and format")
+	}
+
+	mimeType, ok := audioMimeTypes["."+format]
+	if !ok {
+		return nil, "", "", fmt.Errorf("unsupported audio format '%s'", format)
+	}
+	if maxEncoded := maxAudioUploadSize/3*4 + 4; len(raw) > maxEncoded {
+		return nil, "", "", fmt.Errorf("audio is too large, maximum is %d bytes", maxAudioUploadSize)
+	}
+
+	var data []byte
+	var err error
+	if strings.HasPrefix(raw, "data:") {
+		data, _, err = decodeDataURL(raw)
+	} else {
+		data, err = decodeBase64Loose(raw)
+	}
+	if err != nil {
+		return nil, "", "", fmt.Errorf("invalid base64 audio data: %v", err)
+	}
+	if len(data) == 0 {
+		return nil, "", "", fmt.Errorf("audio data is empty")
+	}
+	if len(data) > maxAudioUploadSize {
+		return nil, "", "", fmt.Errorf("audio is too large (%d bytes), maximum is %d bytes", len(data), maxAudioUploadSize)
+	}
+	if matches, ok := audioSignatures[format]; ok && !matches(data) {
+		return nil, "", "", fmt.Errorf("audio data does not look like %s", format)
+	}
+
+	return data, mimeType, fmt.Sprintf("audio_%d.%s", nanos, format), nil
+}
@@ -0,0 +1,59 @@
+package adapter
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// logitBiasThreshold 绝对值达到该值的偏置才转换为提示词（OpenAI 取值范围 -100 ~ 100）
+const logitBiasThreshold = 50
+
+// prependLogitBiasInstruction 把 logit_bias 尽力转换为 "避免/优先使用某词" 的提示词指令。
+// Gemini Web 不支持 token 级偏置，也无法还原 OpenAI 的 token ID，
+// 因此只有以文字作为键的强偏置会生效，数字 token ID 会被忽略。
+func prependLogitBiasInstruction(prompt string, bias map[string]float64) string {
+	if len(bias) == 0 {
+		return prompt
+	}
+
+	var avoid, prefer []string
+	ignored := 0
+	for key, value := range bias {
+		word := strings.TrimSpace(key)
+		if word == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(word); err == nil {
+			ignored++
+			continue
+		}
+		switch {
+		case value <= -logitBiasThreshold:
+			avoid = append(avoid, fmt.Sprintf("%q", word))
+		case value >= logitBiasThreshold:
+			prefer = append(prefer, fmt.Sprintf("%q", word))
+		}
+	}
+	if ignored > 0 {
+		log.Printf("[LogitBias] Ignored %d token ID bias entries (not translatable for Gemini Web)", ignored)
+	}
+	if len(avoid) == 0 && len(prefer) == 0 {
+		return prompt
+	}
+
+	sort.Strings(avoid)
+	sort.Strings(prefer)
+
+	var instruction strings.Builder
+	instruction.WriteString("**System**:")
+	if len(avoid) > 0 {
+		instruction.WriteString(fmt.Sprintf(" Never use the following words: %s.", strings.Join(avoid, ", ")))
+	}
+	if len(prefer) > 0 {
+		instruction.WriteString(fmt.Sprintf(" Prefer using the following words where natural: %s.", strings.Join(prefer, ", ")))
+	}
+	return instruction.String() + "\n\n" + prompt
+}
@@ -0,0 +1,68 @@
+package adapter
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// bytesPerToken 与 count_tokens 接口一致，按 4 字节约 1 token 估算
+const bytesPerToken = 4
+
+// outputLimit 返回请求的输出上限，max_completion_tokens 优先于旧的 max_tokens，未设置时返回 0
+func (r ChatRequest) outputLimit() int {
+	if r.MaxCompletionTokens != nil && *r.MaxCompletionTokens > 0 {
+		return *r.MaxCompletionTokens
+	}
+	if r.MaxTokens != nil && *r.MaxTokens > 0 {
+		return *r.MaxTokens
+	}
+	return 0
+}
+
+// prependMaxTokensInstruction 把输出上限以提示词形式告知模型。
+// Gemini Web 没有 maxOutputTokens 参数，模型可能不遵守，实际上限由 tokenLimiter 保证
+func prependMaxTokensInstruction(prompt string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return prompt
+	}
+	return fmt.Sprintf("**System**: Keep your reply under %d tokens.\n\n%s", maxTokens, prompt)
+}
+
+// tokenLimiter 按估算的 token 数在服务端截断正文（思考过程不计入），
+// max 为 0 时不限制
+type tokenLimiter struct {
+	remaining int
+	limited   bool
+	exceeded  bool
+}
+
+func newTokenLimiter(maxTokens int) *tokenLimiter {
+	return &tokenLimiter{remaining: maxTokens * bytesPerToken, limited: maxTokens > 0}
+}
+
+// Take 返回 text 中仍在上限内的部分，超出部分被丢弃
+func (l *tokenLimiter) Take(text string) string {
+	if !l.limited || text == "" {
+		return text
+	}
+	if l.exceeded {
+		return ""
+	}
+	if len(text) <= l.remaining {
+		l.remaining -= len(text)
+		return text
+	}
+
+	cut := l.remaining
+	for cut > 0 && !utf8.RuneStart(text[cut]) {
+		cut--
+	}
+	l.remaining = 0
+	l.exceeded = true
+	return text[:cut]
+}
+
+// Exceeded 表示正文因达到上限被截断
+func (l *tokenLimiter) Exceeded() bool {
+	return l.exceeded
+}
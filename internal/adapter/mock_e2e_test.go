@@ -0,0 +1,509 @@
+package adapter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gemini-web2api/internal/balancer"
+	"gemini-web2api/internal/gemini"
+	"gemini-web2api/internal/mockgemini"
+	"gemini-web2api/internal/session"
+	"gemini-web2api/internal/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// newMockPool 启动 mockgemini 并返回只有一个账号、指向该 mock 的账号池（上传地址跟随 GEMINI_BASE_URL）
+func newMockPool(t *testing.T, fixture string) (*balancer.AccountPool, *mockgemini.Server) {
+	t.Helper()
+	mock, err := mockgemini.NewServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.Use(fixture); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(mock.Handler())
+	t.Cleanup(srv.Close)
+	t.Setenv("GEMINI_BASE_URL", srv.URL)
+
+	client, err := gemini.NewClient(map[string]string{"__Secure-1PSID": "mock"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Init(); err != nil {
+		t.Fatalf("init against mock: %v", err)
+	}
+	pool := balancer.NewAccountPool()
+	pool.Add(client, "default", "")
+	return pool, mock
+}
+
+func TestChatCompletionStreamAgainstMock(t *testing.T) {
+	pool, mock := newMockPool(t, "chat")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/v1/chat/completions", ChatCompletionHandler(pool, session.NewStore(storage.NewMemory(), time.Hour)))
+
+	api := httptest.NewServer(r)
+	defer api.Close()
+
+	body := `{"model":"gemini-2.5-flash","stream":true,"messages":[{"role":"user","content":"Say hello"}]}`
+	resp, err := http.Post(api.URL+"/v1/chat/completions", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	var content, reasoning strings.Builder
+	var finish string
+	done := false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content          string `json:"content"`
+					ReasoningContent string `json:"reasoning_content"`
+				} `json:"delta"`
+				FinishReason *string `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("bad chunk %q: %v", data, err)
+		}
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+			reasoning.WriteString(choice.Delta.ReasoningContent)
+			if choice.FinishReason != nil {
+				finish = *choice.FinishReason
+			}
+		}
+	}
+
+	if !done {
+		t.Fatal("stream did not end with data: [DONE]")
+	}
+	if got := content.String(); got != "Hello, world!" {
+		t.Fatalf("content = %q, want %q", got, "Hello, world!")
+	}
+	if !strings.HasPrefix(reasoning.String(), "Thinking about the greeting") {
+		t.Fatalf("reasoning = %q", reasoning.String())
+	}
+	if finish != "stop" {
+		t.Fatalf("finish_reason = %q, want stop", finish)
+	}
+	if reqs := mock.Requests(); len(reqs) != 1 || !strings.Contains(reqs[0], "Say hello") {
+		t.Fatalf("mock received %d request(s): %v", len(reqs), reqs)
+	}
+}
+
+// TestThoughtSignatureReplay 续接会话时把上一轮的签名发回上游，客户端回传的 reasoning_signature 优先
+func TestThoughtSignatureReplay(t *testing.T) {
+	pool, mock := newMockPool(t, "thought_signature")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/v1/chat/completions", ChatCompletionHandler(pool, session.NewStore(storage.NewMemory(), time.Hour)))
+	api := httptest.NewServer(r)
+	defer api.Close()
+
+	post := func(body string) map[string]any {
+		t.Helper()
+		resp, err := http.Post(api.URL+"/v1/chat/completions", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out map[string]any
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d: %v", resp.StatusCode, out)
+		}
+		return out
+	}
+	replayed := func(i int) string {
+		t.Helper()
+		reqs := mock.Requests()
+		if len(reqs) <= i {
+			t.Fatalf("mock received %d request(s)", len(reqs))
+		}
+		return gjson.Parse(gjson.Parse(reqs[i]).Get("1").String()).Get("2.3").String()
+	}
+
+	const want = "CqAbCdEf0123456789AbCdEf0123456789AbCdEf0123456789AbCdEf0123456789=="
+	first := post(`{"model":"gemini-2.5-pro","conversation_id":"conv-sig","messages":[{"role":"user","content":"Question"}]}`)
+	message := first["choices"].([]any)[0].(map[string]any)["message"].(map[string]any)
+	if sig := message["reasoning_signature"]; sig != want {
+		t.Fatalf("reasoning_signature = %v, want %q", sig, want)
+	}
+	if sig := replayed(0); sig != "" {
+		t.Fatalf("new conversation sent signature %q", sig)
+	}
+
+	post(`{"model":"gemini-2.5-pro","conversation_id":"conv-sig","messages":[{"role":"user","content":"Next"}]}`)
+	if sig := replayed(1); sig != want {
+		t.Fatalf("continuation sent signature %q, want the saved one", sig)
+	}
+
+	post(`{"model":"gemini-2.5-pro","conversation_id":"conv-sig","messages":[{"role":"assistant","content":"Answer: 42","reasoning_signature":"ClientSignature0123456789ClientSignature0123456789"},{"role":"user","content":"Again"}]}`)
+	if sig := replayed(2); sig != "ClientSignature0123456789ClientSignature0123456789" {
+		t.Fatalf("continuation sent signature %q, want the client's", sig)
+	}
+}
+
+// TestVideoUploadAgainstMock 内联视频经可续传协议上传到 mock，文件地址随提示词一起发送
+func TestVideoUploadAgainstMock(t *testing.T) {
+	pool, mock := newMockPool(t, "chat")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/v1/chat/completions", ChatCompletionHandler(pool, session.NewStore(storage.NewMemory(), time.Hour)))
+	api := httptest.NewServer(r)
+	defer api.Close()
+
+	video := base64.StdEncoding.EncodeToString(testMP4(5, true))
+	body := `{"model":"gemini-2.5-flash","messages":[{"role":"user","content":[{"type":"text","text":"Describe this"},{"type":"video_url","video_url":{"url":"data:video/mp4;base64,` + video + `"}}]}]}`
+	resp, err := http.Post(api.URL+"/v1/chat/completions", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if reqs := mock.Requests(); len(reqs) != 1 || !strings.Contains(reqs[0], "mock_uploaded_file") {
+		t.Fatalf("mock received %v, want the uploaded video reference", reqs)
+	}
+}
+
+// TestFailoverOnUpstreamError 默认的 ACCOUNT_FAILOVER 下，首字节之前的 5xx 换到另一个账号，客户端只看到成功的回答
+func TestFailoverOnUpstreamError(t *testing.T) {
+	pool, mock := newMockPool(t, "chat")
+
+	// 第二个账号指向只有 StreamGenerate 返回 502 的上游
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/StreamGenerate") {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		mock.Handler().ServeHTTP(w, r)
+	}))
+	defer broken.Close()
+	t.Setenv("GEMINI_BASE_URL", broken.URL)
+	brokenClient, err := gemini.NewClient(map[string]string{"__Secure-1PSID": "broken"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := brokenClient.Init(); err != nil {
+		t.Fatal(err)
+	}
+	pool.Add(brokenClient, "broken", "")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/v1/chat/completions", ChatCompletionHandler(pool, session.NewStore(storage.NewMemory(), time.Hour)))
+	api := httptest.NewServer(r)
+	defer api.Close()
+
+	// 轮询会依次落到两个账号上，每个请求都应该成功
+	for i := 0; i < 2; i++ {
+		resp, err := http.Post(api.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model":"gemini-2.5-flash","messages":[{"role":"user","content":"Say hello"}]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out struct {
+			Choices []struct {
+				Message struct {
+					Content string `json:"content"`
+				} `json:"message"`
+			} `json:"choices"`
+		}
+		json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || len(out.Choices) == 0 || out.Choices[0].Message.Content != "Hello, world!" {
+			t.Fatalf("request %d: status = %d, body = %+v", i, resp.StatusCode, out)
+		}
+	}
+
+	t.Setenv("ACCOUNT_FAILOVER", "throttle")
+	for i := 0; i < 2; i++ {
+		resp, err := http.Post(api.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model":"gemini-2.5-flash","messages":[{"role":"user","content":"Say hello"}]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			// throttle 模式下 5xx 不换号
+			return
+		}
+	}
+	t.Fatal("ACCOUNT_FAILOVER=throttle retried a 5xx on another account")
+}
+
+// newWrappedPool 返回只有一个账号的账号池，账号的上游为 wrap 包装后的 mock，便于按请求次序改变上游行为
+func newWrappedPool(t *testing.T, wrap func(mock http.Handler) http.Handler) *balancer.AccountPool {
+	t.Helper()
+	mock, err := mockgemini.NewServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(wrap(mock.Handler()))
+	t.Cleanup(srv.Close)
+	t.Setenv("GEMINI_BASE_URL", srv.URL)
+	t.Setenv("GEMINI_UPLOAD_URL", srv.URL+"/upload")
+
+	client, err := gemini.NewClient(map[string]string{"__Secure-1PSID": "mock"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Init(); err != nil {
+		t.Fatalf("init against mock: %v", err)
+	}
+	pool := balancer.NewAccountPool()
+	pool.Add(client, "default", "")
+	return pool
+}
+
+// TestClaudeStopReasonFromFixture Gemini 报告 MAX_TOKENS 时，Claude 的流式与非流式响应都返回 stop_reason: max_tokens
+func TestClaudeStopReasonFromFixture(t *testing.T) {
+	pool, _ := newMockPool(t, "max_tokens")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/v1/messages", ClaudeMessagesHandler(pool))
+	api := httptest.NewServer(r)
+	defer api.Close()
+
+	for _, stream := range []bool{false, true} {
+		body := fmt.Sprintf(`{"model":"gemini-2.5-flash","max_tokens":1024,"stream":%v,"messages":[{"role":"user","content":"Write a long answer"}]}`, stream)
+		resp, err := http.Post(api.URL+"/v1/messages", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("stream=%v: status = %d: %s", stream, resp.StatusCode, data)
+		}
+
+		var stopReason string
+		if !stream {
+			stopReason = gjson.GetBytes(data, "stop_reason").String()
+		} else {
+			for _, line := range strings.Split(string(data), "\n") {
+				if payload, ok := strings.CutPrefix(line, "data: "); ok && gjson.Get(payload, "type").String() == "message_delta" {
+					stopReason = gjson.Get(payload, "delta.stop_reason").String()
+				}
+			}
+		}
+		if stopReason != "max_tokens" {
+			t.Fatalf("stream=%v: stop_reason = %q, want max_tokens", stream, stopReason)
+		}
+	}
+}
+
+// TestAudioTranscriptionTooLarge 超出限制的请求体返回 413，而不是表单解析失败的 400
+func TestAudioTranscriptionTooLarge(t *testing.T) {
+	pool, mock := newMockPool(t, "chat")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/v1/audio/transcriptions", AudioTranscriptionHandler(pool))
+	api := httptest.NewServer(r)
+	defer api.Close()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("model", "whisper-1")
+	part, _ := form.CreateFormFile("file", "speech.mp3")
+	part.Write(make([]byte, maxAudioUploadSize+2*1024*1024))
+	form.Close()
+
+	resp, err := http.Post(api.URL+"/v1/audio/transcriptions", form.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", resp.StatusCode)
+	}
+	if reqs := mock.Requests(); len(reqs) != 0 {
+		t.Fatalf("mock received %d requests, want none", len(reqs))
+	}
+}
+
+// TestClaudeToolUseAgainstMock 工具说明与 tool_choice 写入提示词，模型输出的 <tool_use> 块在流式与非流式响应中
+// 都转换为 tool_use 内容块并以 stop_reason: tool_use 结束
+func TestClaudeToolUseAgainstMock(t *testing.T) {
+	pool, mock := newMockPool(t, "tool_use")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/v1/messages", ClaudeMessagesHandler(pool))
+	api := httptest.NewServer(r)
+	defer api.Close()
+
+	for _, stream := range []bool{false, true} {
+		body := fmt.Sprintf(`{"model":"gemini-2.5-flash","max_tokens":1024,"stream":%v,
+			"tools":[{"name":"get_weather","description":"Current weather","input_schema":{"type":"object","properties":{"city":{"type":"string"}}}}],
+			"tool_choice":{"type":"any"},
+			"messages":[{"role":"user","content":"What is the weather in Paris?"}]}`, stream)
+		resp, err := http.Post(api.URL+"/v1/messages", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("stream=%v: status = %d: %s", stream, resp.StatusCode, data)
+		}
+
+		var text, name, input, stopReason string
+		if !stream {
+			for _, block := range gjson.GetBytes(data, "content").Array() {
+				switch block.Get("type").String() {
+				case "text":
+					text += block.Get("text").String()
+				case "tool_use":
+					name, input = block.Get("name").String(), block.Get("input").Raw
+					if !strings.HasPrefix(block.Get("id").String(), "toolu_") {
+						t.Fatalf("tool_use id = %q", block.Get("id").String())
+					}
+				}
+			}
+			stopReason = gjson.GetBytes(data, "stop_reason").String()
+		} else {
+			for _, line := range strings.Split(string(data), "\n") {
+				payload, ok := strings.CutPrefix(line, "data: ")
+				if !ok {
+					continue
+				}
+				event := gjson.Parse(payload)
+				switch event.Get("type").String() {
+				case "content_block_start":
+					if event.Get("content_block.type").String() == "tool_use" {
+						name = event.Get("content_block.name").String()
+					}
+				case "content_block_delta":
+					text += event.Get("delta.text").String()
+					input += event.Get("delta.partial_json").String()
+				case "message_delta":
+					stopReason = event.Get("delta.stop_reason").String()
+				}
+			}
+		}
+
+		if name != "get_weather" || gjson.Get(input, "city").String() != "Paris" {
+			t.Fatalf("stream=%v: tool_use = %s %s, want get_weather {\"city\":\"Paris\"}", stream, name, input)
+		}
+		if strings.Contains(text, "<tool_use") || !strings.Contains(text, "Let me check the weather.") {
+			t.Fatalf("stream=%v: text = %q, want the text before the tool call only", stream, text)
+		}
+		if stopReason != "tool_use" {
+			t.Fatalf("stream=%v: stop_reason = %q, want tool_use", stream, stopReason)
+		}
+	}
+
+	reqs := mock.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("mock received %d requests, want 2", len(reqs))
+	}
+	for _, req := range reqs {
+		if !strings.Contains(req, "get_weather") || !strings.Contains(req, "You MUST call at least one tool") {
+			t.Fatalf("prompt does not describe the tools and tool_choice: %s", req)
+		}
+	}
+}
+
+// TestClaudeTruncatedStream 上游在回答中途断开时，非流式 Claude 响应返回已收到的部分并以 max_tokens 结束，
+// 没有收到任何正文时返回 500，而不是 200 与空的 end_turn 回答
+func TestClaudeTruncatedStream(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		fixture    string
+		wantStatus int
+		wantText   string
+	}{
+		{name: "partial", fixture: "truncated", wantStatus: http.StatusOK, wantText: "Hello, this answer was cut"},
+		{name: "empty", wantStatus: http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pool := newWrappedPool(t, func(mock http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if !strings.HasSuffix(r.URL.Path, "/StreamGenerate") {
+						mock.ServeHTTP(w, r)
+						return
+					}
+					if tc.fixture != "" {
+						r.URL.RawQuery += "&fixture=" + tc.fixture
+						mock.ServeHTTP(w, r)
+					} else {
+						// 只有一帧没有正文的快照
+						io.WriteString(w, ")]}'\n\n"+`[["wrb.fr", null, "[null, [\"c_mock\", \"r_mock\"], null, null, [[\"rc_mock\", [\"\"]]]]"]]`+"\n")
+						w.(http.Flusher).Flush()
+					}
+					// 已发送部分响应后中断连接，客户端读取时得到 unexpected EOF
+					panic(http.ErrAbortHandler)
+				})
+			})
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.POST("/v1/messages", ClaudeMessagesHandler(pool))
+			api := httptest.NewServer(r)
+			defer api.Close()
+
+			body := `{"model":"gemini-2.5-flash","max_tokens":1024,"messages":[{"role":"user","content":"Hi"}]}`
+			resp, err := http.Post(api.URL+"/v1/messages", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != tc.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tc.wantStatus, data)
+			}
+			if tc.wantStatus != http.StatusOK {
+				if got := gjson.GetBytes(data, "error.type").String(); got != "api_error" {
+					t.Fatalf("error.type = %q, want api_error: %s", got, data)
+				}
+				return
+			}
+			if got := gjson.GetBytes(data, "content.0.text").String(); got != tc.wantText {
+				t.Fatalf("text = %q, want %q", got, tc.wantText)
+			}
+			if got := gjson.GetBytes(data, "stop_reason").String(); got != "max_tokens" {
+				t.Fatalf("stop_reason = %q, want max_tokens", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package adapter
+
+import (
+	"gemini-web2api/internal/claude"
+	"gemini-web2api/internal/config"
+	"log"
+)
+
+// applyModelDefaults 把 MODEL_DEFAULTS 中的默认参数填入请求中未设置的字段，请求显式给出的值优先，
+// 最后把越界的采样参数收敛到 Gemini 的有效范围。thinking 转换为 reasoning_effort（与 Claude 的 thinking.type 对应），
+// 需要在 applyReasoningEffort 之前调用，才能同样切换到 -no-thinking 变体
+func (r *ChatRequest) applyModelDefaults(mappedModel string) {
+	d := config.ModelDefaultsFor(r.Model, mappedModel)
+
+	if r.Temperature == nil {
+		r.Temperature = d.Temperature
+	}
+	if r.TopP == nil {
+		r.TopP = d.TopP
+	}
+	if r.TopK == nil {
+		r.TopK = d.TopK
+	}
+	if r.MaxTokens == nil && r.MaxCompletionTokens == nil {
+		r.MaxTokens = d.MaxTokens
+	}
+	if d.Thinking != nil && r.ReasoningEffort == "" && r.ThinkingBudget == nil {
+		if *d.Thinking {
+			r.ReasoningEffort = "high"
+		} else {
+			r.ReasoningEffort = "none"
+		}
+	}
+	config.ClampSampling("OpenAI", r.Temperature, r.TopP, r.TopK)
+}
+
+// applyClaudeModelDefaults 与 applyModelDefaults 相同，作用于 Claude 请求，返回实际使用的模型：
+// 请求没有 thinking 时按默认的 thinking 切换映射后模型的 -no-thinking 变体（与 reasoning_effort 相同）。
+// Gemini Web 无法限制思考长度，thinking_budget 只写入 thinking.budget_tokens，不改变上游行为
+func applyClaudeModelDefaults(req *claude.ClaudeRequest, mappedModel string) string {
+	d := config.ModelDefaultsFor(req.Model, mappedModel)
+
+	if req.Temperature == nil {
+		req.Temperature = d.Temperature
+	}
+	if req.TopP == nil {
+		req.TopP = d.TopP
+	}
+	if req.TopK == nil {
+		req.TopK = d.TopK
+	}
+	if req.MaxTokens == nil {
+		req.MaxTokens = d.MaxTokens
+	}
+	if req.Thinking == nil && d.Thinking != nil {
+		if variant := thinkingVariant(mappedModel, *d.Thinking); variant != mappedModel {
+			log.Printf("[Claude] MODEL_DEFAULTS thinking=%v, using %s", *d.Thinking, variant)
+			mappedModel = variant
+		}
+		if *d.Thinking {
+			req.Thinking = &claude.ThinkingConfig{Type: "enabled", BudgetTokens: d.ThinkingBudget}
+		} else {
+			req.Thinking = &claude.ThinkingConfig{Type: "disabled"}
+			if req.ThinkingVisibility == "" {
+				req.ThinkingVisibility = config.ThinkingHide
+			}
+		}
+	} else if req.Thinking != nil && req.Thinking.Type == "enabled" && req.Thinking.BudgetTokens == nil {
+		req.Thinking.BudgetTokens = d.ThinkingBudget
+	}
+	config.ClampSampling("Claude", req.Temperature, req.TopP, req.TopK)
+	return mappedModel
+}
@@ -0,0 +1,50 @@
+package adapter
+
+import (
+	"testing"
+
+	"gemini-web2api/internal/claude"
+	"gemini-web2api/internal/config"
+)
+
+// TestModelDefaultsThinkingOnBothProtocols MODEL_DEFAULTS 的 thinking 同时作用于 OpenAI 与 Claude 请求，请求显式给出的值优先
+func TestModelDefaultsThinkingOnBothProtocols(t *testing.T) {
+	t.Setenv("MODEL_DEFAULTS", `{"gemini-3-flash-preview":{"thinking":false,"top_k":20}}`)
+	config.LoadModelDefaults()
+	t.Cleanup(func() {
+		t.Setenv("MODEL_DEFAULTS", "")
+		config.LoadModelDefaults()
+	})
+
+	req := ChatRequest{Model: "gemini-3-flash-preview"}
+	req.applyModelDefaults(req.Model)
+	req.applyReasoningEffort()
+	if req.Model != "gemini-3-flash-preview-no-thinking" || req.ThinkingVisibility != config.ThinkingHide {
+		t.Fatalf("OpenAI request = %+v, want the no-thinking variant with thinking hidden", req)
+	}
+	if req.TopK == nil || *req.TopK != 20 {
+		t.Fatalf("OpenAI top_k = %v, want 20", req.TopK)
+	}
+
+	req = ChatRequest{Model: "gemini-3-flash-preview", ReasoningEffort: "high"}
+	req.applyModelDefaults(req.Model)
+	req.applyReasoningEffort()
+	if req.Model != "gemini-3-flash-preview" || req.ThinkingVisibility != "" {
+		t.Fatalf("OpenAI request = %+v, want the explicit reasoning_effort to win", req)
+	}
+
+	// Claude 模型名按映射后的名称查找默认值并切换变体
+	creq := claude.ClaudeRequest{Model: "claude-haiku-4-5"}
+	model := applyClaudeModelDefaults(&creq, config.MapModel(creq.Model))
+	if model != "gemini-3-flash-preview-no-thinking" {
+		t.Fatalf("Claude model = %q, want the no-thinking variant", model)
+	}
+	if creq.Thinking == nil || creq.Thinking.Type != "disabled" || creq.ThinkingVisibility != config.ThinkingHide {
+		t.Fatalf("Claude request = %+v, want thinking disabled and hidden", creq)
+	}
+
+	creq = claude.ClaudeRequest{Model: "gemini-3-flash-preview", Thinking: &claude.ThinkingConfig{Type: "enabled"}}
+	if model := applyClaudeModelDefaults(&creq, creq.Model); model != "gemini-3-flash-preview" {
+		t.Fatalf("Claude model = %q, want the explicit thinking to win", model)
+	}
+}
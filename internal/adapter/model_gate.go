@@ -0,0 +1,86 @@
+package adapter
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gemini-web2api/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// modelGate 按映射后的模型限制同时进行的请求数（MODEL_CONCURRENCY），与账号无关：
+// Pro 模型更慢、更容易触发风控，可以设得比 Flash 低，避免一批 Pro 请求拖慢所有账号。
+// 占用从选定账号之前开始，直到响应（包括流式输出）结束
+type modelGate struct {
+	once   sync.Once
+	limits map[string]int
+	mu     sync.Mutex
+	slots  map[string]chan struct{}
+}
+
+var modelGates = &modelGate{}
+
+// slotsFor 返回 model 的并发槽位，未配置上限时返回 nil
+func (g *modelGate) slotsFor(model string) chan struct{} {
+	g.once.Do(func() {
+		g.limits = config.ModelConcurrencyLimits()
+		g.slots = make(map[string]chan struct{})
+		for model, n := range g.limits {
+			log.Printf("[Config] Model concurrency: %s -> %d", model, n)
+		}
+	})
+	key := strings.ToLower(model)
+	n, ok := g.limits[key]
+	if !ok {
+		n, ok = g.limits[config.ModelConcurrencyAny]
+	}
+	if !ok {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	slots, ok := g.slots[key]
+	if !ok {
+		slots = make(chan struct{}, n)
+		g.slots[key] = slots
+	}
+	return slots
+}
+
+// acquireModelSlot 占用 model 的一个并发槽位，已满时最多排队 MODEL_CONCURRENCY_WAIT，客户端断开时放弃。
+// 拿不到槽位时设置 Retry-After 并返回错误信息，调用方按各自协议的格式返回 429；成功时返回的 release 必须调用
+func acquireModelSlot(c *gin.Context, model string) (release func(), msg string) {
+	slots := modelGates.slotsFor(model)
+	if slots == nil {
+		return func() {}, ""
+	}
+	release = func() { <-slots }
+
+	select {
+	case slots <- struct{}{}:
+		return release, ""
+	default:
+	}
+
+	wait := config.ModelConcurrencyWait()
+	if wait > 0 {
+		log.Printf("[Concurrency] Model '%s' is at its concurrency limit (%d), queuing", model, cap(slots))
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case slots <- struct{}{}:
+			return release, ""
+		case <-timer.C:
+		case <-c.Request.Context().Done():
+		}
+	}
+	log.Printf("[Concurrency] Rejected request for model '%s': %d request(s) already in progress", model, cap(slots))
+	c.Header("Retry-After", strconv.Itoa(max(int(wait.Seconds()), 1)))
+	return nil, fmt.Sprintf("Too many concurrent requests for model '%s' (limit %d), please retry later", model, cap(slots))
+}
@@ -0,0 +1,117 @@
+package adapter
+
+import (
+	"gemini-web2api/internal/balancer"
+	"gemini-web2api/internal/config"
+	"gemini-web2api/internal/gemini"
+	"log"
+	"sync"
+	"time"
+)
+
+// ModelProber 用极短的真实请求探测各模型是否可用，结果缓存供 /v1/models 过滤列表。
+// 同一时间最多进行一轮探测，live 模式下结果过期后由列表请求在后台触发刷新，不阻塞响应
+type ModelProber struct {
+	pool *balancer.AccountPool
+	mode string
+	ttl  time.Duration
+
+	mu        sync.Mutex
+	broken    map[string]string
+	checkedAt time.Time
+	probing   bool
+}
+
+func NewModelProber(pool *balancer.AccountPool) *ModelProber {
+	return &ModelProber{
+		pool:   pool,
+		mode:   config.ModelProbeMode(),
+		ttl:    config.ModelProbeTTL(),
+		broken: make(map[string]string),
+	}
+}
+
+// Enabled 报告是否开启了 MODEL_PROBE
+func (p *ModelProber) Enabled() bool {
+	return p != nil && p.mode != config.ModelProbeOff
+}
+
+// Probe 同步探测一轮，已有探测在进行时直接返回
+func (p *ModelProber) Probe() {
+	if !p.Enabled() || !p.begin() {
+		return
+	}
+	p.run()
+}
+
+// Available 报告模型是否可用：未开启探测或尚未探测到的模型视为可用。live 模式下结果过期时触发后台刷新
+func (p *ModelProber) Available(model string) bool {
+	if !p.Enabled() {
+		return true
+	}
+
+	p.mu.Lock()
+	_, broken := p.broken[model]
+	stale := p.mode == config.ModelProbeLive && !p.checkedAt.IsZero() && time.Since(p.checkedAt) >= p.ttl
+	p.mu.Unlock()
+
+	if stale && p.begin() {
+		go p.run()
+	}
+	return !broken
+}
+
+func (p *ModelProber) begin() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.probing {
+		return false
+	}
+	p.probing = true
+	return true
+}
+
+func (p *ModelProber) run() {
+	defer func() {
+		p.mu.Lock()
+		p.probing = false
+		p.mu.Unlock()
+	}()
+
+	if client, _ := p.pool.Next(); client == nil {
+		log.Printf("[Models] No available accounts, skipping model probe")
+		return
+	}
+
+	broken := make(map[string]string)
+	probed := 0
+	for _, model := range openAIModels {
+		// 图片模型对文字提示词可能只返回图片，无法用 SelfTest 判断，不探测
+		if isImageModel(model.ID) || !hasModelHeaders(model.ID) {
+			continue
+		}
+		if probed > 0 {
+			gemini.RandomDelay()
+		}
+		probed++
+		if _, err := SelfTest(p.pool, model.ID); err != nil {
+			if isThrottled(err) {
+				// 限流与模型本身无关，沿用上一轮结果
+				p.mu.Lock()
+				if reason, ok := p.broken[model.ID]; ok {
+					broken[model.ID] = reason
+				}
+				p.mu.Unlock()
+				continue
+			}
+			broken[model.ID] = err.Error()
+			log.Printf("[Models] %s is unavailable: %v", model.ID, err)
+		}
+	}
+
+	p.mu.Lock()
+	p.broken = broken
+	p.checkedAt = time.Now()
+	p.mu.Unlock()
+	log.Printf("[Models] Probed %d model(s), %d unavailable", probed, len(broken))
+}
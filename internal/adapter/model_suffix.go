@@ -0,0 +1,134 @@
+package adapter
+
+import (
+	"gemini-web2api/internal/claude"
+	"gemini-web2api/internal/config"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// modelSuffixOptions 模型名后缀中的请求级参数，例如 gemini-3-flash-preview#t=0.2#think=off，
+// 供只能填写模型名、无法设置请求体参数的客户端使用
+type modelSuffixOptions struct {
+	Temperature *float64
+	TopP        *float64
+	TopK        *int
+	Thinking    *bool
+}
+
+// splitModelSuffix 拆出 # 之后的参数，多个参数用 # 或 , 分隔：
+// t / temperature、p / top_p、k / top_k、think / thinking（on / off）。
+// 不带 # 的模型名原样返回，无法识别的参数记录日志后忽略
+func splitModelSuffix(model string) (string, modelSuffixOptions) {
+	var opts modelSuffixOptions
+	idx := strings.Index(model, "#")
+	if idx < 0 {
+		return model, opts
+	}
+
+	base := strings.TrimSpace(model[:idx])
+	params := strings.FieldsFunc(model[idx+1:], func(r rune) bool { return r == '#' || r == ',' })
+	for _, param := range params {
+		key, value, _ := strings.Cut(param, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		var err error
+		switch key {
+		case "t", "temp", "temperature":
+			opts.Temperature, err = parseSuffixFloat(value)
+		case "p", "top_p":
+			opts.TopP, err = parseSuffixFloat(value)
+		case "k", "top_k":
+			var n int
+			if n, err = strconv.Atoi(value); err == nil {
+				opts.TopK = &n
+			}
+		case "think", "thinking":
+			var on bool
+			if on, err = parseSuffixBool(value); err == nil {
+				opts.Thinking = &on
+			}
+		default:
+			log.Printf("[Model] Unknown model suffix parameter '%s' in %s, ignored", param, model)
+			continue
+		}
+		if err != nil {
+			log.Printf("[Model] Invalid model suffix parameter '%s' in %s, ignored", param, model)
+		}
+	}
+	return base, opts
+}
+
+func parseSuffixFloat(value string) (*float64, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func parseSuffixBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "1", "true", "yes":
+		return true, nil
+	case "off", "0", "false", "no":
+		return false, nil
+	}
+	return false, strconv.ErrSyntax
+}
+
+// applyModelSuffix 拆出模型名后缀并填入请求中未显式设置的字段；think 转换为 reasoning_effort
+func (r *ChatRequest) applyModelSuffix() {
+	var opts modelSuffixOptions
+	r.Model, opts = splitModelSuffix(r.Model)
+
+	if r.Temperature == nil {
+		r.Temperature = opts.Temperature
+	}
+	if r.TopP == nil {
+		r.TopP = opts.TopP
+	}
+	if r.TopK == nil {
+		r.TopK = opts.TopK
+	}
+	if opts.Thinking != nil && r.ReasoningEffort == "" {
+		if *opts.Thinking {
+			r.ReasoningEffort = "high"
+		} else {
+			r.ReasoningEffort = "none"
+		}
+	}
+}
+
+// applyClaudeModelSuffix 与 applyModelSuffix 相同，作用于 Claude 请求：think 与 OpenAI 路径一样切换模型的
+// -no-thinking 变体（off 时默认不输出思考过程），并记录为 thinking 配置，使其优先于 MODEL_DEFAULTS
+func applyClaudeModelSuffix(req *claude.ClaudeRequest) {
+	var opts modelSuffixOptions
+	req.Model, opts = splitModelSuffix(req.Model)
+
+	if req.Temperature == nil {
+		req.Temperature = opts.Temperature
+	}
+	if req.TopP == nil {
+		req.TopP = opts.TopP
+	}
+	if req.TopK == nil {
+		req.TopK = opts.TopK
+	}
+	if opts.Thinking != nil && req.Thinking == nil {
+		if variant := thinkingVariant(req.Model, *opts.Thinking); variant != req.Model {
+			log.Printf("[Claude] Model suffix think=%v, using %s", *opts.Thinking, variant)
+			req.Model = variant
+		}
+		if *opts.Thinking {
+			req.Thinking = &claude.ThinkingConfig{Type: "enabled"}
+		} else {
+			req.Thinking = &claude.ThinkingConfig{Type: "disabled"}
+			if req.ThinkingVisibility == "" {
+				req.ThinkingVisibility = config.ThinkingHide
+			}
+		}
+	}
+}
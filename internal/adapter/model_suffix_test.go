@@ -0,0 +1,48 @@
+package adapter
+
+import (
+	"testing"
+
+	"gemini-web2api/internal/claude"
+)
+
+// TestModelSuffixAppliesToBothProtocols 同一个带后缀的模型名在 OpenAI 与 Claude 请求中得到相同的参数
+func TestModelSuffixAppliesToBothProtocols(t *testing.T) {
+	const model = "gemini-3.1-pro-preview#t=0.7,k=40#think=off"
+
+	req := ChatRequest{Model: model}
+	req.applyModelSuffix()
+	if req.Model != "gemini-3.1-pro-preview" {
+		t.Fatalf("OpenAI model = %q", req.Model)
+	}
+	if req.Temperature == nil || *req.Temperature != 0.7 || req.TopK == nil || *req.TopK != 40 || req.ReasoningEffort != "none" {
+		t.Fatalf("OpenAI request = %+v, want t=0.7 k=40 reasoning_effort=none", req)
+	}
+
+	creq := claude.ClaudeRequest{Model: model}
+	applyClaudeModelSuffix(&creq)
+	if creq.Model != "gemini-3.1-pro-preview" {
+		t.Fatalf("Claude model = %q", creq.Model)
+	}
+	if creq.Temperature == nil || *creq.Temperature != 0.7 || creq.TopK == nil || *creq.TopK != 40 ||
+		creq.Thinking == nil || creq.Thinking.Type != "disabled" {
+		t.Fatalf("Claude request = %+v, want t=0.7 k=40 thinking disabled", creq)
+	}
+
+	// 与 OpenAI 路径的 reasoning_effort 一样切换到 -no-thinking 变体
+	req = ChatRequest{Model: "gemini-3-flash-preview#think=off"}
+	req.applyModelSuffix()
+	req.applyReasoningEffort()
+	creq = claude.ClaudeRequest{Model: "gemini-3-flash-preview#think=off"}
+	applyClaudeModelSuffix(&creq)
+	if req.Model != "gemini-3-flash-preview-no-thinking" || creq.Model != req.Model {
+		t.Fatalf("OpenAI model = %q, Claude model = %q, want gemini-3-flash-preview-no-thinking", req.Model, creq.Model)
+	}
+
+	explicit := 5
+	req = ChatRequest{Model: model, TopK: &explicit}
+	req.applyModelSuffix()
+	if *req.TopK != 5 {
+		t.Fatalf("top_k = %d, want the explicit value 5", *req.TopK)
+	}
+}
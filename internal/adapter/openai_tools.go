@@ -0,0 +1,419 @@
+package adapter
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+
+	"gemini-web2api/internal/ids"
+)
+
+// OpenAITool OpenAI 请求中的 tools 定义
+type OpenAITool struct {
+	Type     string         `json:"type"`
+	Function OpenAIFunction `json:"function"`
+}
+
+type OpenAIFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// OpenAIToolCall 响应中 message.tool_calls 的元素
+type OpenAIToolCall struct {
+	ID       string             `json:"id"`
+	Type     string             `json:"type"`
+	Function OpenAIFunctionCall `json:"function"`
+}
+
+type OpenAIFunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// Gemini Web 没有原生函数调用，工具通过提示词描述，模型按约定的标签格式输出调用，
+// 与 Claude 路径在历史消息中使用的 <tool_use> 格式保持一致
+const (
+	toolUseOpenTag  = "<tool_use"
+	toolUseCloseTag = "</tool_use>"
+)
+
+var toolUseNameRegex = regexp.MustCompile(`name\s*=\s*"([^"]*)"`)
+
+// parallelToolCalls 返回是否允许一次回复包含多个工具调用，与 OpenAI 一致默认允许
+func (r ChatRequest) parallelToolCalls() bool {
+	return r.ParallelToolCalls == nil || *r.ParallelToolCalls
+}
+
+// buildToolsInstruction 根据 tools 与 tool_choice 生成工具说明，不需要工具时返回空串；
+// parallel 为 false（parallel_tool_calls: false）时要求每次回复最多调用一个工具
+func buildToolsInstruction(tools []OpenAITool, toolChoice interface{}, parallel bool) string {
+	if len(tools) == 0 {
+		return ""
+	}
+
+	var requirement string
+	switch v := toolChoice.(type) {
+	case string:
+		switch v {
+		case "none":
+			return ""
+		case "required":
+			requirement = "You MUST call at least one tool in this reply."
+		}
+	case map[string]interface{}:
+		if fn, ok := v["function"].(map[string]interface{}); ok {
+			if name, _ := fn["name"].(string); name != "" {
+				requirement = fmt.Sprintf("You MUST call the tool %q in this reply.", name)
+			}
+		}
+	}
+
+	defs := make([]map[string]interface{}, 0, len(tools))
+	for _, tool := range tools {
+		if tool.Function.Name == "" {
+			continue
+		}
+		def := map[string]interface{}{"name": tool.Function.Name}
+		if tool.Function.Description != "" {
+			def["description"] = tool.Function.Description
+		}
+		if len(tool.Function.Parameters) > 0 {
+			def["parameters"] = tool.Function.Parameters
+		}
+		defs = append(defs, def)
+	}
+	if len(defs) == 0 {
+		return ""
+	}
+	defsJSON, _ := json.Marshal(defs)
+
+	var b strings.Builder
+	b.WriteString("**System**: You can call the following tools (JSON Schema definitions):\n")
+	b.Write(defsJSON)
+	b.WriteString("\n\nTo call a tool, output a block exactly in this form, with the arguments as a single JSON object:\n")
+	b.WriteString(`<tool_use name="TOOL_NAME">{"arg": "value"}</tool_use>`)
+	if parallel {
+		b.WriteString("\nYou may output several blocks to call several tools.")
+	} else {
+		b.WriteString("\nCall at most one tool per reply: output a single block, then wait for its result before calling another tool.")
+	}
+	b.WriteString(" Do not write anything after the tool calls; wait for the results, which will be provided in <tool_result> blocks. If no tool is needed, answer normally.")
+	if requirement != "" {
+		b.WriteString(" ")
+		b.WriteString(requirement)
+	}
+	b.WriteString("\n\n")
+	return b.String()
+}
+
+// formatToolCalls 把助手消息中的 tool_calls 还原为模型输出时使用的 <tool_use> 块，
+// 与 Claude 路径中 tool_use 历史的写法一致
+func formatToolCalls(calls []OpenAIToolCall) string {
+	var b strings.Builder
+	for _, call := range calls {
+		args := strings.TrimSpace(call.Function.Arguments)
+		if args == "" {
+			args = "{}"
+		}
+		b.WriteString(fmt.Sprintf("<tool_use id=\"%s\" name=\"%s\">%s</tool_use>", call.ID, call.Function.Name, args))
+	}
+	return b.String()
+}
+
+// formatToolResult 把 role 为 tool（以及旧版 function）的消息转换为用户轮次中的 <tool_result> 块，
+// 与 Claude 路径处理 tool_result 的方式一致
+func formatToolResult(msg ChatMessage) string {
+	attrs := fmt.Sprintf(` id="%s"`, msg.ToolCallID)
+	if msg.Name != "" {
+		attrs += fmt.Sprintf(` name="%s"`, msg.Name)
+	}
+	return fmt.Sprintf("**User**: <tool_result%s>%s</tool_result>", attrs, messageText(msg.Content))
+}
+
+// messageText 取出消息内容中的文本，content 可以是字符串或 OpenAI 的内容片段数组
+func messageText(content interface{}) string {
+	switch v := content.(type) {
+	case string:
+		return v
+	case []interface{}:
+		var b strings.Builder
+		for _, part := range v {
+			p, ok := part.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if text, ok := p["text"].(string); ok {
+				b.WriteString(text)
+			}
+		}
+		return b.String()
+	case nil:
+		return ""
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+// toolCallExtractor 从流式正文中识别 <tool_use> 块：块之前的文本照常输出，
+// 块本身转换为 tool_calls；可能是标签开头的尾部会暂存到下一段再判断。
+// stream 为 true 时块头到达即开始一个调用，参数随正文增长以增量形式产出，见 TakeDeltas。
+// single 为 true（parallel_tool_calls: false）时只保留第一个调用，模型仍输出的后续块整体丢弃
+type toolCallExtractor struct {
+	buf    string
+	calls  []OpenAIToolCall
+	stream bool
+	single bool
+
+	// 流式模式下正在接收参数的块
+	inBlock    bool
+	blockValid bool
+	rawArgs    string
+	emitted    int
+	argsBegun  bool
+	deltas     []toolCallDelta
+}
+
+// toolCallDelta 流式输出的一段工具调用：Start 为 true 时携带 ID 与名称，之后只有参数片段
+type toolCallDelta struct {
+	Index     int
+	Start     bool
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// Feed 输入一段正文增量，返回可以直接输出的文本
+func (e *toolCallExtractor) Feed(text string) string {
+	e.buf += text
+	var out strings.Builder
+
+	for {
+		if e.inBlock {
+			end := strings.Index(e.buf, toolUseCloseTag)
+			if end < 0 {
+				keep := partialPrefixLen(e.buf, toolUseCloseTag)
+				e.streamArgs(e.buf[:len(e.buf)-keep], false)
+				e.buf = e.buf[len(e.buf)-keep:]
+				break
+			}
+			e.streamArgs(e.buf[:end], true)
+			e.closeBlock()
+			e.buf = e.buf[end+len(toolUseCloseTag):]
+			continue
+		}
+
+		start := strings.Index(e.buf, toolUseOpenTag)
+		if start < 0 {
+			keep := partialPrefixLen(e.buf, toolUseOpenTag)
+			e.emitText(&out, e.buf[:len(e.buf)-keep])
+			e.buf = e.buf[len(e.buf)-keep:]
+			break
+		}
+
+		e.emitText(&out, e.buf[:start])
+		e.buf = e.buf[start:]
+
+		if e.stream {
+			headerEnd := strings.Index(e.buf, ">")
+			if headerEnd < 0 {
+				break
+			}
+			e.startBlock(e.buf[:headerEnd])
+			e.buf = e.buf[headerEnd+1:]
+			continue
+		}
+
+		end := strings.Index(e.buf, toolUseCloseTag)
+		if end < 0 {
+			break
+		}
+		e.addCall(e.buf[:end])
+		e.buf = e.buf[end+len(toolUseCloseTag):]
+	}
+
+	return out.String()
+}
+
+// emitText 工具调用之后的内容只可能是空白或模型违背约定的多余文字，不再输出
+func (e *toolCallExtractor) emitText(out *strings.Builder, text string) {
+	if len(e.calls) == 0 {
+		out.WriteString(text)
+	}
+}
+
+// Finish 响应结束时调用，返回暂存的剩余文本（未闭合的标签按普通文本返回）。
+// 流式模式下已开始的调用没有闭合时，按已收到的参数结束该调用
+func (e *toolCallExtractor) Finish() string {
+	if e.inBlock {
+		e.streamArgs(e.buf, true)
+		e.closeBlock()
+		e.buf = ""
+	}
+	rest := e.buf
+	e.buf = ""
+	if len(e.calls) > 0 {
+		return ""
+	}
+	return rest
+}
+
+// Calls 返回已识别的工具调用
+func (e *toolCallExtractor) Calls() []OpenAIToolCall {
+	return e.calls
+}
+
+// TakeDeltas 取出自上次调用以来产生的工具调用增量（仅流式模式）
+func (e *toolCallExtractor) TakeDeltas() []toolCallDelta {
+	deltas := e.deltas
+	e.deltas = nil
+	return deltas
+}
+
+// addCall 解析 `<tool_use name="x">{...}` （不含闭合标签）
+func (e *toolCallExtractor) addCall(block string) {
+	headerEnd := strings.Index(block, ">")
+	if headerEnd < 0 {
+		return
+	}
+	if e.newCall(block[:headerEnd]) {
+		e.calls[len(e.calls)-1].Function.Arguments = finalToolArgs(block[headerEnd+1:])
+	}
+}
+
+// newCall 按块头中的 name 新建一个调用，没有 name 的块以及 single 模式下第一个之后的块忽略
+func (e *toolCallExtractor) newCall(header string) bool {
+	if e.single && len(e.calls) > 0 {
+		return false
+	}
+	match := toolUseNameRegex.FindStringSubmatch(header)
+	if len(match) < 2 || match[1] == "" {
+		return false
+	}
+	e.calls = append(e.calls, OpenAIToolCall{
+		ID:   ids.New("call_"),
+		Type: "function",
+		Function: OpenAIFunctionCall{
+			Name: match[1],
+		},
+	})
+	return true
+}
+
+func (e *toolCallExtractor) startBlock(header string) {
+	e.inBlock = true
+	e.rawArgs = ""
+	e.emitted = 0
+	e.argsBegun = false
+	e.blockValid = e.newCall(header)
+	if e.blockValid {
+		call := e.calls[len(e.calls)-1]
+		e.deltas = append(e.deltas, toolCallDelta{
+			Index: len(e.calls) - 1,
+			Start: true,
+			ID:    call.ID,
+			Name:  call.Function.Name,
+		})
+	}
+}
+
+// streamArgs 追加块内的参数原文并产出可以确定的片段：开头的空白跳过，
+// 末尾未结束的 HTML 实体（如 "&quo"）暂存到下一段，final 时全部输出
+func (e *toolCallExtractor) streamArgs(raw string, final bool) {
+	e.rawArgs += raw
+	if !e.blockValid {
+		return
+	}
+
+	pending := e.rawArgs[e.emitted:]
+	if !e.argsBegun {
+		trimmed := strings.TrimLeft(pending, " \t\r\n")
+		e.emitted += len(pending) - len(trimmed)
+		pending = trimmed
+	}
+	if !final {
+		if amp := strings.LastIndex(pending, "&"); amp >= 0 && !strings.Contains(pending[amp:], ";") && len(pending)-amp <= maxEntityLen {
+			pending = pending[:amp]
+		}
+	}
+	if pending == "" {
+		return
+	}
+	e.argsBegun = true
+	e.emitted += len(pending)
+	e.deltas = append(e.deltas, toolCallDelta{
+		Index:     len(e.calls) - 1,
+		Arguments: html.UnescapeString(pending),
+	})
+}
+
+func (e *toolCallExtractor) closeBlock() {
+	e.inBlock = false
+	if !e.blockValid {
+		return
+	}
+	args := finalToolArgs(e.rawArgs)
+	e.calls[len(e.calls)-1].Function.Arguments = args
+	if strings.TrimSpace(e.rawArgs) == "" {
+		e.deltas = append(e.deltas, toolCallDelta{Index: len(e.calls) - 1, Arguments: args})
+	}
+}
+
+// maxEntityLen 暂存未结束 HTML 实体时考虑的最大长度
+const maxEntityLen = 10
+
+func finalToolArgs(raw string) string {
+	args := strings.TrimSpace(html.UnescapeString(raw))
+	if args == "" {
+		return "{}"
+	}
+	return args
+}
+
+// partialPrefixLen 返回 s 末尾与 tag 开头重合的最长长度
+func partialPrefixLen(s, tag string) int {
+	max := len(tag) - 1
+	if max > len(s) {
+		max = len(s)
+	}
+	for n := max; n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// sendSSEToolCallDelta 按 OpenAI 的流式格式输出工具调用：首个片段带 id / type / name，之后只有 arguments 片段
+func sendSSEToolCallDelta(w io.Writer, id string, created int64, model string, d toolCallDelta) {
+	call := map[string]interface{}{"index": d.Index}
+	if d.Start {
+		call["id"] = d.ID
+		call["type"] = "function"
+		call["function"] = map[string]interface{}{"name": d.Name, "arguments": ""}
+	} else {
+		call["function"] = map[string]interface{}{"arguments": d.Arguments}
+	}
+	resp := map[string]interface{}{
+		"id":      id,
+		"object":  "chat.completion.chunk",
+		"created": created,
+		"model":   model,
+		"choices": []map[string]interface{}{
+			{
+				"index": 0,
+				"delta": map[string]interface{}{
+					"tool_calls": []map[string]interface{}{call},
+				},
+				"finish_reason": nil,
+			},
+		},
+	}
+	bytes, _ := json.Marshal(resp)
+	writeSSEData(w, string(bytes))
+}
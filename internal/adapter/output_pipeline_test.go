@@ -0,0 +1,75 @@
+package adapter
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"gemini-web2api/internal/claude"
+)
+
+func TestUnescapeEntitySplitAcrossFrames(t *testing.T) {
+	t.Setenv("OUTPUT_PROCESSORS", "")
+
+	got := parseFixture(t, "split_entity", nil)
+	if want := "Fish & chips <3 'n' peas"; got != want {
+		t.Fatalf("answer = %q, want %q", got, want)
+	}
+}
+
+func TestClaudeUnescapeEntitySplitAcrossFrames(t *testing.T) {
+	t.Setenv("OUTPUT_PROCESSORS", "")
+
+	data, err := os.ReadFile("../mockgemini/fixtures/split_entity.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := claude.NewStreamProcessor("gemini-2.5-flash", &out).ProcessGeminiStream(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "&am") || strings.Contains(out.String(), "&#") {
+		t.Fatalf("entity leaked into the Claude stream: %s", out.String())
+	}
+}
+
+func TestCollapseDuplicates(t *testing.T) {
+	t.Setenv("OUTPUT_PROCESSORS", "unescape,collapse_duplicates")
+
+	got := parseFixture(t, "duplicate", nil)
+	if want := "The quick brown fox jumps over the lazy dog."; got != want {
+		t.Fatalf("answer = %q, want %q", got, want)
+	}
+}
+
+func TestThinkingStage(t *testing.T) {
+	data, err := os.ReadFile("../mockgemini/fixtures/chat.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		spec         string
+		wantThoughts bool
+	}{
+		{"", true},
+		{"none", true},
+		{"unescape,thinking", true},
+		{"unescape", false},
+	} {
+		t.Setenv("OUTPUT_PROCESSORS", tc.spec)
+		var text, thoughts strings.Builder
+		if err := parseGeminiResponseWithExtras(bytes.NewReader(data), nil, nil, func(delta, thought string) {
+			text.WriteString(delta)
+			thoughts.WriteString(thought)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if text.String() != "Hello, world!" {
+			t.Errorf("OUTPUT_PROCESSORS=%q: answer = %q", tc.spec, text.String())
+		}
+		if got := thoughts.Len() > 0; got != tc.wantThoughts {
+			t.Errorf("OUTPUT_PROCESSORS=%q: thoughts = %q, want thoughts: %v", tc.spec, thoughts.String(), tc.wantThoughts)
+		}
+	}
+}
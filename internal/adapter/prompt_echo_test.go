@@ -0,0 +1,45 @@
+package adapter
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// parseFixture 用 parseGeminiResponseWithExtras 解析 mockgemini 的 fixture，返回拼接后的正文
+func parseFixture(t *testing.T, name string, extras *responseExtras) string {
+	t.Helper()
+	data, err := os.ReadFile("../mockgemini/fixtures/" + name + ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text strings.Builder
+	if err := parseGeminiResponseWithExtras(bytes.NewReader(data), nil, extras, func(delta, _ string) {
+		text.WriteString(delta)
+	}); err != nil {
+		t.Fatalf("parse %s: %v", name, err)
+	}
+	return text.String()
+}
+
+func TestStripPromptEcho(t *testing.T) {
+	t.Setenv("OUTPUT_PROCESSORS", "strip_prompt_echo,strip_role_prefix")
+
+	prompt := "**System**: Be concise.\n\n**User**: What is the capital of France?"
+	got := parseFixture(t, "prompt_echo", &responseExtras{prompt: prompt})
+	if want := "Paris is the capital of France."; got != want {
+		t.Fatalf("stripped answer = %q, want %q", got, want)
+	}
+}
+
+func TestStripPromptEchoWithoutEcho(t *testing.T) {
+	t.Setenv("OUTPUT_PROCESSORS", "strip_prompt_echo")
+
+	// 提示词与回答开头不一致时原样输出，包括回答中的角色标记
+	prompt := "**User**: Tell me about Berlin."
+	got := parseFixture(t, "prompt_echo", &responseExtras{prompt: prompt})
+	if want := "**User**: What is the capital of France?\n\n**Model**: Paris is the capital of France."; got != want {
+		t.Fatalf("answer = %q, want %q", got, want)
+	}
+}
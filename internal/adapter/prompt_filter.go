@@ -0,0 +1,87 @@
+package adapter
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gemini-web2api/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// blockedPromptMessage 命中拦截规则时返回给客户端的统一说明，不透露具体规则
+const blockedPromptMessage = "This request was rejected by the service's content policy"
+
+var (
+	blockedPatternsOnce sync.Once
+	blockedPatterns     []*regexp.Regexp
+)
+
+// promptBlocked 发送前检查提示词是否命中 BLOCKED_PROMPT_PATTERNS / BLOCKED_PROMPTS_FILE，
+// 命中时记录来源密钥与规则（不记录提示词内容），调用方按各自协议的格式返回 400。
+// 用于拦截容易导致账号被封的内容，保护多人共用的账号
+func promptBlocked(c *gin.Context, prompt string) bool {
+	blockedPatternsOnce.Do(func() {
+		blockedPatterns = config.BlockedPromptPatterns()
+		if len(blockedPatterns) > 0 {
+			log.Printf("[Config] Blocked prompt patterns: %d", len(blockedPatterns))
+		}
+	})
+	for _, re := range blockedPatterns {
+		if re.MatchString(prompt) {
+			log.Printf("[Blocked] Rejected request from %s (%s): prompt matched blocked pattern '%s'",
+				displayAPIKey(c.GetString(apiKeyContextKey)), c.Request.URL.Path, re.String())
+			return true
+		}
+	}
+	return false
+}
+
+// promptSkipKeys 附件数据与结构字段，不参与检查：base64 数据体积大，逐条匹配没有意义，
+// 其余字段下的字符串（消息内容、系统指令、工具参数与结果等）都会发给上游
+var promptSkipKeys = map[string]bool{
+	"data": true, "source": true, "url": true, "image_url": true, "input_audio": true,
+	"video_url": true, "file": true, "inlineData": true, "inline_data": true,
+	"fileData": true, "file_data": true, "gemini_file": true, "type": true, "role": true,
+	"id": true, "tool_use_id": true, "tool_call_id": true, "mimeType": true,
+	"mime_type": true, "cache_control": true, "signature": true,
+}
+
+// requestText 从请求的消息、系统指令等字段中只收集文本部分，不上传任何附件，
+// 供 promptBlocked 在上传附件、选定账号发出请求之前检查
+func requestText(values ...any) string {
+	var b strings.Builder
+	for _, v := range values {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			continue
+		}
+		var decoded any
+		if json.Unmarshal(raw, &decoded) != nil {
+			continue
+		}
+		collectPromptText(&b, decoded)
+	}
+	return b.String()
+}
+
+func collectPromptText(b *strings.Builder, v any) {
+	switch v := v.(type) {
+	case string:
+		b.WriteString(v)
+		b.WriteString("\n")
+	case []any:
+		for _, item := range v {
+			collectPromptText(b, item)
+		}
+	case map[string]any:
+		for k, item := range v {
+			if !promptSkipKeys[k] {
+				collectPromptText(b, item)
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package adapter
+
+import (
+	"fmt"
+	"gemini-web2api/internal/config"
+)
+
+// defaultContextWindow 不在 openAIModels 中的模型使用的上下文窗口
+const defaultContextWindow = 1048576
+
+func contextWindow(model string) int {
+	for _, m := range openAIModels {
+		if m.ID == model {
+			return m.ContextWindow
+		}
+	}
+	return defaultContextWindow
+}
+
+// checkPromptSize 发送前按与 count_tokens 相同的方式估算拼接后提示词的 token 数，超过 MAX_PROMPT_TOKENS
+// （未设置时为模型的上下文窗口）时返回说明，调用方以 413 返回，避免上游给出难以理解的错误；未超出时返回空串
+func checkPromptSize(prompt, model string) string {
+	limit := config.MaxPromptTokens()
+	if limit < 0 {
+		return ""
+	}
+	if limit == 0 {
+		limit = contextWindow(model)
+	}
+	tokens := len(prompt) / bytesPerToken
+	if tokens <= limit {
+		return ""
+	}
+	return fmt.Sprintf("Prompt is too large: about %d tokens (estimated), the limit is %d tokens. Shorten the conversation or the input.", tokens, limit)
+}
@@ -0,0 +1,79 @@
+package adapter
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"gemini-web2api/internal/balancer"
+	"gemini-web2api/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessRetryAfter 预热期间建议客户端重试的间隔（秒）
+const readinessRetryAfter = "5"
+
+// Readiness 启动就绪状态：至少一个账号拿到有效会话（初始化成功且不需要重新认证）后置为就绪，
+// 此后一直保持就绪，之后账号全部失效属于运行期问题，由各接口按 "No available accounts" 等错误返回
+type Readiness struct {
+	pool  *balancer.AccountPool
+	ready atomic.Bool
+}
+
+func NewReadiness(pool *balancer.AccountPool) *Readiness {
+	return &Readiness{pool: pool}
+}
+
+// Ready 报告服务是否已就绪，首次检测到有效账号时记录日志
+func (r *Readiness) Ready() bool {
+	if r.ready.Load() {
+		return true
+	}
+	for _, entry := range r.pool.Entries() {
+		if !entry.Client.NeedsReauth() {
+			if r.ready.CompareAndSwap(false, true) {
+				log.Printf("[Ready] Account '%s' has a valid session, accepting requests", displayAccountID(entry.AccountID))
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware 预热完成前对 /v1 与 /v1beta（BASE_PATH 之下）的 API 请求返回 503，管理、调试与健康检查接口不受影响
+func (r *Readiness) Middleware() gin.HandlerFunc {
+	gate := config.ReadinessGate()
+	apiPrefix := config.BasePath() + "/v1"
+	return func(c *gin.Context) {
+		if !gate || !strings.HasPrefix(c.Request.URL.Path, apiPrefix) || r.Ready() {
+			c.Next()
+			return
+		}
+		c.Header("Retry-After", readinessRetryAfter)
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": gin.H{
+			"message": "Service is warming up: no account has a valid session yet",
+			"type":    "server_error",
+			"code":    "warming_up",
+		}})
+	}
+}
+
+// HealthHandler 存活检查，进程在运行即返回 200，ready 字段反映是否已就绪
+func (r *Readiness) HealthHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+		"ready":  r.Ready(),
+	})
+}
+
+// ReadyHandler 就绪检查，预热完成前返回 503 warming up，供编排系统在就绪后再转发流量
+func (r *Readiness) ReadyHandler(c *gin.Context) {
+	if !r.Ready() {
+		c.Header("Retry-After", readinessRetryAfter)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "warming up"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
@@ -0,0 +1,78 @@
+package adapter
+
+import (
+	"gemini-web2api/internal/config"
+	"gemini-web2api/internal/gemini"
+	"log"
+	"strings"
+)
+
+// noThinkingSuffix 关闭思考的模型变体后缀，见 gemini.ModelHeaders
+const noThinkingSuffix = "-no-thinking"
+
+// minThinkingBudget 低于该值的 thinking_budget 视为关闭思考（与 Claude budget_tokens 的下限一致）
+const minThinkingBudget = 1024
+
+// applyReasoningEffort 把 OpenAI 的 reasoning_effort 转换为思考开关。Gemini Web 无法设置思考预算，
+// none / minimal / low 切换到模型的 -no-thinking 变体（存在时）并默认不输出思考过程，
+// medium / high 切回开启思考的模型；thinking_visibility 显式设置时优先
+func (r *ChatRequest) applyReasoningEffort() {
+	r.applyThinkingBudget()
+	effort := strings.ToLower(strings.TrimSpace(r.ReasoningEffort))
+	switch effort {
+	case "":
+		return
+	case "none", "minimal", "low":
+		if variant := thinkingVariant(r.Model, false); variant != r.Model {
+			log.Printf("[OpenAI] reasoning_effort=%s, using %s", effort, variant)
+			r.Model = variant
+		}
+		if r.ThinkingVisibility == "" {
+			r.ThinkingVisibility = config.ThinkingHide
+		}
+	case "medium", "high":
+		if base := thinkingVariant(r.Model, true); base != r.Model {
+			log.Printf("[OpenAI] reasoning_effort=%s, using %s", effort, base)
+			r.Model = base
+		}
+	default:
+		log.Printf("[OpenAI] Unknown reasoning_effort '%s', ignored", r.ReasoningEffort)
+	}
+}
+
+// thinkingVariant 返回 model 开启（去掉 -no-thinking）或关闭（加上 -no-thinking）思考的变体，
+// 对应的变体不在 gemini.ModelHeaders 中时原样返回
+func thinkingVariant(model string, thinking bool) string {
+	variant := strings.TrimSuffix(model, noThinkingSuffix)
+	if !thinking {
+		variant += noThinkingSuffix
+	}
+	if variant != model && hasModelHeaders(variant) {
+		return variant
+	}
+	return model
+}
+
+func hasModelHeaders(model string) bool {
+	_, ok := gemini.ModelHeaders[model]
+	return ok
+}
+
+// applyThinkingBudget Gemini Web 的请求中没有思考预算字段，thinking_budget 只能转换为 reasoning_effort：
+// 0 到 minThinkingBudget 之间视为 none（使用 -no-thinking 变体，降低 pro 模型的延迟），
+// 不小于 minThinkingBudget 视为 high，负数（如 -1 动态预算）保持模型默认；显式的 reasoning_effort 优先
+func (r *ChatRequest) applyThinkingBudget() {
+	if r.ThinkingBudget == nil || strings.TrimSpace(r.ReasoningEffort) != "" {
+		return
+	}
+	budget := *r.ThinkingBudget
+	switch {
+	case budget < 0:
+		return
+	case budget < minThinkingBudget:
+		r.ReasoningEffort = "none"
+	default:
+		r.ReasoningEffort = "high"
+	}
+	log.Printf("[OpenAI] thinking_budget=%d cannot be enforced by Gemini Web, treated as reasoning_effort=%s", budget, r.ReasoningEffort)
+}
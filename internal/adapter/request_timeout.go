@@ -0,0 +1,29 @@
+package adapter
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestTimeoutHeader 客户端为单个请求指定的上游超时（秒，可为小数）
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// requestTimeoutContext 按 X-Request-Timeout 返回带截止时间的 context，用作 GenerateOptions.Context；
+// 派生自请求的 context，客户端断开时同样结束上游请求。未携带或无效时返回 nil，沿用默认超时。调用方在请求结束时调用返回的 cancel
+func requestTimeoutContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	v := strings.TrimSpace(c.GetHeader(RequestTimeoutHeader))
+	if v == "" {
+		return nil, func() {}
+	}
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil || seconds <= 0 {
+		log.Printf("[Timeout] Ignoring invalid %s '%s'", RequestTimeoutHeader, v)
+		return nil, func() {}
+	}
+	return context.WithTimeout(c.Request.Context(), time.Duration(seconds*float64(time.Second)))
+}
@@ -0,0 +1,565 @@
+package adapter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gemini-web2api/internal/balancer"
+	"gemini-web2api/internal/config"
+	"gemini-web2api/internal/gemini"
+	"gemini-web2api/internal/ids"
+	"gemini-web2api/internal/session"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// ResponsesRequest OpenAI Responses API（/v1/responses）的请求，转换为 ChatRequest 后复用 Chat Completions 的提示词拼接
+type ResponsesRequest struct {
+	Model string `json:"model"`
+	// Input 字符串，或由 message / function_call / function_call_output 组成的数组
+	Input        json.RawMessage `json:"input"`
+	Instructions string          `json:"instructions,omitempty"`
+	Stream       bool            `json:"stream"`
+	// PreviousResponseID 续接 store 保存的响应，input 中只需要新的输入
+	PreviousResponseID string              `json:"previous_response_id,omitempty"`
+	Store              *bool               `json:"store,omitempty"`
+	MaxOutputTokens    *int                `json:"max_output_tokens,omitempty"`
+	Temperature        *float64            `json:"temperature,omitempty"`
+	TopP               *float64            `json:"top_p,omitempty"`
+	Reasoning          *responsesReasoning `json:"reasoning,omitempty"`
+	Tools              []responsesTool     `json:"tools,omitempty"`
+	ToolChoice         interface{}         `json:"tool_choice,omitempty"`
+	ParallelToolCalls  *bool               `json:"parallel_tool_calls,omitempty"`
+	Metadata           map[string]string   `json:"metadata,omitempty"`
+	// AnswerOnly 非标准扩展字段，与 ChatRequest.AnswerOnly 相同
+	AnswerOnly bool `json:"answer_only,omitempty"`
+}
+
+type responsesReasoning struct {
+	Effort string `json:"effort,omitempty"`
+}
+
+// responsesTool Responses API 的函数工具不再嵌套在 function 字段中
+type responsesTool struct {
+	Type        string          `json:"type"`
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// chatRequest 转换为等价的 ChatRequest：instructions 作为开头的 system 消息，
+// max_output_tokens / reasoning.effort 对应 max_completion_tokens / reasoning_effort
+func (r *ResponsesRequest) chatRequest() (ChatRequest, error) {
+	req := ChatRequest{
+		Model:               r.Model,
+		Stream:              r.Stream,
+		MaxCompletionTokens: r.MaxOutputTokens,
+		Temperature:         r.Temperature,
+		TopP:                r.TopP,
+		Store:               r.Store,
+		Metadata:            r.Metadata,
+		ToolChoice:          responsesToolChoice(r.ToolChoice),
+		ParallelToolCalls:   r.ParallelToolCalls,
+		AnswerOnly:          r.AnswerOnly,
+	}
+	if r.Reasoning != nil {
+		req.ReasoningEffort = r.Reasoning.Effort
+	}
+	for _, t := range r.Tools {
+		if t.Type != "function" {
+			log.Printf("[Responses] Ignoring unsupported tool type '%s'", t.Type)
+			continue
+		}
+		req.Tools = append(req.Tools, OpenAITool{
+			Type:     "function",
+			Function: OpenAIFunction{Name: t.Name, Description: t.Description, Parameters: t.Parameters},
+		})
+	}
+
+	if strings.TrimSpace(r.Instructions) != "" {
+		req.Messages = append(req.Messages, ChatMessage{Role: "system", Content: r.Instructions})
+	}
+	messages, err := responsesInputMessages(r.Input)
+	if err != nil {
+		return req, err
+	}
+	req.Messages = append(req.Messages, messages...)
+	return req, nil
+}
+
+// responsesToolChoice 把 {"type":"function","name":"x"} 转换为 Chat Completions 的嵌套格式，其余取值原样保留
+func responsesToolChoice(choice interface{}) interface{} {
+	m, ok := choice.(map[string]interface{})
+	if !ok {
+		return choice
+	}
+	if name, ok := m["name"].(string); ok && m["type"] == "function" {
+		return map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": name}}
+	}
+	return choice
+}
+
+// responsesInputMessages 把 input 转换为 Chat Completions 消息：function_call 并入前一条助手消息的 tool_calls，
+// function_call_output 转换为 tool 消息，reasoning 条目忽略
+func responsesInputMessages(input json.RawMessage) ([]ChatMessage, error) {
+	v := gjson.ParseBytes(input)
+	switch {
+	case len(input) == 0 || v.Type == gjson.Null:
+		return nil, errors.New("input is required")
+	case v.Type == gjson.String:
+		return []ChatMessage{{Role: "user", Content: v.Str}}, nil
+	case !v.IsArray():
+		return nil, errors.New("input must be a string or an array of input items")
+	}
+
+	var messages []ChatMessage
+	for i, item := range v.Array() {
+		switch itemType := item.Get("type").String(); itemType {
+		case "", "message":
+			role := item.Get("role").String()
+			if role == "" {
+				role = "user"
+			}
+			messages = append(messages, ChatMessage{Role: role, Content: responsesContent(item.Get("content"))})
+		case "function_call":
+			call := OpenAIToolCall{
+				ID:   item.Get("call_id").String(),
+				Type: "function",
+				Function: OpenAIFunctionCall{
+					Name:      item.Get("name").String(),
+					Arguments: item.Get("arguments").String(),
+				},
+			}
+			if n := len(messages); n > 0 && strings.EqualFold(messages[n-1].Role, "assistant") {
+				messages[n-1].ToolCalls = append(messages[n-1].ToolCalls, call)
+			} else {
+				messages = append(messages, ChatMessage{Role: "assistant", Content: "", ToolCalls: []OpenAIToolCall{call}})
+			}
+		case "function_call_output":
+			messages = append(messages, ChatMessage{
+				Role:       "tool",
+				ToolCallID: item.Get("call_id").String(),
+				Content:    item.Get("output").String(),
+			})
+		case "reasoning":
+		default:
+			return nil, fmt.Errorf("input[%d]: unsupported item type '%s'", i, itemType)
+		}
+	}
+	return messages, nil
+}
+
+// responsesContent 把 input_text / output_text / input_image 内容转换为 Chat Completions 的 text / image_url 片段，gemini_file 原样保留
+func responsesContent(content gjson.Result) interface{} {
+	if !content.IsArray() {
+		return content.String()
+	}
+	var parts []interface{}
+	for _, part := range content.Array() {
+		switch part.Get("type").String() {
+		case "input_text", "output_text", "text", "refusal":
+			text := part.Get("text").String()
+			if text == "" {
+				text = part.Get("refusal").String()
+			}
+			parts = append(parts, map[string]interface{}{"type": "text", "text": text})
+		case "input_image":
+			imageURL := part.Get("image_url")
+			if imageURL.IsObject() {
+				imageURL = imageURL.Get("url")
+			}
+			if imageURL.String() == "" {
+				continue
+			}
+			parts = append(parts, map[string]interface{}{
+				"type":      "image_url",
+				"image_url": map[string]interface{}{"url": imageURL.String()},
+			})
+		case "gemini_file":
+			parts = append(parts, map[string]interface{}{
+				"type":      "gemini_file",
+				"url":       part.Get("url").String(),
+				"file_name": part.Get("file_name").String(),
+			})
+		}
+	}
+	return parts
+}
+
+// responsesResult 一次响应的标识信息，用于生成 response 对象
+type responsesResult struct {
+	id         string
+	created    int64
+	model      string
+	previousID string
+	metadata   map[string]string
+}
+
+// envelope 生成 response 对象，status 为 in_progress / completed / incomplete / failed
+func (r *responsesResult) envelope(status string, output []gin.H, incompleteReason string) gin.H {
+	resp := gin.H{
+		"id":                   r.id,
+		"object":               "response",
+		"created_at":           r.created,
+		"status":               status,
+		"model":                r.model,
+		"output":               output,
+		"error":                nil,
+		"incomplete_details":   nil,
+		"previous_response_id": nil,
+		"metadata":             r.metadata,
+	}
+	if incompleteReason != "" {
+		resp["incomplete_details"] = gin.H{"reason": incompleteReason}
+	}
+	if r.previousID != "" {
+		resp["previous_response_id"] = r.previousID
+	}
+	if r.metadata == nil {
+		resp["metadata"] = gin.H{}
+	}
+	return resp
+}
+
+func outputTextPart(text string) gin.H {
+	return gin.H{"type": "output_text", "text": text, "annotations": []interface{}{}}
+}
+
+func responsesMessageItem(id, status, text string) gin.H {
+	content := []gin.H{}
+	if status != "in_progress" {
+		content = append(content, outputTextPart(text))
+	}
+	return gin.H{"id": id, "type": "message", "status": status, "role": "assistant", "content": content}
+}
+
+func responsesFunctionCallItem(call OpenAIToolCall, status string) gin.H {
+	return gin.H{
+		"id":        "fc_" + strings.TrimPrefix(call.ID, "call_"),
+		"type":      "function_call",
+		"status":    status,
+		"call_id":   call.ID,
+		"name":      call.Function.Name,
+		"arguments": call.Function.Arguments,
+	}
+}
+
+// responsesStatus 把 OpenAI finish_reason 换算为响应状态与 incomplete_details.reason
+func responsesStatus(finishReason string) (string, string) {
+	switch finishReason {
+	case "length":
+		return "incomplete", "max_output_tokens"
+	case "content_filter":
+		return "incomplete", "content_filter"
+	}
+	return "completed", ""
+}
+
+// ResponsesHandler OpenAI Responses API：提示词拼接与响应解析与 Chat Completions 相同，
+// 流式输出 response.created → response.output_text.delta → response.completed 等事件；思考过程默认不输出，
+// THINKING_FORMAT=think_tags 时以 <think> 标签放在 output_text 开头（遵守 THINKING_VISIBILITY 与 answer_only）
+func ResponsesHandler(pool *balancer.AccountPool, sessions *session.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rreq ResponsesRequest
+		if err := c.ShouldBindJSON(&rreq); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": err.Error(), "type": "invalid_request_error"}})
+			return
+		}
+		rreq.Stream = rreq.Stream && streamingAllowed(c)
+		req, err := rreq.chatRequest()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": err.Error(), "type": "invalid_request_error"}})
+			return
+		}
+		req.applyModelSuffix()
+		req.applyModelDefaults(config.MapModel(req.Model))
+		req.applyReasoningEffort()
+		mappedModel := config.MapModel(req.Model)
+
+		if isImageModel(req.Model) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{
+				"message": "Image models are not supported on /v1/responses, use /v1/chat/completions or /v1/images/generations",
+				"type":    "invalid_request_error",
+				"param":   "model",
+			}})
+			return
+		}
+		if msg := unsupportedModel(pool, mappedModel); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{
+				"message": msg,
+				"type":    "invalid_request_error",
+				"param":   "model",
+			}})
+			return
+		}
+		release, busy := acquireModelSlot(c, mappedModel)
+		if busy != "" {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": gin.H{
+				"message": busy,
+				"type":    "rate_limit_error",
+				"code":    "model_concurrency_limit",
+			}})
+			return
+		}
+		defer release()
+
+		var meta *gemini.ChatMetadata
+		var client *gemini.Client
+		var accountID string
+		if rreq.PreviousResponseID != "" {
+			conv, ok := sessions.Get(rreq.PreviousResponseID)
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{
+					"message": fmt.Sprintf("Previous response with id '%s' not found.", rreq.PreviousResponseID),
+					"type":    "invalid_request_error",
+					"param":   "previous_response_id",
+				}})
+				return
+			}
+			if sticky := pool.Get(conv.AccountID); sticky != nil && !pool.Disabled(conv.AccountID) && pool.ServesModel(conv.AccountID, mappedModel) {
+				client, accountID = sticky, conv.AccountID
+				meta = &conv.Metadata
+				exposeAccount(c, accountID)
+			} else {
+				log.Printf("[Session] Account '%s' for response %s is unavailable, starting a new conversation", conv.AccountID, rreq.PreviousResponseID)
+			}
+		}
+		if client == nil {
+			client, accountID = selectAccountForModel(c, pool, mappedModel)
+		}
+		if client == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": gin.H{"message": "No available accounts", "type": "server_error"}})
+			return
+		}
+
+		c.Set("account_id", accountID)
+		if len(req.Metadata) > 0 {
+			log.Printf("[Responses] Request metadata: %v", req.Metadata)
+		}
+
+		prompt, ok := buildChatPrompt(c, client, &req, meta)
+		if !ok {
+			return
+		}
+
+		gemini.RandomDelay()
+
+		timeoutCtx, cancel := requestTimeoutContext(c)
+		defer cancel()
+		opts := gemini.GenerateOptions{Context: timeoutCtx}
+		respBody, client, accountID, err := generateWithFailover(c, pool, client, accountID, meta == nil && len(prompt.files) == 0, func(client *gemini.Client) (io.ReadCloser, error) {
+			return client.StreamGenerateContentWithOptions(prompt.text, req.Model, prompt.files, meta, opts)
+		})
+		if err != nil {
+			log.Printf("Gemini request failed: %v", err)
+			c.JSON(generateErrorStatus(c, client, err), gin.H{"error": gin.H{
+				"message": "Failed to communicate with Gemini: " + err.Error(),
+				"type":    "server_error",
+			}})
+			return
+		}
+		defer respBody.Close()
+
+		result := &responsesResult{
+			id:         ids.New("resp_"),
+			created:    time.Now().Unix(),
+			model:      req.Model,
+			previousID: rreq.PreviousResponseID,
+			metadata:   req.Metadata,
+		}
+		msgID := ids.New("msg_")
+		limiter := newTokenLimiter(req.outputLimit())
+		var extractor *toolCallExtractor
+		if prompt.toolsInstruction != "" {
+			extractor = &toolCallExtractor{single: !req.parallelToolCalls()}
+		}
+		extras := responseExtras{prompt: prompt.text}
+		// Responses API 没有思考字段，只有 THINKING_FORMAT=think_tags 时以 <think> 标签内联到 output_text
+		thinkTags := config.ResolveThinkingFormat(req.ThinkingFormat) == config.ThinkingFormatTags
+		thinkingVisibility := config.ResolveThinkingVisibility(req.ThinkingVisibility)
+		if !thinkTags || config.AnswerOnly(req.AnswerOnly) {
+			thinkingVisibility = config.ThinkingHide
+		}
+		var respMeta gemini.ChatMetadata
+		if meta != nil {
+			respMeta = *meta
+		}
+		saveResponse := func() {
+			if rreq.Store == nil || *rreq.Store {
+				sessions.Put(result.id, respMeta, accountID)
+			}
+		}
+		finishReason := func(truncated bool) string {
+			reason := config.OpenAIFinishReason(extras.finishReason)
+			if truncated || limiter.Exceeded() {
+				reason = "length"
+			}
+			return reason
+		}
+
+		if !rreq.Stream {
+			var fullText, fullThinking strings.Builder
+			truncated, err := parseWithContinuation(client, req.Model, respBody, &respMeta, &extras, opts, func(text, thought string) {
+				fullText.WriteString(text)
+				fullThinking.WriteString(thought)
+			})
+			content := fullText.String()
+			var calls []OpenAIToolCall
+			if extractor != nil {
+				content = extractor.Feed(content) + extractor.Finish()
+				calls = extractor.Calls()
+			}
+			content = limiter.Take(content)
+			if thinkingVisibility != config.ThinkingHide && fullThinking.Len() > 0 {
+				content = thinkOpenTag + fullThinking.String() + thinkCloseTag + content
+			}
+			if err != nil && content == "" && len(calls) == 0 {
+				log.Printf("Gemini response parse failed: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": gin.H{
+					"message": "Failed to read Gemini response: " + err.Error(),
+					"type":    "server_error",
+				}})
+				return
+			}
+			status, reason := responsesStatus(finishReason(truncated || err != nil))
+			saveResponse()
+
+			output := []gin.H{}
+			if content != "" {
+				output = append(output, responsesMessageItem(msgID, status, content))
+			}
+			for _, call := range calls {
+				output = append(output, responsesFunctionCallItem(call, "completed"))
+			}
+			c.JSON(http.StatusOK, result.envelope(status, output, reason))
+			return
+		}
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("Transfer-Encoding", "chunked")
+
+		sw := newSSEWriter(c.Writer)
+		sequence := 0
+		emit := func(event string, payload gin.H) {
+			payload["type"] = event
+			payload["sequence_number"] = sequence
+			sequence++
+			data, _ := json.Marshal(payload)
+			sw.writeEvent(event, string(data))
+		}
+
+		c.Stream(func(io.Writer) bool {
+			emit("response.created", gin.H{"response": result.envelope("in_progress", []gin.H{}, "")})
+			emit("response.in_progress", gin.H{"response": result.envelope("in_progress", []gin.H{}, "")})
+
+			var fullText strings.Builder
+			messageOpen := false
+			sendDelta := func(text string) {
+				if !messageOpen {
+					messageOpen = true
+					emit("response.output_item.added", gin.H{"output_index": 0, "item": responsesMessageItem(msgID, "in_progress", "")})
+					emit("response.content_part.added", gin.H{"item_id": msgID, "output_index": 0, "content_index": 0, "part": outputTextPart("")})
+				}
+				fullText.WriteString(text)
+				emit("response.output_text.delta", gin.H{"item_id": msgID, "output_index": 0, "content_index": 0, "delta": text})
+			}
+
+			thinkOpen := false
+			var thinkingSummary strings.Builder
+			sendThinking := func(thought string) {
+				if !thinkOpen {
+					thought = thinkOpenTag + thought
+					thinkOpen = true
+				}
+				sendDelta(thought)
+			}
+			closeThinking := func() {
+				if thinkingSummary.Len() > 0 {
+					sendThinking(thinkingSummary.String())
+					thinkingSummary.Reset()
+				}
+				if thinkOpen {
+					sendDelta(thinkCloseTag)
+					thinkOpen = false
+				}
+			}
+			sendText := func(text string) {
+				text = limiter.Take(text)
+				if text == "" {
+					return
+				}
+				closeThinking()
+				sendDelta(text)
+			}
+
+			truncated, err := parseWithContinuation(client, req.Model, respBody, &respMeta, &extras, opts, func(text, thought string) {
+				if thought != "" {
+					switch thinkingVisibility {
+					case config.ThinkingShow:
+						sendThinking(thought)
+					case config.ThinkingSummary:
+						thinkingSummary.WriteString(thought)
+					}
+				}
+				if extractor != nil {
+					text = extractor.Feed(text)
+				}
+				sendText(text)
+			})
+			var calls []OpenAIToolCall
+			if extractor != nil {
+				sendText(extractor.Finish())
+				calls = extractor.Calls()
+			}
+			closeThinking()
+			if err != nil {
+				log.Printf("Gemini response parse failed: %v", err)
+			}
+
+			if err != nil && !messageOpen && len(calls) == 0 {
+				failed := result.envelope("failed", []gin.H{}, "")
+				failed["error"] = gin.H{"code": "server_error", "message": "Failed to read Gemini response: " + err.Error()}
+				emit("response.failed", gin.H{"response": failed})
+				return false
+			}
+			status, reason := responsesStatus(finishReason(truncated || err != nil))
+
+			output := []gin.H{}
+			if messageOpen {
+				text := fullText.String()
+				emit("response.output_text.done", gin.H{"item_id": msgID, "output_index": 0, "content_index": 0, "text": text})
+				emit("response.content_part.done", gin.H{"item_id": msgID, "output_index": 0, "content_index": 0, "part": outputTextPart(text)})
+				item := responsesMessageItem(msgID, status, text)
+				emit("response.output_item.done", gin.H{"output_index": 0, "item": item})
+				output = append(output, item)
+			}
+			for _, call := range calls {
+				index := len(output)
+				item := responsesFunctionCallItem(call, "completed")
+				added := responsesFunctionCallItem(call, "in_progress")
+				added["arguments"] = ""
+				emit("response.output_item.added", gin.H{"output_index": index, "item": added})
+				emit("response.function_call_arguments.delta", gin.H{"item_id": item["id"], "output_index": index, "delta": call.Function.Arguments})
+				emit("response.function_call_arguments.done", gin.H{"item_id": item["id"], "output_index": index, "arguments": call.Function.Arguments})
+				emit("response.output_item.done", gin.H{"output_index": index, "item": item})
+				output = append(output, item)
+			}
+
+			saveResponse()
+			terminal := "response.completed"
+			if status == "incomplete" {
+				terminal = "response.incomplete"
+			}
+			emit(terminal, gin.H{"response": result.envelope(status, output, reason)})
+			return false
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package adapter
+
+import (
+	"errors"
+	"fmt"
+	"gemini-web2api/internal/balancer"
+	"strings"
+)
+
+const (
+	selfTestPrompt       = "Reply with exactly: OK"
+	defaultSelfTestModel = "gemini-2.5-flash"
+)
+
+// SelfTest 用第一个可以使用 model 的可用账号发送一个极短的真实提示词，确认生成与解析链路正常。
+// 用于发现 Init 成功（拿到 SNlM0e）但因协议变化导致生成失败的情况，返回模型的回复
+func SelfTest(pool *balancer.AccountPool, model string) (string, error) {
+	if strings.TrimSpace(model) == "" {
+		model = defaultSelfTestModel
+	}
+
+	if msg := unsupportedModel(pool, model); msg != "" {
+		return "", errors.New(msg)
+	}
+	client, accountID := pool.NextForModel(model)
+	if client == nil {
+		return "", fmt.Errorf("no available accounts")
+	}
+
+	respBody, err := client.StreamGenerateContent(selfTestPrompt, model, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("account '%s': request failed: %w", displayAccountID(accountID), err)
+	}
+	defer respBody.Close()
+
+	var text strings.Builder
+	parseErr := parseGeminiResponse(respBody, func(t, _ string) {
+		text.WriteString(t)
+	})
+
+	reply := strings.TrimSpace(text.String())
+	if reply == "" {
+		if parseErr != nil {
+			return "", fmt.Errorf("account '%s': failed to parse response: %v", displayAccountID(accountID), parseErr)
+		}
+		return "", fmt.Errorf("account '%s': response contained no text (protocol may have changed)", displayAccountID(accountID))
+	}
+	return reply, nil
+}
@@ -0,0 +1,55 @@
+package adapter
+
+import (
+	"fmt"
+	"strings"
+
+	"gemini-web2api/internal/config"
+	"gemini-web2api/internal/gemini"
+)
+
+// renderSources 按 list / footnotes 方式把引用来源渲染为追加在回答末尾的 Markdown，没有来源时返回空串。
+// Web 接口不提供引用在正文中的位置，footnotes 只输出脚注定义，不在正文中插入 [^n] 标记
+func renderSources(sources []gemini.Source, mode string) string {
+	if len(sources) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	switch mode {
+	case config.WebSourcesList:
+		sb.WriteString("\n\nSources:\n")
+		for i, s := range sources {
+			fmt.Fprintf(&sb, "%d. [%s](%s)\n", i+1, escapeLinkText(s.Title), s.URL)
+		}
+	case config.WebSourcesFootnotes:
+		sb.WriteString("\n\n")
+		for i, s := range sources {
+			fmt.Fprintf(&sb, "[^%d]: [%s](%s)\n", i+1, escapeLinkText(s.Title), s.URL)
+		}
+	}
+	return sb.String()
+}
+
+func escapeLinkText(s string) string {
+	return strings.NewReplacer("[", "\\[", "]", "\\]").Replace(s)
+}
+
+// urlCitations web_sources=field 时以 OpenAI 标准的 url_citation 注释返回引用来源与搜索入口中的搜索建议。
+// Web 接口不提供引用在正文中的位置，start_index / end_index 覆盖整个回答（contentLen 为回答的字符数）
+func urlCitations(sources *gemini.SourceList, contentLen int) []map[string]interface{} {
+	var annotations []map[string]interface{}
+	for _, group := range [][]gemini.Source{sources.Items(), sources.Suggestions()} {
+		for _, s := range group {
+			annotations = append(annotations, map[string]interface{}{
+				"type": "url_citation",
+				"url_citation": map[string]interface{}{
+					"url":         s.URL,
+					"title":       s.Title,
+					"start_index": 0,
+					"end_index":   contentLen,
+				},
+			})
+		}
+	}
+	return annotations
+}
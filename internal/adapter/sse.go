@@ -0,0 +1,60 @@
+package adapter
+
+import (
+	"fmt"
+	"gemini-web2api/internal/config"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sseWriter 按 SSE 规范输出事件：每个事件带递增的 id:，首个事件前附带 retry: 重连提示，
+// 多行数据拆成多个 data: 行，并以空行结束事件；event 非空时（Responses API）附带 event: 类型
+type sseWriter struct {
+	w       io.Writer
+	retry   time.Duration
+	eventID int
+}
+
+func newSSEWriter(w io.Writer) *sseWriter {
+	return &sseWriter{w: w, retry: config.SSERetry()}
+}
+
+func (s *sseWriter) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+func (s *sseWriter) Flush() {
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *sseWriter) writeEvent(event, data string) {
+	var b strings.Builder
+	if s.eventID == 0 && s.retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", s.retry.Milliseconds())
+	}
+	s.eventID++
+	fmt.Fprintf(&b, "id: %d\n", s.eventID)
+	if event != "" {
+		b.WriteString("event: " + event + "\n")
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	io.WriteString(s.w, b.String())
+	s.Flush()
+}
+
+// writeSSEData 输出一个 data 事件并立即 flush；w 为 sseWriter 时附带事件 id
+func writeSSEData(w io.Writer, data string) {
+	if sw, ok := w.(*sseWriter); ok {
+		sw.writeEvent("", data)
+		return
+	}
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	w.(http.Flusher).Flush()
+}
@@ -0,0 +1,125 @@
+package adapter
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"gemini-web2api/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DisableStreamingHeader 请求头 X-Disable-Streaming: 1 时本次请求忽略 stream: true，返回完整的非流式响应，
+// 供所在网络无法转发 SSE 的客户端使用，效果与 DISABLE_STREAMING 相同
+const DisableStreamingHeader = "X-Disable-Streaming"
+
+// streamingAllowed 报告 stream: true 的请求是否可以按 SSE 返回；不能时各接口退化为非流式响应（带 Content-Length，不分块）
+func streamingAllowed(c *gin.Context) bool {
+	if config.StreamingDisabled() {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(c.GetHeader(DisableStreamingHeader))) {
+	case "1", "true", "on", "yes":
+		return false
+	}
+	return true
+}
+
+// StreamFlushMiddleware 为所有 SSE 响应加上 X-Accel-Buffering: no，提示 nginx 等反向代理不要缓冲；
+// 配置了 SSE_FLUSH_INTERVAL 时把间隔内的多次 flush 合并为一次，间隔结束时补上最后一次
+func StreamFlushMiddleware() gin.HandlerFunc {
+	interval := config.SSEFlushInterval()
+	return func(c *gin.Context) {
+		w := &batchFlushWriter{ResponseWriter: c.Writer, interval: interval}
+		c.Writer = w
+		c.Next()
+		w.finish()
+	}
+}
+
+// batchFlushWriter 写入与 flush 都在 mu 下进行，定时器补发的 flush 不会与处理函数的写入交错
+type batchFlushWriter struct {
+	gin.ResponseWriter
+	interval time.Duration
+
+	mu        sync.Mutex
+	lastFlush time.Time
+	timer     *time.Timer
+	pending   bool
+	done      bool
+}
+
+func (w *batchFlushWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.markStream()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *batchFlushWriter) WriteString(s string) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.markStream()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// markStream 首次写入（即发送响应头）之前为 SSE 响应补上 X-Accel-Buffering
+func (w *batchFlushWriter) markStream() {
+	if w.Written() {
+		return
+	}
+	header := w.Header()
+	if strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") && header.Get("X-Accel-Buffering") == "" {
+		header.Set("X-Accel-Buffering", "no")
+	}
+}
+
+func (w *batchFlushWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return
+	}
+	if w.interval <= 0 {
+		w.markStream()
+		w.ResponseWriter.Flush()
+		return
+	}
+	if wait := w.interval - time.Since(w.lastFlush); wait > 0 {
+		if !w.pending {
+			w.pending = true
+			w.timer = time.AfterFunc(wait, w.flushPending)
+		}
+		return
+	}
+	w.flushLocked()
+}
+
+func (w *batchFlushWriter) flushPending() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.pending && !w.done {
+		w.flushLocked()
+	}
+}
+
+func (w *batchFlushWriter) flushLocked() {
+	w.markStream()
+	w.ResponseWriter.Flush()
+	w.lastFlush = time.Now()
+	w.pending = false
+}
+
+// finish 请求处理结束时发出尚未执行的 flush，之后定时器不再访问已结束的响应
+func (w *batchFlushWriter) finish() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	if w.pending {
+		w.flushLocked()
+	}
+	w.done = true
+}
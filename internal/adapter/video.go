@@ -0,0 +1,216 @@
+package adapter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"gemini-web2api/internal/config"
+	"math"
+	"time"
+)
+
+const (
+	videoMimeMP4  = "video/mp4"
+	videoMimeWebM = "video/webm"
+)
+
+var videoExtensions = map[string]string{
+	videoMimeMP4:  ".mp4",
+	videoMimeWebM: ".webm",
+}
+
+// prepareVideo 校验内联视频：按文件头识别 mp4 / webm（忽略客户端声明的 MIME），
+// 并检查 VIDEO_MAX_SIZE / VIDEO_MAX_DURATION。设置了时长上限时无法读取时长的视频同样被拒绝，
+// 否则只要去掉元数据就能绕过限制
+func prepareVideo(data []byte) (string, error) {
+	if maxSize := config.MaxVideoSize(); len(data) > maxSize {
+		return "", fmt.Errorf("video is too large (%d bytes), maximum is %d bytes", len(data), maxSize)
+	}
+
+	var mimeType string
+	var duration time.Duration
+	var known bool
+	switch {
+	case len(data) >= 12 && string(data[4:8]) == "ftyp":
+		mimeType = videoMimeMP4
+		duration, known = mp4Duration(data)
+	case bytes.HasPrefix(data, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		mimeType = videoMimeWebM
+		duration, known = webmDuration(data)
+	default:
+		return "", fmt.Errorf("unsupported video format, only mp4 and webm are accepted")
+	}
+
+	maxDuration := config.MaxVideoDuration()
+	if maxDuration == 0 {
+		return mimeType, nil
+	}
+	if !known {
+		return "", fmt.Errorf("could not determine the video duration, maximum is %v", maxDuration)
+	}
+	if duration > maxDuration {
+		return "", fmt.Errorf("video is too long (%v), maximum is %v", duration.Round(time.Second), maxDuration)
+	}
+	return mimeType, nil
+}
+
+func videoFileName(mimeType string, nanos int64) string {
+	return fmt.Sprintf("video_%d%s", nanos, videoExtensions[mimeType])
+}
+
+// mp4Duration 读取 moov/mvhd 中的时长，找不到或无法解析时 ok 为 false
+func mp4Duration(data []byte) (time.Duration, bool) {
+	moov := findMP4Box(data, "moov")
+	if moov == nil {
+		return 0, false
+	}
+	mvhd := findMP4Box(moov, "mvhd")
+	if len(mvhd) < 4 {
+		return 0, false
+	}
+
+	var timescale, duration uint64
+	if mvhd[0] == 1 {
+		if len(mvhd) < 32 {
+			return 0, false
+		}
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[20:24]))
+		duration = binary.BigEndian.Uint64(mvhd[24:32])
+	} else {
+		if len(mvhd) < 20 {
+			return 0, false
+		}
+		timescale = uint64(binary.BigEndian.Uint32(mvhd[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+	}
+	// 时长全为 1 表示未知（如分片 mp4 的 mvhd）
+	if timescale == 0 || duration == math.MaxUint32 || duration == math.MaxUint64 {
+		return 0, false
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second)), true
+}
+
+// findMP4Box 在同一层级中查找指定类型的 box，返回其内容（不含头部）
+func findMP4Box(data []byte, boxType string) []byte {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil
+		}
+		if string(data[4:8]) == boxType {
+			return data[header:size]
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// WebM (EBML) 中用到的元素 ID
+const (
+	ebmlSegment       = 0x18538067
+	ebmlInfo          = 0x1549A966
+	ebmlTimecodeScale = 0x2AD7B1
+	ebmlDuration      = 0x4489
+)
+
+// webmDuration 读取 Segment/Info 中的 Duration（单位为 TimecodeScale 纳秒），找不到或无法解析时 ok 为 false
+func webmDuration(data []byte) (time.Duration, bool) {
+	segment := findEBMLElement(data, ebmlSegment)
+	if segment == nil {
+		return 0, false
+	}
+	info := findEBMLElement(segment, ebmlInfo)
+	if info == nil {
+		return 0, false
+	}
+
+	scale := uint64(1000000)
+	if raw := findEBMLElement(info, ebmlTimecodeScale); len(raw) > 0 && len(raw) <= 8 {
+		scale = 0
+		for _, b := range raw {
+			scale = scale<<8 | uint64(b)
+		}
+	}
+
+	var duration float64
+	raw := findEBMLElement(info, ebmlDuration)
+	switch len(raw) {
+	case 4:
+		duration = float64(math.Float32frombits(binary.BigEndian.Uint32(raw)))
+	case 8:
+		duration = math.Float64frombits(binary.BigEndian.Uint64(raw))
+	default:
+		return 0, false
+	}
+	if math.IsNaN(duration) || math.IsInf(duration, 0) || duration < 0 {
+		return 0, false
+	}
+	return time.Duration(duration * float64(scale)), true
+}
+
+// findEBMLElement 在同一层级中查找指定 ID 的元素，返回其内容；
+// 未知大小（直播写入的 Segment）视为延伸到数据末尾
+func findEBMLElement(data []byte, id uint64) []byte {
+	for len(data) > 0 {
+		elemID, idLen := readEBMLVint(data, true)
+		if idLen == 0 {
+			return nil
+		}
+		size, sizeLen := readEBMLVint(data[idLen:], false)
+		if sizeLen == 0 {
+			return nil
+		}
+		start := idLen + sizeLen
+		end := uint64(len(data))
+		if size != math.MaxUint64 && uint64(start)+size <= end {
+			end = uint64(start) + size
+		}
+		if elemID == id {
+			return data[start:end]
+		}
+		data = data[end:]
+	}
+	return nil
+}
+
+// readEBMLVint 读取变长整数，keepMarker 为 true 时保留长度标记位（用于元素 ID）；
+// 所有数据位为 1 的大小表示未知，返回 math.MaxUint64
+func readEBMLVint(data []byte, keepMarker bool) (uint64, int) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0
+	}
+	length := 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 || len(data) < length {
+		return 0, 0
+	}
+
+	value := uint64(data[0])
+	if !keepMarker {
+		value &= uint64(0xFF >> length)
+	}
+	allOnes := value == uint64(0xFF>>length)
+	for i := 1; i < length; i++ {
+		value = value<<8 | uint64(data[i])
+		if data[i] != 0xFF {
+			allOnes = false
+		}
+	}
+	if !keepMarker && allOnes {
+		return math.MaxUint64, length
+	}
+	return value, length
+}
@@ -0,0 +1,44 @@
+package adapter
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// testMP4 构造只含 ftyp 与 moov/mvhd（version 0）的最小 mp4，withMoov 为 false 时去掉时长元数据
+func testMP4(seconds uint32, withMoov bool) []byte {
+	box := func(kind string, payload []byte) []byte {
+		b := make([]byte, 8, 8+len(payload))
+		binary.BigEndian.PutUint32(b, uint32(8+len(payload)))
+		copy(b[4:], kind)
+		return append(b, payload...)
+	}
+	data := box("ftyp", []byte("isom\x00\x00\x02\x00isommp41"))
+	if withMoov {
+		mvhd := make([]byte, 100)
+		binary.BigEndian.PutUint32(mvhd[12:16], 1000)
+		binary.BigEndian.PutUint32(mvhd[16:20], seconds*1000)
+		data = append(data, box("moov", box("mvhd", mvhd))...)
+	}
+	return append(data, box("mdat", make([]byte, 64))...)
+}
+
+func TestPrepareVideoDuration(t *testing.T) {
+	t.Setenv("VIDEO_MAX_DURATION", "60s")
+	if mime, err := prepareVideo(testMP4(30, true)); err != nil || mime != videoMimeMP4 {
+		t.Fatalf("30s clip: mime = %q, err = %v", mime, err)
+	}
+	if _, err := prepareVideo(testMP4(90, true)); err == nil || !strings.Contains(err.Error(), "too long") {
+		t.Fatalf("90s clip: err = %v, want too long", err)
+	}
+	// 去掉元数据的视频无法读取时长，设置了上限时同样拒绝
+	if _, err := prepareVideo(testMP4(0, false)); err == nil || !strings.Contains(err.Error(), "could not determine") {
+		t.Fatalf("clip without moov: err = %v, want unknown duration", err)
+	}
+
+	t.Setenv("VIDEO_MAX_DURATION", "0")
+	if _, err := prepareVideo(testMP4(0, false)); err != nil {
+		t.Fatalf("clip without moov and no limit: err = %v", err)
+	}
+}
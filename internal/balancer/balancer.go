@@ -1,7 +1,13 @@
 package balancer
 
 import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
 	"gemini-web2api/internal/gemini"
+	"gemini-web2api/internal/storage"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 )
@@ -10,20 +16,51 @@ type AccountEntry struct {
 	Client    *gemini.Client
 	AccountID string
 	ProxyURL  string
+	// Models 账号可以使用的模型（ALLOWED_MODELS / ALLOWED_MODELS_{id}），为空表示不限制
+	Models []string
+}
+
+// Serves 报告账号是否可以处理 model 的请求，model 为空或账号未限制模型时返回 true
+func (e AccountEntry) Serves(model string) bool {
+	if model == "" || len(e.Models) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(e.Models, func(m string) bool { return strings.EqualFold(m, model) })
 }
 
 type AccountPool struct {
 	entries []AccountEntry
 	index   uint64
 	mu      sync.RWMutex
+
+	bindMu   sync.Mutex
+	rotation Rotation
+
+	// store 保存窗口绑定（binding:）与管理员手动停用的账号（disabled:，不随重载清除）
+	store storage.Store
 }
 
+// 账号池状态在 storage.Store 中的命名空间
+const (
+	bindingKeyPrefix  = "binding:"
+	disabledKeyPrefix = "disabled:"
+)
+
 func NewAccountPool() *AccountPool {
 	return &AccountPool{
-		entries: make([]AccountEntry, 0),
+		entries:  make([]AccountEntry, 0),
+		rotation: Rotation{Mode: RotationRoundRobin},
+		store:    storage.NewMemory(),
 	}
 }
 
+// SetStore 使用指定的存储保存账号池状态（如 STORAGE_BACKEND=file 时停用状态在重启后保留），需在处理请求前调用
+func (p *AccountPool) SetStore(store storage.Store) {
+	p.bindMu.Lock()
+	defer p.bindMu.Unlock()
+	p.store = store
+}
+
 func (p *AccountPool) Clear() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -41,15 +78,129 @@ func (p *AccountPool) Add(client *gemini.Client, accountID string, proxyURL stri
 	})
 }
 
+// Next 轮询返回下一个可用账号，跳过手动停用、需要重新认证或处于限流冷却期的账号
 func (p *AccountPool) Next() (*gemini.Client, string) {
+	return p.NextForModel("")
+}
+
+// NextForModel 与 Next 相同，同时跳过不能使用 model 的账号
+func (p *AccountPool) NextForModel(model string) (*gemini.Client, string) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
-	if len(p.entries) == 0 {
+	n := uint64(len(p.entries))
+	if n == 0 {
 		return nil, ""
 	}
-	idx := atomic.AddUint64(&p.index, 1) - 1
-	entry := p.entries[idx%uint64(len(p.entries))]
-	return entry.Client, entry.AccountID
+	for i := uint64(0); i < n; i++ {
+		idx := atomic.AddUint64(&p.index, 1) - 1
+		entry := p.entries[idx%n]
+		if entry.Serves(model) && !p.isDisabled(entry.AccountID) && entry.Client.Available() {
+			return entry.Client, entry.AccountID
+		}
+	}
+	return nil, ""
+}
+
+// Pinned 按 key 固定选择可以使用 model 的账号（rendezvous 哈希）：相同 key 总是得到同一个账号，
+// 增删账号时只有原本落在这些账号上的 key 会改变；首选账号不可用时按同一 key 的固定顺序选择下一个
+func (p *AccountPool) Pinned(key, model string) (*gemini.Client, string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	type candidate struct {
+		entry AccountEntry
+		score uint64
+	}
+	var candidates []candidate
+	for _, entry := range p.entries {
+		if !entry.Serves(model) {
+			continue
+		}
+		sum := sha256.Sum256([]byte(key + "|" + entry.AccountID))
+		candidates = append(candidates, candidate{entry: entry, score: binary.BigEndian.Uint64(sum[:8])})
+	}
+	slices.SortFunc(candidates, func(a, b candidate) int { return cmp.Compare(b.score, a.score) })
+	for _, c := range candidates {
+		if !p.isDisabled(c.entry.AccountID) && c.entry.Client.Available() {
+			return c.entry.Client, c.entry.AccountID
+		}
+	}
+	return nil, ""
+}
+
+// ServesModel 报告账号 accountID 是否可以使用 model，账号不存在时返回 false
+func (p *AccountPool) ServesModel(accountID, model string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, entry := range p.entries {
+		if entry.AccountID == accountID {
+			return entry.Serves(model)
+		}
+	}
+	return false
+}
+
+// HasModel 报告是否至少有一个账号（不论当前是否可用）可以使用 model
+func (p *AccountPool) HasModel(model string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, entry := range p.entries {
+		if entry.Serves(model) {
+			return true
+		}
+	}
+	return false
+}
+
+// Entries 返回当前账号列表的快照
+func (p *AccountPool) Entries() []AccountEntry {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]AccountEntry(nil), p.entries...)
+}
+
+// Get 按账号 ID 查找客户端，用于会话粘滞路由
+func (p *AccountPool) Get(accountID string) *gemini.Client {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, entry := range p.entries {
+		if entry.AccountID == accountID {
+			return entry.Client
+		}
+	}
+	return nil
+}
+
+// SetDisabled 手动停用或重新启用账号（保留 Cookie 与客户端），账号不存在时返回 false
+func (p *AccountPool) SetDisabled(accountID string, disabled bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	found := false
+	for _, entry := range p.entries {
+		if entry.AccountID == accountID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+	if disabled {
+		p.store.Set(disabledKeyPrefix+accountID, []byte("true"), 0)
+	} else {
+		p.store.Delete(disabledKeyPrefix + accountID)
+	}
+	return true
+}
+
+// Disabled 报告账号是否被手动停用
+func (p *AccountPool) Disabled(accountID string) bool {
+	return p.isDisabled(accountID)
+}
+
+func (p *AccountPool) isDisabled(accountID string) bool {
+	_, ok := p.store.Get(disabledKeyPrefix + accountID)
+	return ok
 }
 
 func (p *AccountPool) Size() int {
@@ -58,7 +209,23 @@ func (p *AccountPool) Size() int {
 	return len(p.entries)
 }
 
-func (p *AccountPool) ReplaceAccounts(newAccountIDs []string, changedEntries map[string]AccountEntry) {
+// ReloadResult 一次账号重载的结果，供日志与 /admin/reload 返回
+type ReloadResult struct {
+	Added     []string
+	Updated   []string
+	Removed   []string
+	Unchanged []string
+	// Failed 重新初始化失败、已从负载均衡池移除的账号（等待后台重试）
+	Failed []string
+	// Unhealthy 未变更但仍处于 needs_reauth 状态的账号，保留在池中但不参与负载均衡
+	Unhealthy []string
+	// Healthy 重建后池中可以参与负载均衡的账号：重新初始化成功的账号，以及未变更且不处于 needs_reauth 的账号
+	Healthy []string
+}
+
+// ReplaceAccounts 按 newAccountIDs 重建账号列表：changedEntries 中重新初始化成功的账号替换旧客户端，
+// failedIDs 中初始化失败的账号即使存在旧客户端也不再保留，避免把已知失效的账号重新加入，其余账号沿用旧客户端
+func (p *AccountPool) ReplaceAccounts(newAccountIDs []string, changedEntries map[string]AccountEntry, failedIDs map[string]bool) ReloadResult {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -67,12 +234,37 @@ func (p *AccountPool) ReplaceAccounts(newAccountIDs []string, changedEntries map
 		oldEntries[entry.AccountID] = entry
 	}
 
+	result := ReloadResult{}
+	kept := make(map[string]bool, len(newAccountIDs))
 	p.entries = make([]AccountEntry, 0, len(newAccountIDs))
 	for _, accountID := range newAccountIDs {
+		kept[accountID] = true
+		oldEntry, existed := oldEntries[accountID]
 		if newEntry, changed := changedEntries[accountID]; changed {
 			p.entries = append(p.entries, newEntry)
-		} else if oldEntry, existed := oldEntries[accountID]; existed {
+			result.Healthy = append(result.Healthy, accountID)
+			if existed {
+				result.Updated = append(result.Updated, accountID)
+			} else {
+				result.Added = append(result.Added, accountID)
+			}
+		} else if failedIDs[accountID] {
+			result.Failed = append(result.Failed, accountID)
+		} else if existed {
 			p.entries = append(p.entries, oldEntry)
+			result.Unchanged = append(result.Unchanged, accountID)
+			if oldEntry.Client.NeedsReauth() {
+				result.Unhealthy = append(result.Unhealthy, accountID)
+			} else {
+				result.Healthy = append(result.Healthy, accountID)
+			}
+		}
+	}
+
+	for _, entry := range oldEntries {
+		if !kept[entry.AccountID] {
+			result.Removed = append(result.Removed, entry.AccountID)
 		}
 	}
+	return result
 }
@@ -0,0 +1,133 @@
+package balancer
+
+import (
+	"gemini-web2api/internal/gemini"
+	"gemini-web2api/internal/storage"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 账号选择策略
+const (
+	RotationRoundRobin = "round_robin" // 每个请求轮换账号（默认）
+	RotationWindow     = "window"      // 同一客户端在窗口内固定使用一个账号，窗口结束后轮换
+)
+
+const (
+	defaultRotationWindow = 5 * time.Minute
+	maxRotationBindings   = 1024
+)
+
+// Rotation 账号轮换配置。Window 模式下 MaxRequests 与 Duration 任一达到即轮换，为 0 表示不限制
+type Rotation struct {
+	Mode        string
+	MaxRequests int
+	Duration    time.Duration
+}
+
+// RotationFromEnv 读取 ACCOUNT_STRATEGY / ACCOUNT_WINDOW_REQUESTS / ACCOUNT_WINDOW_DURATION
+func RotationFromEnv() Rotation {
+	r := Rotation{Mode: RotationRoundRobin}
+	if strings.ToLower(strings.TrimSpace(os.Getenv("ACCOUNT_STRATEGY"))) != RotationWindow {
+		return r
+	}
+	r.Mode = RotationWindow
+
+	if v := strings.TrimSpace(os.Getenv("ACCOUNT_WINDOW_REQUESTS")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			r.MaxRequests = n
+		} else {
+			log.Printf("[Balancer] Invalid ACCOUNT_WINDOW_REQUESTS '%s', ignored", v)
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("ACCOUNT_WINDOW_DURATION")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			r.Duration = d
+		} else {
+			log.Printf("[Balancer] Invalid ACCOUNT_WINDOW_DURATION '%s', ignored", v)
+		}
+	}
+	if r.MaxRequests == 0 && r.Duration == 0 {
+		r.Duration = defaultRotationWindow
+	}
+	return r
+}
+
+// binding 某个客户端当前窗口绑定的账号
+type binding struct {
+	AccountID string    `json:"account_id"`
+	Count     int       `json:"count"`
+	Since     time.Time `json:"since"`
+}
+
+func (r Rotation) expired(b *binding, now time.Time) bool {
+	if r.MaxRequests > 0 && b.Count >= r.MaxRequests {
+		return true
+	}
+	return r.Duration > 0 && now.Sub(b.Since) >= r.Duration
+}
+
+// bindingTTL 绑定在存储中的过期时间：按时长轮换时随窗口过期，只按请求数轮换时不设过期
+func (r Rotation) bindingTTL(b *binding, now time.Time) time.Duration {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return max(b.Since.Add(r.Duration).Sub(now), time.Millisecond)
+}
+
+// SetRotation 设置账号轮换策略，已有的窗口绑定全部失效
+func (p *AccountPool) SetRotation(r Rotation) {
+	p.bindMu.Lock()
+	defer p.bindMu.Unlock()
+	p.rotation = r
+	for _, key := range p.store.Keys(bindingKeyPrefix) {
+		p.store.Delete(key)
+	}
+}
+
+// NextFor 按轮换策略为客户端 key 选择可以使用 model 的账号：round_robin 模式等同于 NextForModel；
+// window 模式下窗口内复用同一账号，账号不可用或窗口结束后轮换到下一个。
+// 绑定的账号不能使用 model 时临时改用其他账号，不影响绑定
+func (p *AccountPool) NextFor(key, model string) (*gemini.Client, string) {
+	p.bindMu.Lock()
+	defer p.bindMu.Unlock()
+
+	if p.rotation.Mode != RotationWindow || key == "" {
+		return p.NextForModel(model)
+	}
+
+	now := time.Now()
+	storeKey := bindingKeyPrefix + key
+	var b binding
+	if storage.GetJSON(p.store, storeKey, &b) && !p.rotation.expired(&b, now) {
+		if !p.ServesModel(b.AccountID, model) {
+			return p.NextForModel(model)
+		}
+		if client := p.Get(b.AccountID); client != nil && client.Available() && !p.Disabled(b.AccountID) {
+			b.Count++
+			storage.SetJSON(p.store, storeKey, b, p.rotation.bindingTTL(&b, now))
+			return client, b.AccountID
+		}
+	}
+
+	client, accountID := p.NextForModel(model)
+	if client == nil {
+		p.store.Delete(storeKey)
+		return nil, ""
+	}
+
+	if keys := p.store.Keys(bindingKeyPrefix); len(keys) >= maxRotationBindings {
+		for _, k := range keys {
+			var old binding
+			if !storage.GetJSON(p.store, k, &old) || p.rotation.expired(&old, now) {
+				p.store.Delete(k)
+			}
+		}
+	}
+	b = binding{AccountID: accountID, Count: 1, Since: now}
+	storage.SetJSON(p.store, storeKey, b, p.rotation.bindingTTL(&b, now))
+	return client, accountID
+}
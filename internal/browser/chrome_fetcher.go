@@ -11,6 +11,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -110,12 +111,25 @@ func findChromePath() string {
 	return "chrome"
 }
 
+// FetchCookiesFromProfile 通过 DevTools 协议读取 profile 的 Cookie。Chrome 已在运行时不能再用同一个用户数据目录启动：
+// 运行中的实例开放了调试端口且当前只打开了该配置文件时直接连接，在新标签页中读取；否则把配置文件复制到临时目录后启动无头 Chrome
 func FetchCookiesFromProfile(profile ChromeProfile) (map[string]string, error) {
+	userDataDir := getChomeUserDataDir()
+	if chromeRunning(userDataDir) {
+		if port, ok := runningDevToolsPort(userDataDir, profile); ok {
+			return fetchCookiesFromRunningChrome(fmt.Sprintf("127.0.0.1:%d", port))
+		}
+		tmpDir, err := copyChromeProfile(userDataDir, profile)
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmpDir)
+		userDataDir = tmpDir
+	}
+
 	chromePath := findChromePath()
 	port := 20000 + time.Now().Nanosecond()%10000
 
-	userDataDir := getChomeUserDataDir()
-
 	cmd := exec.Command(chromePath,
 		fmt.Sprintf("--remote-debugging-port=%d", port),
 		fmt.Sprintf("--user-data-dir=%s", userDataDir),
@@ -157,7 +171,38 @@ func FetchCookiesFromProfile(profile ChromeProfile) (map[string]string, error) {
 		return nil, fmt.Errorf("failed to get debugger URL")
 	}
 
-	wsURL := targets[0].WebSocketDebuggerUrl
+	return readCookiesFromTarget(targets[0].WebSocketDebuggerUrl)
+}
+
+// fetchCookiesFromRunningChrome 在已开放调试端口（addr 为 host:port）的 Chrome 中新建一个标签页读取 Cookie，
+// 完成后关闭该标签页，不影响用户已打开的页面
+func fetchCookiesFromRunningChrome(addr string) (map[string]string, error) {
+	base := "http://" + addr
+	req, _ := http.NewRequest(http.MethodPut, base+"/json/new?about:blank", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a tab in the running Chrome: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var target struct {
+		ID                   string `json:"id"`
+		WebSocketDebuggerUrl string `json:"webSocketDebuggerUrl"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&target); err != nil || target.WebSocketDebuggerUrl == "" {
+		return nil, fmt.Errorf("failed to open a tab in the running Chrome")
+	}
+	defer func() {
+		if closeResp, err := http.Get(base + "/json/close/" + target.ID); err == nil {
+			closeResp.Body.Close()
+		}
+	}()
+
+	return readCookiesFromTarget(target.WebSocketDebuggerUrl)
+}
+
+// readCookiesFromTarget 让标签页打开 gemini.google.com 后通过 Network.getAllCookies 读取 Google 账号 Cookie
+func readCookiesFromTarget(wsURL string) (map[string]string, error) {
 	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to WebSocket: %v", err)
@@ -204,8 +249,9 @@ func FetchCookiesFromProfile(profile ChromeProfile) (map[string]string, error) {
 			}
 			if result.ID == 4 {
 				cookies := make(map[string]string)
+				names := CookieNames()
 				for _, c := range result.Result.Cookies {
-					if (c.Name == "__Secure-1PSID" || c.Name == "__Secure-1PSIDTS") && strings.Contains(c.Domain, "google.com") {
+					if slices.Contains(names, c.Name) && strings.Contains(c.Domain, "google.com") {
 						cookies[c.Name] = c.Value
 					}
 				}
@@ -217,7 +263,7 @@ func FetchCookiesFromProfile(profile ChromeProfile) (map[string]string, error) {
 
 	select {
 	case cookies := <-resultChan:
-		if cookies["__Secure-1PSID"] == "" {
+		if cookies[PrimaryCookie] == "" {
 			return nil, fmt.Errorf("cookie not found, please login to Google in this profile")
 		}
 		return cookies, nil
@@ -230,9 +276,13 @@ func FetchCookiesFromProfile(profile ChromeProfile) (map[string]string, error) {
 
 func RunFetchCookies() error {
 	fmt.Println("=== Chrome Cookie Fetcher ===")
-	fmt.Println("\n[!] Please close Chrome browser before proceeding!")
-	fmt.Println("    (Press Enter to continue after closing Chrome...)")
-	bufio.NewReader(os.Stdin).ReadString('\n')
+	if addr := chromeDebugAddr(); addr != "" {
+		return fetchFromDebugPort(addr)
+	}
+	if chromeRunning(getChomeUserDataDir()) {
+		fmt.Println("\n[i] Chrome is running: profiles will be copied to a temporary directory")
+		fmt.Println("    (or read through its --remote-debugging-port when one is open).")
+	}
 
 	fmt.Println("Scanning Chrome profiles...")
 	profiles, err := ListChromeProfiles()
@@ -312,6 +362,7 @@ func RunFetchCookies() error {
 		allResults[res.index] = res
 	}
 
+	names := CookieNames()
 	allCookies := make(map[string]string)
 	successCount := 0
 	for _, res := range allResults {
@@ -324,8 +375,9 @@ func RunFetchCookies() error {
 		if res.profile.Name != "Default" {
 			suffix = "_" + strings.ReplaceAll(res.profile.DisplayName, " ", "_")
 		}
-		allCookies["__Secure-1PSID"+suffix] = res.cookies["__Secure-1PSID"]
-		allCookies["__Secure-1PSIDTS"+suffix] = res.cookies["__Secure-1PSIDTS"]
+		for _, name := range names {
+			allCookies[name+suffix] = res.cookies[name]
+		}
 		successCount++
 		fmt.Println("OK")
 	}
@@ -344,15 +396,48 @@ func RunFetchCookies() error {
 		if res.profile.Name != "Default" {
 			suffix = "_" + strings.ReplaceAll(res.profile.DisplayName, " ", "_")
 		}
-		psidKey := "__Secure-1PSID" + suffix
-		psidtsKey := "__Secure-1PSIDTS" + suffix
-
-		orderedKeys = append(orderedKeys, psidKey, psidtsKey)
-		orderedCookies[psidKey] = allCookies[psidKey]
-		orderedCookies[psidtsKey] = allCookies[psidtsKey]
+		for _, name := range names {
+			key := name + suffix
+			orderedKeys = append(orderedKeys, key)
+			orderedCookies[key] = allCookies[key]
+		}
 	}
 
 	saveToEnvWithOrder(orderedKeys, orderedCookies)
 	fmt.Printf("\nDone! Saved %d/%d cookie pairs to .env\n", successCount, len(selectedProfiles))
 	return nil
 }
+
+// chromeDebugAddr 读取 CHROME_DEBUG_PORT：端口号（连接本机）或 host:port，未设置时返回空串
+func chromeDebugAddr() string {
+	v := strings.TrimSpace(os.Getenv("CHROME_DEBUG_PORT"))
+	v = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(v, "http://"), "ws://"), "/")
+	if v == "" {
+		return ""
+	}
+	if _, err := strconv.Atoi(v); err == nil {
+		return "127.0.0.1:" + v
+	}
+	return v
+}
+
+// fetchFromDebugPort 从已用 --remote-debugging-port 启动的 Chrome 读取当前配置文件的 Cookie，保存为默认账号。
+// 不启动新的 Chrome，也不需要关闭浏览器；读取的是该实例最近使用的窗口所属的配置文件
+func fetchFromDebugPort(addr string) error {
+	fmt.Printf("Connecting to Chrome DevTools at %s...\n", addr)
+	cookies, err := fetchCookiesFromRunningChrome(addr)
+	if err != nil {
+		return err
+	}
+
+	names := CookieNames()
+	var keys []string
+	for _, name := range names {
+		if cookies[name] != "" {
+			keys = append(keys, name)
+		}
+	}
+	saveToEnvWithOrder(keys, cookies)
+	fmt.Println("\nDone! Saved cookies of the running Chrome profile to .env as the default account")
+	return nil
+}
@@ -0,0 +1,115 @@
+package browser
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chromeRunning 判断是否已有 Chrome 使用该用户数据目录：运行中的 Chrome 在目录下持有
+// SingletonLock（Linux / macOS 上是符号链接）或 lockfile（Windows），正常退出时删除
+func chromeRunning(userDataDir string) bool {
+	name := "SingletonLock"
+	if runtime.GOOS == "windows" {
+		name = "lockfile"
+	}
+	_, err := os.Lstat(filepath.Join(userDataDir, name))
+	return err == nil
+}
+
+// runningDevToolsPort 返回运行中的 Chrome 对外开放的 --remote-debugging-port，可以用来读取 profile 的 Cookie 时才返回 true。
+// Chrome 把实际端口写在用户数据目录的 DevToolsActivePort 中；一个实例可能同时打开多个配置文件，
+// 新标签页总是在最近使用的窗口所属的配置文件中打开，因此只有当前活动的配置文件恰好只有 profile 一个时才连接
+func runningDevToolsPort(userDataDir string, profile ChromeProfile) (int, bool) {
+	data, err := os.ReadFile(filepath.Join(userDataDir, "DevToolsActivePort"))
+	if err != nil {
+		return 0, false
+	}
+	port, err := strconv.Atoi(strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0]))
+	if err != nil || port <= 0 {
+		return 0, false
+	}
+	if active := activeChromeProfiles(userDataDir); len(active) != 1 || active[0] != profile.Name {
+		return 0, false
+	}
+
+	client := http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/json/version", port))
+	if err != nil {
+		// 端口文件是上次带调试端口启动时留下的，当前实例没有开放端口
+		return 0, false
+	}
+	resp.Body.Close()
+	return port, resp.StatusCode == http.StatusOK
+}
+
+// activeChromeProfiles 读取 Local State 中当前打开的配置文件，读取失败时返回 nil
+func activeChromeProfiles(userDataDir string) []string {
+	data, err := os.ReadFile(filepath.Join(userDataDir, "Local State"))
+	if err != nil {
+		return nil
+	}
+	var localState struct {
+		Profile struct {
+			LastActiveProfiles []string `json:"last_active_profiles"`
+		} `json:"profile"`
+	}
+	if json.Unmarshal(data, &localState) != nil {
+		return nil
+	}
+	return localState.Profile.LastActiveProfiles
+}
+
+// chromeProfileFiles 复制配置文件时需要的文件：Cookie 数据库与解密 Cookie 所需的 Local State / Preferences，
+// 缓存等其他内容不复制，避免复制体积过大或被运行中的 Chrome 占用的文件
+var chromeProfileFiles = []string{
+	"Preferences",
+	filepath.Join("Network", "Cookies"),
+	filepath.Join("Network", "Cookies-journal"),
+	"Cookies",
+	"Cookies-journal",
+}
+
+// copyChromeProfile 把 profile 的 Cookie 相关文件复制到临时用户数据目录，返回该目录，调用方负责删除。
+// Windows 上运行中的 Chrome 会独占 Cookie 数据库，此时复制失败，需要先关闭 Chrome
+func copyChromeProfile(userDataDir string, profile ChromeProfile) (string, error) {
+	tmpDir, err := os.MkdirTemp("", "gemini-chrome-")
+	if err != nil {
+		return "", err
+	}
+	if err := copyFile(filepath.Join(userDataDir, "Local State"), filepath.Join(tmpDir, "Local State")); err != nil && !os.IsNotExist(err) {
+		os.RemoveAll(tmpDir)
+		return "", err
+	}
+
+	copied := false
+	for _, name := range chromeProfileFiles {
+		src := filepath.Join(profile.Path, name)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		dst := filepath.Join(tmpDir, profile.Name, name)
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			os.RemoveAll(tmpDir)
+			return "", err
+		}
+		if err := copyFile(src, dst); err != nil {
+			os.RemoveAll(tmpDir)
+			return "", fmt.Errorf("failed to copy %s (close Chrome and try again): %v", name, err)
+		}
+		if strings.HasSuffix(name, "Cookies") {
+			copied = true
+		}
+	}
+	if !copied {
+		os.RemoveAll(tmpDir)
+		return "", fmt.Errorf("cookie database not found in profile %s", profile.Name)
+	}
+	return tmpDir, nil
+}
@@ -2,10 +2,12 @@ package browser
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"slices"
 	"strings"
 
@@ -81,25 +83,55 @@ func loadCookiesFromBrowser() (map[string]string, error) {
 		}
 	}
 
-	for _, c := range foundCookies {
-		if c.Name == "__Secure-1PSID" || c.Name == "__Secure-1PSIDTS" {
-			if strings.Contains(c.Domain, "google.com") {
-				cookies[c.Name] = c.Value
-			}
+	collectGoogleCookies(cookies, foundCookies)
+
+	// macOS 上没有 Firefox 登录态时回退到 Safari
+	if cookies[PrimaryCookie] == "" && runtime.GOOS == "darwin" {
+		fmt.Println("Google cookies not found in Firefox, trying Safari...")
+		safariCookies, safariErr := readSafariCookies()
+		if safariErr != nil {
+			fmt.Printf("Warning: Could not read Safari cookies: %v\n", safariErr)
+			fmt.Println("Tip: Grant your terminal Full Disk Access (System Settings > Privacy & Security) so it can read Safari cookies.")
 		}
+		collectGoogleCookies(cookies, safariCookies)
 	}
 
-	if val, ok := cookies["__Secure-1PSID"]; !ok || val == "" {
-		return nil, fmt.Errorf("cookie '__Secure-1PSID' not found in browser. Please ensure you are logged into Google in your browser")
+	if val, ok := cookies[PrimaryCookie]; !ok || val == "" {
+		return nil, fmt.Errorf("cookie '%s' not found in browser. Please ensure you are logged into Google in your browser", PrimaryCookie)
 	}
 
 	return cookies, nil
 }
 
-func LoadMultiCookies(accountIDs []string) ([]map[string]string, []string, []string, error) {
-	var results []map[string]string
-	var usedIDs []string
-	var proxyURLs []string
+// collectGoogleCookies 从浏览器 Cookie 中取出 google.com 下 CookieNames 列出的 Cookie
+func collectGoogleCookies(cookies map[string]string, found []*kooky.Cookie) {
+	names := CookieNames()
+	for _, c := range found {
+		if slices.Contains(names, c.Name) {
+			if strings.Contains(c.Domain, "google.com") {
+				cookies[c.Name] = c.Value
+			}
+		}
+	}
+}
+
+// AccountConfig 单个账号的完整配置
+type AccountConfig struct {
+	ID       string
+	Cookies  map[string]string
+	ProxyURL string
+	// Headers 覆盖/追加到 Gemini 请求中的额外 HTTP 头，来自 HEADERS 与 HEADERS_{id}
+	Headers map[string]string
+	// Models 账号可以使用的模型，来自 ALLOWED_MODELS_{id}（未设置时为 ALLOWED_MODELS），为空表示不限制
+	Models []string
+}
+
+func LoadAccounts(accountIDs []string) ([]AccountConfig, error) {
+	if raw := strings.TrimSpace(os.Getenv("GEMINI_ACCOUNTS")); raw != "" {
+		return loadAccountsFromEnv(raw, accountIDs)
+	}
+
+	var results []AccountConfig
 
 	envMap := make(map[string]string)
 
@@ -109,14 +141,17 @@ func LoadMultiCookies(accountIDs []string) ([]map[string]string, []string, []str
 		cookies, browserErr := loadCookiesFromBrowser()
 		if browserErr != nil {
 			createEnvTemplate()
-			return nil, nil, nil, fmt.Errorf("failed to auto-detect cookies: %v. A template .env file has been created", browserErr)
+			return nil, fmt.Errorf("failed to auto-detect cookies: %v. A template .env file has been created", browserErr)
 		}
 		saveToEnv(cookies)
-		results = append(results, cookies)
-		usedIDs = append(usedIDs, "")
-		proxyURLs = append(proxyURLs, strings.TrimSpace(os.Getenv("PROXY")))
+		results = append(results, AccountConfig{
+			Cookies:  cookies,
+			ProxyURL: strings.TrimSpace(os.Getenv("PROXY")),
+			Headers:  resolveHeaders(map[string]string{"HEADERS": os.Getenv("HEADERS")}, ""),
+			Models:   resolveModels(map[string]string{"ALLOWED_MODELS": os.Getenv("ALLOWED_MODELS")}, ""),
+		})
 		fmt.Println("Auto-detected cookies from browser and saved to .env")
-		return results, usedIDs, proxyURLs, nil
+		return results, nil
 	}
 
 	lines := strings.Split(string(content), "\n")
@@ -133,13 +168,13 @@ func LoadMultiCookies(accountIDs []string) ([]map[string]string, []string, []str
 
 	if len(accountIDs) == 0 || (len(accountIDs) == 1 && accountIDs[0] == "") {
 		accountIDs = []string{}
-		if envMap["__Secure-1PSID"] != "" {
+		if envMap[PrimaryCookie] != "" {
 			accountIDs = append(accountIDs, "")
 		}
 		var extraIDs []string
 		for key := range envMap {
-			if strings.HasPrefix(key, "__Secure-1PSID_") && key != "__Secure-1PSID" {
-				suffix := strings.TrimPrefix(key, "__Secure-1PSID_")
+			if strings.HasPrefix(key, PrimaryCookie+"_") {
+				suffix := strings.TrimPrefix(key, PrimaryCookie+"_")
 				extraIDs = append(extraIDs, suffix)
 			}
 		}
@@ -151,48 +186,52 @@ func LoadMultiCookies(accountIDs []string) ([]map[string]string, []string, []str
 		fmt.Println("No accounts configured in .env, attempting to auto-detect cookies from browser...")
 		cookies, browserErr := loadCookiesFromBrowser()
 		if browserErr != nil {
-			return nil, nil, nil, fmt.Errorf("no accounts in .env and failed to auto-detect: %v", browserErr)
+			return nil, fmt.Errorf("no accounts in .env and failed to auto-detect: %v", browserErr)
 		}
 		saveToEnv(cookies)
-		results = append(results, cookies)
-		usedIDs = append(usedIDs, "")
-		proxyURLs = append(proxyURLs, strings.TrimSpace(envMap["PROXY"]))
+		results = append(results, AccountConfig{
+			Cookies:  cookies,
+			ProxyURL: strings.TrimSpace(envMap["PROXY"]),
+			Headers:  resolveHeaders(envMap, ""),
+			Models:   resolveModels(envMap, ""),
+		})
 		fmt.Println("Auto-detected cookies from browser and saved to .env")
-		return results, usedIDs, proxyURLs, nil
+		return results, nil
 	}
 
 	fmt.Printf("Auto-detected accounts: %v\n", accountIDs)
 
+	names := cookieNamesFrom(envMap)
 	for _, id := range accountIDs {
-		var psidKey, psidtsKey string
-		if id == "" {
-			psidKey = "__Secure-1PSID"
-			psidtsKey = "__Secure-1PSIDTS"
-		} else {
-			psidKey = fmt.Sprintf("__Secure-1PSID_%s", id)
-			psidtsKey = fmt.Sprintf("__Secure-1PSIDTS_%s", id)
+		cookies := make(map[string]string, len(names))
+		for _, name := range names {
+			key := name
+			if id != "" {
+				key = name + "_" + id
+			}
+			cookies[name] = envMap[key]
 		}
 
-		psid := envMap[psidKey]
-		psidts := envMap[psidtsKey]
-
-		if psid == "" {
+		if cookies[PrimaryCookie] == "" {
 			displayID := id
 			if displayID == "" {
 				displayID = "default"
 			}
+			psidKey := PrimaryCookie
+			if id != "" {
+				psidKey += "_" + id
+			}
 			fmt.Printf("Warning: Account '%s' missing %s, skipped\n", displayID, psidKey)
 			continue
 		}
 
-		cookies := map[string]string{
-			"__Secure-1PSID":   psid,
-			"__Secure-1PSIDTS": psidts,
-		}
-		proxyURL := resolveProxyURL(envMap, id)
-		results = append(results, cookies)
-		usedIDs = append(usedIDs, id)
-		proxyURLs = append(proxyURLs, proxyURL)
+		results = append(results, AccountConfig{
+			ID:       id,
+			Cookies:  cookies,
+			ProxyURL: resolveProxyURL(envMap, id),
+			Headers:  resolveHeaders(envMap, id),
+			Models:   resolveModels(envMap, id),
+		})
 		displayID := id
 		if displayID == "" {
 			displayID = "default"
@@ -201,15 +240,19 @@ func LoadMultiCookies(accountIDs []string) ([]map[string]string, []string, []str
 	}
 
 	if len(results) == 0 {
-		return nil, nil, nil, fmt.Errorf("no valid accounts found in .env")
+		return nil, fmt.Errorf("no valid accounts found in .env")
 	}
 
-	return results, usedIDs, proxyURLs, nil
+	return results, nil
 }
 
 func createEnvTemplate() {
-	template := "__Secure-1PSID=\n__Secure-1PSIDTS=\nACCOUNTS=\nPROXY=\nPROXY_API_KEY=\nPORT=8007\n"
-	err := os.WriteFile(".env", []byte(template), 0644)
+	var template strings.Builder
+	for _, name := range CookieNames() {
+		template.WriteString(name + "=\n")
+	}
+	template.WriteString("ACCOUNTS=\nPROXY=\nPROXY_API_KEY=\nPORT=8007\n")
+	err := os.WriteFile(".env", []byte(template.String()), 0644)
 	if err != nil {
 		fmt.Printf("Warning: Failed to create .env template: %v\n", err)
 	} else {
@@ -310,3 +353,55 @@ func resolveProxyURL(envMap map[string]string, accountID string) string {
 
 	return proxyURL
 }
+
+// resolveHeaders 解析 HEADERS（全局）与 HEADERS_{id}（单账号）中的 JSON 对象，单账号配置覆盖同名全局配置
+func resolveHeaders(envMap map[string]string, accountID string) map[string]string {
+	headers := make(map[string]string)
+
+	keys := []string{"HEADERS"}
+	if accountID != "" {
+		keys = append(keys, fmt.Sprintf("HEADERS_%s", accountID))
+	}
+
+	for _, key := range keys {
+		raw := strings.TrimSpace(envMap[key])
+		if raw == "" {
+			continue
+		}
+		var parsed map[string]string
+		if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+			fmt.Printf("Warning: %s is not a valid JSON object, ignored: %v\n", key, err)
+			continue
+		}
+		for k, v := range parsed {
+			headers[k] = v
+		}
+	}
+
+	if len(headers) == 0 {
+		return nil
+	}
+	return headers
+}
+
+// resolveModels 解析 ALLOWED_MODELS_{id}（单账号）或 ALLOWED_MODELS（全局）中逗号分隔的模型名，单账号配置优先
+func resolveModels(envMap map[string]string, accountID string) []string {
+	raw := strings.TrimSpace(envMap["ALLOWED_MODELS"])
+	if accountID != "" {
+		if v := strings.TrimSpace(envMap[fmt.Sprintf("ALLOWED_MODELS_%s", accountID)]); v != "" {
+			raw = v
+		}
+	}
+	return cleanModels(strings.Split(raw, ","))
+}
+
+// cleanModels 去掉模型名两端的空白并丢弃空项
+func cleanModels(names []string) []string {
+	var models []string
+	for _, m := range names {
+		if m = strings.TrimSpace(m); m != "" {
+			models = append(models, m)
+		}
+	}
+	return models
+}
@@ -0,0 +1,53 @@
+package browser
+
+import (
+	"os"
+	"slices"
+	"strings"
+)
+
+// PrimaryCookie 必需的登录 Cookie，同时用于在 .env 中识别账号（__Secure-1PSID_{id}）
+const PrimaryCookie = "__Secure-1PSID"
+
+var defaultCookieNames = []string{PrimaryCookie, "__Secure-1PSIDTS"}
+
+// CookieNames 需要读取并随请求发送的 Cookie 名列表，COOKIE_NAMES 逗号分隔可配置，
+// 默认 __Secure-1PSID,__Secure-1PSIDTS。Google 新增认证 Cookie（如 __Secure-1PSIDCC）时追加即可；
+// __Secure-1PSID 总是包含在内并排在第一位
+func CookieNames() []string {
+	return parseCookieNames(os.Getenv("COOKIE_NAMES"))
+}
+
+// cookieNamesFrom 优先使用 .env 中的 COOKIE_NAMES，未配置时回退到环境变量
+func cookieNamesFrom(envMap map[string]string) []string {
+	if v := strings.TrimSpace(envMap["COOKIE_NAMES"]); v != "" {
+		return parseCookieNames(v)
+	}
+	return CookieNames()
+}
+
+func parseCookieNames(v string) []string {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return slices.Clone(defaultCookieNames)
+	}
+
+	names := []string{PrimaryCookie}
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// isCookieKey 判断 .env 中的键是否为已配置的 Cookie（含 _{id} 后缀的多账号形式）
+func isCookieKey(key string, names []string) bool {
+	for _, name := range names {
+		if key == name || strings.HasPrefix(key, name+"_") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package browser
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// envAccount GEMINI_ACCOUNTS 中的单个账号
+type envAccount struct {
+	Name    string            `json:"name"`
+	PSID    string            `json:"psid"`
+	PSIDTS  string            `json:"psidts"`
+	Proxy   string            `json:"proxy"`
+	Headers map[string]string `json:"headers"`
+	// Cookies 额外的 Cookie（如 __Secure-1PSIDCC），只取 COOKIE_NAMES 中列出的名字
+	Cookies map[string]string `json:"cookies"`
+	// Models 账号可以使用的模型，为空时使用 ALLOWED_MODELS
+	Models []string `json:"models"`
+}
+
+// loadAccountsFromEnv 从 GEMINI_ACCOUNTS 环境变量（JSON 数组）读取账号，完全不读写 .env，
+// 适合 Kubernetes / Render / Fly 等以环境变量注入密钥的部署方式。
+// name 为空或 default 表示默认账号；accountIDs 非空时只保留其中列出的账号。
+func loadAccountsFromEnv(raw string, accountIDs []string) ([]AccountConfig, error) {
+	var entries []envAccount
+	if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+		return nil, fmt.Errorf("GEMINI_ACCOUNTS is not a valid JSON array: %v", err)
+	}
+
+	filter := len(accountIDs) > 0 && !(len(accountIDs) == 1 && accountIDs[0] == "")
+	envMap := map[string]string{
+		"PROXY":          os.Getenv("PROXY"),
+		"HEADERS":        os.Getenv("HEADERS"),
+		"ALLOWED_MODELS": os.Getenv("ALLOWED_MODELS"),
+	}
+
+	names := CookieNames()
+	var results []AccountConfig
+	seen := make(map[string]bool)
+	for i, entry := range entries {
+		id := strings.TrimSpace(entry.Name)
+		if id == "default" {
+			id = ""
+		}
+		displayID := id
+		if displayID == "" {
+			displayID = "default"
+		}
+
+		if filter && !slices.Contains(accountIDs, id) {
+			continue
+		}
+		if seen[id] {
+			fmt.Printf("Warning: GEMINI_ACCOUNTS[%d] duplicates account '%s', skipped\n", i, displayID)
+			continue
+		}
+		psid := strings.TrimSpace(entry.PSID)
+		if psid == "" {
+			fmt.Printf("Warning: GEMINI_ACCOUNTS[%d] (account '%s') missing psid, skipped\n", i, displayID)
+			continue
+		}
+		seen[id] = true
+
+		proxyURL := strings.TrimSpace(entry.Proxy)
+		if proxyURL == "" {
+			proxyURL = resolveProxyURL(envMap, "")
+		}
+		headers := resolveHeaders(envMap, "")
+		if len(entry.Headers) > 0 {
+			if headers == nil {
+				headers = make(map[string]string)
+			}
+			for k, v := range entry.Headers {
+				headers[k] = v
+			}
+		}
+
+		cookies := make(map[string]string, len(names))
+		for _, name := range names {
+			cookies[name] = strings.TrimSpace(entry.Cookies[name])
+		}
+		cookies[PrimaryCookie] = psid
+		if psidts := strings.TrimSpace(entry.PSIDTS); psidts != "" {
+			cookies["__Secure-1PSIDTS"] = psidts
+		}
+
+		models := resolveModels(envMap, "")
+		if len(entry.Models) > 0 {
+			models = cleanModels(entry.Models)
+		}
+
+		results = append(results, AccountConfig{
+			ID:       id,
+			Cookies:  cookies,
+			ProxyURL: proxyURL,
+			Headers:  headers,
+			Models:   models,
+		})
+		fmt.Printf("Loaded account '%s' cookies from GEMINI_ACCOUNTS\n", displayID)
+	}
+
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no valid accounts found in GEMINI_ACCOUNTS")
+	}
+	return results, nil
+}
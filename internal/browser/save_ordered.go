@@ -15,6 +15,7 @@ func saveToEnvWithOrder(cookieKeys []string, cookies map[string]string) {
 	}
 
 	var newLines []string
+	names := CookieNames()
 
 	for _, line := range lines {
 		trimmed := strings.TrimSpace(line)
@@ -25,7 +26,7 @@ func saveToEnvWithOrder(cookieKeys []string, cookies map[string]string) {
 		parts := strings.SplitN(line, "=", 2)
 		if len(parts) == 2 {
 			key := strings.TrimSpace(parts[0])
-			if !strings.HasPrefix(key, "__Secure-1PSID") {
+			if !isCookieKey(key, names) {
 				newLines = append(newLines, line)
 			}
 		} else {
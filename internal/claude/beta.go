@@ -0,0 +1,52 @@
+package claude
+
+import "strings"
+
+// DefaultAnthropicVersion 请求未携带 anthropic-version 时在响应头中返回的版本
+const DefaultAnthropicVersion = "2023-06-01"
+
+// Betas 请求头 anthropic-beta 中会影响响应结构的特性，其余 beta（token-efficient-tools、
+// fine-grained-tool-streaming、context-1m 等）在 Gemini Web 上没有对应能力，接受但不改变输出
+type Betas struct {
+	// Raw 请求中声明的全部 beta，原样回显在响应头中
+	Raw []string
+	// PromptCaching prompt-caching-*：usage 中始终包含 cache_creation_input_tokens / cache_read_input_tokens（均为 0）
+	PromptCaching bool
+	// InterleavedThinking interleaved-thinking-*：允许正文开始后再出现 thinking 块；
+	// 未声明时按标准格式只在正文之前输出思考过程，之后的思考内容被丢弃
+	InterleavedThinking bool
+}
+
+// ParseBetas 解析逗号分隔的 anthropic-beta 请求头（可以出现多次）
+func ParseBetas(headers []string) Betas {
+	var b Betas
+	for _, header := range headers {
+		for _, name := range strings.Split(header, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			b.Raw = append(b.Raw, name)
+			switch {
+			case strings.HasPrefix(name, "prompt-caching-"):
+				b.PromptCaching = true
+			case strings.HasPrefix(name, "interleaved-thinking-"):
+				b.InterleavedThinking = true
+			}
+		}
+	}
+	return b
+}
+
+// ApplyUsage 按声明的 beta 补全 usage 字段
+func (b Betas) ApplyUsage(usage *Usage) {
+	if !b.PromptCaching {
+		return
+	}
+	if usage.CacheCreationInputTokens == nil {
+		usage.CacheCreationInputTokens = new(int)
+	}
+	if usage.CacheReadInputTokens == nil {
+		usage.CacheReadInputTokens = new(int)
+	}
+}
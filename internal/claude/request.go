@@ -89,9 +89,7 @@ func TransformRequest(req *ClaudeRequest, projectID string) (map[string]interfac
 	if tools != nil {
 		innerRequest["tools"] = tools
 		innerRequest["toolConfig"] = map[string]interface{}{
-			"functionCallingConfig": map[string]interface{}{
-				"mode": "AUTO",
-			},
+			"functionCallingConfig": buildFunctionCallingConfig(req.ToolChoice),
 		}
 	}
 
@@ -332,6 +330,30 @@ func buildTools(tools []Tool, toolIDMap map[string]string) ([]map[string]interfa
 	return nil, nil
 }
 
+// buildFunctionCallingConfig 把 tool_choice 换算为 Gemini 的 functionCallingConfig：
+// auto 为 AUTO，any 为 ANY，tool 为只允许指定函数的 ANY，none 为 NONE
+func buildFunctionCallingConfig(choice *ToolChoice) map[string]interface{} {
+	callingConfig := map[string]interface{}{
+		"mode": "AUTO",
+	}
+	if choice == nil {
+		return callingConfig
+	}
+
+	switch choice.Type {
+	case "any":
+		callingConfig["mode"] = "ANY"
+	case "tool":
+		callingConfig["mode"] = "ANY"
+		if choice.Name != "" {
+			callingConfig["allowedFunctionNames"] = []string{choice.Name}
+		}
+	case "none":
+		callingConfig["mode"] = "NONE"
+	}
+	return callingConfig
+}
+
 func cleanJSONSchema(schema map[string]interface{}) {
 	blacklist := []string{"$schema", "additionalProperties", "default", "examples", "x-", "definitions", "$ref", "$defs"}
 
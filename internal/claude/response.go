@@ -1,8 +1,11 @@
 package claude
 
 import (
+	"encoding/json"
 	"fmt"
-	"time"
+
+	"gemini-web2api/internal/config"
+	"gemini-web2api/internal/ids"
 )
 
 func TransformResponse(geminiResp *GeminiResponse, requestModel string) (*ClaudeResponse, error) {
@@ -11,7 +14,7 @@ func TransformResponse(geminiResp *GeminiResponse, requestModel string) (*Claude
 	}
 
 	response := &ClaudeResponse{
-		ID:    fmt.Sprintf("msg_%d", time.Now().UnixNano()),
+		ID:    ids.New("msg_"),
 		Type:  "message",
 		Role:  "assistant",
 		Model: requestModel,
@@ -24,18 +27,7 @@ func TransformResponse(geminiResp *GeminiResponse, requestModel string) (*Claude
 		candidate := geminiResp.Candidates[0]
 
 		if candidate.FinishReason != nil {
-			switch *candidate.FinishReason {
-			case "STOP", "MAX_TOKENS":
-				stopReason = "end_turn"
-			case "SAFETY":
-				stopReason = "stop_sequence"
-			case "RECITATION":
-				stopReason = "stop_sequence"
-			case "TOOL_USE":
-				stopReason = "tool_use"
-			default:
-				stopReason = "end_turn"
-			}
+			stopReason = MapFinishReason(*candidate.FinishReason)
 		}
 
 		if candidate.Content != nil {
@@ -60,7 +52,7 @@ func TransformResponse(geminiResp *GeminiResponse, requestModel string) (*Claude
 					if part.FunctionCall.ID != nil {
 						id = *part.FunctionCall.ID
 					} else {
-						id = fmt.Sprintf("toolu_%d", time.Now().UnixNano())
+						id = ids.New("toolu_")
 					}
 					contentBlocks = append(contentBlocks, ContentBlock{
 						Type:  "tool_use",
@@ -73,7 +65,7 @@ func TransformResponse(geminiResp *GeminiResponse, requestModel string) (*Claude
 		}
 
 		if candidate.GroundingMetadata != nil && len(candidate.GroundingMetadata.GroundingChunks) > 0 {
-			toolUseID := fmt.Sprintf("srvtoolu_%d", time.Now().UnixNano())
+			toolUseID := ids.New("srvtoolu_")
 
 			query := ""
 			if len(candidate.GroundingMetadata.WebSearchQueries) > 0 {
@@ -88,6 +80,25 @@ func TransformResponse(geminiResp *GeminiResponse, requestModel string) (*Claude
 					"query": query,
 				},
 			})
+
+			var results []WebSearchResult
+			for _, chunk := range candidate.GroundingMetadata.GroundingChunks {
+				if chunk.Web == nil || chunk.Web.URI == nil {
+					continue
+				}
+				result := WebSearchResult{Type: "web_search_result", URL: *chunk.Web.URI}
+				if chunk.Web.Title != nil {
+					result.Title = *chunk.Web.Title
+				}
+				results = append(results, result)
+			}
+			content, _ := json.Marshal(results)
+			contentBlocks = append(contentBlocks, ContentBlock{
+				Type:             "web_search_tool_result",
+				ToolUseID:        toolUseID,
+				Content:          content,
+				SearchEntryPoint: candidate.GroundingMetadata.SearchEntryPoint,
+			})
 		}
 	}
 
@@ -125,19 +136,7 @@ func TransformResponse(geminiResp *GeminiResponse, requestModel string) (*Claude
 	return response, nil
 }
 
+// MapFinishReason 按 FINISH_REASON_MAP_CLAUDE（未配置时为默认映射）把 Gemini 结束原因换算为 stop_reason
 func MapFinishReason(geminiReason string) string {
-	switch geminiReason {
-	case "STOP":
-		return "end_turn"
-	case "MAX_TOKENS":
-		return "max_tokens"
-	case "SAFETY":
-		return "stop_sequence"
-	case "RECITATION":
-		return "stop_sequence"
-	case "TOOL_USE":
-		return "tool_use"
-	default:
-		return "end_turn"
-	}
+	return config.ClaudeStopReason(geminiReason)
 }
@@ -0,0 +1,81 @@
+package claude
+
+import "strings"
+
+// StopSequenceMatcher 在正文中查找 stop_sequences，命中后截断后续输出。
+// 流式输出时保留可能是某个停止序列开头的尾部，避免序列被拆分到两个片段中而漏判
+type StopSequenceMatcher struct {
+	sequences []string
+	holdback  string
+	matched   string
+	stopped   bool
+}
+
+func NewStopSequenceMatcher(sequences []string) *StopSequenceMatcher {
+	m := &StopSequenceMatcher{}
+	for _, seq := range sequences {
+		if seq != "" {
+			m.sequences = append(m.sequences, seq)
+		}
+	}
+	return m
+}
+
+// Feed 输入一段正文，返回可以立即输出的部分
+func (m *StopSequenceMatcher) Feed(text string) string {
+	if m.stopped {
+		return ""
+	}
+	if len(m.sequences) == 0 {
+		return text
+	}
+
+	buf := m.holdback + text
+	cut, seq := -1, ""
+	for _, s := range m.sequences {
+		if i := strings.Index(buf, s); i >= 0 && (cut < 0 || i < cut) {
+			cut, seq = i, s
+		}
+	}
+	if cut >= 0 {
+		m.holdback = ""
+		m.matched = seq
+		m.stopped = true
+		return buf[:cut]
+	}
+
+	keep := 0
+	for _, s := range m.sequences {
+		if n := overlapSuffixPrefix(buf, s); n > keep {
+			keep = n
+		}
+	}
+	m.holdback = buf[len(buf)-keep:]
+	return buf[:len(buf)-keep]
+}
+
+// Flush 响应结束时返回暂存的尾部
+func (m *StopSequenceMatcher) Flush() string {
+	rest := m.holdback
+	m.holdback = ""
+	return rest
+}
+
+// Matched 返回命中的停止序列，未命中时返回空串
+func (m *StopSequenceMatcher) Matched() string {
+	return m.matched
+}
+
+// overlapSuffixPrefix 返回 s 末尾与 seq 开头重合的最长长度（小于 len(seq)）
+func overlapSuffixPrefix(s, seq string) int {
+	max := len(seq) - 1
+	if max > len(s) {
+		max = len(s)
+	}
+	for n := max; n > 0; n-- {
+		if strings.HasSuffix(s, seq[:n]) {
+			return n
+		}
+	}
+	return 0
+}
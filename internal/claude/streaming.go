@@ -1,14 +1,19 @@
 package claude
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html"
 	"io"
+	"log"
 	"strings"
-	"time"
+
+	"gemini-web2api/internal/config"
+	"gemini-web2api/internal/gemini"
+	"gemini-web2api/internal/ids"
+
+	"github.com/tidwall/gjson"
 )
 
 type StreamingState struct {
@@ -21,11 +26,13 @@ type StreamingState struct {
 	InputTokens      int
 	OutputTokens     int
 	Buffer           bytes.Buffer
+	// PromptCaching 为 true 时 message_start 的 usage 附带缓存相关的 token 字段，见 Betas
+	PromptCaching bool
 }
 
 func NewStreamingState(model string) *StreamingState {
 	return &StreamingState{
-		MessageID:  fmt.Sprintf("msg_%d", time.Now().UnixNano()),
+		MessageID:  ids.New("msg_"),
 		Model:      model,
 		BlockIndex: 0,
 	}
@@ -37,6 +44,14 @@ func (s *StreamingState) EmitMessageStart() string {
 	}
 	s.MessageStartSent = true
 
+	usage := map[string]interface{}{
+		"input_tokens":  0,
+		"output_tokens": 0,
+	}
+	if s.PromptCaching {
+		usage["cache_creation_input_tokens"] = 0
+		usage["cache_read_input_tokens"] = 0
+	}
 	event := map[string]interface{}{
 		"type": "message_start",
 		"message": map[string]interface{}{
@@ -47,10 +62,7 @@ func (s *StreamingState) EmitMessageStart() string {
 			"content":       []interface{}{},
 			"stop_reason":   nil,
 			"stop_sequence": nil,
-			"usage": map[string]interface{}{
-				"input_tokens":  0,
-				"output_tokens": 0,
-			},
+			"usage":         usage,
 		},
 	}
 
@@ -75,6 +87,11 @@ func (s *StreamingState) EmitContentBlockStart(blockType string, extra map[strin
 			block["name"] = extra["name"]
 			block["input"] = map[string]interface{}{}
 		}
+	case "server_tool_use", "web_search_tool_result":
+		// 服务端工具块一次性给出完整内容
+		for k, v := range extra {
+			block[k] = v
+		}
 	}
 
 	event := map[string]interface{}{
@@ -102,6 +119,11 @@ func (s *StreamingState) EmitContentBlockDelta(blockType string, content string)
 			"type":     "thinking_delta",
 			"thinking": content,
 		}
+	case "tool_use":
+		delta = map[string]interface{}{
+			"type":         "input_json_delta",
+			"partial_json": content,
+		}
 	default:
 		delta = map[string]interface{}{
 			"type": "text_delta",
@@ -119,6 +141,21 @@ func (s *StreamingState) EmitContentBlockDelta(blockType string, content string)
 	return fmt.Sprintf("event: content_block_delta\ndata: %s\n\n", data)
 }
 
+// EmitSignatureDelta 在 thinking 块结束前输出其签名
+func (s *StreamingState) EmitSignatureDelta(signature string) string {
+	event := map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": s.BlockIndex,
+		"delta": map[string]interface{}{
+			"type":      "signature_delta",
+			"signature": signature,
+		},
+	}
+
+	data, _ := json.Marshal(event)
+	return fmt.Sprintf("event: content_block_delta\ndata: %s\n\n", data)
+}
+
 func (s *StreamingState) EmitContentBlockStop() string {
 	event := map[string]interface{}{
 		"type":  "content_block_stop",
@@ -131,12 +168,17 @@ func (s *StreamingState) EmitContentBlockStop() string {
 	return fmt.Sprintf("event: content_block_stop\ndata: %s\n\n", data)
 }
 
-func (s *StreamingState) EmitMessageDelta(stopReason string, outputTokens int) string {
+// EmitMessageDelta stopSequence 为命中的停止序列，未命中时传空串
+func (s *StreamingState) EmitMessageDelta(stopReason string, stopSequence string, outputTokens int) string {
+	var sequence interface{}
+	if stopSequence != "" {
+		sequence = stopSequence
+	}
 	event := map[string]interface{}{
 		"type": "message_delta",
 		"delta": map[string]interface{}{
 			"stop_reason":   stopReason,
-			"stop_sequence": nil,
+			"stop_sequence": sequence,
 		},
 		"usage": map[string]interface{}{
 			"output_tokens": outputTokens,
@@ -168,21 +210,84 @@ type StreamProcessor struct {
 	inTextMode     bool
 	inToolUse      bool
 	toolUseBuffer  bytes.Buffer
+
+	thinkingVisibility string
+	thinkingBuffer     strings.Builder
+	pipeline           *gemini.OutputPipeline
+	// finishReason Gemini 在候选中报告的结束原因（如 MAX_TOKENS），finalize 时换算为 stop_reason
+	finishReason string
+	stopMatcher  *StopSequenceMatcher
+	// interleavedThinking 为 false 时正文开始后不再输出 thinking 块，textStarted 记录正文是否已经开始
+	interleavedThinking bool
+	textStarted         bool
+	// thoughtSignature 候选中找到的思考签名，关闭 thinking 块时以 signature_delta 输出
+	thoughtSignature string
+	// outputCap 超过 MAX_OUTPUT_BYTES 后停止读取上游，以 max_tokens 结束
+	outputCap *gemini.OutputCap
+	// sources 请求声明了 web_search 工具时收集引用来源，结束时以 web_search_tool_result 输出
+	sources *gemini.SourceList
+	// tools 请求声明了工具时从正文中识别工具调用，结束时以 tool_use 块输出
+	tools ToolUseParser
+}
+
+// ToolUseParser 从正文中识别模型按提示词约定输出的 <tool_use> 块（实现见 adapter 包）：
+// Feed 返回可以直接输出的文本，Finish 返回暂存的剩余文本，Blocks 返回识别出的 tool_use 块
+type ToolUseParser interface {
+	Feed(text string) string
+	Finish() string
+	Blocks() []ContentBlock
 }
 
 func NewStreamProcessor(model string, writer io.Writer) *StreamProcessor {
 	return &StreamProcessor{
-		state:  NewStreamingState(model),
-		writer: writer,
+		state:              NewStreamingState(model),
+		writer:             writer,
+		thinkingVisibility: config.ThinkingShow,
+		pipeline:           gemini.NewOutputPipeline(),
+		stopMatcher:        NewStopSequenceMatcher(nil),
+		outputCap:          gemini.NewOutputCap(),
+	}
+}
+
+// SetStopSequences 设置 stop_sequences，正文命中后不再输出并以 stop_sequence 结束
+func (p *StreamProcessor) SetStopSequences(sequences []string) {
+	p.stopMatcher = NewStopSequenceMatcher(sequences)
+}
+
+// SetBetas 按请求声明的 anthropic-beta 调整输出结构
+func (p *StreamProcessor) SetBetas(b Betas) {
+	p.state.PromptCaching = b.PromptCaching
+	p.interleavedThinking = b.InterleavedThinking
+}
+
+// SetPrompt 传入本次发送的提示词，供 strip_prompt_echo 等后处理阶段使用
+func (p *StreamProcessor) SetPrompt(prompt string) {
+	p.pipeline.SetPrompt(prompt)
+}
+
+// SetWebSearch 请求声明了 web_search 工具时开启，回答引用的网页在正文之后以 server_tool_use / web_search_tool_result 块输出
+func (p *StreamProcessor) SetWebSearch(enabled bool) {
+	if enabled {
+		p.sources = &gemini.SourceList{}
+	} else {
+		p.sources = nil
 	}
 }
 
+// SetToolUse 设置工具调用解析器，为 nil 时正文原样输出
+func (p *StreamProcessor) SetToolUse(parser ToolUseParser) {
+	p.tools = parser
+}
+
+// SetThinkingVisibility 设置思考过程输出方式，取值见 config.ThinkingShow / ThinkingHide / ThinkingSummary
+func (p *StreamProcessor) SetThinkingVisibility(visibility string) {
+	p.thinkingVisibility = visibility
+}
+
 func (p *StreamProcessor) ProcessGeminiStream(reader io.Reader) error {
-	scanner := bufio.NewScanner(reader)
-	buf := make([]byte, 0, 1024*1024)
-	scanner.Buffer(buf, 10*1024*1024)
+	scanner := gemini.NewResponseScanner(reader)
 
-	for scanner.Scan() {
+	for !p.outputCap.Exceeded() && scanner.Scan() {
 		line := scanner.Text()
 		line = strings.TrimPrefix(line, ")]}'")
 		line = strings.TrimSpace(line)
@@ -195,141 +300,201 @@ func (p *StreamProcessor) ProcessGeminiStream(reader io.Reader) error {
 		}
 	}
 
+	if errors.Is(scanner.Err(), gemini.ErrRequestTimeout) {
+		log.Printf("[Claude] Request timeout expired, closing the partial response")
+		p.finishReason = "MAX_TOKENS"
+	}
 	p.finalize()
-	return scanner.Err()
+	return gemini.LogScanError("Claude", scanner.Err())
 }
 
+// processLine 解析一行 StreamGenerate 响应：外层为 [["wrb.fr", null, "<inner json>"], ...]
 func (p *StreamProcessor) processLine(line string) error {
-	var outer []json.RawMessage
-	if err := json.Unmarshal([]byte(line), &outer); err != nil {
-		return err
+	outer := gjson.Parse(line)
+	if !outer.IsArray() {
+		return fmt.Errorf("unexpected stream line")
 	}
 
-	for _, item := range outer {
-		var arr []json.RawMessage
-		if err := json.Unmarshal(item, &arr); err != nil {
-			continue
-		}
-
-		if len(arr) < 3 {
-			continue
-		}
-
-		var dataStr string
-		if err := json.Unmarshal(arr[2], &dataStr); err != nil {
-			continue
-		}
-
+	outer.ForEach(func(_, item gjson.Result) bool {
+		dataStr := item.Get("2").String()
 		if dataStr == "" {
-			continue
-		}
-
-		var inner map[string]interface{}
-		if err := json.Unmarshal([]byte(dataStr), &inner); err != nil {
-			continue
+			return true
 		}
-
-		p.processGeminiData(inner)
-	}
+		p.processGeminiData(gjson.Parse(dataStr))
+		return !p.outputCap.Exceeded()
+	})
 
 	return nil
 }
 
-func (p *StreamProcessor) processGeminiData(data map[string]interface{}) {
+// processGeminiData 处理一帧快照：candidate[1][0] 为正文，candidate[37][0][0] 为思考过程
+func (p *StreamProcessor) processGeminiData(data gjson.Result) {
 	if !p.state.MessageStartSent {
 		p.emit(p.state.EmitMessageStart())
 	}
 
-	candidates, ok := data["4"].([]interface{})
-	if !ok {
+	candidate := data.Get("4.0")
+	if !candidate.Exists() {
 		return
 	}
+	if reason := gemini.CandidateFinishReason(candidate); reason != "" {
+		p.finishReason = reason
+	}
+	if sig := gemini.CandidateThoughtSignature(candidate); sig != "" {
+		p.thoughtSignature = sig
+	}
+	p.sources.Add(candidate)
 
-	for _, candidate := range candidates {
-		candidateMap, ok := candidate.(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		content, ok := candidateMap["1"].(map[string]interface{})
-		if !ok {
-			continue
-		}
+	p.processDelta(p.pipeline.TextDelta(candidate.Get("1.0").String()), p.pipeline.ThoughtDelta(candidate.Get("37.0.0").String()))
+}
 
-		parts, ok := content["1"].([]interface{})
-		if !ok {
-			continue
-		}
+// processDelta 按 MAX_OUTPUT_BYTES 截取快照增量后交给后处理链
+func (p *StreamProcessor) processDelta(textDelta, thoughtDelta string) {
+	if textDelta == "" && thoughtDelta == "" {
+		return
+	}
+	if textDelta, thoughtDelta = p.outputCap.Take(textDelta, thoughtDelta); p.outputCap.Exceeded() {
+		log.Printf("[Claude] Output exceeded MAX_OUTPUT_BYTES (%d), cutting off the upstream response", config.MaxOutputBytes())
+		p.finishReason = "MAX_TOKENS"
+	}
 
-		for _, part := range parts {
-			partArr, ok := part.([]interface{})
-			if !ok {
-				continue
-			}
+	if thoughtDelta != "" {
+		p.processPart(thoughtDelta, true)
+	}
+	if textDelta != "" {
+		p.processPart(textDelta, false)
+	}
+}
 
-			p.processPart(partArr)
-		}
+func (p *StreamProcessor) processPart(text string, isThought bool) {
+	if isThought {
+		text = p.pipeline.Thought(text)
+	} else {
+		text = p.toolText(p.stopMatcher.Feed(p.pipeline.Text(text)))
 	}
+	p.emitPart(text, isThought)
 }
 
-func (p *StreamProcessor) processPart(part []interface{}) {
-	if len(part) == 0 {
-		return
+// toolText 把正文交给工具调用解析器，返回 <tool_use> 块以外可以输出的文本
+func (p *StreamProcessor) toolText(text string) string {
+	if p.tools == nil {
+		return text
 	}
+	return p.tools.Feed(text)
+}
 
-	text, _ := part[0].(string)
+// emitPart 输出已经过后处理链的片段
+func (p *StreamProcessor) emitPart(text string, isThought bool) {
 	if text == "" {
 		return
 	}
 
-	text = strings.ReplaceAll(text, `\<`, `<`)
-	text = strings.ReplaceAll(text, `\>`, `>`)
-	text = strings.ReplaceAll(text, `\_`, `_`)
-	text = strings.ReplaceAll(text, `\[`, `[`)
-	text = strings.ReplaceAll(text, `\]`, `]`)
-
-	// Decode HTML entities
-	text = html.UnescapeString(text)
-
-	isThought := false
-	if len(part) > 2 {
-		if thought, ok := part[2].(bool); ok && thought {
-			isThought = true
-		}
-	}
-
 	if isThought {
-		if p.inTextMode {
-			p.emit(p.state.EmitContentBlockStop())
-			p.inTextMode = false
+		if p.textStarted && !p.interleavedThinking {
+			return
 		}
-		if !p.inThinkingMode {
-			p.emit(p.state.EmitContentBlockStart("thinking", nil))
-			p.inThinkingMode = true
+		switch p.thinkingVisibility {
+		case config.ThinkingHide:
+			return
+		case config.ThinkingSummary:
+			p.thinkingBuffer.WriteString(text)
+			return
 		}
-		p.emit(p.state.EmitContentBlockDelta("thinking", text))
+		p.emitThinking(text)
 	} else {
-		if p.inThinkingMode {
-			p.emit(p.state.EmitContentBlockStop())
-			p.inThinkingMode = false
-		}
+		p.flushThinkingSummary()
+		p.closeThinking()
 		if !p.inTextMode {
 			p.emit(p.state.EmitContentBlockStart("text", nil))
 			p.inTextMode = true
+			p.textStarted = true
 		}
 		p.emit(p.state.EmitContentBlockDelta("text", text))
 	}
 }
 
-func (p *StreamProcessor) finalize() {
-	if p.inThinkingMode {
+func (p *StreamProcessor) emitThinking(text string) {
+	if p.inTextMode {
 		p.emit(p.state.EmitContentBlockStop())
+		p.inTextMode = false
+	}
+	if !p.inThinkingMode {
+		p.emit(p.state.EmitContentBlockStart("thinking", nil))
+		p.inThinkingMode = true
+	}
+	p.emit(p.state.EmitContentBlockDelta("thinking", text))
+}
+
+// closeThinking 结束当前 thinking 块，已知签名时先输出 signature_delta
+func (p *StreamProcessor) closeThinking() {
+	if !p.inThinkingMode {
+		return
 	}
+	if p.thoughtSignature != "" {
+		p.emit(p.state.EmitSignatureDelta(p.thoughtSignature))
+	}
+	p.emit(p.state.EmitContentBlockStop())
+	p.inThinkingMode = false
+}
+
+// flushThinkingSummary 在 summary 模式下把缓存的思考过程作为一个完整的 thinking 块输出
+func (p *StreamProcessor) flushThinkingSummary() {
+	if p.thinkingBuffer.Len() == 0 {
+		return
+	}
+	p.emitThinking(p.thinkingBuffer.String())
+	p.thinkingBuffer.Reset()
+}
+
+func (p *StreamProcessor) finalize() {
+	if !p.state.MessageStartSent {
+		p.emit(p.state.EmitMessageStart())
+	}
+	if !p.outputCap.Exceeded() {
+		p.processDelta(p.pipeline.SnapshotRemainder())
+	}
+	text, thought := p.pipeline.Flush()
+	p.emitPart(thought, true)
+	p.emitPart(p.toolText(p.stopMatcher.Feed(text)), false)
+	p.emitPart(p.toolText(p.stopMatcher.Flush()), false)
+	var toolBlocks []ContentBlock
+	if p.tools != nil {
+		p.emitPart(p.tools.Finish(), false)
+		toolBlocks = p.tools.Blocks()
+	}
+	p.flushThinkingSummary()
+
+	p.closeThinking()
 	if p.inTextMode {
 		p.emit(p.state.EmitContentBlockStop())
+		p.inTextMode = false
+	}
+	for _, block := range WebSearchBlocks(p.sources) {
+		var extra map[string]interface{}
+		data, _ := json.Marshal(block)
+		json.Unmarshal(data, &extra)
+		p.emit(p.state.EmitContentBlockStart(block.Type, extra))
+		p.emit(p.state.EmitContentBlockStop())
+	}
+	// tool_use 块在正文之后输出，完整的参数放在一个 input_json_delta 中
+	for _, block := range toolBlocks {
+		input, _ := json.Marshal(block.Input)
+		p.emit(p.state.EmitContentBlockStart("tool_use", map[string]interface{}{"id": block.ID, "name": block.Name}))
+		p.emit(p.state.EmitContentBlockDelta("tool_use", string(input)))
+		p.emit(p.state.EmitContentBlockStop())
 	}
 
-	p.emit(p.state.EmitMessageDelta("end_turn", p.state.OutputTokens))
+	stopReason := MapFinishReason(p.finishReason)
+	if len(toolBlocks) > 0 {
+		stopReason = "tool_use"
+	} else if p.stopMatcher.Matched() != "" {
+		stopReason = "stop_sequence"
+	}
+	stopSequence := p.stopMatcher.Matched()
+	if stopReason != "stop_sequence" {
+		stopSequence = ""
+	}
+	p.emit(p.state.EmitMessageDelta(stopReason, stopSequence, p.state.OutputTokens))
 	p.emit(p.state.EmitMessageStop())
 }
 
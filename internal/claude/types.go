@@ -9,13 +9,21 @@ type ClaudeRequest struct {
 	Messages    []Message       `json:"messages"`
 	System      json.RawMessage `json:"system,omitempty"`
 	Tools       []Tool          `json:"tools,omitempty"`
+	ToolChoice  *ToolChoice     `json:"tool_choice,omitempty"`
 	Stream      bool            `json:"stream"`
 	MaxTokens   *int            `json:"max_tokens,omitempty"`
 	Temperature *float64        `json:"temperature,omitempty"`
 	TopP        *float64        `json:"top_p,omitempty"`
 	TopK        *int            `json:"top_k,omitempty"`
-	Thinking    *ThinkingConfig `json:"thinking,omitempty"`
-	Metadata    *Metadata       `json:"metadata,omitempty"`
+	// StopSequences Gemini Web 不支持，命中后由服务端截断输出，见 StopSequenceMatcher
+	StopSequences []string        `json:"stop_sequences,omitempty"`
+	Thinking      *ThinkingConfig `json:"thinking,omitempty"`
+	Metadata      *Metadata       `json:"metadata,omitempty"`
+	Language      string          `json:"language,omitempty"`
+	// ThinkingVisibility 非标准扩展字段: show / hide / summary
+	ThinkingVisibility string `json:"thinking_visibility,omitempty"`
+	// AnswerOnly 非标准扩展字段: 只返回最终回答，丢弃思考过程（优先于 ThinkingVisibility）
+	AnswerOnly bool `json:"answer_only,omitempty"`
 }
 
 type ThinkingConfig struct {
@@ -23,6 +31,12 @@ type ThinkingConfig struct {
 	BudgetTokens *int   `json:"budget_tokens,omitempty"`
 }
 
+type ToolChoice struct {
+	Type                   string `json:"type"`
+	Name                   string `json:"name,omitempty"`
+	DisableParallelToolUse *bool  `json:"disable_parallel_tool_use,omitempty"`
+}
+
 type Metadata struct {
 	UserID string `json:"user_id,omitempty"`
 }
@@ -33,24 +47,31 @@ type Message struct {
 }
 
 type ContentBlock struct {
-	Type      string                 `json:"type"`
-	Text      string                 `json:"text,omitempty"`
-	Thinking  string                 `json:"thinking,omitempty"`
-	Signature string                 `json:"signature,omitempty"`
-	ID        string                 `json:"id,omitempty"`
-	Name      string                 `json:"name,omitempty"`
-	Input     map[string]interface{} `json:"input,omitempty"`
-	ToolUseID string                 `json:"tool_use_id,omitempty"`
-	Content   json.RawMessage        `json:"content,omitempty"`
-	IsError   *bool                  `json:"is_error,omitempty"`
-	Source    *ImageSource           `json:"source,omitempty"`
-	Data      string                 `json:"data,omitempty"`
+	Type      string `json:"type"`
+	Text      string `json:"text,omitempty"`
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	ID        string `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// Input tool_use 块的参数对象，空对象 {} 同样输出
+	Input     interface{}     `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	IsError   *bool           `json:"is_error,omitempty"`
+	Source    *ImageSource    `json:"source,omitempty"`
+	Data      string          `json:"data,omitempty"`
+	// URL / FileName gemini_file 块引用的已上传文件
+	URL      string `json:"url,omitempty"`
+	FileName string `json:"file_name,omitempty"`
+	// SearchEntryPoint web_search_tool_result 块附带的搜索入口，见 WebSearchBlocks
+	SearchEntryPoint *SearchEntryPoint `json:"search_entry_point,omitempty"`
 }
 
 type ImageSource struct {
 	Type      string `json:"type"`
 	MediaType string `json:"media_type"`
 	Data      string `json:"data"`
+	URL       string `json:"url,omitempty"`
 }
 
 type Tool struct {
@@ -144,6 +165,13 @@ type GroundingMetadata struct {
 	WebSearchQueries  []string           `json:"webSearchQueries,omitempty"`
 	GroundingChunks   []GroundingChunk   `json:"groundingChunks,omitempty"`
 	GroundingSupports []GroundingSupport `json:"groundingSupports,omitempty"`
+	SearchEntryPoint  *SearchEntryPoint  `json:"searchEntryPoint,omitempty"`
+}
+
+// SearchEntryPoint Google 要求与联网搜索结果一同展示的相关搜索入口，RenderedContent 为可直接嵌入的 HTML，
+// 与 Gemini API 的 groundingMetadata.searchEntryPoint 结构相同
+type SearchEntryPoint struct {
+	RenderedContent string `json:"renderedContent,omitempty"`
 }
 
 type GroundingChunk struct {
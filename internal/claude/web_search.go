@@ -0,0 +1,65 @@
+package claude
+
+import (
+	"encoding/json"
+
+	"gemini-web2api/internal/gemini"
+	"gemini-web2api/internal/ids"
+)
+
+// WebSearchResult web_search_tool_result 块中的一条搜索结果
+type WebSearchResult struct {
+	Type             string  `json:"type"`
+	URL              string  `json:"url"`
+	Title            string  `json:"title"`
+	EncryptedContent string  `json:"encrypted_content"`
+	PageAge          *string `json:"page_age"`
+}
+
+// HasWebSearchTool 请求是否声明了 web_search 服务端工具，声明时才以 web_search_tool_result 返回引用来源
+func HasWebSearchTool(tools []Tool) bool {
+	for _, tool := range tools {
+		if tool.IsWebSearch() {
+			return true
+		}
+	}
+	return false
+}
+
+// WebSearchBlocks 把回答引用的网页转换为 server_tool_use 与 web_search_tool_result 块，没有来源时返回 nil。
+// Web 接口不返回搜索词与加密内容：query 取搜索入口中的第一条搜索建议，encrypted_content 为空；
+// Google 要求展示的搜索入口 HTML 放在结果块的 search_entry_point 中
+func WebSearchBlocks(sources *gemini.SourceList) []ContentBlock {
+	items := sources.Items()
+	if len(items) == 0 {
+		return nil
+	}
+	query := ""
+	if suggestions := sources.Suggestions(); len(suggestions) > 0 {
+		query = suggestions[0].Title
+	}
+	results := make([]WebSearchResult, 0, len(items))
+	for _, s := range items {
+		results = append(results, WebSearchResult{Type: "web_search_result", URL: s.URL, Title: s.Title})
+	}
+	content, _ := json.Marshal(results)
+
+	toolUseID := ids.New("srvtoolu_")
+	result := ContentBlock{
+		Type:      "web_search_tool_result",
+		ToolUseID: toolUseID,
+		Content:   content,
+	}
+	if entry := sources.EntryPoint(); entry != "" {
+		result.SearchEntryPoint = &SearchEntryPoint{RenderedContent: entry}
+	}
+	return []ContentBlock{
+		{
+			Type:  "server_tool_use",
+			ID:    toolUseID,
+			Name:  "web_search",
+			Input: map[string]interface{}{"query": query},
+		},
+		result,
+	}
+}
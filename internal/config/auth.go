@@ -0,0 +1,24 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// APIKeys 读取 PROXY_API_KEY，逗号分隔可配置多个密钥（每个团队一个），公平调度与按密钥限速以密钥为单位；
+// 未设置时返回 nil，即不鉴权
+func APIKeys() []string {
+	var keys []string
+	for _, key := range strings.Split(os.Getenv("PROXY_API_KEY"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// AdminAPIKey 管理与调试接口（/admin/*、/debug/*）专用的密钥（ADMIN_API_KEY）。设置后这些接口只接受该密钥，
+// 普通 API Key 不能访问；未设置时沿用 PROXY_API_KEY，两者都未设置时管理接口一律返回 403
+func AdminAPIKey() string {
+	return strings.TrimSpace(os.Getenv("ADMIN_API_KEY"))
+}
@@ -0,0 +1,16 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// BasePath 所有接口的路径前缀（BASE_PATH），用于部署在反向代理的子路径下，如 /gemini；
+// 规范化为以 / 开头、不以 / 结尾，未设置或为 / 时返回空串
+func BasePath() string {
+	v := strings.Trim(strings.TrimSpace(os.Getenv("BASE_PATH")), "/")
+	if v == "" {
+		return ""
+	}
+	return "/" + v
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// BlockedPromptPatterns 读取拒绝发送的提示词规则，均按不区分大小写的正则表达式处理，默认为空即不拦截：
+// BLOCKED_PROMPT_PATTERNS 为一条正则（多个关键词用 | 分隔），BLOCKED_PROMPTS_FILE 指向每行一条正则的文件（# 开头为注释）。
+// 无法编译的规则记录日志后忽略
+func BlockedPromptPatterns() []*regexp.Regexp {
+	var sources []string
+	if v := strings.TrimSpace(os.Getenv("BLOCKED_PROMPT_PATTERNS")); v != "" {
+		sources = append(sources, v)
+	}
+	if path := strings.TrimSpace(os.Getenv("BLOCKED_PROMPTS_FILE")); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Printf("[Config] Failed to read BLOCKED_PROMPTS_FILE '%s': %v", path, err)
+		} else {
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				line := strings.TrimSpace(scanner.Text())
+				if line != "" && !strings.HasPrefix(line, "#") {
+					sources = append(sources, line)
+				}
+			}
+			f.Close()
+		}
+	}
+
+	patterns := make([]*regexp.Regexp, 0, len(sources))
+	for _, src := range sources {
+		re, err := regexp.Compile("(?i)" + src)
+		if err != nil {
+			log.Printf("[Config] Invalid blocked prompt pattern '%s', ignored: %v", src, err)
+			continue
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns
+}
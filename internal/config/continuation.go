@@ -0,0 +1,42 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+const defaultContinuePrompt = "Continue exactly from where you stopped. Do not repeat any previous content or add any preamble."
+
+// MaxContinuations 回答被截断时自动追加 "continue" 请求的最大次数（AUTO_CONTINUE_MAX），默认 0 即关闭
+func MaxContinuations() int {
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("AUTO_CONTINUE_MAX")))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+const defaultContinueMinLength = 6000
+
+// ContinueMinLength 按长度判断截断的阈值（AUTO_CONTINUE_MIN_LENGTH，字符数）：一次响应的回答达到该长度
+// 且没有在句末结束时视为被截断，默认 6000，0 关闭按长度判断
+func ContinueMinLength() int {
+	v := strings.TrimSpace(os.Getenv("AUTO_CONTINUE_MIN_LENGTH"))
+	if v == "" {
+		return defaultContinueMinLength
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return defaultContinueMinLength
+	}
+	return n
+}
+
+// ContinuePrompt 自动续写时发送的提示词，可通过 AUTO_CONTINUE_PROMPT 覆盖
+func ContinuePrompt() string {
+	if v := strings.TrimSpace(os.Getenv("AUTO_CONTINUE_PROMPT")); v != "" {
+		return v
+	}
+	return defaultContinuePrompt
+}
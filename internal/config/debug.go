@@ -0,0 +1,23 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// 账号标识在响应中的暴露方式（EXPOSE_ACCOUNT_ID），会泄露账号命名，仅供排查使用
+const (
+	AccountExposeOff         = "off"         // 不暴露（默认）
+	AccountExposeHeader      = "header"      // 响应头 X-Account-Id
+	AccountExposeFingerprint = "fingerprint" // 响应头 + OpenAI 响应的 system_fingerprint
+)
+
+func AccountExposure() string {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("EXPOSE_ACCOUNT_ID"))) {
+	case AccountExposeHeader:
+		return AccountExposeHeader
+	case AccountExposeFingerprint, "1", "true", "all":
+		return AccountExposeFingerprint
+	}
+	return AccountExposeOff
+}
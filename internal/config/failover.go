@@ -0,0 +1,28 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// 首字节之前生成失败时的换号策略
+const (
+	FailoverOff       = "off"       // 不换号，直接返回错误
+	FailoverThrottle  = "throttle"  // 仅在账号被限流时换号
+	FailoverTransient = "transient" // 限流与临时故障（连接失败或重置、超时、5xx、首帧之前中断）时换号（默认）
+	FailoverAll       = "all"       // 任何错误（包括认证失败与其他 4xx）都换号重试
+)
+
+// AccountFailover 读取 ACCOUNT_FAILOVER，默认 transient
+func AccountFailover() string {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("ACCOUNT_FAILOVER")))
+	switch v {
+	case "":
+		return FailoverTransient
+	case FailoverOff, FailoverThrottle, FailoverTransient, FailoverAll:
+		return v
+	}
+	log.Printf("[Config] Invalid ACCOUNT_FAILOVER '%s', using %s", v, FailoverTransient)
+	return FailoverTransient
+}
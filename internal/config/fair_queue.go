@@ -0,0 +1,44 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultFairQueueWait = 60 * time.Second
+
+// FairQueueConcurrency 整个服务同时向上游发出的生成请求数上限（FAIR_QUEUE_CONCURRENCY），
+// 超出的请求按 API Key 分队列排队、轮流放行；默认 0 即不限制、不排队
+func FairQueueConcurrency() int {
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("FAIR_QUEUE_CONCURRENCY")))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// FairQueueWait 请求在公平队列中等待的最长时间（FAIR_QUEUE_WAIT），超时返回 429，默认 60s
+func FairQueueWait() time.Duration {
+	v := strings.TrimSpace(os.Getenv("FAIR_QUEUE_WAIT"))
+	if v == "" {
+		return defaultFairQueueWait
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("[Config] Invalid FAIR_QUEUE_WAIT '%s', using %s", v, defaultFairQueueWait)
+		return defaultFairQueueWait
+	}
+	return d
+}
+
+// APIKeyRateLimit 每个 API Key 每分钟允许的生成请求数（API_KEY_RATE_LIMIT），默认 0 即不限制
+func APIKeyRateLimit() int {
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("API_KEY_RATE_LIMIT")))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
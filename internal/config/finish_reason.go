@@ -0,0 +1,95 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// defaultOpenAIFinishReasons Gemini 结束原因到 OpenAI finish_reason 的默认映射，未列出的原因按 stop 处理
+var defaultOpenAIFinishReasons = map[string]string{
+	"STOP":                    "stop",
+	"MAX_TOKENS":              "length",
+	"SAFETY":                  "content_filter",
+	"RECITATION":              "content_filter",
+	"BLOCKLIST":               "content_filter",
+	"PROHIBITED_CONTENT":      "content_filter",
+	"SPII":                    "content_filter",
+	"MALFORMED_FUNCTION_CALL": "stop",
+	"OTHER":                   "stop",
+}
+
+// defaultClaudeStopReasons Gemini 结束原因到 Claude stop_reason 的默认映射，未列出的原因按 end_turn 处理
+var defaultClaudeStopReasons = map[string]string{
+	"STOP":                    "end_turn",
+	"MAX_TOKENS":              "max_tokens",
+	"SAFETY":                  "refusal",
+	"RECITATION":              "refusal",
+	"BLOCKLIST":               "refusal",
+	"PROHIBITED_CONTENT":      "refusal",
+	"SPII":                    "refusal",
+	"MALFORMED_FUNCTION_CALL": "end_turn",
+	"TOOL_USE":                "tool_use",
+	"OTHER":                   "end_turn",
+}
+
+var (
+	finishReasonOnce   sync.Once
+	openAIFinishReason map[string]string
+	claudeStopReason   map[string]string
+)
+
+// OpenAIFinishReason 把 Gemini 报告的结束原因（如 SAFETY）换算为 OpenAI 的 finish_reason，
+// FINISH_REASON_MAP_OPENAI（如 SAFETY=stop,RECITATION=stop）可覆盖默认映射，空原因返回 stop
+func OpenAIFinishReason(geminiReason string) string {
+	finishReasonOnce.Do(loadFinishReasonMaps)
+	if v, ok := openAIFinishReason[strings.ToUpper(strings.TrimSpace(geminiReason))]; ok {
+		return v
+	}
+	return "stop"
+}
+
+// ClaudeStopReason 把 Gemini 报告的结束原因换算为 Claude 的 stop_reason，
+// FINISH_REASON_MAP_CLAUDE（如 SAFETY=end_turn）可覆盖默认映射，空原因返回 end_turn
+func ClaudeStopReason(geminiReason string) string {
+	finishReasonOnce.Do(loadFinishReasonMaps)
+	if v, ok := claudeStopReason[strings.ToUpper(strings.TrimSpace(geminiReason))]; ok {
+		return v
+	}
+	return "end_turn"
+}
+
+func loadFinishReasonMaps() {
+	openAIFinishReason = applyFinishReasonOverrides("FINISH_REASON_MAP_OPENAI", defaultOpenAIFinishReasons)
+	claudeStopReason = applyFinishReasonOverrides("FINISH_REASON_MAP_CLAUDE", defaultClaudeStopReasons)
+}
+
+// applyFinishReasonOverrides 解析逗号分隔的 GEMINI_REASON=client_reason 列表，覆盖默认映射中的对应项
+func applyFinishReasonOverrides(env string, defaults map[string]string) map[string]string {
+	result := make(map[string]string, len(defaults))
+	for k, v := range defaults {
+		result[k] = v
+	}
+
+	raw := strings.TrimSpace(os.Getenv(env))
+	if raw == "" {
+		return result
+	}
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(pair, "=")
+		from = strings.ToUpper(strings.TrimSpace(from))
+		to = strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			log.Printf("[Config] Ignoring invalid %s entry '%s' (expected GEMINI_REASON=value)", env, pair)
+			continue
+		}
+		result[from] = to
+	}
+	log.Printf("[Config] %s: %v", env, result)
+	return result
+}
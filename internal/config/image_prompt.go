@@ -0,0 +1,190 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const defaultImagePromptTemplate = "Generate an image of {prompt}"
+
+// defaultLocalizedImagePromptTemplates 按提示词语言选用的内置模板，避免中文等提示词被套上英文指令
+var defaultLocalizedImagePromptTemplates = map[string]string{
+	"ZH": "生成一张{prompt}的图片",
+	"JA": "{prompt}の画像を生成してください",
+	"KO": "{prompt} 이미지를 생성해 주세요",
+}
+
+const defaultImageRetryTemplate = "Your reply MUST contain a generated image; do not answer with text only. {prompt}"
+
+const defaultImageEmptyRetries = 1
+
+const defaultImageConcurrency = 1
+
+// 同一次图片请求中相邻两个生成请求之间的默认随机间隔，避免短时间内连续发出相似请求触发异常检测
+const (
+	defaultImageRequestDelayMin = time.Second
+	defaultImageRequestDelayMax = 3 * time.Second
+)
+
+// defaultImageAugmentations 保留历史上硬编码的 quality/style 增强语句作为默认值
+var defaultImageAugmentations = map[string]string{
+	"QUALITY_HD":    " (high quality, highly detailed, 4k resolution, hdr)",
+	"STYLE_VIVID":   " (vivid colors, dramatic lighting, rich details)",
+	"STYLE_NATURAL": " (natural lighting, realistic, photorealistic)",
+}
+
+// ImagePromptAugmentEnabled 为 false 时图片提示词原样发送，不加任何前缀和增强
+func ImagePromptAugmentEnabled() bool {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("IMAGE_PROMPT_AUGMENT")))
+	return v != "0" && v != "false" && v != "off"
+}
+
+// BuildImagePrompt 按 IMAGE_PROMPT_TEMPLATE 以及 IMAGE_QUALITY_<值> / IMAGE_STYLE_<值> 模板组装图片提示词。
+// 对应环境变量设为空字符串可以单独关闭某一项增强。
+func BuildImagePrompt(prompt, quality, style string) string {
+	if !ImagePromptAugmentEnabled() {
+		return prompt
+	}
+
+	template := imagePromptTemplate(prompt)
+
+	var result string
+	if strings.Contains(template, "{prompt}") {
+		result = strings.ReplaceAll(template, "{prompt}", prompt)
+	} else {
+		result = template + prompt
+	}
+
+	result += imageAugmentation("QUALITY", quality)
+	result += imageAugmentation("STYLE", style)
+	return result
+}
+
+// imagePromptTemplate 选择提示词模板：IMAGE_PROMPT_AUTO_LANGUAGE 开启（默认）时按提示词语言优先使用
+// IMAGE_PROMPT_TEMPLATE_<ZH|JA|KO>，其次是 IMAGE_PROMPT_TEMPLATE，最后是对应语言的内置模板
+func imagePromptTemplate(prompt string) string {
+	lang := ""
+	if v := strings.ToLower(strings.TrimSpace(os.Getenv("IMAGE_PROMPT_AUTO_LANGUAGE"))); v != "0" && v != "false" && v != "off" {
+		lang = detectPromptLanguage(prompt)
+	}
+
+	if lang != "" {
+		if v := os.Getenv("IMAGE_PROMPT_TEMPLATE_" + lang); strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	if v := os.Getenv("IMAGE_PROMPT_TEMPLATE"); strings.TrimSpace(v) != "" {
+		return v
+	}
+	if template, ok := defaultLocalizedImagePromptTemplates[lang]; ok {
+		return template
+	}
+	return defaultImagePromptTemplate
+}
+
+// detectPromptLanguage 按文字脚本粗略判断提示词语言：含假名为 JA，含谚文为 KO，含汉字为 ZH，其余返回空串
+func detectPromptLanguage(prompt string) string {
+	var han bool
+	for _, r := range prompt {
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			return "JA"
+		case unicode.Is(unicode.Hangul, r):
+			return "KO"
+		case unicode.Is(unicode.Han, r):
+			han = true
+		}
+	}
+	if han {
+		return "ZH"
+	}
+	return ""
+}
+
+func imageAugmentation(kind, value string) string {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return ""
+	}
+
+	key := kind + "_" + value
+	if v, ok := os.LookupEnv("IMAGE_" + key); ok {
+		if strings.TrimSpace(v) == "" {
+			return ""
+		}
+		return " " + strings.TrimSpace(v)
+	}
+	return defaultImageAugmentations[key]
+}
+
+// ImageEmptyRetries 图片请求只返回文字（拒绝或被当作聊天回答）时的重试次数（IMAGE_EMPTY_RETRIES），默认 1
+func ImageEmptyRetries() int {
+	v := strings.TrimSpace(os.Getenv("IMAGE_EMPTY_RETRIES"))
+	if v == "" {
+		return defaultImageEmptyRetries
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return defaultImageEmptyRetries
+	}
+	return n
+}
+
+// ImageConcurrency n > 1 时同时发出的图片请求数（IMAGE_CONCURRENCY），默认 1 即逐个请求
+func ImageConcurrency() int {
+	v := strings.TrimSpace(os.Getenv("IMAGE_CONCURRENCY"))
+	if v == "" {
+		return defaultImageConcurrency
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return defaultImageConcurrency
+	}
+	return n
+}
+
+// ImageRequestDelay n > 1 时相邻两个图片生成请求之间的随机间隔（IMAGE_REQUEST_DELAY）：
+// 单个时长（如 2s）为固定间隔，"1s-3s" 为随机范围，0 / off 关闭，默认 1s-3s
+func ImageRequestDelay() (time.Duration, time.Duration) {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("IMAGE_REQUEST_DELAY")))
+	switch v {
+	case "":
+		return defaultImageRequestDelayMin, defaultImageRequestDelayMax
+	case "0", "off", "false":
+		return 0, 0
+	}
+
+	loStr, hiStr, isRange := strings.Cut(v, "-")
+	lo, err := time.ParseDuration(strings.TrimSpace(loStr))
+	hi := lo
+	if err == nil && isRange {
+		hi, err = time.ParseDuration(strings.TrimSpace(hiStr))
+	}
+	if err != nil || lo < 0 || hi < lo {
+		log.Printf("Warning: invalid IMAGE_REQUEST_DELAY '%s', using %s-%s", v, defaultImageRequestDelayMin, defaultImageRequestDelayMax)
+		return defaultImageRequestDelayMin, defaultImageRequestDelayMax
+	}
+	return lo, hi
+}
+
+// ImageRotateAccounts 为 true 时 n > 1 的图片生成请求在相邻请求之间轮换账号（IMAGE_ROTATE_ACCOUNTS），默认关闭
+func ImageRotateAccounts() bool {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("IMAGE_ROTATE_ACCOUNTS")))
+	return v == "1" || v == "true" || v == "on"
+}
+
+// StrengthenImagePrompt 重试时用 IMAGE_RETRY_TEMPLATE（{prompt} 占位）强调必须输出图片
+func StrengthenImagePrompt(prompt string) string {
+	template := defaultImageRetryTemplate
+	if v := strings.TrimSpace(os.Getenv("IMAGE_RETRY_TEMPLATE")); v != "" {
+		template = v
+	}
+	if strings.Contains(template, "{prompt}") {
+		return strings.ReplaceAll(template, "{prompt}", prompt)
+	}
+	return template + " " + prompt
+}
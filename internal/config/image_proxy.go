@@ -0,0 +1,37 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultImageProxyTTL = 24 * time.Hour
+
+// ImageURLProxy 为 true 时 response_format=url 返回本服务的 /images/proxy 地址而不是 Gemini 的原始图片地址（IMAGE_URL_PROXY），
+// 原始地址需要账号 Cookie 且会过期，代理地址在 IMAGE_PROXY_TTL 内每次访问都用账号重新下载
+func ImageURLProxy() bool {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("IMAGE_URL_PROXY")))
+	return v == "1" || v == "true" || v == "on"
+}
+
+// ImageProxyTTL 代理地址的有效期（IMAGE_PROXY_TTL），每次访问后重新计时，默认 24 小时
+func ImageProxyTTL() time.Duration {
+	v := strings.TrimSpace(os.Getenv("IMAGE_PROXY_TTL"))
+	if v == "" {
+		return defaultImageProxyTTL
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("[Images] Invalid IMAGE_PROXY_TTL '%s', using %s", v, defaultImageProxyTTL)
+		return defaultImageProxyTTL
+	}
+	return d
+}
+
+// ImageProxyBaseURL 生成代理地址使用的外部访问地址（IMAGE_PROXY_BASE_URL），如 https://api.example.com/gemini；
+// 未设置时由请求的 Host 与 BASE_PATH 推断
+func ImageProxyBaseURL() string {
+	return strings.TrimRight(strings.TrimSpace(os.Getenv("IMAGE_PROXY_BASE_URL")), "/")
+}
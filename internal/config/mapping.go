@@ -57,5 +57,41 @@ func MapModel(model string) string {
 	if mapped, ok := modelMapping[model]; ok {
 		return mapped
 	}
+	if detected := detectClaudeBaseModel(model); detected != "" {
+		return detected
+	}
 	return model
 }
+
+// ModelMappings 返回 MODEL_MAPPING 中配置的映射（副本）
+func ModelMappings() map[string]string {
+	mappingMu.RLock()
+	defer mappingMu.RUnlock()
+	mappings := make(map[string]string, len(modelMapping))
+	for k, v := range modelMapping {
+		mappings[k] = v
+	}
+	return mappings
+}
+
+const (
+	defaultClaudeProModel   = "gemini-3.1-pro-preview"
+	defaultClaudeFlashModel = "gemini-3-flash-preview"
+)
+
+// detectClaudeBaseModel 根据 Claude 模型名中的系列关键字推断对应的 Gemini 模型，
+// opus/sonnet -> Pro，haiku -> Flash。无法识别时返回空字符串。
+func detectClaudeBaseModel(model string) string {
+	lower := strings.ToLower(strings.TrimSpace(model))
+	if !strings.HasPrefix(lower, "claude") {
+		return ""
+	}
+
+	switch {
+	case strings.Contains(lower, "opus"), strings.Contains(lower, "sonnet"):
+		return defaultClaudeProModel
+	case strings.Contains(lower, "haiku"):
+		return defaultClaudeFlashModel
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ModelConcurrencyAny MODEL_CONCURRENCY 中的默认项，未单独列出的每个模型各自使用该上限
+const ModelConcurrencyAny = "*"
+
+const defaultModelConcurrencyWait = 30 * time.Second
+
+// ModelConcurrencyLimits 读取 MODEL_CONCURRENCY（如 gemini-3-pro-preview:2,gemini-2.5-flash:8,*:16），
+// 返回映射后的模型名（小写）到同时进行的请求数上限，未配置的模型不限制
+func ModelConcurrencyLimits() map[string]int {
+	limits := make(map[string]int)
+	for _, pair := range strings.Split(os.Getenv("MODEL_CONCURRENCY"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		idx := strings.LastIndex(pair, ":")
+		if idx <= 0 {
+			log.Printf("[Config] Invalid MODEL_CONCURRENCY entry '%s', ignored", pair)
+			continue
+		}
+		model := strings.ToLower(strings.TrimSpace(pair[:idx]))
+		n, err := strconv.Atoi(strings.TrimSpace(pair[idx+1:]))
+		if err != nil || n <= 0 {
+			log.Printf("[Config] Invalid MODEL_CONCURRENCY entry '%s', ignored", pair)
+			continue
+		}
+		limits[model] = n
+	}
+	return limits
+}
+
+// ModelConcurrencyWait 模型达到并发上限时请求排队等待的最长时间（MODEL_CONCURRENCY_WAIT），超时返回 429；
+// 默认 30s，0 表示不排队直接返回 429
+func ModelConcurrencyWait() time.Duration {
+	v := strings.TrimSpace(os.Getenv("MODEL_CONCURRENCY_WAIT"))
+	if v == "" {
+		return defaultModelConcurrencyWait
+	}
+	if v == "0" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("[Config] Invalid MODEL_CONCURRENCY_WAIT '%s', using %s", v, defaultModelConcurrencyWait)
+		return defaultModelConcurrencyWait
+	}
+	return d
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ModelDefaults 单个模型的默认生成参数，仅在请求未指定对应字段时生效
+type ModelDefaults struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	TopK        *int     `json:"top_k,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+	// Thinking 为 false 时默认不输出思考过程；ThinkingBudget 对应 Claude 的 thinking.budget_tokens
+	Thinking       *bool `json:"thinking,omitempty"`
+	ThinkingBudget *int  `json:"thinking_budget,omitempty"`
+}
+
+var (
+	modelDefaults   map[string]ModelDefaults
+	modelDefaultsMu sync.RWMutex
+)
+
+// LoadModelDefaults 从 MODEL_DEFAULTS 加载按模型名索引的默认参数，
+// 值可以是 JSON 对象，也可以是 JSON 文件路径
+func LoadModelDefaults() {
+	defaults := make(map[string]ModelDefaults)
+
+	raw := strings.TrimSpace(os.Getenv("MODEL_DEFAULTS"))
+	if raw != "" && !strings.HasPrefix(raw, "{") {
+		data, err := os.ReadFile(raw)
+		if err != nil {
+			log.Printf("[Config] Failed to read MODEL_DEFAULTS file %s: %v", raw, err)
+			raw = ""
+		} else {
+			raw = string(data)
+		}
+	}
+	if raw != "" {
+		if err := json.Unmarshal([]byte(raw), &defaults); err != nil {
+			log.Printf("[Config] Invalid MODEL_DEFAULTS, ignoring: %v", err)
+			defaults = make(map[string]ModelDefaults)
+		}
+	}
+	for model := range defaults {
+		log.Printf("[Config] Model defaults loaded for %s", model)
+	}
+
+	modelDefaultsMu.Lock()
+	modelDefaults = defaults
+	modelDefaultsMu.Unlock()
+}
+
+// ModelDefaultsFor 按顺序查找第一个配置了默认参数的模型名（通常先请求中的名称，再映射后的名称），
+// 都没有时返回 "*" 的配置
+func ModelDefaultsFor(models ...string) ModelDefaults {
+	modelDefaultsMu.RLock()
+	defer modelDefaultsMu.RUnlock()
+
+	for _, model := range models {
+		if d, ok := modelDefaults[model]; ok {
+			return d
+		}
+	}
+	return modelDefaults["*"]
+}
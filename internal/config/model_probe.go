@@ -0,0 +1,45 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// /v1/models 的可用性探测方式（MODEL_PROBE）
+const (
+	ModelProbeOff     = "off"     // 不探测，返回静态列表（默认）
+	ModelProbeStartup = "startup" // 启动时探测一次，结果一直使用
+	ModelProbeLive    = "live"    // 结果超过 MODEL_PROBE_TTL 后在后台重新探测
+)
+
+const defaultModelProbeTTL = 10 * time.Minute
+
+func ModelProbeMode() string {
+	switch v := strings.ToLower(strings.TrimSpace(os.Getenv("MODEL_PROBE"))); v {
+	case "", "0", "false", ModelProbeOff:
+		return ModelProbeOff
+	case ModelProbeStartup, "1", "true":
+		return ModelProbeStartup
+	case ModelProbeLive:
+		return ModelProbeLive
+	default:
+		log.Printf("[Models] Invalid MODEL_PROBE '%s', probing disabled", v)
+		return ModelProbeOff
+	}
+}
+
+// ModelProbeTTL live 模式下探测结果的有效期（MODEL_PROBE_TTL），默认 10 分钟
+func ModelProbeTTL() time.Duration {
+	v := strings.TrimSpace(os.Getenv("MODEL_PROBE_TTL"))
+	if v == "" {
+		return defaultModelProbeTTL
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("[Models] Invalid MODEL_PROBE_TTL '%s', using %s", v, defaultModelProbeTTL)
+		return defaultModelProbeTTL
+	}
+	return d
+}
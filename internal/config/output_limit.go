@@ -0,0 +1,23 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MaxOutputBytes 单个请求从上游读取的正文与思考过程总字节数上限（MAX_OUTPUT_BYTES），
+// 超过后停止读取并按输出长度截断结束；未设置或 0 / off 表示不限制
+func MaxOutputBytes() int {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("MAX_OUTPUT_BYTES")))
+	if v == "" || v == "0" || v == "off" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("[Config] Invalid MAX_OUTPUT_BYTES '%s', output size is not limited", v)
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MaxPromptTokens 提示词估算 token 数上限（MAX_PROMPT_TOKENS）：未设置返回 0，表示使用模型的上下文窗口；
+// 0 / off 关闭检查，返回 -1
+func MaxPromptTokens() int {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("MAX_PROMPT_TOKENS")))
+	switch v {
+	case "":
+		return 0
+	case "0", "off", "false":
+		return -1
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("[Config] Invalid MAX_PROMPT_TOKENS '%s', using the model context window", v)
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,13 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// ReadinessGate 为 true（默认）时，在至少一个账号完成初始化之前 API 请求返回 503 warming up；
+// READINESS_GATE=0 关闭，此时 /ready 仍然反映就绪状态
+func ReadinessGate() bool {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("READINESS_GATE")))
+	return v != "0" && v != "false" && v != "off"
+}
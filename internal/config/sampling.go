@@ -0,0 +1,40 @@
+package config
+
+import "log"
+
+// Gemini 接受的采样参数范围
+const (
+	MinTemperature = 0.0
+	MaxTemperature = 2.0
+	MinTopP        = 0.0
+	MaxTopP        = 1.0
+	MinTopK        = 1
+)
+
+// ClampSampling 检查 temperature（0–2）、top_p（0–1）、top_k（≥1）是否在 Gemini 的范围内，越界时收敛到边界值并记录日志；
+// Gemini Web 不接收采样参数，这些值不会发给上游，这里只做校验与记录，不会影响请求结果。nil 表示未设置，保持不变
+func ClampSampling(source string, temperature, topP *float64, topK *int) {
+	if temperature != nil {
+		*temperature = clampFloat(source, "temperature", *temperature, MinTemperature, MaxTemperature)
+	}
+	if topP != nil {
+		*topP = clampFloat(source, "top_p", *topP, MinTopP, MaxTopP)
+	}
+	if topK != nil && *topK < MinTopK {
+		log.Printf("[%s] top_k %d is out of range, clamped to %d", source, *topK, MinTopK)
+		*topK = MinTopK
+	}
+}
+
+func clampFloat(source, name string, v, min, max float64) float64 {
+	clamped := v
+	if clamped < min {
+		clamped = min
+	} else if clamped > max {
+		clamped = max
+	}
+	if clamped != v {
+		log.Printf("[%s] %s %g is out of range [%g, %g], clamped to %g", source, name, v, min, max, clamped)
+	}
+	return clamped
+}
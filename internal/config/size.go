@@ -0,0 +1,28 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ParseByteSize 解析纯字节数或带 KB / MB / GB 后缀的大小，非法或非正数时 ok 为 false
+func ParseByteSize(value string) (int, bool) {
+	v := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := 1
+	for _, unit := range []struct {
+		suffix string
+		size   int
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(v, unit.suffix) {
+			v = strings.TrimSpace(strings.TrimSuffix(v, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n * multiplier, true
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// 联网搜索引用来源在 OpenAI 响应中的呈现方式
+const (
+	WebSourcesOff       = "off"       // 不输出（默认）
+	WebSourcesField     = "field"     // 非标准的 sources 字段：[{"title","url"}]
+	WebSourcesList      = "list"      // 在回答末尾追加 Markdown 来源列表
+	WebSourcesFootnotes = "footnotes" // 在回答末尾追加 Markdown 脚注定义 [^1]: ...
+)
+
+// ResolveWebSources 优先使用请求中指定的值，其次是 WEB_SOURCES，默认 off
+func ResolveWebSources(requested string) string {
+	if v := normalizeWebSources(requested); v != "" {
+		return v
+	}
+	if v := normalizeWebSources(os.Getenv("WEB_SOURCES")); v != "" {
+		return v
+	}
+	return WebSourcesOff
+}
+
+func normalizeWebSources(v string) string {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case WebSourcesOff, "0", "false", "none":
+		return WebSourcesOff
+	case WebSourcesField, "sources":
+		return WebSourcesField
+	case WebSourcesList, "markdown":
+		return WebSourcesList
+	case WebSourcesFootnotes, "footnote":
+		return WebSourcesFootnotes
+	}
+	return ""
+}
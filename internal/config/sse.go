@@ -0,0 +1,49 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultSSERetry = 3 * time.Second
+
+// SSERetry 流式响应首个事件携带的 retry: 重连间隔提示（SSE_RETRY，如 3s、500ms），默认 3s；0 / off 不发送
+func SSERetry() time.Duration {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("SSE_RETRY")))
+	switch v {
+	case "":
+		return defaultSSERetry
+	case "0", "off", "false":
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("[Config] Invalid SSE_RETRY '%s', using %s", v, defaultSSERetry)
+		return defaultSSERetry
+	}
+	return d
+}
+
+// SSEFlushInterval 流式响应的 flush 间隔（SSE_FLUSH_INTERVAL，如 100ms），默认 0 即每个事件立即 flush；
+// 大于 0 时同一间隔内的事件合并为一次 flush，减少经过缓冲代理时的小包数量
+func SSEFlushInterval() time.Duration {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("SSE_FLUSH_INTERVAL")))
+	if v == "" || v == "0" || v == "off" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("[Config] Invalid SSE_FLUSH_INTERVAL '%s', flushing immediately", v)
+		return 0
+	}
+	return d
+}
+
+// StreamingDisabled 为 true 时（DISABLE_STREAMING）忽略请求中的 stream: true，一律返回完整的非流式响应，
+// 用于无法转发 SSE 的网络环境
+func StreamingDisabled() bool {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("DISABLE_STREAMING")))
+	return v == "1" || v == "true" || v == "on"
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+	// 内置时区数据，Windows 等没有 zoneinfo 的环境也能解析 CURRENT_TIME_ZONE
+	_ "time/tzdata"
+)
+
+// GlobalSystemPrompt 运营方为所有请求统一注入的系统指令（GLOBAL_SYSTEM_PROMPT），未设置时返回空串
+func GlobalSystemPrompt() string {
+	return strings.TrimSpace(os.Getenv("GLOBAL_SYSTEM_PROMPT"))
+}
+
+const defaultCurrentTimeFormat = "Monday, January 2, 2006 15:04 MST"
+
+// CurrentTimeEnabled 是否在每个请求的提示词前注入当前日期时间（INJECT_CURRENT_TIME），默认关闭
+func CurrentTimeEnabled() bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("INJECT_CURRENT_TIME"))) {
+	case "1", "true", "on":
+		return true
+	}
+	return false
+}
+
+// CurrentTimeFormat 注入时间使用的 Go 时间格式（CURRENT_TIME_FORMAT），默认 Monday, January 2, 2006 15:04 MST
+func CurrentTimeFormat() string {
+	if v := strings.TrimSpace(os.Getenv("CURRENT_TIME_FORMAT")); v != "" {
+		return v
+	}
+	return defaultCurrentTimeFormat
+}
+
+// CurrentTimeLocation 注入时间使用的时区（CURRENT_TIME_ZONE，IANA 名称如 Asia/Shanghai），未设置或无效时使用服务器本地时区
+func CurrentTimeLocation() *time.Location {
+	name := strings.TrimSpace(os.Getenv("CURRENT_TIME_ZONE"))
+	if name == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("[Config] Invalid CURRENT_TIME_ZONE '%s', using local time zone: %v", name, err)
+		return time.Local
+	}
+	return loc
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// 思考过程的输出方式
+const (
+	ThinkingShow    = "show"    // 增量输出思考过程（默认）
+	ThinkingHide    = "hide"    // 完全不输出思考过程
+	ThinkingSummary = "summary" // 思考结束后一次性输出完整思考过程
+)
+
+// ResolveThinkingVisibility 优先使用请求中指定的值，其次是 THINKING_VISIBILITY，默认 show
+func ResolveThinkingVisibility(requested string) string {
+	if v := normalizeThinkingVisibility(requested); v != "" {
+		return v
+	}
+	if v := normalizeThinkingVisibility(os.Getenv("THINKING_VISIBILITY")); v != "" {
+		return v
+	}
+	return ThinkingShow
+}
+
+// AnswerOnly ANSWER_ONLY=1 或请求字段 answer_only 为 true 时只返回最终回答：思考过程与思考签名一律丢弃，
+// 优先于 thinking_visibility，请求无法通过其他字段让思考过程重新出现
+func AnswerOnly(requested bool) bool {
+	if requested {
+		return true
+	}
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("ANSWER_ONLY")))
+	return v == "1" || v == "true" || v == "on"
+}
+
+func normalizeThinkingVisibility(v string) string {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case ThinkingShow:
+		return ThinkingShow
+	case ThinkingHide:
+		return ThinkingHide
+	case ThinkingSummary:
+		return ThinkingSummary
+	}
+	return ""
+}
+
+// 思考过程在 OpenAI 响应中的呈现格式
+const (
+	ThinkingFormatReasoning = "reasoning_content" // 独立的 reasoning_content 字段（默认）
+	ThinkingFormatTags      = "think_tags"        // 以 <think>...</think> 包裹后放在 content 开头（DeepSeek-R1 风格）
+)
+
+// ResolveThinkingFormat 优先使用请求中指定的值，其次是 THINKING_FORMAT，默认 reasoning_content
+func ResolveThinkingFormat(requested string) string {
+	if v := normalizeThinkingFormat(requested); v != "" {
+		return v
+	}
+	if v := normalizeThinkingFormat(os.Getenv("THINKING_FORMAT")); v != "" {
+		return v
+	}
+	return ThinkingFormatReasoning
+}
+
+// OpenAI 响应中承载思考过程的字段名
+const (
+	ReasoningFieldContent = "reasoning_content" // DeepSeek 风格（默认）
+	ReasoningFieldShort   = "reasoning"         // OpenRouter 等使用的字段名
+)
+
+// ReasoningField 流式 delta 与非流式 message 统一使用的思考字段名（THINKING_FIELD），默认 reasoning_content
+func ReasoningField() string {
+	if strings.ToLower(strings.TrimSpace(os.Getenv("THINKING_FIELD"))) == ReasoningFieldShort {
+		return ReasoningFieldShort
+	}
+	return ReasoningFieldContent
+}
+
+func normalizeThinkingFormat(v string) string {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case ThinkingFormatReasoning, "reasoning":
+		return ThinkingFormatReasoning
+	case ThinkingFormatTags, "think", "tags":
+		return ThinkingFormatTags
+	}
+	return ""
+}
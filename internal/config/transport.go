@@ -0,0 +1,57 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UpstreamTransport 访问 Gemini 的 TLS 客户端的连接池与超时设置，零值表示沿用 tls-client 的默认行为
+type UpstreamTransport struct {
+	// MaxIdleConns 每个账号客户端保留的空闲连接数（UPSTREAM_MAX_IDLE_CONNS），同时作为每个主机的上限
+	MaxIdleConns int
+	// IdleConnTimeout 空闲连接保留时长（UPSTREAM_IDLE_CONN_TIMEOUT），库默认 90s
+	IdleConnTimeout time.Duration
+	// DialTimeout 建立 TCP 连接（含代理握手）的超时（UPSTREAM_DIAL_TIMEOUT），库默认与请求超时相同
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout 连接建立后等待服务器响应 TLS 握手的超时（UPSTREAM_TLS_HANDSHAKE_TIMEOUT），库默认不单独限制
+	TLSHandshakeTimeout time.Duration
+}
+
+// UpstreamTransportSettings 读取 UPSTREAM_* 连接池与超时配置，未设置或无效的项为零值
+func UpstreamTransportSettings() UpstreamTransport {
+	return UpstreamTransport{
+		MaxIdleConns:        upstreamInt("UPSTREAM_MAX_IDLE_CONNS"),
+		IdleConnTimeout:     upstreamDuration("UPSTREAM_IDLE_CONN_TIMEOUT"),
+		DialTimeout:         upstreamDuration("UPSTREAM_DIAL_TIMEOUT"),
+		TLSHandshakeTimeout: upstreamDuration("UPSTREAM_TLS_HANDSHAKE_TIMEOUT"),
+	}
+}
+
+func upstreamInt(env string) int {
+	v := strings.TrimSpace(os.Getenv(env))
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("[Config] Invalid %s '%s', using library default", env, v)
+		return 0
+	}
+	return n
+}
+
+func upstreamDuration(env string) time.Duration {
+	v := strings.ToLower(strings.TrimSpace(os.Getenv(env)))
+	if v == "" || v == "0" || v == "off" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("[Config] Invalid %s '%s', using library default", env, v)
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// 视频按可续传协议分片上传，上限主要受请求体中 base64 的大小约束
+const (
+	defaultMaxVideoSize     = 100 * 1024 * 1024
+	defaultMaxVideoDuration = 60 * time.Second
+)
+
+// MaxVideoSize 聊天消息中内联视频的大小上限（VIDEO_MAX_SIZE，支持 KB / MB 后缀），默认 100MB
+func MaxVideoSize() int {
+	v := strings.TrimSpace(os.Getenv("VIDEO_MAX_SIZE"))
+	if v == "" {
+		return defaultMaxVideoSize
+	}
+	n, ok := ParseByteSize(v)
+	if !ok {
+		log.Printf("Warning: invalid VIDEO_MAX_SIZE '%s', using default %d bytes", v, defaultMaxVideoSize)
+		return defaultMaxVideoSize
+	}
+	return n
+}
+
+// MaxVideoDuration 内联视频的时长上限（VIDEO_MAX_DURATION，如 90s / 2m），默认 60s；
+// 设为 0 时不限制时长，无法读取时长的视频也会被接受
+func MaxVideoDuration() time.Duration {
+	v := strings.TrimSpace(os.Getenv("VIDEO_MAX_DURATION"))
+	if v == "" {
+		return defaultMaxVideoDuration
+	}
+	if v == "0" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Warning: invalid VIDEO_MAX_DURATION '%s', using default %v", v, defaultMaxVideoDuration)
+		return defaultMaxVideoDuration
+	}
+	return d
+}
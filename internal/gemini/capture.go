@@ -0,0 +1,158 @@
+package gemini
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CaptureDir 录制原始 StreamGenerate 响应的目录（CAPTURE_DIR），留空时不录制
+func CaptureDir() string {
+	return strings.TrimSpace(os.Getenv("CAPTURE_DIR"))
+}
+
+// captureRequest 与录制的响应一起保存的请求信息，只包含提示词与参数，不含 Cookie、at 令牌、f.sid 等凭据
+type captureRequest struct {
+	Model        string   `json:"model"`
+	Language     string   `json:"language"`
+	Prompt       string   `json:"prompt"`
+	Files        []string `json:"files,omitempty"`
+	Conversation bool     `json:"conversation,omitempty"`
+}
+
+// captureKey 按请求内容计算文件名，相同请求的录制会覆盖之前的结果
+func (r captureRequest) captureKey() string {
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// captureBody 边读边把原始响应写入目录中独立的临时文件（同一请求并发录制时互不覆盖），
+// 只有读到 EOF 的完整响应在关闭时才重命名为 <key>.txt 并写出 <key>.json 请求信息，
+// 中途断开或读取出错的响应直接丢弃。文件格式与 mockgemini 的 fixture 相同，可以直接用 -fixtures 目录回放
+type captureBody struct {
+	io.ReadCloser
+	file    *os.File
+	dir     string
+	key     string
+	request []byte
+	once    sync.Once
+	wrote   bool
+	eof     bool
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && b.file != nil {
+		if _, werr := b.file.Write(p[:n]); werr != nil {
+			log.Printf("[Capture] Failed to write %s: %v", b.file.Name(), werr)
+			b.discard()
+		} else {
+			b.wrote = true
+		}
+	}
+	if err == io.EOF {
+		b.eof = true
+	} else if err != nil && b.file != nil {
+		b.discard()
+	}
+	return n, err
+}
+
+// discard 丢弃未完成的录制
+func (b *captureBody) discard() {
+	b.file.Close()
+	os.Remove(b.file.Name())
+	b.file = nil
+}
+
+func (b *captureBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		if b.file == nil {
+			return
+		}
+		if !b.wrote || !b.eof {
+			b.discard()
+			return
+		}
+		tmp := b.file.Name()
+		b.file.Close()
+		b.file = nil
+		path := filepath.Join(b.dir, b.key+".txt")
+		if werr := writeFileAtomic(filepath.Join(b.dir, b.key+".json"), b.request); werr != nil {
+			log.Printf("[Capture] Failed to write request for %s: %v", b.key, werr)
+			os.Remove(tmp)
+			return
+		}
+		if rerr := os.Rename(tmp, path); rerr != nil {
+			log.Printf("[Capture] Failed to save %s: %v", path, rerr)
+			os.Remove(tmp)
+			return
+		}
+		log.Printf("[Capture] Saved response to %s", path)
+	})
+	return err
+}
+
+// writeFileAtomic 先写入同目录的临时文件再重命名，并发写同一路径时不会得到交错的内容
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+	return err
+}
+
+// captureResponse CAPTURE_DIR 非空时录制响应，返回边读边落盘的 body
+func captureResponse(body io.ReadCloser, prompt, model string, files []FileData, meta *ChatMetadata, opts GenerateOptions) io.ReadCloser {
+	dir := CaptureDir()
+	if dir == "" {
+		return body
+	}
+
+	req := captureRequest{
+		Model:        model,
+		Language:     opts.language(),
+		Prompt:       prompt,
+		Conversation: meta != nil && meta.CID != "",
+	}
+	for _, f := range files {
+		req.Files = append(req.Files, f.FileName)
+	}
+	key := req.captureKey()
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Printf("[Capture] Failed to create %s: %v", dir, err)
+		return body
+	}
+	record := struct {
+		captureRequest
+		CapturedAt time.Time `json:"captured_at"`
+	}{req, time.Now()}
+	data, _ := json.MarshalIndent(record, "", "  ")
+
+	file, err := os.CreateTemp(dir, key+".txt.*.tmp")
+	if err != nil {
+		log.Printf("[Capture] Failed to create a capture file in %s: %v", dir, err)
+		return body
+	}
+	return &captureBody{ReadCloser: body, file: file, dir: dir, key: key, request: data}
+}
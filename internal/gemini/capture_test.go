@@ -0,0 +1,49 @@
+package gemini
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCaptureKeepsOnlyCompleteStreams(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CAPTURE_DIR", dir)
+	fixture := readFixture(t, "chat")
+
+	// 同一请求的两个响应同时录制，各自写入自己的临时文件
+	complete := captureResponse(io.NopCloser(bytes.NewReader(fixture)), "hi", "gemini-2.5-flash", nil, nil, GenerateOptions{})
+	partial := captureResponse(io.NopCloser(bytes.NewReader(fixture)), "hi", "gemini-2.5-flash", nil, nil, GenerateOptions{})
+
+	buf := make([]byte, 16)
+	if _, err := partial.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(complete); err != nil {
+		t.Fatal(err)
+	}
+	// 客户端中途断开：没有读到 EOF 的录制被丢弃
+	partial.Close()
+	complete.Close()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 2 || filepath.Ext(names[0]) != ".json" || filepath.Ext(names[1]) != ".txt" {
+		t.Fatalf("capture dir = %v, want one .json and one .txt", names)
+	}
+	saved, err := os.ReadFile(filepath.Join(dir, names[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, fixture) {
+		t.Fatalf("saved %d bytes, want the complete %d-byte response", len(saved), len(fixture))
+	}
+}
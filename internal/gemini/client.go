@@ -1,13 +1,18 @@
 package gemini
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	http "github.com/bogdanfinn/fhttp"
 	tls_client "github.com/bogdanfinn/tls-client"
@@ -15,10 +20,64 @@ import (
 
 const (
 	EndpointGoogle   = "https://www.google.com"
-	EndpointInit     = "https://gemini.google.com/app"
-	EndpointGenerate = "https://gemini.google.com/_/BardChatUi/data/assistant.lamda.BardFrontendService/StreamGenerate"
+	EndpointBase     = "https://gemini.google.com"
+	EndpointInit     = EndpointBase + PathInit
+	EndpointGenerate = EndpointBase + PathGenerate
+
+	PathInit     = "/app"
+	PathUpload   = "/upload"
+	PathGenerate = "/_/BardChatUi/data/assistant.lamda.BardFrontendService/StreamGenerate"
 )
 
+// Endpoints Gemini Web 各接口地址，可通过 GEMINI_BASE_URL / GEMINI_UPLOAD_URL 指向区域镜像或 mock 服务
+type Endpoints struct {
+	Base     string
+	Init     string
+	Generate string
+	Upload   string
+}
+
+// DefaultEndpoints 读取环境变量生成接口地址，未配置时使用官方地址。
+// GEMINI_INIT_PATH / GEMINI_GENERATE_PATH 可在 Google 调整路径时临时覆盖
+func DefaultEndpoints() Endpoints {
+	e := NewEndpoints(os.Getenv("GEMINI_BASE_URL"), os.Getenv("GEMINI_UPLOAD_URL"))
+	if p := strings.TrimSpace(os.Getenv("GEMINI_INIT_PATH")); p != "" {
+		e.Init = joinEndpoint(e.Base, p)
+	}
+	if p := strings.TrimSpace(os.Getenv("GEMINI_GENERATE_PATH")); p != "" {
+		e.Generate = joinEndpoint(e.Base, p)
+	}
+	return e
+}
+
+// NewEndpoints 由 base 推导 init / generate 地址；uploadURL 以 / 开头时视为 base 下的路径。
+// 自定义了 base 而没有配置 uploadURL 时上传地址为 base + PathUpload，三个接口都指向同一个镜像或 mock
+func NewEndpoints(baseURL, uploadURL string) Endpoints {
+	base := strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if base == "" {
+		base = EndpointBase
+	}
+	upload := strings.TrimSpace(uploadURL)
+	if upload == "" {
+		upload = EndpointUpload
+		if base != EndpointBase {
+			upload = base + PathUpload
+		}
+	} else if strings.HasPrefix(upload, "/") {
+		upload = joinEndpoint(base, upload)
+	}
+	return Endpoints{
+		Base:     base,
+		Init:     base + PathInit,
+		Generate: base + PathGenerate,
+		Upload:   upload,
+	}
+}
+
+func joinEndpoint(base, path string) string {
+	return base + "/" + strings.TrimLeft(path, "/")
+}
+
 // ModelHeaders maps model names to their specific required headers.
 // You can add new models here by inspecting the 'x-goog-ext-525001261-jspb' header in browser DevTools.
 var ModelHeaders = map[string]string{
@@ -39,6 +98,84 @@ type Client struct {
 	ReqID      int
 	AccountID  string
 	ProxyURL   string
+	Endpoints  Endpoints
+	// ExtraHeaders 在默认请求头之后合并，用于按账号调整指纹相关的请求头
+	ExtraHeaders map[string]string
+
+	authMu       sync.Mutex
+	authFailures int
+	needsReauth  bool
+	// initErr 最近一次 Init 失败的原因，成功后清空
+	initErr string
+	// cooldownUntil 被限流后暂停参与负载均衡直到该时间
+	cooldownUntil time.Time
+}
+
+// ErrNeedsReauth 账号连续认证失败，已停止发送请求，需要更新 Cookie 后手动重置
+var ErrNeedsReauth = errors.New("account needs manual re-authentication: update cookies and reset the account")
+
+// ErrAuthRejected Init 页面返回 401 / 403，Cookie 被拒绝；只有这类错误计入连续认证失败，网络错误等不计入
+var ErrAuthRejected = errors.New("authentication rejected")
+
+// authFailureThreshold 连续认证失败多少次后进入需要重新认证状态，AUTH_FAILURE_THRESHOLD 可配置
+func authFailureThreshold() int {
+	if v, err := strconv.Atoi(strings.TrimSpace(os.Getenv("AUTH_FAILURE_THRESHOLD"))); err == nil && v > 0 {
+		return v
+	}
+	return 3
+}
+
+// NeedsReauth 报告账号是否因连续认证失败被停用
+func (c *Client) NeedsReauth() bool {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	return c.needsReauth
+}
+
+// AuthFailures 返回当前连续认证失败次数
+func (c *Client) AuthFailures() int {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	return c.authFailures
+}
+
+// InitError 返回最近一次 Init 失败的原因，最近一次成功时返回空串
+func (c *Client) InitError() string {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	return c.initErr
+}
+
+// MarkNeedsReauth 直接进入需要重新认证状态，用于管理员重置后 Cookie 仍被拒绝的情况
+func (c *Client) MarkNeedsReauth() {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	c.needsReauth = true
+}
+
+// ResetAuthState 清除认证失败状态与限流冷却，由管理员在更新 Cookie 后触发
+func (c *Client) ResetAuthState() {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	c.authFailures = 0
+	c.needsReauth = false
+	c.cooldownUntil = time.Time{}
+}
+
+func (c *Client) recordAuthFailure() {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	c.authFailures++
+	if !c.needsReauth && c.authFailures >= authFailureThreshold() {
+		c.needsReauth = true
+		log.Printf("账号 '%s' 连续 %d 次认证失败，已停止请求，等待重新认证", c.displayAccountID(), c.authFailures)
+	}
+}
+
+func (c *Client) recordAuthSuccess() {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	c.authFailures = 0
 }
 
 func NewClient(cookies map[string]string, proxyURL string) (*Client, error) {
@@ -50,9 +187,15 @@ func NewClient(cookies map[string]string, proxyURL string) (*Client, error) {
 		return nil, err
 	}
 
-	u, _ := url.Parse("https://gemini.google.com")
+	endpoints := DefaultEndpoints()
+
+	u, _ := url.Parse(endpoints.Base)
+	// 账号加载时已按 COOKIE_NAMES 取出全部 Cookie，这里逐个写入 Cookie jar，未配置值的跳过
 	var cookieList []*http.Cookie
 	for k, v := range cookies {
+		if v == "" {
+			continue
+		}
 		cookieList = append(cookieList, &http.Cookie{
 			Name:   k,
 			Value:  v,
@@ -67,11 +210,25 @@ func NewClient(cookies map[string]string, proxyURL string) (*Client, error) {
 		Cookies:    cookies,
 		ReqID:      GenerateReqID(),
 		ProxyURL:   strings.TrimSpace(proxyURL),
+		Endpoints:  endpoints,
 	}, nil
 }
 
+// Init 访问 Gemini 首页获取 SNlM0e 等会话参数，并记录结果供 InitError 查询
 func (c *Client) Init() error {
-	req, _ := http.NewRequest(http.MethodGet, EndpointInit, nil)
+	err := c.initSession()
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	if err != nil {
+		c.initErr = err.Error()
+	} else {
+		c.initErr = ""
+	}
+	return err
+}
+
+func (c *Client) initSession() error {
+	req, _ := http.NewRequest(http.MethodGet, c.Endpoints.Init, nil)
 	req.Header.Set("User-Agent", GetCurrentUserAgent())
 	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8")
 	req.Header.Set("Accept-Language", getLangHeader())
@@ -80,6 +237,7 @@ func (c *Client) Init() error {
 	req.Header.Set("Sec-Fetch-Site", "same-origin")
 	req.Header.Set("Sec-Fetch-User", "?1")
 	req.Header.Set("Upgrade-Insecure-Requests", "1")
+	c.applyExtraHeaders(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -87,6 +245,9 @@ func (c *Client) Init() error {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("account '%s' init page returned status %d: %w", c.displayAccountID(), resp.StatusCode, ErrAuthRejected)
+	}
 	if resp.StatusCode != 200 {
 		return fmt.Errorf("account '%s' init page returned status: %d", c.displayAccountID(), resp.StatusCode)
 	}
@@ -97,6 +258,13 @@ func (c *Client) Init() error {
 	reSN := regexp.MustCompile(`"SNlM0e":"(.*?)"`)
 	matchSN := reSN.FindStringSubmatch(bodyString)
 	if len(matchSN) < 2 {
+		finalURL := ""
+		if resp.Request != nil && resp.Request.URL != nil {
+			finalURL = resp.Request.URL.String()
+		}
+		if err := detectInterstitial(c.displayAccountID(), finalURL, bodyString); err != nil {
+			return err
+		}
 		return fmt.Errorf("account '%s' SNlM0e token not found. Cookies might be invalid", c.displayAccountID())
 	}
 	c.SNlM0e = matchSN[1]
@@ -143,46 +311,90 @@ func (c *Client) Init() error {
 }
 
 func (c *Client) StreamGenerateContent(prompt string, model string, files []FileData, meta *ChatMetadata) (io.ReadCloser, error) {
-	resp, err := c.doGenerateContentRequest(prompt, model, files, meta)
+	return c.StreamGenerateContentWithOptions(prompt, model, files, meta, GenerateOptions{})
+}
+
+func (c *Client) StreamGenerateContentWithOptions(prompt string, model string, files []FileData, meta *ChatMetadata, opts GenerateOptions) (io.ReadCloser, error) {
+	if c.NeedsReauth() {
+		return nil, ErrNeedsReauth
+	}
+
+	resp, err := c.doGenerateContentRequest(prompt, model, files, meta, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode == http.StatusForbidden {
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized {
 		preview := readBodyPreview(resp.Body)
 		resp.Body.Close()
-		log.Printf("账号 '%s' 请求返回 403，准备重新初始化后重试。响应预览: %s", c.displayAccountID(), preview)
+		log.Printf("账号 '%s' 请求返回 %d，准备重新初始化后重试。响应预览: %s", c.displayAccountID(), resp.StatusCode, preview)
 
 		if err := c.Init(); err != nil {
+			if errors.Is(err, ErrAuthRejected) {
+				c.recordAuthFailure()
+			}
 			return nil, err
 		}
 
-		resp, err = c.doGenerateContentRequest(prompt, model, files, meta)
+		resp, err = c.doGenerateContentRequest(prompt, model, files, meta, opts)
 		if err != nil {
 			return nil, err
 		}
 
-		if resp.StatusCode == http.StatusForbidden {
+		if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized {
+			statusCode := resp.StatusCode
 			preview = readBodyPreview(resp.Body)
 			resp.Body.Close()
-			log.Printf("账号 '%s' 重新初始化后仍然返回 403。响应预览: %s", c.displayAccountID(), preview)
-			return nil, fmt.Errorf("Account authentication failed (403). Cookie may be expired. Please update cookies in .env")
+			log.Printf("账号 '%s' 重新初始化后仍然返回 %d。响应预览: %s", c.displayAccountID(), statusCode, preview)
+			c.recordAuthFailure()
+			return nil, fmt.Errorf("Account authentication failed (%d). Cookie may be expired. Please update cookies in .env", statusCode)
 		}
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		preview := readBodyPreview(resp.Body)
-		statusCode := resp.StatusCode
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, throttlePeekLimit))
 		resp.Body.Close()
+		statusCode := resp.StatusCode
+		preview := readBodyPreview(io.NopCloser(bytes.NewReader(data)))
 		log.Printf("账号 '%s' 请求失败，状态码 %d，响应预览: %s", c.displayAccountID(), statusCode, preview)
+
+		// 429，或带有重试间隔的 503，按限流处理：冷却时长以 Google 给出的间隔为准
+		retryAfter := retryAfterHint(resp.Header, data)
+		if statusCode == http.StatusTooManyRequests || (statusCode == http.StatusServiceUnavailable && retryAfter > 0) {
+			cooldown := throttleCooldownFor(retryAfter)
+			c.startCooldown(cooldown)
+			log.Printf("账号 '%s' 被 Gemini 限流（状态码 %d），暂停使用 %s", c.displayAccountID(), statusCode, cooldown)
+			return nil, fmt.Errorf("%w (status %d)", ErrThrottled, statusCode)
+		}
+		if statusCode >= 500 {
+			return nil, fmt.Errorf("%w (status %d)", ErrUpstreamUnavailable, statusCode)
+		}
 		return nil, fmt.Errorf("generate request failed with status: %d", statusCode)
 	}
 
-	return resp.Body, nil
+	c.recordAuthSuccess()
+	body, notice, err := checkThrottle(captureResponse(resp.Body, prompt, model, files, meta, opts))
+	if err != nil {
+		body.Close()
+		log.Printf("账号 '%s' 的响应在首帧之前中断: %v", c.displayAccountID(), err)
+		return nil, err
+	}
+	if notice.code != 0 {
+		body.Close()
+		retryAfter := notice.retryAfter
+		if retryAfter == 0 {
+			retryAfter = retryAfterHint(resp.Header, nil)
+		}
+		cooldown := throttleCooldownFor(retryAfter)
+		c.startCooldown(cooldown)
+		log.Printf("账号 '%s' 被 Gemini 限流（错误码 %d），暂停使用 %s", c.displayAccountID(), notice.code, cooldown)
+		return nil, fmt.Errorf("%w (code %d)", ErrThrottled, notice.code)
+	}
+	return body, nil
 }
 
-func (c *Client) doGenerateContentRequest(prompt string, model string, files []FileData, meta *ChatMetadata) (*http.Response, error) {
-	payload := BuildGeneratePayload(prompt, c.ReqID, files, meta)
+func (c *Client) doGenerateContentRequest(prompt string, model string, files []FileData, meta *ChatMetadata, opts GenerateOptions) (*http.Response, error) {
+	payload := BuildGeneratePayload(prompt, c.ReqID, files, meta, opts)
 	c.ReqID++
 
 	form := url.Values{}
@@ -190,7 +402,7 @@ func (c *Client) doGenerateContentRequest(prompt string, model string, files []F
 	form.Set("at", c.SNlM0e)
 	data := form.Encode()
 
-	req, _ := http.NewRequest(http.MethodPost, EndpointGenerate, strings.NewReader(data))
+	req, _ := http.NewRequest(http.MethodPost, c.Endpoints.Generate, strings.NewReader(data))
 
 	q := req.URL.Query()
 	q.Add("bl", c.VersionBL)
@@ -203,24 +415,30 @@ func (c *Client) doGenerateContentRequest(prompt string, model string, files []F
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded;charset=utf-8")
 	req.Header.Set("User-Agent", GetCurrentUserAgent())
-	req.Header.Set("Origin", "https://gemini.google.com")
-	req.Header.Set("Referer", "https://gemini.google.com/")
+	req.Header.Set("Origin", c.Endpoints.Base)
+	req.Header.Set("Referer", c.Endpoints.Base+"/")
 	req.Header.Set("X-Same-Domain", "1")
-	req.Header.Set("Accept-Language", getLangHeader())
+	req.Header.Set("Accept-Language", langHeader(opts.language()))
 	req.Header.Set("Sec-Fetch-Dest", "empty")
 	req.Header.Set("Sec-Fetch-Mode", "cors")
 	req.Header.Set("Sec-Fetch-Site", "same-origin")
 
-	if headerVal, ok := ModelHeaders[model]; ok {
-		req.Header.Set("x-goog-ext-525001261-jspb", headerVal)
-	} else {
-		log.Printf("Warning: Unknown model '%s', using default header (gemini-2.5-flash).", model)
-		req.Header.Set("x-goog-ext-525001261-jspb", ModelHeaders["gemini-2.5-flash"])
+	headerVal, _ := resolveModelHeader(model)
+	req.Header.Set("x-goog-ext-525001261-jspb", headerVal)
+	c.applyExtraHeaders(req)
+	if opts.Context != nil {
+		req = req.WithContext(opts.Context)
 	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		if opts.Context != nil && opts.Context.Err() != nil {
+			return nil, timeoutError(err)
+		}
+		return nil, fmt.Errorf("%w: %w", ErrUpstreamUnavailable, err)
+	}
+	if opts.Context != nil {
+		resp.Body = newContextBody(opts.Context, resp.Body)
 	}
 
 	return resp, nil
@@ -283,10 +501,23 @@ func GetLanguage() string {
 }
 
 func getLangHeader() string {
-	lang := GetLanguage()
+	return langHeader(GetLanguage())
+}
+
+func langHeader(lang string) string {
 	return lang + ",en;q=0.9"
 }
 
+func (c *Client) applyExtraHeaders(req *http.Request) {
+	for k, v := range c.ExtraHeaders {
+		if v == "" {
+			req.Header.Del(k)
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+}
+
 func (c *Client) displayAccountID() string {
 	if strings.TrimSpace(c.AccountID) == "" {
 		return "default"
@@ -0,0 +1,20 @@
+package gemini
+
+import "testing"
+
+func TestNewEndpointsUploadFollowsBase(t *testing.T) {
+	cases := []struct {
+		base, upload, want string
+	}{
+		{"", "", EndpointUpload},
+		{"http://127.0.0.1:8765/", "", "http://127.0.0.1:8765/upload"},
+		{"http://127.0.0.1:8765", "/custom/upload", "http://127.0.0.1:8765/custom/upload"},
+		{"http://127.0.0.1:8765", "https://upload.example.com/upload", "https://upload.example.com/upload"},
+		{EndpointBase, "", EndpointUpload},
+	}
+	for _, tc := range cases {
+		if got := NewEndpoints(tc.base, tc.upload).Upload; got != tc.want {
+			t.Errorf("NewEndpoints(%q, %q).Upload = %q, want %q", tc.base, tc.upload, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package gemini
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrRequestTimeout GenerateOptions.Context 到期（如客户端通过 X-Request-Timeout 指定的时限）后，
+// 发送请求或读取响应返回的错误
+var ErrRequestTimeout = errors.New("request timeout exceeded")
+
+// contextBody context 结束时关闭响应体，使阻塞中的读取立即返回；之后的读取错误包装为 ErrRequestTimeout，
+// 解析方据此把已经读到的内容作为被截断的回答输出
+type contextBody struct {
+	io.ReadCloser
+	ctx  context.Context
+	stop func() bool
+}
+
+func newContextBody(ctx context.Context, body io.ReadCloser) io.ReadCloser {
+	b := &contextBody{ReadCloser: body, ctx: ctx}
+	b.stop = context.AfterFunc(ctx, func() { body.Close() })
+	return b
+}
+
+func (b *contextBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && b.ctx.Err() != nil {
+		err = timeoutError(err)
+	}
+	return n, err
+}
+
+func (b *contextBody) Close() error {
+	b.stop()
+	return b.ReadCloser.Close()
+}
+
+func timeoutError(err error) error {
+	return fmt.Errorf("%w: %w", ErrRequestTimeout, err)
+}
@@ -0,0 +1,212 @@
+package gemini
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+	tls_client "github.com/bogdanfinn/tls-client"
+	"golang.org/x/net/proxy"
+)
+
+// timeoutDialer 配置了 UPSTREAM_DIAL_TIMEOUT / UPSTREAM_TLS_HANDSHAKE_TIMEOUT 时替代 tls-client 内置的拨号器。
+// 内置拨号器把整个请求的超时（600s）同时用作连接超时，TLS 握手也只受请求超时限制，
+// 网络异常时一个卡住的连接会一直占用请求；这里支持直连、HTTP/HTTPS CONNECT 与 SOCKS5 代理
+type timeoutDialer struct {
+	dialer           net.Dialer
+	timeout          time.Duration
+	handshakeTimeout time.Duration
+	proxyURL         *url.URL
+	connectHeader    http.Header
+	socks            proxy.ContextDialer
+}
+
+// newTimeoutDialerFactory dialTimeout 为 0 时沿用 tls-client 传入的请求超时
+func newTimeoutDialerFactory(dialTimeout, handshakeTimeout time.Duration) tls_client.ProxyDialerFactory {
+	return func(proxyURL string, timeout time.Duration, localAddr *net.TCPAddr, connectHeaders http.Header, _ tls_client.Logger) (proxy.ContextDialer, error) {
+		if dialTimeout > 0 {
+			timeout = dialTimeout
+		}
+		d := &timeoutDialer{
+			dialer:           net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second},
+			timeout:          timeout,
+			handshakeTimeout: handshakeTimeout,
+			connectHeader:    connectHeaders.Clone(),
+		}
+		if localAddr != nil {
+			d.dialer.LocalAddr = localAddr
+		}
+		if proxyURL == "" {
+			return d, nil
+		}
+
+		u, err := url.Parse(proxyURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy url '%s'", proxyURL)
+		}
+		switch u.Scheme {
+		case "http", "https":
+			if u.Port() == "" {
+				port := "80"
+				if u.Scheme == "https" {
+					port = "443"
+				}
+				u.Host = net.JoinHostPort(u.Host, port)
+			}
+			d.proxyURL = u
+		case "socks5", "socks5h":
+			var auth *proxy.Auth
+			if u.User != nil {
+				password, _ := u.User.Password()
+				auth = &proxy.Auth{User: u.User.Username(), Password: password}
+			}
+			socks, err := proxy.SOCKS5("tcp", u.Host, auth, &d.dialer)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create socks5 proxy: %w", err)
+			}
+			d.socks = socks.(proxy.ContextDialer)
+		default:
+			return nil, fmt.Errorf("proxy scheme '%s' is not supported", u.Scheme)
+		}
+		return d, nil
+	}
+}
+
+func (d *timeoutDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext 连接（含代理握手）整体受 timeout 限制，返回的连接在配置了握手超时时带有握手截止时间
+func (d *timeoutDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	var conn net.Conn
+	var err error
+	switch {
+	case d.socks != nil:
+		conn, err = d.socks.DialContext(ctx, network, addr)
+	case d.proxyURL != nil:
+		conn, err = d.dialConnect(ctx, addr)
+	default:
+		conn, err = d.dialer.DialContext(ctx, network, addr)
+	}
+	if err != nil || d.handshakeTimeout <= 0 {
+		return conn, err
+	}
+	return newHandshakeConn(conn, d.handshakeTimeout), nil
+}
+
+// dialConnect 通过 HTTP/HTTPS 代理的 CONNECT 方法建立到 addr 的隧道
+func (d *timeoutDialer) dialConnect(ctx context.Context, addr string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, "tcp", d.proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if d.proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: d.proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	header := d.connectHeader.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	if u := d.proxyURL.User; u != nil && u.Username() != "" {
+		password, _ := u.Password()
+		header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+password)))
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: header,
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT to %s failed: %s", addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// tlsRecordHandshake TLS 握手记录的 ContentType，ClientHello 以它开头
+const tlsRecordHandshake = 0x16
+
+const (
+	handshakeStart int32 = iota
+	handshakeSent
+	handshakeReceived
+	handshakeDone
+)
+
+// handshakeConn 在连接建立时设置握手截止时间：客户端发出 ClientHello、读到服务器的响应后再次写入时清除，
+// 即限制的是服务器响应 TLS 握手所需的时间；第一次写入不是 TLS 握手记录（明文 HTTP）时立即清除
+type handshakeConn struct {
+	net.Conn
+	state atomic.Int32
+}
+
+func newHandshakeConn(conn net.Conn, timeout time.Duration) net.Conn {
+	conn.SetDeadline(time.Now().Add(timeout))
+	return &handshakeConn{Conn: conn}
+}
+
+func (c *handshakeConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.state.CompareAndSwap(handshakeSent, handshakeReceived)
+	}
+	if err != nil && c.state.Load() != handshakeDone {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			err = fmt.Errorf("TLS handshake timeout: %w", err)
+		}
+	}
+	return n, err
+}
+
+func (c *handshakeConn) Write(p []byte) (int, error) {
+	switch c.state.Load() {
+	case handshakeStart:
+		if len(p) > 0 && p[0] != tlsRecordHandshake {
+			c.finish()
+		} else {
+			c.state.CompareAndSwap(handshakeStart, handshakeSent)
+		}
+	case handshakeReceived:
+		c.finish()
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *handshakeConn) finish() {
+	if c.state.Swap(handshakeDone) != handshakeDone {
+		c.Conn.SetDeadline(time.Time{})
+	}
+}
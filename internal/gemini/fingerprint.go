@@ -9,6 +9,8 @@ import (
 
 	fakeUA "github.com/lib4u/fake-useragent"
 
+	"gemini-web2api/internal/config"
+
 	tls_client "github.com/bogdanfinn/tls-client"
 	"github.com/bogdanfinn/tls-client/profiles"
 )
@@ -122,6 +124,25 @@ func GetClientOptions(profile ProfileConfig, proxyURL string) []tls_client.HttpC
 		options = append(options, tls_client.WithProxyUrl(strings.TrimSpace(proxyURL)))
 	}
 
+	return append(options, transportOptions(config.UpstreamTransportSettings())...)
+}
+
+// transportOptions 按 UPSTREAM_* 配置调整连接池与超时，未配置的项保持 tls-client 的默认值
+func transportOptions(s config.UpstreamTransport) []tls_client.HttpClientOption {
+	var options []tls_client.HttpClientOption
+	if s.MaxIdleConns > 0 || s.IdleConnTimeout > 0 {
+		transport := &tls_client.TransportOptions{
+			MaxIdleConns:        s.MaxIdleConns,
+			MaxIdleConnsPerHost: s.MaxIdleConns,
+		}
+		if s.IdleConnTimeout > 0 {
+			transport.IdleConnTimeout = &s.IdleConnTimeout
+		}
+		options = append(options, tls_client.WithTransportOptions(transport))
+	}
+	if s.DialTimeout > 0 || s.TLSHandshakeTimeout > 0 {
+		options = append(options, tls_client.WithProxyDialerFactory(newTimeoutDialerFactory(s.DialTimeout, s.TLSHandshakeTimeout)))
+	}
 	return options
 }
 
@@ -129,3 +150,14 @@ func RandomDelay() {
 	delay := time.Duration(100+rng.Intn(200)) * time.Millisecond
 	time.Sleep(delay)
 }
+
+// RandomDelayBetween 等待 [lo, hi] 之间的随机时长，hi <= lo 时固定等待 lo，可以在多个请求中并发调用
+func RandomDelayBetween(lo, hi time.Duration) {
+	delay := lo
+	if hi > lo {
+		delay += time.Duration(rand.Int63n(int64(hi - lo + 1)))
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
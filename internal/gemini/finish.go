@@ -0,0 +1,55 @@
+package gemini
+
+import "github.com/tidwall/gjson"
+
+// finishReasons 候选中可能出现的结束原因枚举（与 Gemini API 的 finishReason 取值相同）
+var finishReasons = map[string]bool{
+	"STOP":                    true,
+	"MAX_TOKENS":              true,
+	"SAFETY":                  true,
+	"RECITATION":              true,
+	"BLOCKLIST":               true,
+	"PROHIBITED_CONTENT":      true,
+	"SPII":                    true,
+	"MALFORMED_FUNCTION_CALL": true,
+	"OTHER":                   true,
+}
+
+// candidateContentFields 正文、图片和思考过程所在的字段，查找结束原因时跳过，避免误判模型输出的文字
+var candidateContentFields = map[int]bool{1: true, 12: true, 37: true}
+
+const finishReasonSearchDepth = 4
+
+// CandidateFinishReason 返回候选中携带的结束原因（如 MAX_TOKENS），没有时返回空串。
+// Web 接口的位置不固定，这里在除正文以外的字段中查找已知的枚举值，样例见 fixtures/max_tokens.txt 与 grounded.txt
+func CandidateFinishReason(candidate gjson.Result) string {
+	if !candidate.IsArray() {
+		return ""
+	}
+	var reason string
+	for i, field := range candidate.Array() {
+		if candidateContentFields[i] {
+			continue
+		}
+		if reason = findFinishReason(field, finishReasonSearchDepth); reason != "" {
+			break
+		}
+	}
+	return reason
+}
+
+func findFinishReason(value gjson.Result, depth int) string {
+	switch {
+	case value.Type == gjson.String:
+		if finishReasons[value.Str] {
+			return value.Str
+		}
+	case value.IsArray() && depth > 0:
+		for _, item := range value.Array() {
+			if reason := findFinishReason(item, depth-1); reason != "" {
+				return reason
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,23 @@
+package gemini
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestCandidateFinishReason(t *testing.T) {
+	candidates := fixtureCandidates(t, "max_tokens")
+	if reason := CandidateFinishReason(candidates[0]); reason != "" {
+		t.Fatalf("first frame reason = %q, want empty", reason)
+	}
+	if reason := CandidateFinishReason(candidates[len(candidates)-1]); reason != "MAX_TOKENS" {
+		t.Fatalf("reason = %q, want MAX_TOKENS", reason)
+	}
+
+	// 正文（1）、图片（12）与思考过程（37）中出现的枚举值是模型输出的文字，不是结束原因
+	decoy := gjson.Parse(`["rc", ["Setting MAX_TOKENS is explained below"], null, null, null, null, null, null, null, null, null, null, [["SAFETY"]], null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, [["STOP"]]]`)
+	if reason := CandidateFinishReason(decoy); reason != "" {
+		t.Fatalf("decoy reason = %q, want empty", reason)
+	}
+}
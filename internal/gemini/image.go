@@ -1,5 +1,11 @@
 package gemini
 
+import (
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
 type ImageType string
 
 const (
@@ -13,3 +19,39 @@ type Image struct {
 	Title string    `json:"title,omitempty"`
 	Alt   string    `json:"alt,omitempty"`
 }
+
+// imagePlaceholderPrefix 图片尚未生成完成时候选中的占位地址
+const imagePlaceholderPrefix = "http://googleusercontent.com/image_generation_content"
+
+// FullSizeImageURL 生成图片的地址没有尺寸参数时请求 2048 像素的版本
+func FullSizeImageURL(url string) string {
+	if strings.Contains(url, "=s") {
+		return url
+	}
+	return url + "=s2048"
+}
+
+// GeneratedImageList 在一次回答（包括续写）的所有快照中按 URL 去重收集生成的图片
+type GeneratedImageList struct {
+	seen map[string]bool
+}
+
+// Add 返回候选中新出现的生成图片地址（candidate[12][7][0]），跳过尚未生成完成的占位地址
+func (l *GeneratedImageList) Add(candidate gjson.Result) []string {
+	var urls []string
+	candidate.Get("12.7.0").ForEach(func(_, genImg gjson.Result) bool {
+		url := genImg.Get("0.3.3").String()
+		if url == "" || strings.HasPrefix(url, imagePlaceholderPrefix) {
+			return true
+		}
+		if l.seen == nil {
+			l.seen = make(map[string]bool)
+		}
+		if !l.seen[url] {
+			l.seen[url] = true
+			urls = append(urls, url)
+		}
+		return true
+	})
+	return urls
+}
@@ -0,0 +1,49 @@
+package gemini
+
+import (
+	"fmt"
+	"strings"
+)
+
+// interstitial Google 在返回 Gemini 应用页之前可能插入的中间页
+type interstitial struct {
+	name    string
+	markers []string
+	hint    string
+}
+
+// knownInterstitials 按匹配优先级排列，markers 任一命中最终 URL 或页面内容即视为该中间页
+var knownInterstitials = []interstitial{
+	{
+		name:    "consent page",
+		markers: []string{"consent.google.com", "consent.youtube.com", `action="https://consent.`},
+		hint:    "open https://gemini.google.com in the browser, accept the cookie consent, then export fresh cookies",
+	},
+	{
+		name:    "sign-in page",
+		markers: []string{"accounts.google.com/ServiceLogin", "accounts.google.com/v3/signin", "accounts.google.com/InteractiveLogin"},
+		hint:    "the cookies are expired or logged out; sign in again in the browser and update __Secure-1PSID / __Secure-1PSIDTS",
+	},
+	{
+		name:    "unusual traffic (captcha) page",
+		markers: []string{"google.com/sorry/", "unusual traffic from your computer network"},
+		hint:    "complete the captcha in the browser from the same IP or switch to another proxy",
+	},
+	{
+		name:    "unsupported region page",
+		markers: []string{"Gemini isn't currently supported in your country", "not available in your country"},
+		hint:    "use a proxy located in a supported region (PROXY / PROXY_{id})",
+	},
+}
+
+// detectInterstitial 检查 Init 拿到的页面是否为同意页、登录页等中间页，命中时返回可操作的错误
+func detectInterstitial(accountID, finalURL, body string) error {
+	for _, page := range knownInterstitials {
+		for _, marker := range page.markers {
+			if strings.Contains(finalURL, marker) || strings.Contains(body, marker) {
+				return fmt.Errorf("account '%s': Google returned a %s instead of Gemini; %s", accountID, page.name, page.hint)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package gemini
+
+import (
+	"log"
+	"sort"
+	"strings"
+)
+
+// defaultHeaderModel 无法判断模型系列时使用的请求头
+const defaultHeaderModel = "gemini-2.5-flash"
+
+// modelFamily 按名称判断模型系列：图片模型单独一类，lite 归入 flash，无法判断时返回空
+func modelFamily(model string) string {
+	model = strings.ToLower(model)
+	switch {
+	case strings.Contains(model, "image"):
+		return "image"
+	case strings.Contains(model, "pro"):
+		return "pro"
+	case strings.Contains(model, "flash"), strings.Contains(model, "lite"):
+		return "flash"
+	}
+	return ""
+}
+
+// resolveModelHeader 返回 model 使用的 x-goog-ext-525001261-jspb 请求头及实际对应的模型。
+// 不在 ModelHeaders 中的模型回退到同系列（pro→pro、flash→flash）中名称最接近的已知模型，
+// 例如 gemini-3-flash-lite 使用 gemini-3-flash-preview 的请求头，而不是一律使用 gemini-2.5-flash
+func resolveModelHeader(model string) (header, resolved string) {
+	if header, ok := ModelHeaders[model]; ok {
+		return header, model
+	}
+
+	resolved = closestKnownModel(model)
+	log.Printf("Warning: Unknown model '%s', using the closest known model header (%s).", model, resolved)
+	return ModelHeaders[resolved], resolved
+}
+
+// closestKnownModel 在同系列的已知模型中选择与 model 公共前缀最长的一个（相同时取名称较大的，通常是较新的版本）；
+// 关闭思考的变体只在请求的模型名本身带 -no-thinking 时参与选择
+func closestKnownModel(model string) string {
+	family := modelFamily(model)
+	if family == "" {
+		return defaultHeaderModel
+	}
+	noThinking := strings.HasSuffix(strings.ToLower(model), "-no-thinking")
+
+	candidates := make([]string, 0, len(ModelHeaders))
+	for known := range ModelHeaders {
+		if modelFamily(known) == family && strings.HasSuffix(known, "-no-thinking") == noThinking {
+			candidates = append(candidates, known)
+		}
+	}
+	if len(candidates) == 0 {
+		return defaultHeaderModel
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(candidates)))
+
+	lower := strings.ToLower(model)
+	best, bestLen := candidates[0], -1
+	for _, known := range candidates {
+		if n := commonPrefixLen(lower, known); n > bestLen {
+			best, bestLen = known, n
+		}
+	}
+	return best
+}
+
+func commonPrefixLen(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,55 @@
+package gemini
+
+import (
+	"unicode/utf8"
+
+	"gemini-web2api/internal/config"
+)
+
+// OutputCap 按 MAX_OUTPUT_BYTES 限制一个请求从上游读取的正文与思考过程总量（含续写），
+// 防止模型陷入循环时无休止地输出；超出后调用方应停止读取上游并以 MAX_TOKENS 结束
+type OutputCap struct {
+	remaining int
+	limited   bool
+	exceeded  bool
+}
+
+func NewOutputCap() *OutputCap {
+	limit := config.MaxOutputBytes()
+	return &OutputCap{remaining: limit, limited: limit > 0}
+}
+
+// Take 返回 text 与 thought 中仍在上限内的部分（正文优先），超出部分被丢弃
+func (c *OutputCap) Take(text, thought string) (string, string) {
+	if c == nil || !c.limited {
+		return text, thought
+	}
+	text = c.take(text)
+	thought = c.take(thought)
+	return text, thought
+}
+
+func (c *OutputCap) take(s string) string {
+	if s == "" {
+		return s
+	}
+	if c.exceeded {
+		return ""
+	}
+	if len(s) <= c.remaining {
+		c.remaining -= len(s)
+		return s
+	}
+	cut := c.remaining
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	c.remaining = 0
+	c.exceeded = true
+	return s[:cut]
+}
+
+// Exceeded 表示输出已达到上限
+func (c *OutputCap) Exceeded() bool {
+	return c != nil && c.exceeded
+}
@@ -1,9 +1,11 @@
 package gemini
 
 import (
+	"context"
 	"fmt"
 	"math/rand"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/tidwall/sjson"
@@ -18,6 +20,26 @@ type ChatMetadata struct {
 	CID  string
 	RID  string
 	RCID string
+	// ThoughtSignature 上一轮回复的思考签名，续接会话时随会话元数据发回，见 CandidateThoughtSignature
+	ThoughtSignature string `json:",omitempty"`
+}
+
+// thoughtSignatureMetaIndex 续接会话时签名在会话元数据 [cid, rid, rcid, ...] 中的位置
+const thoughtSignatureMetaIndex = 3
+
+// GenerateOptions 单次请求级别的可选参数，零值表示沿用全局配置
+type GenerateOptions struct {
+	// Language 覆盖 LANGUAGE 环境变量，作用于 f.req 语言字段与 Accept-Language
+	Language string
+	// Context 非 nil 时限制请求与读取响应的时间，到期后返回 ErrRequestTimeout
+	Context context.Context
+}
+
+func (o GenerateOptions) language() string {
+	if lang := strings.TrimSpace(o.Language); lang != "" {
+		return lang
+	}
+	return GetLanguage()
 }
 
 // BuildGeneratePayload constructs the 'f.req' parameter.
@@ -34,7 +56,7 @@ type ChatMetadata struct {
 //	None
 //
 // ])
-func BuildGeneratePayload(prompt string, reqID int, files []FileData, meta *ChatMetadata) string {
+func BuildGeneratePayload(prompt string, reqID int, files []FileData, meta *ChatMetadata, opts GenerateOptions) string {
 	imagesJSON := `[]`
 	if len(files) > 0 {
 		for i, f := range files {
@@ -64,7 +86,7 @@ func BuildGeneratePayload(prompt string, reqID int, files []FileData, meta *Chat
 
 	// 語言字段，匹配瀏覽器 f.req 格式
 	langArr := `[]`
-	langArr, _ = sjson.Set(langArr, "0", GetLanguage())
+	langArr, _ = sjson.Set(langArr, "0", opts.language())
 	inner, _ = sjson.SetRaw(inner, "1", langArr)
 
 	if meta != nil {
@@ -72,6 +94,9 @@ func BuildGeneratePayload(prompt string, reqID int, files []FileData, meta *Chat
 		metaArr, _ = sjson.Set(metaArr, "0", meta.CID)
 		metaArr, _ = sjson.Set(metaArr, "1", meta.RID)
 		metaArr, _ = sjson.Set(metaArr, "2", meta.RCID)
+		if meta.ThoughtSignature != "" {
+			metaArr, _ = sjson.Set(metaArr, fmt.Sprintf("%d", thoughtSignatureMetaIndex), meta.ThoughtSignature)
+		}
 		inner, _ = sjson.SetRaw(inner, "2", metaArr)
 	} else {
 		inner, _ = sjson.Set(inner, "2", nil)
@@ -0,0 +1,295 @@
+package gemini
+
+import (
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// OutputStage 输出后处理阶段，按增量片段依次调用 Process；
+// 需要跨片段判断的阶段可以暂存内容，在响应结束时由 Flush 交还
+type OutputStage interface {
+	Process(text string) string
+	Flush() string
+}
+
+// SnapshotStage 作用于快照式响应截至目前的完整文本（计算增量之前），final 为 true 表示响应已经结束。
+// 返回值必须以上一次的返回值开头：暂时无法确定的结尾（如被拆到下一帧的 HTML 实体）先不返回，留到下一帧
+type SnapshotStage interface {
+	Snapshot(raw string, final bool) string
+}
+
+// defaultOutputProcessors 默认后处理链，与引入 OUTPUT_PROCESSORS 前的行为一致
+const defaultOutputProcessors = "unescape,thinking,strip_image_placeholders"
+
+// thinkingStage 把候选中的思考过程作为思考输出，链中没有这一阶段时思考过程被丢弃
+const thinkingStage = "thinking"
+
+// snapshotStages 作用于完整快照的阶段，在所有增量阶段之前执行
+var snapshotStages = map[string]func() SnapshotStage{
+	"unescape":            func() SnapshotStage { return snapshotFunc(unescapeSnapshot) },
+	"collapse_duplicates": func() SnapshotStage { return &duplicateSnapshotStage{} },
+}
+
+// outputStages 可用的增量阶段。有状态阶段每条响应都要新建实例，因此登记的是构造函数
+var outputStages = map[string]func() OutputStage{
+	"strip_image_placeholders": func() OutputStage { return statelessStage(StripImagePlaceholders) },
+	"strip_role_prefix":        func() OutputStage { return &rolePrefixStage{} },
+	"strip_disclaimer":         func() OutputStage { return &disclaimerStage{} },
+	"strip_prompt_echo":        func() OutputStage { return &promptEchoStage{} },
+}
+
+// promptAwareStage 需要知道本次提示词的阶段（如 strip_prompt_echo），由 SetPrompt 传入
+type promptAwareStage interface {
+	setPrompt(prompt string)
+}
+
+var warnedOutputStages sync.Map
+
+// OutputPipeline 一条响应的后处理链，正文与思考过程各自维护一份阶段状态。
+// 解析器把每帧的完整快照交给 TextDelta / ThoughtDelta 换算为增量，再交给 Text / Thought
+type OutputPipeline struct {
+	text     outputChannel
+	thoughts outputChannel
+	// thinking 链中包含 thinking 阶段，为 false 时丢弃思考过程
+	thinking bool
+}
+
+type outputChannel struct {
+	snapshot []SnapshotStage
+	stages   []OutputStage
+	// raw 最近一帧的原始快照，last 已经换算过增量的处理后快照
+	raw  string
+	last string
+}
+
+// NewOutputPipeline 按 OUTPUT_PROCESSORS（逗号分隔的阶段名）创建后处理链：快照阶段先于增量阶段执行，
+// 同类阶段按书写顺序执行。留空使用默认链，none 表示正文与思考过程都原样输出
+func NewOutputPipeline() *OutputPipeline {
+	spec := strings.TrimSpace(os.Getenv("OUTPUT_PROCESSORS"))
+	if spec == "" {
+		spec = defaultOutputProcessors
+	}
+	if strings.EqualFold(spec, "none") {
+		return &OutputPipeline{thinking: true}
+	}
+	p := &OutputPipeline{}
+	p.text, p.thinking = buildOutputChannel(spec)
+	p.thoughts, _ = buildOutputChannel(spec)
+	return p
+}
+
+func buildOutputChannel(spec string) (ch outputChannel, thinking bool) {
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == thinkingStage {
+			thinking = true
+			continue
+		}
+		if factory, ok := snapshotStages[name]; ok {
+			ch.snapshot = append(ch.snapshot, factory())
+			continue
+		}
+		factory, ok := outputStages[name]
+		if !ok {
+			if _, warned := warnedOutputStages.LoadOrStore(name, true); !warned {
+				log.Printf("Warning: unknown output processor '%s' in OUTPUT_PROCESSORS, ignored", name)
+			}
+			continue
+		}
+		ch.stages = append(ch.stages, factory())
+	}
+	return ch, thinking
+}
+
+// SetPrompt 在处理响应前传入本次发送的提示词，没有调用时依赖提示词的阶段原样输出
+func (p *OutputPipeline) SetPrompt(prompt string) {
+	for _, stage := range p.text.stages {
+		if s, ok := stage.(promptAwareStage); ok {
+			s.setPrompt(prompt)
+		}
+	}
+}
+
+// TextDelta 传入一帧正文快照，返回经过快照阶段后相对上一帧新增的部分
+func (p *OutputPipeline) TextDelta(snapshot string) string {
+	return p.text.delta(snapshot, false)
+}
+
+// ThoughtDelta 传入一帧思考过程快照，返回新增的部分
+func (p *OutputPipeline) ThoughtDelta(snapshot string) string {
+	if !p.thinking {
+		return ""
+	}
+	return p.thoughts.delta(snapshot, false)
+}
+
+// SnapshotRemainder 响应结束时调用，返回快照阶段暂未交出的结尾，之后同样交给 Text / Thought
+func (p *OutputPipeline) SnapshotRemainder() (text, thought string) {
+	text = p.text.delta(p.text.raw, true)
+	if p.thinking {
+		thought = p.thoughts.delta(p.thoughts.raw, true)
+	}
+	return text, thought
+}
+
+// Text 处理一段正文增量
+func (p *OutputPipeline) Text(text string) string {
+	return runStages(p.text.stages, text)
+}
+
+// Thought 处理一段思考过程增量
+func (p *OutputPipeline) Thought(text string) string {
+	if !p.thinking {
+		return ""
+	}
+	return runStages(p.thoughts.stages, text)
+}
+
+// Flush 响应结束时调用，返回各阶段暂存的剩余正文与思考过程
+func (p *OutputPipeline) Flush() (text, thought string) {
+	return flushStages(p.text.stages), flushStages(p.thoughts.stages)
+}
+
+// Complete 处理一次性拿到的完整正文与思考过程（非流式解析），相当于只有一帧的响应
+func (p *OutputPipeline) Complete(text, thought string) (string, string) {
+	text, thought = p.Text(p.TextDelta(text)), p.Thought(p.ThoughtDelta(thought))
+	restText, restThought := p.SnapshotRemainder()
+	text, thought = text+p.Text(restText), thought+p.Thought(restThought)
+	flushText, flushThought := p.Flush()
+	return text + flushText, thought + flushThought
+}
+
+func (ch *outputChannel) delta(snapshot string, final bool) string {
+	ch.raw = snapshot
+	for _, stage := range ch.snapshot {
+		snapshot = stage.Snapshot(snapshot, final)
+	}
+	var delta string
+	delta, ch.last = SnapshotDelta(snapshot, ch.last)
+	return delta
+}
+
+func runStages(stages []OutputStage, text string) string {
+	for _, stage := range stages {
+		if text == "" {
+			return ""
+		}
+		text = stage.Process(text)
+	}
+	return text
+}
+
+// flushStages 依次冲刷每个阶段，前一阶段交还的内容继续经过后续阶段
+func flushStages(stages []OutputStage) string {
+	var out string
+	for i, stage := range stages {
+		out = runStages(stages[i:i+1], out) + stage.Flush()
+	}
+	return out
+}
+
+type snapshotFunc func(raw string, final bool) string
+
+func (f snapshotFunc) Snapshot(raw string, final bool) string { return f(raw, final) }
+
+type statelessStage func(string) string
+
+func (f statelessStage) Process(text string) string { return f(text) }
+func (f statelessStage) Flush() string              { return "" }
+
+var imagePlaceholderRegex = regexp.MustCompile(`\s*https?://googleusercontent\.com/image_generation_content/\d+\s*`)
+
+// StripImagePlaceholders 去掉图片生成结果在正文中留下的占位链接
+func StripImagePlaceholders(text string) string {
+	return imagePlaceholderRegex.ReplaceAllString(text, "")
+}
+
+// rolePrefixLookahead 判断开头是否为角色前缀需要暂存的字节数
+const rolePrefixLookahead = 24
+
+var rolePrefixRegex = regexp.MustCompile(`^\s*\**(Model|Assistant)\**\s*:\s*(\*\*)?\s*`)
+
+// rolePrefixStage 去掉回答开头复述的 "**Model**:" / "Assistant:" 前缀（提示词中使用了这类角色标记）
+type rolePrefixStage struct {
+	buf  strings.Builder
+	done bool
+}
+
+func (s *rolePrefixStage) Process(text string) string {
+	if s.done {
+		return text
+	}
+	s.buf.WriteString(text)
+	if s.buf.Len() < rolePrefixLookahead {
+		return ""
+	}
+	return s.Flush()
+}
+
+func (s *rolePrefixStage) Flush() string {
+	if s.done {
+		return ""
+	}
+	s.done = true
+	out := rolePrefixRegex.ReplaceAllString(s.buf.String(), "")
+	s.buf.Reset()
+	return out
+}
+
+// disclaimerHoldback 为了识别结尾的免责声明，始终暂存最后这么多字节再输出
+const disclaimerHoldback = 200
+
+var disclaimerRegex = regexp.MustCompile(`(?is)\s*(Gemini (can|may) make mistakes|Gemini may display inaccurate info)[^\n]*\s*$`)
+
+// disclaimerStage 去掉回答末尾 Gemini 附加的免责声明，会让流式输出延后 disclaimerHoldback 字节
+type disclaimerStage struct {
+	tail string
+}
+
+func (s *disclaimerStage) Process(text string) string {
+	s.tail += text
+	if len(s.tail) <= disclaimerHoldback {
+		return ""
+	}
+	cut := len(s.tail) - disclaimerHoldback
+	for cut > 0 && !utf8.RuneStart(s.tail[cut]) {
+		cut--
+	}
+	out := s.tail[:cut]
+	s.tail = s.tail[cut:]
+	return out
+}
+
+func (s *disclaimerStage) Flush() string {
+	out := disclaimerRegex.ReplaceAllString(s.tail, "")
+	s.tail = ""
+	return out
+}
+
+// duplicateMinLength 回答至少这么多个字符后才判断重复，避免把 "ha" → "haha" 之类的正常回答当成重复
+const duplicateMinLength = 32
+
+// duplicateSnapshotStage 上游偶尔在快照中把已经输出的整段回答再从头输出一遍，
+// 新增部分与回答开头一致时暂不输出；一旦出现不同内容说明不是重复，原样补发暂存的部分。
+// 响应结束时重复部分已经达到 duplicateMinLength 才丢弃
+type duplicateSnapshotStage struct {
+	last string
+}
+
+func (s *duplicateSnapshotStage) Snapshot(raw string, final bool) string {
+	if utf8.RuneCountInString(s.last) >= duplicateMinLength && strings.HasPrefix(raw, s.last) {
+		rest := strings.TrimLeft(raw[len(s.last):], " \t\r\n")
+		if strings.HasPrefix(s.last, rest) && (!final || utf8.RuneCountInString(rest) >= duplicateMinLength) {
+			return s.last
+		}
+	}
+	s.last = raw
+	return raw
+}
@@ -0,0 +1,128 @@
+package gemini
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	// promptEchoMaxLines 最多比较提示词末尾的行数
+	promptEchoMaxLines = 8
+	// promptEchoMinLength 复述内容短于这个长度时不去除，避免把 "Hi" 之类的正常回答当成复述
+	promptEchoMinLength = 16
+)
+
+// echoRolePrefixRegex 比较时忽略行首的角色标记，如 "**User**: "
+var echoRolePrefixRegex = regexp.MustCompile(`^\**(User|Model|System|Assistant)\**\s*:\s*(\*\*)?\s*`)
+
+// promptEchoStage 去掉回答开头逐行复述的提示词末尾（多见于 **User**: / **Model**: 拼接的多轮提示词）。
+// 比较时忽略每行首尾空白、空行与角色标记；只有开头可能是复述时才暂存，确定不是复述后立即原样输出
+type promptEchoStage struct {
+	lines []string
+	buf   strings.Builder
+	done  bool
+	// trimming 去掉复述后继续丢弃紧随其后的空白，直到出现正文
+	trimming bool
+}
+
+func (s *promptEchoStage) setPrompt(prompt string) {
+	s.lines = nil
+	all := strings.Split(prompt, "\n")
+	for i := len(all) - 1; i >= 0 && len(s.lines) < promptEchoMaxLines; i-- {
+		if line := normalizeEchoLine(all[i]); line != "" {
+			s.lines = append([]string{line}, s.lines...)
+		}
+	}
+}
+
+func (s *promptEchoStage) Process(text string) string {
+	if s.done {
+		if s.trimming {
+			text = strings.TrimLeft(text, " \t\r\n")
+			s.trimming = text == ""
+		}
+		return text
+	}
+	s.buf.WriteString(text)
+	end, needMore := matchPromptEcho(s.buf.String(), s.lines, false)
+	if needMore {
+		return ""
+	}
+	return s.release(end)
+}
+
+func (s *promptEchoStage) Flush() string {
+	if s.done {
+		return ""
+	}
+	end, _ := matchPromptEcho(s.buf.String(), s.lines, true)
+	return s.release(end)
+}
+
+// release 输出暂存内容中复述部分（前 end 字节）之后的内容，之后的片段原样通过
+func (s *promptEchoStage) release(end int) string {
+	s.done = true
+	out := s.buf.String()[end:]
+	s.buf.Reset()
+	if end > 0 {
+		out = strings.TrimLeft(out, " \t\r\n")
+		s.trimming = out == ""
+	}
+	return out
+}
+
+// matchPromptEcho 判断 text 是否以 lines 的最后 k 行开头（优先匹配最长的 k），返回复述结束的位置；
+// needMore 表示 text 目前与某个候选一致但还不足以判断。final 为 true 时最后一行没有换行也视为完整
+func matchPromptEcho(text string, lines []string, final bool) (end int, needMore bool) {
+	for k := len(lines); k >= 1; k-- {
+		n, more := matchEchoLines(text, lines[len(lines)-k:], final)
+		if more {
+			return 0, true
+		}
+		if n > 0 {
+			return n, false
+		}
+	}
+	return 0, false
+}
+
+// matchEchoLines 逐行比较 text 开头与 expected，全部一致时返回最后一行（含换行）结束的位置
+func matchEchoLines(text string, expected []string, final bool) (int, bool) {
+	pos, matched, length := 0, 0, 0
+	for matched < len(expected) {
+		if pos >= len(text) {
+			return 0, !final
+		}
+		lineEnd := strings.IndexByte(text[pos:], '\n')
+		complete := lineEnd >= 0 || final
+		if lineEnd < 0 {
+			lineEnd = len(text) - pos
+		}
+		line := normalizeEchoLine(text[pos : pos+lineEnd])
+		next := min(pos+lineEnd+1, len(text))
+		switch {
+		case !complete && len(strings.TrimSpace(text[pos:])) < rolePrefixLookahead:
+			// 可能是尚未完整的角色标记，等更多内容再判断
+			return 0, true
+		case line == "":
+			// 空行（或只有角色标记的行）不参与比较
+		case !complete:
+			return 0, strings.HasPrefix(expected[matched], line)
+		case line != expected[matched]:
+			return 0, false
+		default:
+			length += len(line)
+			matched++
+		}
+		pos = next
+	}
+	if length < promptEchoMinLength {
+		return 0, false
+	}
+	return pos, false
+}
+
+func normalizeEchoLine(line string) string {
+	line = strings.TrimSpace(line)
+	return strings.TrimSpace(echoRolePrefixRegex.ReplaceAllString(line, ""))
+}
@@ -0,0 +1,159 @@
+package gemini
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	http "github.com/bogdanfinn/fhttp"
+)
+
+const (
+	// resumableChunkSize 分片大小，服务端声明的粒度（X-Goog-Upload-Chunk-Granularity）更大时按粒度取整
+	resumableChunkSize = 8 * 1024 * 1024
+	// resumableMaxRetries 单个分片失败后查询进度并续传的次数
+	resumableMaxRetries = 3
+)
+
+// UploadResumable 按 Google 的可续传上传协议分片上传文件（与网页端上传大文件的方式相同）：
+// 先以 start 命令建立会话取得 X-Goog-Upload-URL，再按偏移逐片发送，最后一片带 finalize；
+// 某一片因网络错误或 5xx 失败时用 query 命令取得服务端已收到的字节数，从该位置继续。返回值与 UploadFile 相同
+func (c *Client) UploadResumable(data []byte, filename string, mimeType string) (string, error) {
+	uploadURL, granularity, err := c.startResumableUpload(len(data), filename, mimeType)
+	if err != nil {
+		return "", err
+	}
+
+	chunkSize := resumableChunkSize
+	if granularity > 0 && chunkSize%granularity != 0 {
+		chunkSize = (chunkSize/granularity + 1) * granularity
+	}
+
+	offset, retries := 0, 0
+	for {
+		end := min(offset+chunkSize, len(data))
+		command := "upload"
+		if end == len(data) {
+			command = "upload, finalize"
+		}
+		ref, retryable, err := c.sendResumableChunk(uploadURL, command, offset, data[offset:end])
+		if err == nil {
+			if end == len(data) {
+				return ref, nil
+			}
+			offset, retries = end, 0
+			continue
+		}
+
+		if !retryable || retries >= resumableMaxRetries {
+			return "", err
+		}
+		retries++
+		received, qerr := c.queryResumableUpload(uploadURL)
+		if qerr != nil {
+			return "", fmt.Errorf("%v (querying upload progress failed: %v)", err, qerr)
+		}
+		if received > len(data) {
+			return "", fmt.Errorf("upload server reports %d bytes received for a %d-byte file", received, len(data))
+		}
+		log.Printf("[Upload] Chunk at offset %d failed (%v), resuming %s from byte %d", offset, err, filename, received)
+		offset = received
+	}
+}
+
+// startResumableUpload 建立上传会话，返回分片上传地址与服务端要求的分片粒度（没有声明时为 0）
+func (c *Client) startResumableUpload(size int, filename string, mimeType string) (string, int, error) {
+	body := "File name: " + strings.ReplaceAll(filename, "\n", "")
+	req, err := http.NewRequest(http.MethodPost, c.Endpoints.Upload, strings.NewReader(body))
+	if err != nil {
+		return "", 0, err
+	}
+	c.setUploadHeaders(req)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded;charset=utf-8")
+	req.Header.Set("X-Goog-Upload-Protocol", "resumable")
+	req.Header.Set("X-Goog-Upload-Command", "start")
+	req.Header.Set("X-Goog-Upload-Header-Content-Length", strconv.Itoa(size))
+	req.Header.Set("X-Goog-Upload-Header-Content-Type", mimeType)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("upload failed: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != 200 {
+		return "", 0, fmt.Errorf("upload failed with status: %d", resp.StatusCode)
+	}
+	uploadURL := resp.Header.Get("X-Goog-Upload-URL")
+	if uploadURL == "" {
+		return "", 0, fmt.Errorf("upload server did not return a resumable upload URL")
+	}
+	granularity, _ := strconv.Atoi(resp.Header.Get("X-Goog-Upload-Chunk-Granularity"))
+	return uploadURL, granularity, nil
+}
+
+// sendResumableChunk 发送一个分片，finalize 分片的响应体为文件地址；retryable 表示网络错误或 5xx，可以查询进度后续传
+func (c *Client) sendResumableChunk(uploadURL, command string, offset int, chunk []byte) (ref string, retryable bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, uploadURL, bytes.NewReader(chunk))
+	if err != nil {
+		return "", false, err
+	}
+	c.setUploadHeaders(req)
+	req.Header.Set("X-Goog-Upload-Command", command)
+	req.Header.Set("X-Goog-Upload-Offset", strconv.Itoa(offset))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", true, fmt.Errorf("upload failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		io.Copy(io.Discard, resp.Body)
+		return "", resp.StatusCode >= 500, fmt.Errorf("upload failed with status: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", true, err
+	}
+	return string(body), false, nil
+}
+
+// queryResumableUpload 查询服务端已收到的字节数（X-Goog-Upload-Size-Received）
+func (c *Client) queryResumableUpload(uploadURL string) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, uploadURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	c.setUploadHeaders(req)
+	req.Header.Set("X-Goog-Upload-Command", "query")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if status := resp.Header.Get("X-Goog-Upload-Status"); status != "" && status != "active" {
+		return 0, fmt.Errorf("upload session is %s", status)
+	}
+	received, err := strconv.Atoi(resp.Header.Get("X-Goog-Upload-Size-Received"))
+	if err != nil {
+		return 0, fmt.Errorf("missing X-Goog-Upload-Size-Received")
+	}
+	return received, nil
+}
+
+func (c *Client) setUploadHeaders(req *http.Request) {
+	req.Header.Set("Push-ID", UploadPushID)
+	req.Header.Set("User-Agent", GetCurrentUserAgent())
+	req.Header.Set("Origin", c.Endpoints.Base)
+}
@@ -0,0 +1,77 @@
+package gemini
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestUploadResumableResumesAfterFailedChunk 服务端收下第二片后返回 503，客户端查询进度后从已收到的位置继续
+func TestUploadResumableResumesAfterFailedChunk(t *testing.T) {
+	var mu sync.Mutex
+	var received []byte
+	var commands []string
+	failed := false
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		command := r.Header.Get("X-Goog-Upload-Command")
+		commands = append(commands, command)
+		body, _ := io.ReadAll(r.Body)
+
+		switch {
+		case r.URL.Path == "/upload" && command == "start":
+			if got := r.Header.Get("X-Goog-Upload-Header-Content-Length"); got != strconv.Itoa(17<<20) {
+				http.Error(w, "bad length "+got, http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("X-Goog-Upload-URL", srv.URL+"/session")
+		case command == "query":
+			w.Header().Set("X-Goog-Upload-Status", "active")
+			w.Header().Set("X-Goog-Upload-Size-Received", strconv.Itoa(len(received)))
+		default:
+			if r.Header.Get("X-Goog-Upload-Offset") != strconv.Itoa(len(received)) {
+				http.Error(w, "offset mismatch", http.StatusBadRequest)
+				return
+			}
+			received = append(received, body...)
+			if len(received) > 8<<20 && !failed {
+				failed = true
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			if command == "upload, finalize" {
+				io.WriteString(w, "/contrib_service/ttl_1d/video")
+			}
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("GEMINI_UPLOAD_URL", srv.URL+"/upload")
+
+	client, err := NewClient(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bytes.Repeat([]byte("v"), 17<<20)
+	ref, err := client.UploadResumable(data, "clip.mp4", "video/mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref != "/contrib_service/ttl_1d/video" {
+		t.Fatalf("ref = %q", ref)
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatalf("server received %d bytes, want %d", len(received), len(data))
+	}
+	want := "[start upload upload query upload, finalize]"
+	if got := fmt.Sprint(commands); got != want {
+		t.Fatalf("commands = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,52 @@
+package gemini
+
+import (
+	"bufio"
+	"errors"
+	"gemini-web2api/internal/config"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// defaultMaxLineSize 单行响应的默认上限。超长输出时 Gemini 会在一行内返回完整快照
+const defaultMaxLineSize = 64 * 1024 * 1024
+
+const initialScanBufferSize = 1024 * 1024
+
+// MaxResponseLineSize 读取 RESPONSE_MAX_LINE_SIZE，支持纯字节数或 KB / MB / GB 后缀
+func MaxResponseLineSize() int {
+	v := strings.TrimSpace(os.Getenv("RESPONSE_MAX_LINE_SIZE"))
+	if v == "" {
+		return defaultMaxLineSize
+	}
+
+	n, ok := config.ParseByteSize(v)
+	if !ok {
+		log.Printf("Warning: invalid RESPONSE_MAX_LINE_SIZE '%s', using default %d bytes", os.Getenv("RESPONSE_MAX_LINE_SIZE"), defaultMaxLineSize)
+		return defaultMaxLineSize
+	}
+	return n
+}
+
+// NewResponseScanner 创建按行读取 StreamGenerate 响应的 Scanner，
+// 缓冲区从 1MB 起按需增长，上限为 MaxResponseLineSize
+func NewResponseScanner(r io.Reader) *bufio.Scanner {
+	maxSize := MaxResponseLineSize()
+	initial := initialScanBufferSize
+	if initial > maxSize {
+		initial = maxSize
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, initial), maxSize)
+	return scanner
+}
+
+// LogScanError 单行超出上限时记录日志并提示调大 RESPONSE_MAX_LINE_SIZE，原样返回 err
+func LogScanError(source string, err error) error {
+	if errors.Is(err, bufio.ErrTooLong) {
+		log.Printf("[%s] Response line exceeds %d bytes, remaining output was dropped; raise RESPONSE_MAX_LINE_SIZE", source, MaxResponseLineSize())
+	}
+	return err
+}
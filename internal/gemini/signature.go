@@ -0,0 +1,22 @@
+package gemini
+
+import (
+	"regexp"
+
+	"github.com/tidwall/gjson"
+)
+
+// thoughtSignaturePattern 思考签名是较长的不透明 base64 串
+var thoughtSignaturePattern = regexp.MustCompile(`^[A-Za-z0-9+/_-]{40,}={0,2}$`)
+
+// thoughtSignaturePath 签名在候选中的位置：candidate[37] 为 [[思考正文], 签名]，见 fixtures/thought_signature.txt
+const thoughtSignaturePath = "37.1"
+
+// CandidateThoughtSignature 返回候选思考过程中携带的签名，没有或不像签名时返回空串
+func CandidateThoughtSignature(candidate gjson.Result) string {
+	sig := candidate.Get(thoughtSignaturePath)
+	if sig.Type != gjson.String || !thoughtSignaturePattern.MatchString(sig.Str) {
+		return ""
+	}
+	return sig.Str
+}
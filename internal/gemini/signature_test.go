@@ -0,0 +1,42 @@
+package gemini
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestCandidateThoughtSignature(t *testing.T) {
+	candidates := fixtureCandidates(t, "thought_signature")
+	if len(candidates) != 3 {
+		t.Fatalf("got %d candidates", len(candidates))
+	}
+	// 第一帧只有思考正文，还没有签名
+	if sig := CandidateThoughtSignature(candidates[0]); sig != "" {
+		t.Fatalf("first frame signature = %q, want empty", sig)
+	}
+	want := "CqAbCdEf0123456789AbCdEf0123456789AbCdEf0123456789AbCdEf0123456789=="
+	if sig := CandidateThoughtSignature(candidates[2]); sig != want {
+		t.Fatalf("signature = %q, want %q", sig, want)
+	}
+
+	// 其他位置上形如 base64 的长串不是签名
+	decoy := gjson.Parse(`["rc", [""], null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, null, [["CqAbCdEf0123456789AbCdEf0123456789AbCdEf0123456789"]]]`)
+	if sig := CandidateThoughtSignature(decoy); sig != "" {
+		t.Fatalf("decoy signature = %q, want empty", sig)
+	}
+}
+
+func TestBuildGeneratePayloadReplaysSignature(t *testing.T) {
+	meta := &ChatMetadata{CID: "c", RID: "r", RCID: "rc", ThoughtSignature: "sig"}
+	inner := gjson.Parse(gjson.Parse(BuildGeneratePayload("hi", 1, nil, meta, GenerateOptions{})).Get("1").String())
+	if got := inner.Get("2.3").String(); got != "sig" {
+		t.Fatalf("metadata signature = %q, want sig", got)
+	}
+
+	meta.ThoughtSignature = ""
+	inner = gjson.Parse(gjson.Parse(BuildGeneratePayload("hi", 1, nil, meta, GenerateOptions{})).Get("1").String())
+	if n := len(inner.Get("2").Array()); n != 3 {
+		t.Fatalf("metadata has %d fields without a signature, want 3", n)
+	}
+}
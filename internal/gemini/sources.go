@@ -0,0 +1,159 @@
+package gemini
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Source 联网搜索回答引用的网页
+type Source struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+const sourceSearchDepth = 8
+
+// sourceSkipHosts 图片、图标等 Google 自身资源的域名，不作为引用来源
+var sourceSkipHosts = []string{"googleusercontent.com", "gstatic.com", "ggpht.com", "google.com", "googleapis.com"}
+
+// SourceList 在一次回答（包括续写）的所有快照中按 URL 去重收集引用来源
+type SourceList struct {
+	seen  map[string]bool
+	items []Source
+	// entryPoint Google 要求与搜索结果一同展示的"相关搜索"HTML 片段（searchEntryPoint.renderedContent）
+	entryPoint string
+}
+
+// Add 收集候选中的引用来源。Web 接口的位置不固定，这里与 CandidateFinishReason 一样
+// 在除正文、图片和思考过程以外的字段中查找形如 ["https://...", ..., "标题"] 的数组，样例见 fixtures/grounded.txt
+func (l *SourceList) Add(candidate gjson.Result) {
+	if l == nil || !candidate.IsArray() {
+		return
+	}
+	for i, field := range candidate.Array() {
+		if candidateContentFields[i] {
+			continue
+		}
+		l.find(field, sourceSearchDepth)
+		if l.entryPoint == "" {
+			l.entryPoint = findSearchEntryPoint(field, sourceSearchDepth)
+		}
+	}
+}
+
+// EntryPoint 返回回答附带的搜索入口 HTML，没有时返回空串
+func (l *SourceList) EntryPoint() string {
+	if l == nil {
+		return ""
+	}
+	return l.entryPoint
+}
+
+// entryPointLinkRegex 搜索入口中的搜索建议链接，标题为链接文字
+var entryPointLinkRegex = regexp.MustCompile(`(?s)<a\b[^>]*\bhref="([^"]*google\.com/search[^"]*)"[^>]*>(.*?)</a>`)
+
+var htmlTagRegex = regexp.MustCompile(`<[^>]*>`)
+
+// Suggestions 返回搜索入口中的搜索建议（标题为建议的查询词，URL 为对应的 google.com/search 链接），
+// 供无法嵌入 HTML 的协议以标准的引用形式返回，没有搜索入口时返回 nil
+func (l *SourceList) Suggestions() []Source {
+	var suggestions []Source
+	for _, m := range entryPointLinkRegex.FindAllStringSubmatch(l.EntryPoint(), -1) {
+		title := strings.TrimSpace(html.UnescapeString(htmlTagRegex.ReplaceAllString(m[2], "")))
+		suggestions = append(suggestions, Source{Title: title, URL: html.UnescapeString(m[1])})
+	}
+	return suggestions
+}
+
+// Items 返回按出现顺序排列的引用来源
+func (l *SourceList) Items() []Source {
+	if l == nil {
+		return nil
+	}
+	return l.items
+}
+
+func (l *SourceList) find(value gjson.Result, depth int) {
+	if !value.IsArray() || depth == 0 {
+		return
+	}
+	items := value.Array()
+	var link, title string
+	for _, item := range items {
+		if item.Type != gjson.String {
+			continue
+		}
+		if link == "" && isSourceURL(item.Str) {
+			link = item.Str
+		} else if title == "" && !strings.HasPrefix(item.Str, "http") && !isSearchEntryPoint(item.Str) {
+			title = strings.TrimSpace(item.Str)
+		}
+	}
+	if link != "" {
+		l.add(link, title)
+	}
+	for _, item := range items {
+		l.find(item, depth-1)
+	}
+}
+
+func (l *SourceList) add(link, title string) {
+	if l.seen == nil {
+		l.seen = make(map[string]bool)
+	}
+	if l.seen[link] {
+		return
+	}
+	l.seen[link] = true
+	if title == "" {
+		if u, err := url.Parse(link); err == nil {
+			title = strings.TrimPrefix(u.Hostname(), "www.")
+		}
+	}
+	l.items = append(l.items, Source{Title: title, URL: link})
+}
+
+func isSourceURL(s string) bool {
+	if !strings.HasPrefix(s, "https://") && !strings.HasPrefix(s, "http://") {
+		return false
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := u.Hostname()
+	for _, skip := range sourceSkipHosts {
+		if host == skip || strings.HasSuffix(host, "."+skip) {
+			return false
+		}
+	}
+	return true
+}
+
+// findSearchEntryPoint 查找搜索入口的 HTML 片段：与 Gemini API 的 renderedContent 一样是带 <style> 的 HTML，
+// 其中的搜索建议链接指向 google.com/search
+func findSearchEntryPoint(value gjson.Result, depth int) string {
+	if value.Type == gjson.String {
+		if isSearchEntryPoint(value.Str) {
+			return value.Str
+		}
+		return ""
+	}
+	if !value.IsArray() || depth == 0 {
+		return ""
+	}
+	for _, item := range value.Array() {
+		if html := findSearchEntryPoint(item, depth-1); html != "" {
+			return html
+		}
+	}
+	return ""
+}
+
+func isSearchEntryPoint(s string) bool {
+	return strings.Contains(s, "<style") && strings.Contains(s, "google.com/search")
+}
@@ -0,0 +1,89 @@
+package gemini
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// fixtureCandidates 返回 fixture 每一帧中的第一个候选
+func fixtureCandidates(t *testing.T, name string) []gjson.Result {
+	t.Helper()
+	var candidates []gjson.Result
+	for _, line := range strings.Split(string(readFixture(t, name)), "\n") {
+		outer := gjson.Parse(strings.TrimSpace(line))
+		if !outer.IsArray() {
+			continue
+		}
+		outer.ForEach(func(_, item gjson.Result) bool {
+			if candidate := gjson.Parse(item.Get("2").String()).Get("4.0"); candidate.Exists() {
+				candidates = append(candidates, candidate)
+			}
+			return true
+		})
+	}
+	return candidates
+}
+
+func TestSearchEntryPoint(t *testing.T) {
+	var sources SourceList
+	for _, candidate := range fixtureCandidates(t, "search_entry") {
+		sources.Add(candidate)
+	}
+
+	items := sources.Items()
+	if len(items) != 2 || items[0].URL != "https://example.com/a" || items[1].Title != "Example B" {
+		t.Fatalf("sources = %+v", items)
+	}
+
+	// 同样带 <style>、但没有 google.com/search 链接的 HTML 不是搜索入口
+	entry := sources.EntryPoint()
+	if !strings.HasPrefix(entry, "<style>.chip") {
+		t.Fatalf("entry point = %q", entry)
+	}
+
+	suggestions := sources.Suggestions()
+	want := []Source{
+		{Title: "paris weather", URL: "https://www.google.com/search?q=paris+weather&client=gemini"},
+		{Title: "paris events", URL: "https://www.google.com/search?q=paris+events"},
+	}
+	if len(suggestions) != len(want) {
+		t.Fatalf("suggestions = %+v", suggestions)
+	}
+	for i := range want {
+		if suggestions[i] != want[i] {
+			t.Errorf("suggestion %d = %+v, want %+v", i, suggestions[i], want[i])
+		}
+	}
+}
+
+func TestSearchEntryPointMissing(t *testing.T) {
+	var sources SourceList
+	for _, candidate := range fixtureCandidates(t, "chat") {
+		sources.Add(candidate)
+	}
+	if sources.EntryPoint() != "" || sources.Suggestions() != nil {
+		t.Fatalf("entry point = %q, suggestions = %+v", sources.EntryPoint(), sources.Suggestions())
+	}
+}
+
+// TestSourcesFromGroundedFixture 来源嵌套在引用片段中（candidate[2]），跨帧去重；
+// 正文中的链接、图片（candidate[12]）与 Google 自身的链接不算来源，没有标题时用域名
+func TestSourcesFromGroundedFixture(t *testing.T) {
+	var sources SourceList
+	candidates := fixtureCandidates(t, "grounded")
+	for _, candidate := range candidates {
+		sources.Add(candidate)
+	}
+	want := []Source{
+		{Title: "Paris forecast", URL: "https://weather.example.com/paris"},
+		{Title: "news.example.org", URL: "https://news.example.org/heatwave"},
+	}
+	if got := sources.Items(); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("sources = %+v, want %+v", got, want)
+	}
+	if reason := CandidateFinishReason(candidates[len(candidates)-1]); reason != "STOP" {
+		t.Fatalf("finish reason = %q, want STOP", reason)
+	}
+}
@@ -0,0 +1,63 @@
+package gemini
+
+import (
+	"html"
+	"strings"
+)
+
+var markdownUnescaper = strings.NewReplacer(
+	`\<`, `<`,
+	`\>`, `>`,
+	`\_`, `_`,
+	`\[`, `[`,
+	`\]`, `]`,
+)
+
+// UnescapeText 还原 Gemini Web 返回文本中的 Markdown 转义与 HTML 实体，
+// OpenAI / Claude / Gemini 各协议的解析器共用，保证同一响应输出一致。
+func UnescapeText(text string) string {
+	if text == "" {
+		return text
+	}
+	return html.UnescapeString(markdownUnescaper.Replace(text))
+}
+
+// maxEntityLength 结尾的 & 之后最多这么多个字母数字时视为可能被拆开的 HTML 实体（如 &amp; 的前半段）
+const maxEntityLength = 32
+
+// unescapeSnapshot 对完整快照执行 UnescapeText。流式响应中实体或 Markdown 转义可能被拆在两帧之间，
+// 按增量逐段还原会原样漏出 "&am" + "p;"，因此未结束时先留下结尾不完整的部分，等下一帧补全后再还原
+func unescapeSnapshot(raw string, final bool) string {
+	if !final {
+		raw = raw[:len(raw)-len(unfinishedEscape(raw))]
+	}
+	return UnescapeText(raw)
+}
+
+// unfinishedEscape 返回快照结尾可能尚未写完的转义：单独的反斜杠，或 & 之后尚未出现分号的实体名
+func unfinishedEscape(raw string) string {
+	if strings.HasSuffix(raw, `\`) {
+		return `\`
+	}
+	i := strings.LastIndexByte(raw, '&')
+	if i < 0 || len(raw)-i > maxEntityLength {
+		return ""
+	}
+	for _, r := range raw[i+1:] {
+		if !(r == '#' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return ""
+		}
+	}
+	return raw[i:]
+}
+
+// SnapshotDelta 计算快照式流响应中新增的部分。Gemini Web 每一帧返回截至目前的完整文本，
+// 返回值 delta 为相对 last 新增的内容，next 为下一次比较使用的快照。
+func SnapshotDelta(raw, last string) (delta string, next string) {
+	rawRunes := []rune(raw)
+	lastRunes := []rune(last)
+	if len(rawRunes) > len(lastRunes) {
+		return string(rawRunes[len(lastRunes):]), raw
+	}
+	return "", last
+}
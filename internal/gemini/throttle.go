@@ -0,0 +1,172 @@
+package gemini
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	http "github.com/bogdanfinn/fhttp"
+	"github.com/tidwall/gjson"
+)
+
+// ErrThrottled Gemini 返回了 200，但内容是"请稍后再试"一类的限流通知而不是候选回答
+var ErrThrottled = errors.New("Gemini is throttling this account (try again later)")
+
+// ErrNoResponse 响应在第一个 wrb.fr 帧之前就中断或结束，没有任何可用内容
+var ErrNoResponse = errors.New("Gemini stream ended before the first response frame")
+
+// ErrUpstreamUnavailable 临时的上游故障：连接失败或被重置、连接 / 握手超时、5xx 状态码，换个账号或稍后重试可能成功
+var ErrUpstreamUnavailable = errors.New("Gemini is temporarily unavailable")
+
+// throttleErrorCodes BardErrorInfo 中表示临时限流的错误码：
+// 1013 临时错误，1037 超出使用限制，1060 IP 被临时封禁
+var throttleErrorCodes = map[int64]bool{
+	1013: true,
+	1037: true,
+	1060: true,
+}
+
+const (
+	defaultThrottleCooldown = time.Minute
+	// maxThrottleCooldown Google 给出的重试间隔的上限，避免异常值让账号长时间不可用
+	maxThrottleCooldown = time.Hour
+	// throttlePeekLimit 判断是否限流时最多预读的字节数，限流通知总是第一个 wrb.fr 帧
+	throttlePeekLimit = 64 * 1024
+)
+
+// ThrottleCooldown 账号被限流后暂停参与负载均衡的时长，THROTTLE_COOLDOWN 可配置（如 30s、5m），默认 1 分钟
+func ThrottleCooldown() time.Duration {
+	v := strings.TrimSpace(os.Getenv("THROTTLE_COOLDOWN"))
+	if v == "" {
+		return defaultThrottleCooldown
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("[Gemini] Invalid THROTTLE_COOLDOWN '%s', using %s", v, defaultThrottleCooldown)
+		return defaultThrottleCooldown
+	}
+	return d
+}
+
+// retryDelayPattern google.rpc.RetryInfo 中的 "retryDelay": "30s"（JSON 或 JSPB 形式）
+var retryDelayPattern = regexp.MustCompile(`"?retryDelay"?\s*:\s*"(\d+(?:\.\d+)?)s"`)
+
+// throttleCooldownFor 优先使用 Google 给出的重试间隔（不超过 maxThrottleCooldown），没有时使用 THROTTLE_COOLDOWN
+func throttleCooldownFor(retryAfter time.Duration) time.Duration {
+	if retryAfter <= 0 {
+		return ThrottleCooldown()
+	}
+	return min(retryAfter, maxThrottleCooldown)
+}
+
+// retryAfterHint 从 Retry-After 响应头（秒数或 HTTP 日期）或响应体中的 retryDelay 读取重试间隔，没有时返回 0
+func retryAfterHint(header http.Header, body []byte) time.Duration {
+	if v := strings.TrimSpace(header.Get("Retry-After")); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			if d := time.Until(t); d > 0 {
+				return d
+			}
+		}
+	}
+	if m := retryDelayPattern.FindSubmatch(body); m != nil {
+		if secs, err := strconv.ParseFloat(string(m[1]), 64); err == nil && secs > 0 {
+			return time.Duration(secs * float64(time.Second))
+		}
+	}
+	return 0
+}
+
+// CoolingDown 报告账号是否处于限流冷却期
+func (c *Client) CoolingDown() bool {
+	return c.CooldownRemaining() > 0
+}
+
+// CooldownRemaining 返回冷却期剩余时长，未冷却时为 0
+func (c *Client) CooldownRemaining() time.Duration {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	if remaining := time.Until(c.cooldownUntil); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// Available 账号既不需要重新认证也不在冷却期时才参与负载均衡
+func (c *Client) Available() bool {
+	return !c.NeedsReauth() && !c.CoolingDown()
+}
+
+func (c *Client) startCooldown(d time.Duration) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	c.cooldownUntil = time.Now().Add(d)
+}
+
+// throttleNotice 限流通知帧中的错误码，以及帧中附带的重试间隔（没有时为 0）
+type throttleNotice struct {
+	code       int64
+	retryAfter time.Duration
+}
+
+// checkThrottle 预读响应直到第一个 wrb.fr 帧，命中限流错误码时返回限流通知；
+// 首帧之前连接中断或响应结束时返回 ErrNoResponse。否则把预读的内容拼回去，调用方照常按流读取
+func checkThrottle(body io.ReadCloser) (io.ReadCloser, throttleNotice, error) {
+	reader := bufio.NewReader(body)
+	var peeked bytes.Buffer
+	for peeked.Len() < throttlePeekLimit {
+		line, err := reader.ReadBytes('\n')
+		peeked.Write(line)
+		if bytes.Contains(line, []byte(`"wrb.fr"`)) {
+			if code := throttleCode(line); code != 0 {
+				return body, throttleNotice{code: code, retryAfter: retryAfterHint(http.Header{}, line)}, nil
+			}
+			break
+		}
+		if err == io.EOF {
+			return body, throttleNotice{}, ErrNoResponse
+		}
+		if err != nil {
+			return body, throttleNotice{}, fmt.Errorf("%w: %w", ErrNoResponse, err)
+		}
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(&peeked, reader), body}, throttleNotice{}, nil
+}
+
+// throttleCode 限流时 Gemini 返回不带内容的错误帧：
+// [["wrb.fr",null,null,null,null,[8,null,[["type.googleapis.com/assistant.boq.bard.application.BardErrorInfo",[1037]]]]]]
+func throttleCode(line []byte) int64 {
+	frames := gjson.ParseBytes(bytes.TrimSpace(line))
+	if !frames.IsArray() {
+		return 0
+	}
+	var found int64
+	frames.ForEach(func(_, frame gjson.Result) bool {
+		if frame.Get("0").String() != "wrb.fr" || frame.Get("2").Type == gjson.String {
+			return true
+		}
+		frame.Get("5.2").ForEach(func(_, detail gjson.Result) bool {
+			if code := detail.Get("1.0").Int(); throttleErrorCodes[code] {
+				found = code
+				return false
+			}
+			return true
+		})
+		return found == 0
+	})
+	return found
+}
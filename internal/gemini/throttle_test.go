@@ -0,0 +1,45 @@
+package gemini
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("../mockgemini/fixtures/" + name + ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCheckThrottleDetectsThrottleFixture(t *testing.T) {
+	_, notice, err := checkThrottle(io.NopCloser(bytes.NewReader(readFixture(t, "throttle"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notice.code != 1037 {
+		t.Fatalf("throttle code = %d, want 1037", notice.code)
+	}
+}
+
+func TestCheckThrottlePassesThroughNormalResponse(t *testing.T) {
+	data := readFixture(t, "chat")
+	body, notice, err := checkThrottle(io.NopCloser(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notice.code != 0 {
+		t.Fatalf("chat fixture reported as throttled (code %d)", notice.code)
+	}
+	got, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("peeked bytes were not replayed in front of the rest of the body")
+	}
+}
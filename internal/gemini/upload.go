@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io"
 	"mime/multipart"
+	"net/textproto"
+	"regexp"
+	"strings"
 
 	http "github.com/bogdanfinn/fhttp"
 )
@@ -14,35 +17,59 @@ const (
 	UploadPushID   = "feeds/mcudyrk2a4khkz"
 )
 
+const maxFileRefLength = 512
+
+// fileRefRegex 上传接口返回的文件地址，形如 /contrib_service/ttl_1d/<id>
+var fileRefRegex = regexp.MustCompile(`^/contrib_service(/[A-Za-z0-9_.-]+)+$`)
+
+// ValidFileRef 判断 ref 是否为上传接口返回的文件地址，客户端直接引用已上传的文件时用于校验，
+// 避免把任意内容写入请求载荷
+func ValidFileRef(ref string) bool {
+	return len(ref) <= maxFileRefLength && fileRefRegex.MatchString(ref) && !strings.Contains(ref, "..")
+}
+
 func (c *Client) UploadFile(data []byte, filename string) (string, error) {
-	var buf bytes.Buffer
-	writer := multipart.NewWriter(&buf)
+	return c.UploadFileWithMime(data, filename, "application/octet-stream")
+}
 
-	part, err := writer.CreateFormFile("file", filename)
-	if err != nil {
-		return "", fmt.Errorf("failed to create form file: %v", err)
-	}
+// UploadFileWithMime 上传文件并在表单中声明指定的 Content-Type（音频等非图片文件需要）
+func (c *Client) UploadFileWithMime(data []byte, filename string, mimeType string) (string, error) {
+	return c.UploadReader(bytes.NewReader(data), filename, mimeType)
+}
 
-	if _, err := part.Write(data); err != nil {
-		return "", fmt.Errorf("failed to write file data: %v", err)
-	}
+// UploadReader 与 UploadFileWithMime 相同，文件内容从 r 读取后经 io.Pipe 边读边写入请求体，
+// 配合边读边解码的 base64 Reader 时整个上传过程都不需要把文件放在内存中
+func (c *Client) UploadReader(r io.Reader, filename string, mimeType string) (string, error) {
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	writer := multipart.NewWriter(pw)
 
-	if err := writer.Close(); err != nil {
-		return "", fmt.Errorf("failed to close multipart writer: %v", err)
-	}
+	// writeErr 写入表单时的错误，在关闭管道之前送出：读取 r 失败导致请求失败时返回它而不是笼统的发送错误。
+	// 请求提前结束时由 defer 关闭 pr，让仍在写入的 goroutine 退出
+	writeErr := make(chan error, 1)
+	go func() {
+		err := writeUploadForm(writer, r, filename, mimeType)
+		writeErr <- err
+		pw.CloseWithError(err)
+	}()
 
-	req, err := http.NewRequest(http.MethodPost, EndpointUpload, &buf)
+	req, err := http.NewRequest(http.MethodPost, c.Endpoints.Upload, pr)
 	if err != nil {
 		return "", err
 	}
 
-	req.Header.Set("Push-ID", UploadPushID)
+	c.setUploadHeaders(req)
 	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("User-Agent", GetCurrentUserAgent())
-	req.Header.Set("Origin", "https://gemini.google.com")
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		select {
+		case werr := <-writeErr:
+			if werr != nil {
+				return "", werr
+			}
+		default:
+		}
 		return "", fmt.Errorf("upload failed: %v", err)
 	}
 	defer resp.Body.Close()
@@ -58,3 +85,23 @@ func (c *Client) UploadFile(data []byte, filename string) (string, error) {
 
 	return string(body), nil
 }
+
+// writeUploadForm 把文件写成 multipart 表单的 file 字段，完成后关闭 writer 写入结尾的分隔符
+func writeUploadForm(writer *multipart.Writer, r io.Reader, filename string, mimeType string) error {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, strings.ReplaceAll(filename, `"`, "")))
+	h.Set("Content-Type", mimeType)
+	part, err := writer.CreatePart(h)
+	if err != nil {
+		return fmt.Errorf("failed to create form file: %v", err)
+	}
+
+	if _, err := io.Copy(part, r); err != nil {
+		return fmt.Errorf("failed to write file data: %v", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to close multipart writer: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package gemini
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingReader 读出 data 后返回 err，模拟上传途中解码失败的 base64 Reader
+type failingReader struct {
+	data io.Reader
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, r.err
+	}
+	return n, err
+}
+
+func TestUploadReaderStreamsMultipart(t *testing.T) {
+	var got string
+	var contentLength int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentLength = r.ContentLength
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		data, _ := io.ReadAll(file)
+		got = header.Filename + ":" + header.Header.Get("Content-Type") + ":" + string(data)
+		io.WriteString(w, "/contrib_service/ttl_1d/uploaded")
+	}))
+	defer srv.Close()
+	t.Setenv("GEMINI_UPLOAD_URL", srv.URL)
+
+	client, err := NewClient(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := strings.Repeat("0123456789", 100000)
+	ref, err := client.UploadReader(strings.NewReader(content), "clip.mp4", "video/mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref != "/contrib_service/ttl_1d/uploaded" {
+		t.Fatalf("ref = %q", ref)
+	}
+	if got != "clip.mp4:video/mp4:"+content {
+		t.Fatalf("server received %.80q", got)
+	}
+	// 边读边写的请求体长度未知，以 chunked 方式发送
+	if contentLength != -1 {
+		t.Fatalf("Content-Length = %d, want a streamed body", contentLength)
+	}
+
+	readErr := errors.New("bad base64")
+	if _, err := client.UploadReader(&failingReader{data: strings.NewReader(content), err: readErr}, "clip.mp4", "video/mp4"); err == nil || !strings.Contains(err.Error(), "bad base64") {
+		t.Fatalf("err = %v, want the read error", err)
+	}
+}
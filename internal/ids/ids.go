@@ -0,0 +1,11 @@
+// Package ids 生成返回给客户端的响应与工具调用标识
+package ids
+
+import "crypto/rand"
+
+// New 返回 prefix 加 128 位随机后缀（26 个 base32 字符）的标识，如 chatcmpl-7Q3J...。
+// 以时间戳作后缀时同一秒（Windows 上甚至同一时钟刻度）内的并发请求会拿到相同的 id，
+// 客户端按 id 关联或缓存响应时会出错，随机后缀在并发下也不会重复
+func New(prefix string) string {
+	return prefix + rand.Text()
+}
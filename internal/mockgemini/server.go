@@ -0,0 +1,208 @@
+// Package mockgemini 提供一个模拟 Gemini Web 接口的本地服务，
+// 回放 fixtures 目录下录制的 StreamGenerate 响应（带 )]}' 前缀的分帧格式），
+// 配合 GEMINI_BASE_URL（上传地址随之指向 mock）可以在不访问 Google 的情况下端到端验证各协议处理器。
+package mockgemini
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//go:embed fixtures/*.txt
+var embeddedFixtures embed.FS
+
+const DefaultFixture = "chat"
+
+const initPage = `<!DOCTYPE html><html><head><script>window.WIZ_global_data = {"SNlM0e":"mock-at-token","bl":"boq_assistant-bard-web-server_mock.00_p0","f.sid":"-1234567890"};</script></head><body></body></html>`
+
+// Server 回放指定 fixture 的 mock 服务，fixture 可在运行时切换
+type Server struct {
+	mu       sync.RWMutex
+	fixtures map[string][]byte
+	current  string
+	requests []string
+	// uploads 进行中的可续传上传会话，键为会话 ID，值为已收到的内容
+	uploads  map[string][]byte
+	uploadID int
+}
+
+// NewServer 加载内置 fixtures；dir 非空时额外加载该目录下的 *.txt（同名覆盖内置）
+func NewServer(dir string) (*Server, error) {
+	s := &Server{
+		fixtures: make(map[string][]byte),
+		current:  DefaultFixture,
+		uploads:  make(map[string][]byte),
+	}
+
+	entries, err := embeddedFixtures.ReadDir("fixtures")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		data, err := embeddedFixtures.ReadFile(path.Join("fixtures", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		s.fixtures[strings.TrimSuffix(entry.Name(), ".txt")] = data
+	}
+
+	if dir != "" {
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fixtures dir: %v", err)
+		}
+		for _, f := range files {
+			if f.IsDir() || !strings.HasSuffix(f.Name(), ".txt") {
+				continue
+			}
+			data, err := os.ReadFile(path.Join(dir, f.Name()))
+			if err != nil {
+				return nil, err
+			}
+			s.fixtures[strings.TrimSuffix(f.Name(), ".txt")] = data
+		}
+	}
+
+	return s, nil
+}
+
+// Fixtures 返回可用的 fixture 名称
+func (s *Server) Fixtures() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.fixtures))
+	for name := range s.fixtures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Use 切换后续 StreamGenerate 请求回放的 fixture
+func (s *Server) Use(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.fixtures[name]; !ok {
+		return fmt.Errorf("unknown fixture: %s", name)
+	}
+	s.current = name
+	return nil
+}
+
+// Requests 返回收到的 StreamGenerate 请求中的 f.req 参数，便于校验发送的 payload
+func (s *Server) Requests() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Handler 返回 mock 服务的路由；挂到任意监听地址后该地址即可用作 GEMINI_BASE_URL，
+// 上传地址为其后加 "/upload"（测试中用 httptest.NewServer 包装）
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/app", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, initPage)
+	})
+
+	mux.HandleFunc("/_/BardChatUi/data/assistant.lamda.BardFrontendService/StreamGenerate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		_ = r.ParseForm()
+
+		name := r.URL.Query().Get("fixture")
+
+		s.mu.Lock()
+		s.requests = append(s.requests, r.PostForm.Get("f.req"))
+		if name == "" {
+			name = s.current
+		}
+		data, ok := s.fixtures[name]
+		s.mu.Unlock()
+
+		if !ok {
+			http.Error(w, "unknown fixture", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		flusher, _ := w.(http.Flusher)
+		for _, line := range strings.SplitAfter(string(data), "\n") {
+			io.WriteString(w, line)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	})
+
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Goog-Upload-Command") == "start" {
+			io.Copy(io.Discard, r.Body)
+			s.mu.Lock()
+			s.uploadID++
+			id := fmt.Sprintf("%d", s.uploadID)
+			s.uploads[id] = nil
+			s.mu.Unlock()
+			scheme := "http"
+			if r.TLS != nil {
+				scheme = "https"
+			}
+			w.Header().Set("X-Goog-Upload-Status", "active")
+			w.Header().Set("X-Goog-Upload-URL", fmt.Sprintf("%s://%s/upload/resumable?upload_id=%s", scheme, r.Host, id))
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, "/contrib_service/ttl_1d/mock_uploaded_file")
+	})
+
+	// 可续传上传的分片：按 X-Goog-Upload-Offset 追加，query 返回已收到的字节数，finalize 返回文件地址
+	mux.HandleFunc("/upload/resumable", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("upload_id")
+		command := r.Header.Get("X-Goog-Upload-Command")
+		data, _ := io.ReadAll(r.Body)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		received, ok := s.uploads[id]
+		if !ok {
+			w.Header().Set("X-Goog-Upload-Status", "final")
+			http.Error(w, "unknown upload", http.StatusNotFound)
+			return
+		}
+		if command == "query" {
+			w.Header().Set("X-Goog-Upload-Status", "active")
+			w.Header().Set("X-Goog-Upload-Size-Received", fmt.Sprintf("%d", len(received)))
+			return
+		}
+		if offset := r.Header.Get("X-Goog-Upload-Offset"); offset != fmt.Sprintf("%d", len(received)) {
+			http.Error(w, "offset mismatch", http.StatusBadRequest)
+			return
+		}
+		s.uploads[id] = append(received, data...)
+		if strings.Contains(command, "finalize") {
+			delete(s.uploads, id)
+			w.Header().Set("X-Goog-Upload-Status", "final")
+			io.WriteString(w, "/contrib_service/ttl_1d/mock_uploaded_file")
+			return
+		}
+		w.Header().Set("X-Goog-Upload-Status", "active")
+	})
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("[MockGemini] Unhandled request: %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+	})
+
+	return mux
+}
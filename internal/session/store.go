@@ -0,0 +1,207 @@
+package session
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gemini-web2api/internal/gemini"
+	"gemini-web2api/internal/storage"
+)
+
+const defaultTTL = 24 * time.Hour
+
+// keyPrefix 会话在 storage.Store 中的命名空间
+const keyPrefix = "conversation:"
+
+// Conversation 记录一个会话在 Gemini Web 端的上下文以及所属账号
+type Conversation struct {
+	Metadata  gemini.ChatMetadata `json:"metadata"`
+	AccountID string              `json:"account_id"`
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+// Store 保存 conversation_id -> Conversation 的映射，实际数据放在 storage.Store 中，
+// 每次写入都会刷新 TTL
+type Store struct {
+	backend storage.Store
+	ttl     time.Duration
+}
+
+func NewStore(backend storage.Store, ttl time.Duration) *Store {
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	if backend == nil {
+		backend = storage.NewMemory()
+	}
+	return &Store{backend: backend, ttl: ttl}
+}
+
+// NewStoreFromEnv 读取 CONVERSATION_STORE 与 CONVERSATION_TTL 创建会话存储：
+// 配置了 CONVERSATION_STORE 时会话单独保存在该文件中，否则使用共享的 shared 存储
+func NewStoreFromEnv(shared storage.Store) *Store {
+	ttl := defaultTTL
+	if v := strings.TrimSpace(os.Getenv("CONVERSATION_TTL")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			ttl = d
+		} else if hours, err := strconv.Atoi(v); err == nil {
+			ttl = time.Duration(hours) * time.Hour
+		} else {
+			log.Printf("[Session] Invalid CONVERSATION_TTL '%s', using default %v", v, defaultTTL)
+		}
+	}
+
+	backend := shared
+	if path := strings.TrimSpace(os.Getenv("CONVERSATION_STORE")); path != "" {
+		file, err := openFile(path, ttl)
+		if err != nil {
+			log.Printf("[Session] Failed to load conversations: %v", err)
+		} else {
+			backend = file
+		}
+	}
+
+	store := NewStore(backend, ttl)
+	if removed := store.Cleanup(); removed > 0 || store.Size() > 0 {
+		log.Printf("[Session] Loaded %d conversation(s) (%d expired)", store.Size(), removed)
+	}
+	return store
+}
+
+// openFile 打开 CONVERSATION_STORE 文件；旧版本直接保存 id -> Conversation 的文件会备份为 .bak 后迁移
+func openFile(path string, ttl time.Duration) (*storage.File, error) {
+	legacy := readLegacyFile(path)
+	if legacy != nil {
+		if err := os.Rename(path, path+".bak"); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := storage.NewFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for id, conv := range legacy {
+		if remaining := ttl - time.Since(conv.UpdatedAt); remaining > 0 {
+			storage.SetJSON(file, keyPrefix+id, conv, remaining)
+		}
+	}
+	if legacy != nil {
+		log.Printf("[Session] Migrated %d conversation(s) from the old %s format (backup: %s.bak)", len(legacy), path, path)
+	}
+	return file, nil
+}
+
+// readLegacyFile 识别旧版本的会话文件（顶层键是 conversation_id，值带 metadata 字段），不是时返回 nil
+func readLegacyFile(path string) map[string]Conversation {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	var raw map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil || len(raw) == 0 {
+		return nil
+	}
+	for _, fields := range raw {
+		if _, ok := fields["metadata"]; !ok {
+			return nil
+		}
+	}
+	var items map[string]Conversation
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil
+	}
+	return items
+}
+
+func (s *Store) Get(id string) (*Conversation, bool) {
+	if id == "" {
+		return nil, false
+	}
+	var conv Conversation
+	if !storage.GetJSON(s.backend, keyPrefix+id, &conv) {
+		return nil, false
+	}
+	return &conv, true
+}
+
+func (s *Store) Put(id string, meta gemini.ChatMetadata, accountID string) {
+	if id == "" || meta.CID == "" {
+		return
+	}
+	storage.SetJSON(s.backend, keyPrefix+id, Conversation{
+		Metadata:  meta,
+		AccountID: accountID,
+		UpdatedAt: time.Now(),
+	}, s.ttl)
+}
+
+// Entry 导出 / 导入时使用的会话记录，ID 为 conversation_id（或 Responses API 的 response id）
+type Entry struct {
+	ID string `json:"id"`
+	Conversation
+}
+
+// Export 返回所有未过期的会话，按 ID 排序
+func (s *Store) Export() []Entry {
+	var entries []Entry
+	for _, key := range s.backend.Keys(keyPrefix) {
+		var conv Conversation
+		if storage.GetJSON(s.backend, key, &conv) {
+			entries = append(entries, Entry{ID: strings.TrimPrefix(key, keyPrefix), Conversation: conv})
+		}
+	}
+	return entries
+}
+
+// Import 写入导出的会话并保留原来的 UpdatedAt，剩余有效期按本实例的 TTL 计算；
+// 缺少 ID / CID 或已过期的记录不写入，返回 false
+func (s *Store) Import(entry Entry) bool {
+	if entry.ID == "" || entry.Metadata.CID == "" {
+		return false
+	}
+	remaining := s.ttl - time.Since(entry.UpdatedAt)
+	if remaining <= 0 {
+		return false
+	}
+	storage.SetJSON(s.backend, keyPrefix+entry.ID, entry.Conversation, remaining)
+	return true
+}
+
+func (s *Store) Delete(id string) bool {
+	if id == "" {
+		return false
+	}
+	return s.backend.Delete(keyPrefix + id)
+}
+
+// TTL 会话的有效期
+func (s *Store) TTL() time.Duration {
+	return s.ttl
+}
+
+func (s *Store) Size() int {
+	return len(s.backend.Keys(keyPrefix))
+}
+
+// Cleanup 删除过期的键，返回删除数量
+func (s *Store) Cleanup() int {
+	return s.backend.Cleanup()
+}
+
+// StartCleanup 在后台定期清理过期会话
+func (s *Store) StartCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if removed := s.Cleanup(); removed > 0 {
+				log.Printf("[Session] Purged %d expired key(s)", removed)
+			}
+		}
+	}()
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// persistDelay 修改后延迟写盘的时间，期间的多次修改合并为一次整体写入
+const persistDelay = time.Second
+
+var (
+	openFilesMu sync.Mutex
+	openFiles   []*File
+)
+
+// File 在内存存储的基础上把全部键值保存到一个 JSON 文件：修改后延迟 persistDelay 整体写入临时文件再重命名，
+// 高频写入（每个请求的 window 绑定、批量导入会话）只落盘一次；启动时加载，退出前由 FlushAll 写入尚未保存的修改
+type File struct {
+	*Memory
+	path   string
+	saveMu sync.Mutex
+
+	timerMu sync.Mutex
+	timer   *time.Timer
+}
+
+// NewFile 打开（不存在时新建）文件存储并加载已有内容，已过期的键在加载时丢弃
+func NewFile(path string) (*File, error) {
+	f := &File{Memory: NewMemory(), path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &f.items); err != nil {
+			return nil, err
+		}
+		if f.items == nil {
+			f.items = make(map[string]item)
+		}
+		f.Memory.Cleanup()
+	}
+
+	openFilesMu.Lock()
+	openFiles = append(openFiles, f)
+	openFilesMu.Unlock()
+	return f, nil
+}
+
+func (f *File) Set(key string, value []byte, ttl time.Duration) {
+	if f.Memory.set(key, value, ttl) {
+		f.schedulePersist()
+	}
+}
+
+func (f *File) Delete(key string) bool {
+	ok := f.Memory.Delete(key)
+	if ok {
+		f.schedulePersist()
+	}
+	return ok
+}
+
+func (f *File) Cleanup() int {
+	removed := f.Memory.Cleanup()
+	if removed > 0 {
+		f.schedulePersist()
+	}
+	return removed
+}
+
+// schedulePersist 安排一次延迟写盘，已有待写入的修改时不重复安排
+func (f *File) schedulePersist() {
+	f.timerMu.Lock()
+	defer f.timerMu.Unlock()
+	if f.timer != nil {
+		return
+	}
+	f.timer = time.AfterFunc(persistDelay, func() {
+		f.timerMu.Lock()
+		f.timer = nil
+		f.timerMu.Unlock()
+		f.persist()
+	})
+}
+
+// Flush 立即写入尚未保存的修改
+func (f *File) Flush() {
+	f.timerMu.Lock()
+	pending := f.timer != nil && f.timer.Stop()
+	f.timer = nil
+	f.timerMu.Unlock()
+	if pending {
+		f.persist()
+	}
+}
+
+// FlushAll 写入所有文件存储中尚未保存的修改，进程退出前调用
+func FlushAll() {
+	openFilesMu.Lock()
+	files := slices.Clone(openFiles)
+	openFilesMu.Unlock()
+	for _, f := range files {
+		f.Flush()
+	}
+}
+
+func (f *File) persist() {
+	f.saveMu.Lock()
+	defer f.saveMu.Unlock()
+
+	f.mu.RLock()
+	data, err := json.MarshalIndent(f.items, "", "  ")
+	f.mu.RUnlock()
+	if err != nil {
+		log.Printf("[Storage] Failed to encode %s: %v", f.path, err)
+		return
+	}
+
+	if dir := filepath.Dir(f.path); dir != "" {
+		_ = os.MkdirAll(dir, 0755)
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		log.Printf("[Storage] Failed to write %s: %v", f.path, err)
+		return
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		log.Printf("[Storage] Failed to save %s: %v", f.path, err)
+	}
+}
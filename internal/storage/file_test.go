@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileRejectsNonJSONValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	f, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Set("bad", []byte("not json"), 0)
+	SetJSON(f, "good", "ref", time.Hour)
+	f.Flush()
+
+	if _, ok := f.Get("bad"); ok {
+		t.Fatal("non-JSON value was stored")
+	}
+	reopened, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	var got string
+	if !GetJSON(reopened, "good", &got) || got != "ref" {
+		t.Fatalf("good = %q, want %q", got, "ref")
+	}
+}
+
+func TestFileBatchesWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	f, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 100 {
+		SetJSON(f, "binding", i, 0)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("file written before the persist delay: %v", err)
+	}
+
+	f.Flush()
+	reopened, err := NewFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got int
+	if !GetJSON(reopened, "binding", &got) || got != 99 {
+		t.Fatalf("binding = %d, want 99", got)
+	}
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type item struct {
+	Value     json.RawMessage `json:"value"`
+	ExpiresAt time.Time       `json:"expires_at,omitzero"`
+}
+
+func (i item) expired(now time.Time) bool {
+	return !i.ExpiresAt.IsZero() && now.After(i.ExpiresAt)
+}
+
+// Memory 内存存储，过期的键在读取时视为不存在，由 Cleanup 实际删除
+type Memory struct {
+	mu    sync.RWMutex
+	items map[string]item
+}
+
+func NewMemory() *Memory {
+	return &Memory{items: make(map[string]item)}
+}
+
+func (m *Memory) Get(key string) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	it, ok := m.items[key]
+	if !ok || it.expired(time.Now()) {
+		return nil, false
+	}
+	return slices.Clone(it.Value), true
+}
+
+func (m *Memory) Set(key string, value []byte, ttl time.Duration) {
+	m.set(key, value, ttl)
+}
+
+// set 值必须是合法的 JSON（文件存储把所有值作为 json.RawMessage 一起编码，一个非法值会导致整个文件无法保存），
+// 否则记录日志并拒绝写入，返回是否已写入
+func (m *Memory) set(key string, value []byte, ttl time.Duration) bool {
+	if !json.Valid(value) {
+		log.Printf("[Storage] Rejected non-JSON value for %s", key)
+		return false
+	}
+	it := item{Value: slices.Clone(value)}
+	if ttl > 0 {
+		it.ExpiresAt = time.Now().Add(ttl)
+	}
+	m.mu.Lock()
+	m.items[key] = it
+	m.mu.Unlock()
+	return true
+}
+
+func (m *Memory) Delete(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.items[key]
+	delete(m.items, key)
+	return ok
+}
+
+func (m *Memory) Keys(prefix string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	var keys []string
+	for key, it := range m.items {
+		if strings.HasPrefix(key, prefix) && !it.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (m *Memory) Cleanup() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	removed := 0
+	for key, it := range m.items {
+		if it.expired(now) {
+			delete(m.items, key)
+			removed++
+		}
+	}
+	return removed
+}
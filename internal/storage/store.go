@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Store 带过期时间的键值存储，用于会话、账号停用状态、窗口绑定等需要在各模块间共享或持久化的状态。
+// 键按 "<命名空间>:<id>" 组织，ttl <= 0 表示永不过期；值必须是合法的 JSON（通常通过 SetJSON 写入），否则 Set 拒绝写入
+type Store interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+	Delete(key string) bool
+	// Keys 返回以 prefix 开头且未过期的键
+	Keys(prefix string) []string
+	// Cleanup 删除已过期的键，返回删除数量
+	Cleanup() int
+}
+
+// 存储后端
+const (
+	BackendMemory = "memory" // 仅内存，重启后丢失（默认）
+	BackendFile   = "file"   // JSON 文件，修改后延迟约 1 秒落盘，启动时加载
+)
+
+const defaultFilePath = "data/state.json"
+
+// FromEnv 按 STORAGE_BACKEND / STORAGE_PATH 创建存储，默认内存；文件加载失败时回退到内存
+func FromEnv() Store {
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("STORAGE_BACKEND")))
+	switch backend {
+	case "", BackendMemory:
+		return NewMemory()
+	case BackendFile:
+		path := strings.TrimSpace(os.Getenv("STORAGE_PATH"))
+		if path == "" {
+			path = defaultFilePath
+		}
+		store, err := NewFile(path)
+		if err != nil {
+			log.Printf("[Storage] Failed to load %s, falling back to memory: %v", path, err)
+			return NewMemory()
+		}
+		log.Printf("[Storage] Using file storage %s", path)
+		return store
+	}
+	log.Printf("[Storage] Unknown STORAGE_BACKEND '%s', using memory", backend)
+	return NewMemory()
+}
+
+// GetJSON 读取并解码 JSON 值，键不存在或解码失败时返回 false
+func GetJSON(s Store, key string, v any) bool {
+	data, ok := s.Get(key)
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		log.Printf("[Storage] Failed to decode %s: %v", key, err)
+		return false
+	}
+	return true
+}
+
+// SetJSON 以 JSON 编码写入值
+func SetJSON(s Store, key string, v any, ttl time.Duration) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("[Storage] Failed to encode %s: %v", key, err)
+		return
+	}
+	s.Set(key, data, ttl)
+}
+
+// StartCleanup 在后台定期清理过期的键
+func StartCleanup(s Store, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if removed := s.Cleanup(); removed > 0 {
+				log.Printf("[Storage] Purged %d expired key(s)", removed)
+			}
+		}
+	}()
+}